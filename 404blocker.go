@@ -1,162 +1,646 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"net/http"
+	"net/netip"
+	"regexp"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// clientRequest holds the request details the tracker looks at, independent
+// of the web framework serving it
+type clientRequest struct {
+	ctx            context.Context
+	ip             string
+	key            string // What the client is tracked and banned under
+	method         string
+	path           string
+	userAgent      string
+	acceptLanguage string
+	passCookie     string       // Cookie challenge cookie, if WithCookieChallenge is on
+	policy         *routePolicy // Set by MiddlewareWithPolicy
+	batch          *recordBatch // Set when the asynchronous worker handles it
+}
+
 // IP404Tracker tracks 404 responses by IP address
 type IP404Tracker struct {
-	// Map to track 404 counts by IP
-	counts map[string][]time.Time
+	// Backend holding 404 counts and shadow bans
+	store BanStore
 
-	// Map to track shadow-banned IPs and when they can be unbanned
-	bannedUntil map[string]time.Time
+	// Manually banned address ranges and when they can be unbanned
+	cidrBans *prefixTrie[BanRecord]
 
-	// Set of whitelisted IPs that are exempt from tracking/banning
-	whitelist map[string]bool
+	// Whitelisted IPs and CIDRs that are exempt from tracking/banning
+	whitelist *prefixTrie[whitelistSource]
 
 	// Mutex for thread safety
 	mu sync.RWMutex
 
-	// Banned Request counter
-	bannedRequest map[string]int
+	// Banned Request counter, sharded by client, and the requests of the
+	// counters forgotten since, see ReporterConfig.Retention
+	bannedRequest    *shardedMap[blockedCount]
+	forgottenBlocked atomic.Uint64
+
+	// Subscribers to tracker events
+	events      eventBus
+	callbacks   atomic.Pointer[eventCallbacks]
+	eventBuffer int
+	eventsOnce  sync.Once
+	eventsCh    chan Event
+
+	// Totals exported as metrics
+	counters trackerCounters
+
+	// Latest 404 paths per IP, kept while the IP is tracked or banned
+	paths          *shardedMap[*ipPaths]
+	offendingLimit int // How many, see WithOffendingRequests
+
+	// Ring buffer of the most recent tracked 404s, with a lock of its own
+	recentMu   sync.Mutex
+	recent     []Activity404
+	recentNext int
+
+	// Closed by Shutdown to stop the background loops, which wg tracks and
+	// loops keeps the state of
+	done      chan struct{}
+	wg        sync.WaitGroup
+	loops     loopRegistry
+	closeOnce sync.Once
+	closeErr  error
+
+	// Optional response for banned clients in place of the shadow 404
+	banResponder func(BlockedRequest) BanResponse
+	banHandler   gin.HandlerFunc
+	tarpit       *tarpit
+	decoys       *decoys
+	challenge    *challenge
+
+	// Exempts browsers that solved the cookie challenge from counting
+	cookieChallenge *cookieChallenge
+
+	// Thresholds for responses by status, on top of the 404 threshold
+	statusRules []*statusCounter
+
+	// Route policies by name, see MiddlewareWithPolicy; guarded by mu
+	policies map[string]*routePolicy
+
+	// Paths whose 404s never count, see WithExcludedPaths
+	exclusions          []string
+	exclusionRegexps    []*regexp.Regexp
+	noDefaultExclusions bool
+
+	// 404s per path across all clients, see WithPathStats
+	pathStats *pathStats
+
+	// Clients per 404 path, see WithCampaignDetection
+	campaigns *campaigns
+
+	// Site-wide 404 rate guard, see WithCircuitBreaker
+	breaker *circuitBreaker
+
+	// Distinct 404 paths per client, see WithUniquePathLimit
+	uniquePaths *uniquePaths
+
+	// Penalizes unusual methods, see WithMethodRules
+	methodRules *methodRules
+
+	// How much 404s for risky paths count, see WithPathWeights
+	pathWeights []PathWeight
+
+	// Scanner paths and what their 404s weigh, see WithScannerSignatures;
+	// signatures is guarded by mu
+	signatures      []string
+	signatureWeight int
+
+	// Clients by when they were last seen, see WithMaxTrackedIPs
+	tracked *trackedKeys
+
+	// Queue of responses recorded in the background, see WithAsyncRecording
+	async *asyncRecorder
+
+	// Token buckets of the request rate limit, see WithRateLimit
+	rateLimiter *rateLimiter
+
+	// 404s after which clients get 429s before the ban, see WithWarningThreshold
+	warningThreshold int
+
+	// Send RateLimit-* and Retry-After headers, see WithRateLimitHeaders
+	sendRateLimit bool
+
+	// Let banned clients through, only logging that they would be blocked
+	dryRun bool
+
+	// Source of the current time
+	clock Clock
+
+	// Threshold, window and ban duration, swapped as a whole by Reload
+	limits atomic.Pointer[trackerLimits]
+
+	// Optional cross-instance ban propagation
+	propagator Propagator
+	instanceID string
+
+	// Optional pseudonymization of stored client addresses
+	anonymizer *anonymizer
+
+	// State sizes past which the readiness check fails
+	healthLimits HealthLimits
+
+	// Optional authentication for the admin API
+	adminAuth *AdminAuth
+
+	// Optional record of administrative changes
+	auditLog *auditLog
+
+	// Destination for structured log output
+	logger Logger
+
+	// Optional OpenTelemetry tracer and meter provider (nil when disabled)
+	tracer        trace.Tracer
+	meterProvider metric.MeterProvider
+
+	// Optional StatsD emitter settings
+	statsd *StatsDConfig
+
+	// Optional ban notification webhook
+	webhook *WebhookConfig
+
+	// Optional Slack/Discord notifiers
+	chatNotifiers []*chatNotifier
+
+	// Optional log of bans for fail2ban
+	fail2banPath string
+
+	// Optional JSON Lines file of events
+	eventLog *EventLogConfig
+
+	// Optional Kafka event publishing
+	kafka *KafkaConfig
+
+	// Optional NATS event publishing
+	nats *NATSConfig
+
+	// Optional syslog, CEF or LEEF output for a SIEM
+	siem *siemSink
+
+	// Optional ipset/nftables set kept in sync with the bans
+	firewall *FirewallConfig
+
+	// Banned request report settings
+	reporter ReporterConfig
+
+	// Background cleanup timings
+	cleanupCfg CleanupConfig
+
+	// Optional hot-reloaded whitelist file
+	whitelistPath string
+
+	// Expiry of temporary whitelist entries
+	whitelistExpiry map[netip.Prefix]time.Time
+
+	// Whitelisted hostnames, guarded by mu, and their re-resolution loop
+	whitelistHosts map[string]*whitelistHost
+	hostsOnce      sync.Once
+	hostsWake      chan struct{}
+
+	// Permanently banned IPs and ranges
+	blacklist *prefixTrie[struct{}]
+
+	// Copy of the lists for lock-free request checks, nil after they change
+	view atomic.Pointer[listView]
+
+	// Treat private, link-local and loopback addresses as whitelisted
+	exemptPrivate bool
+
+	// Prefix lengths 404s and bans are aggregated to
+	ipv4Bits int
+	ipv6Bits int
+
+	// Optional escalating ban durations for repeat offenders
+	escalation *EscalationConfig
+
+	// Optional permanent ban of persistent offenders
+	permanentBan *PermanentBanConfig
+
+	// Optional reduced threshold after a ban expires, and when each IP's
+	// probation starts
+	probation     *ProbationConfig
+	probationFrom map[string]time.Time
+
+	// Optional trusted reverse proxies and the headers they report clients in
+	proxies        *ProxyConfig
+	trustedProxies *prefixTrie[struct{}]
+
+	// Optional CDN client address headers
+	cdn *cdnResolver
+
+	// Optional extractors of something other than the IP to track clients by
+	keyFunc      KeyFunc
+	httpKeyFunc  func(*http.Request) string
+	fiberKeyFunc func(*fiber.Ctx) string
 
-	// Configuration
-	threshold   int           // Number of 404s allowed in window
-	window      time.Duration // Time window to count 404s
-	banDuration time.Duration // How long to shadow ban
+	// Optional IP + User-Agent keying
+	fingerprint *FingerprintConfig
+
+	// Optional GeoIP enrichment and country rules
+	geo *geoRules
+
+	// Optional threat intelligence blocklists
+	feeds []*feed
+
+	// Optional AbuseIPDB reporting and confidence checks
+	abuseIPDB *abuseIPDB
+
+	// Optional CrowdSec decision sync
+	crowdSec *crowdSec
+
+	// Optional search engine crawler verification
+	crawlers *crawlerVerifier
+
+	// Optional JSON snapshot persistence
+	snapshotPath     string
+	snapshotInterval time.Duration
+
+	// Optional ban list imported on startup
+	importPath   string
+	importFormat ImportFormat
+}
+
+// Option configures optional behaviour of an IP404Tracker
+type Option func(*IP404Tracker)
+
+// WithStore sets the backend used to keep 404 counts and bans (in-memory by default)
+func WithStore(store BanStore) Option {
+	return func(t *IP404Tracker) {
+		t.store = store
+	}
 }
 
 // NewIP404Tracker creates a new tracker with the specified settings
-func NewIP404Tracker(threshold int, window, banDuration time.Duration) *IP404Tracker {
+func NewIP404Tracker(threshold int, window, banDuration time.Duration, opts ...Option) *IP404Tracker {
 	tracker := &IP404Tracker{
-		counts:        make(map[string][]time.Time),
-		bannedUntil:   make(map[string]time.Time),
-		whitelist:     make(map[string]bool),
-		bannedRequest: make(map[string]int), // Don't forget to initialize this!
-		threshold:     threshold,
-		window:        window,
-		banDuration:   banDuration,
+		store:           NewMemoryStore(),
+		cidrBans:        &prefixTrie[BanRecord]{},
+		whitelist:       &prefixTrie[whitelistSource]{},
+		blacklist:       &prefixTrie[struct{}]{},
+		bannedRequest:   newShardedMap[blockedCount](), // Don't forget to initialize this!
+		paths:           newShardedMap[*ipPaths](),
+		whitelistExpiry: make(map[netip.Prefix]time.Time),
+		probationFrom:   make(map[string]time.Time),
+		offendingLimit:  defaultOffendingRequests,
+		ipv4Bits:        defaultIPv4PrefixBits,
+		ipv6Bits:        defaultIPv6PrefixBits,
+		whitelistHosts:  make(map[string]*whitelistHost),
+		hostsWake:       make(chan struct{}, 1),
+		instanceID:      newInstanceID(),
+		logger:          defaultLogger(),
+		reporter:        ReporterConfig{Interval: 10 * time.Second, Retention: defaultBannedRequestRetention},
+		eventBuffer:     defaultEventBuffer,
+		done:            make(chan struct{}),
+		clock:           systemClock{},
+		cleanupCfg: CleanupConfig{
+			Interval:    defaultCleanupInterval,
+			MinInterval: defaultCleanupMinInterval,
+			HighWater:   defaultCleanupHighWater,
+		},
+	}
+	tracker.limits.Store(&trackerLimits{threshold: threshold, window: window, banDuration: banDuration})
+	for _, opt := range opts {
+		opt(tracker)
+	}
+	if store, ok := tracker.store.(clockUser); ok {
+		store.useClock(tracker.clock)
+	}
+	if tracker.anonymizer != nil {
+		tracker.logger = anonymizingLogger{Logger: tracker.logger, tracker: tracker}
+	}
+	if tracker.sendRateLimit && tracker.banResponder == nil && tracker.banHandler == nil {
+		tracker.logger.Warn("rate limit headers need a ban response; not sending them to keep shadow bans hidden")
+		tracker.sendRateLimit = false
 	}
 	// Add hardcoded IPs to whitelist
 	tracker.initializeWhitelist()
+	if tracker.dryRun {
+		tracker.holdEnforcement()
+	}
+	if tracker.whitelistPath != "" {
+		tracker.loadWhitelistFile()
+		tracker.watchWhitelistFile()
+	}
+	// Report metrics through OpenTelemetry
+	if tracker.meterProvider != nil {
+		if err := tracker.registerOTelMetrics(tracker.meterProvider.Meter(otelScope)); err != nil {
+			tracker.logger.Error("registering OpenTelemetry metrics failed", "error", err)
+		}
+	}
+	// Apply bans made by other instances
+	if tracker.propagator != nil {
+		tracker.subscribeBans()
+	}
+	// Start delivering ban notifications
+	if tracker.webhook != nil {
+		events := tracker.events.subscribe(webhookBuffer, webhookEvents...)
+		tracker.background("webhook", func() { tracker.webhookLoop(events) })
+	}
+	for _, n := range tracker.chatNotifiers {
+		events := tracker.events.subscribe(chatBuffer, chatEvents...)
+		tracker.background("chat", func() { tracker.chatLoop(n, events) })
+	}
+	if tracker.fail2banPath != "" {
+		events := tracker.events.subscribe(fail2banBuffer, EventBanned)
+		tracker.background("fail2ban", func() { tracker.fail2banLoop(events) })
+	}
+	if tracker.eventLog != nil {
+		events := tracker.events.subscribe(eventLogBuffer, tracker.eventLog.Types...)
+		tracker.background("event_log", func() { tracker.eventLogLoop(events) })
+	}
+	if tracker.kafka != nil {
+		events := tracker.events.subscribe(tracker.kafka.Buffer, tracker.kafka.Types...)
+		tracker.background("kafka", func() { tracker.kafkaLoop(events) })
+	}
+	if tracker.nats != nil {
+		events := tracker.events.subscribe(natsBuffer, tracker.nats.Types...)
+		tracker.background("nats", func() { tracker.natsLoop(events) })
+	}
+	if tracker.siem != nil {
+		events := tracker.events.subscribe(siemBuffer, siemEvents...)
+		tracker.background("siem", func() { tracker.siemLoop(events) })
+	}
+	// Start reporting to and checking with AbuseIPDB
+	if tracker.abuseIPDB != nil {
+		if tracker.abuseIPDB.cfg.Report {
+			events := tracker.events.subscribe(abuseIPDBBuffer, EventBanned)
+			tracker.background("abuseipdb_report", func() { tracker.abuseReportLoop(events) })
+		}
+		if tracker.abuseIPDB.cfg.MinConfidence > 0 {
+			tracker.background("abuseipdb_check", tracker.abuseCheckLoop)
+		}
+	}
+	// Start syncing with CrowdSec
+	if tracker.crowdSec != nil {
+		if tracker.crowdSec.cfg.BouncerKey != "" {
+			tracker.background("crowdsec_pull", tracker.crowdSecPullLoop)
+		}
+		if tracker.crowdSec.cfg.MachineID != "" {
+			events := tracker.events.subscribe(crowdSecBuffer, EventBanned)
+			tracker.background("crowdsec_push", func() { tracker.crowdSecPushLoop(events) })
+		}
+	}
+	// Start pushing metrics to StatsD
+	if tracker.statsd != nil {
+		tracker.background("statsd", tracker.statsdLoop)
+	}
+	// Load CDN edge ranges before the first request needs them
+	if tracker.cdn != nil {
+		loaded := tracker.cdn.refreshAll(tracker.logger)
+		tracker.background("cdn_refresh", func() { tracker.cdnRefreshLoop(loaded) })
+	}
+	// Start fetching threat feeds
+	for _, f := range tracker.feeds {
+		tracker.background("feed "+f.cfg.Name, func() { tracker.feedLoop(f) })
+	}
+	// Reload the last snapshot and keep writing new ones
+	if tracker.snapshotPath != "" {
+		tracker.loadSnapshotFile()
+		if tracker.snapshotInterval > 0 {
+			tracker.background("snapshot", tracker.snapshotLoop)
+		}
+	}
+	// Seed bans from a list
+	if tracker.importPath != "" {
+		tracker.loadBanImport()
+	}
+	// Mirror bans into the firewall once the restored ones are in place
+	if tracker.firewall != nil {
+		events := tracker.events.subscribe(firewallBuffer, EventBanned, EventBanExtended, EventUnbanned, EventBanExpired)
+		tracker.background("firewall", func() { tracker.firewallLoop(events) })
+	}
 	// Start a background goroutine to clean up expired entries
-	go tracker.cleanupLoop()
-	// Start hourly logging of banned requests
-	go tracker.startBannedRequestLogger()
+	tracker.background("cleanup", tracker.cleanupLoop)
+	if tracker.async != nil {
+		tracker.background("async", tracker.asyncLoop)
+	}
+	// Start periodic reporting of banned requests
+	if !tracker.reporter.Disabled {
+		tracker.background("report", tracker.startBannedRequestLogger)
+	}
 
 	return tracker
 }
 
 // initializeWhitelist adds hardcoded IPs to the whitelist
 func (t *IP404Tracker) initializeWhitelist() {
-	// Add your testing/admin IPs here
+	// Prefer WithWhitelistFile; entries here need a rebuild to change
 	hardcodedWhitelist := []string{
-		"1.1.1.1", // Replace with your DEV Machine IP
-		// Add more IPs as needed
+		// "10.0.0.0/8",
 	}
 
-	t.mu.Lock()
-	defer t.mu.Unlock()
-
 	for _, ip := range hardcodedWhitelist {
-		t.whitelist[ip] = true
+		if err := t.AddToWhitelist(ip); err != nil {
+			t.logger.Error("invalid whitelist entry", "entry", ip, "error", err)
+		}
 	}
 }
 
-// IsWhitelisted checks if an IP is in the whitelist
+// IsWhitelisted checks if an IP is in the whitelist or a whitelisted range
 func (t *IP404Tracker) IsWhitelisted(ip string) bool {
-	t.mu.RLock()
-	defer t.mu.RUnlock()
-	return t.whitelist[ip]
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+
+	if t.exemptPrivate && isInternalAddr(addr) {
+		return true
+	}
+	if t.asnExempt(addr) {
+		return true
+	}
+
+	return t.lists().whitelisted(addr, t.clock.Now())
 }
 
 // cleanupLoop periodically removes expired entries to prevent memory leaks
 func (t *IP404Tracker) cleanupLoop() {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
-
-	for range ticker.C {
+	for t.sleep(t.cleanupDelay()) {
 		t.cleanup()
 	}
 }
 
 // cleanup removes expired counts and bans
 func (t *IP404Tracker) cleanup() {
-	now := time.Now()
-	windowCutoff := now.Add(-t.window)
-
-	t.mu.Lock()
-	defer t.mu.Unlock()
-
-	// Clean up expired 404 counts
-	for ip, timestamps := range t.counts {
-		var validTimestamps []time.Time
-		for _, ts := range timestamps {
-			if ts.After(windowCutoff) {
-				validTimestamps = append(validTimestamps, ts)
-			}
-		}
-		if len(validTimestamps) == 0 {
-			delete(t.counts, ip)
-		} else {
-			t.counts[ip] = validTimestamps
-		}
-	}
+	now := t.clock.Now()
 
-	// Clean up expired bans
-	for ip, bannedUntil := range t.bannedUntil {
-		if bannedUntil.Before(now) {
-			delete(t.bannedUntil, ip)
-		}
+	expired, err := t.store.Cleanup(now, t.countRetention())
+	if err != nil {
+		t.logger.Error("cleanup failed", "error", err)
+	}
+	expired = append(expired, t.cleanupCIDRBans()...)
+	t.cleanupPaths(now)
+	t.cleanupStatusCounts(now)
+	t.uniquePaths.cleanup(now)
+	t.campaigns.cleanup(now)
+	t.rateLimiter.cleanup(now)
+	t.crawlers.cleanup(now)
+	t.cleanupTemporaryWhitelist(now)
+	t.cleanupProbation(now)
+	t.cleanupBannedRequests(now)
+	t.abuseIPDB.cleanup(now)
+	t.crowdSec.cleanup(now)
+
+	for _, ip := range expired {
+		t.logger.Info("ban expired", "ip", ip)
+		t.emit(Event{Type: EventBanExpired, IP: ip, Reason: BanReasonExpired})
 	}
 }
 
-// Record404 records a 404 for the given IP and returns true if the IP is now banned
+// Record404 records a 404 for the given IP and returns true if the IP is now
+// banned. With WithAsyncRecording it only queues the 404 and reports whether
+// the IP was banned already.
 func (t *IP404Tracker) Record404(ip string) bool {
+	req := clientRequest{ip: ip, key: ip}
+	if t.deferred(req, 0) {
+		return t.IsBanned(ip)
+	}
+	return t.record404(req, 0)
+}
+
+// record404 records a 404 for the key and path of req, counting it weight
+// times, or by its path when weight is 0
+func (t *IP404Tracker) record404(req clientRequest, weight int) bool {
+	ip, path := req.key, req.path
+
 	// Skip tracking for whitelisted IPs
 	if t.IsWhitelisted(ip) {
 		return false
 	}
 
-	now := time.Now()
-	windowStart := now.Add(-t.window)
-
-	t.mu.Lock()
-	defer t.mu.Unlock()
+	now := t.clock.Now()
 
 	// Check if already banned
-	if banTime, exists := t.bannedUntil[ip]; exists && banTime.After(now) {
+	if t.IsBanned(ip) {
 		return true // Already banned
 	}
 
-	// Add current timestamp to the IP's record
-	timestamps := t.counts[ip]
+	// Add current timestamp to the IP's (or its network's) record
+	p := pending404{req: req, key: t.trackingKey(ip), at: now}
+	t.track(p.key)
+	t.checkCircuit(path, now)
+	p.threshold = t.thresholdFor(p.key, now)
+	if p.weight = weight; p.weight == 0 {
+		p.weight = t.weightFor(path, p.threshold)
+	}
+	if req.batch != nil {
+		req.batch.pending = append(req.batch.pending, p)
+		return false
+	}
+	count, err := t.store.Record404(p.key, now, t.countRetention(), p.weight)
+	return t.counted404(p, count, err)
+}
 
-	// Filter out timestamps outside the window
-	var recentTimestamps []time.Time
-	for _, ts := range timestamps {
-		if ts.After(windowStart) {
-			recentTimestamps = append(recentTimestamps, ts)
-		}
+// pending404 is a 404 that passed the checks of record404, to be judged
+// once the store has counted it
+type pending404 struct {
+	req       clientRequest
+	key       string // Tracking key
+	at        time.Time
+	threshold int
+	weight    int
+}
+
+// counted404 judges a 404 the store counted, reporting whether its key is
+// banned now
+func (t *IP404Tracker) counted404(p pending404, count int, err error) bool {
+	ip, path, now := p.key, p.req.path, p.at
+	if p.req.batch != nil && t.isBannedIP(ip) {
+		// An earlier 404 of the batch got it banned
+		return true
+	}
+	if l := t.limits.Load(); err == nil && len(l.windows) > 0 {
+		count, err = t.store.Count404s(ip, now, l.window)
 	}
+	if err != nil {
+		t.logger.Error("recording 404 failed", "ip", ip, "error", err)
+		return false
+	}
+	t.counters.recorded404s.Add(1)
+	t.recordActivity(p.req.key, path, now)
+	t.recordPath(ip, p.req, now)
+	t.emit(Event{Type: Event404Recorded, IP: ip, Path: path, Count: count, Weight: p.weight, Threshold: p.threshold})
 
-	// Add the new timestamp
-	recentTimestamps = append(recentTimestamps, now)
-	t.counts[ip] = recentTimestamps
+	if t.judge404(ip, path, count, p.threshold, "", now) {
+		return true
+	}
+	return t.checkWindows(ip, path, now)
+}
 
+// judge404 bans the tracking key ip after a 404 for path if it crossed the
+// threshold of the named policy, or the 404 threshold when it's empty, or
+// gave itself away otherwise, reporting whether it did
+func (t *IP404Tracker) judge404(ip, path string, count, threshold int, rule string, now time.Time) bool {
+	if t.checkCampaign(ip, path, now) {
+		return true
+	}
 	// Check if threshold exceeded
-	if len(recentTimestamps) > t.threshold {
-		// Ban the IP
-		t.bannedUntil[ip] = now.Add(t.banDuration)
+	if count > threshold {
+		t.banForCount(ip, path, count, rule, now)
 		return true
 	}
+	return t.checkUniquePaths(ip, path, now)
+}
+
+// banForCount bans the tracking key ip for crossing the threshold of the
+// named status rule, or the 404 threshold when it's empty, with count
+func (t *IP404Tracker) banForCount(ip, path string, count int, rule string, now time.Time) {
+	reason := BanReasonThreshold
+	if rule != "" {
+		reason = rule + " threshold exceeded"
+	}
+	t.banAutomatic(ip, path, count, rule, reason, now)
+}
 
-	return false
+// banAutomatic bans the tracking key ip for what it did, escalating the
+// ban with its offenses
+func (t *IP404Tracker) banAutomatic(ip, path string, count int, rule, reason string, now time.Time) {
+	offense := t.recordOffense(ip, now)
+	if t.permanentFor(offense) {
+		reason = BanReasonRepeatOffender
+	}
+	requests := t.offendingRequests(ip)
+	record := BanRecord{
+		BannedAt:  now,
+		ExpiresAt: now.Add(t.breaker.lengthen(t.ruleBanDuration(rule, offense), now)),
+		Reason:    reason,
+		Count:     count,
+		Rule:      rule,
+		Paths:     requestPaths(requests),
+		Requests:  requests,
+		Offense:   offense,
+		Source:    BanSourceAutomatic,
+		GeoInfo:   t.geoLookup(ip),
+	}
+	record = t.ban(ip, record)
+	t.counters.bansIssued.Add(1)
+	t.logger.Info("ban issued", "ip", ip, "path", path, "count", count, "offense", offense, "expires_at", record.ExpiresAt, "reason", record.Reason)
+	t.emit(Event{
+		Type:      EventBanned,
+		IP:        ip,
+		Reason:    record.Reason,
+		Path:      path,
+		Paths:     record.Paths,
+		Requests:  record.Requests,
+		Count:     count,
+		Rule:      rule,
+		ExpiresAt: record.ExpiresAt,
+		GeoInfo:   record.GeoInfo,
+	})
 }
 
 // IsBanned checks if an IP is currently banned
@@ -166,57 +650,57 @@ func (t *IP404Tracker) IsBanned(ip string) bool {
 		return false
 	}
 
-	now := time.Now()
-
-	t.mu.RLock()
-	defer t.mu.RUnlock()
+	return t.IsBlacklisted(ip) || t.isBannedIP(ip) || t.cidrBanned(ip) || t.feedListing(ip) != "" || t.crowdSecBanned(ip)
+}
 
-	banTime, exists := t.bannedUntil[ip]
-	return exists && banTime.After(now)
+// isBannedIP checks the store for a ban on this IP (or its aggregated prefix)
+func (t *IP404Tracker) isBannedIP(ip string) bool {
+	ip = t.trackingKey(ip)
+	banned, err := t.store.IsBanned(ip, t.clock.Now())
+	if err != nil {
+		t.logger.Error("checking ban failed", "ip", ip, "error", err)
+	}
+	return banned
 }
 
 // GetBannedIPs returns a map of currently banned IPs and their ban expiry times
 func (t *IP404Tracker) GetBannedIPs() map[string]time.Time {
-	now := time.Now()
+	result := make(map[string]time.Time)
+	for ip, record := range t.GetBans() {
+		result[ip] = record.ExpiresAt
+	}
 
-	t.mu.RLock()
-	defer t.mu.RUnlock()
+	return result
+}
 
-	result := make(map[string]time.Time)
-	for ip, banTime := range t.bannedUntil {
-		if banTime.After(now) {
-			result[ip] = banTime
-		}
+// GetBans returns the currently banned IPs with the details of each ban
+func (t *IP404Tracker) GetBans() map[string]BanRecord {
+	result, err := t.store.ListBans(t.clock.Now())
+	if err != nil {
+		t.logger.Error("listing bans failed", "error", err)
+		return make(map[string]BanRecord)
 	}
 
 	return result
 }
 
-func (t *IP404Tracker) BannedRequestCounter(clientIP string) {
-	t.mu.Lock()
-	t.bannedRequest[clientIP]++
-	t.mu.Unlock()
-}
-
-// startBannedRequestLogger prints banned request counts to stdout every hour
-func (t *IP404Tracker) startBannedRequestLogger() {
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		t.mu.RLock()
-		fmt.Println("=== Banned Requests Report ===")
-		fmt.Printf("Timestamp: %s\n", time.Now().Format(time.RFC3339))
-		if len(t.bannedRequest) == 0 {
-			fmt.Println("No banned requests recorded")
-		} else {
-			for ip, count := range t.bannedRequest {
-				fmt.Printf("IP: %s - Banned Requests: %d\n", ip, count)
-			}
-		}
-		fmt.Println("==============================")
-		t.mu.RUnlock()
+// GetBanInfo returns why and until when an IP is banned
+func (t *IP404Tracker) GetBanInfo(ip string) (BanRecord, bool) {
+	ip = t.trackingKey(ip)
+	record, ok, err := t.store.GetBan(ip, t.clock.Now())
+	if err != nil {
+		t.logger.Error("checking ban failed", "ip", ip, "error", err)
 	}
+	return record, ok
+}
+
+func (t *IP404Tracker) BannedRequestCounter(clientIP string) {
+	clientIP = t.trackingKey(clientIP)
+	t.track(clientIP)
+	now := t.clock.Now()
+	t.bannedRequest.update(clientIP, func(c blockedCount, _ bool) blockedCount {
+		return blockedCount{requests: c.requests + 1, last: now}
+	})
 }
 
 // ExtendBan extends the ban duration for an IP to the full ban duration from now
@@ -227,43 +711,293 @@ func (t *IP404Tracker) ExtendBan(ip string) {
 		return
 	}
 
-	t.mu.Lock()
-	defer t.mu.Unlock()
+	// Extend the ban to the full duration from now, keeping why it was issued
+	ip = t.trackingKey(ip)
+	now := t.clock.Now()
+	record, ok, err := t.store.GetBan(ip, now)
+	if err != nil {
+		t.logger.Error("checking ban failed", "ip", ip, "error", err)
+	}
+	if !ok {
+		record = BanRecord{BannedAt: now, Reason: BanReasonThreshold, Source: BanSourceAutomatic}
+	}
+	// A longer manual ban is never cut short
+	duration := t.ruleBanDuration(record.Rule, record.Offense)
+	newBanTime := now.Add(duration)
+	if record.ExpiresAt.After(newBanTime) {
+		newBanTime = record.ExpiresAt
+	}
+	// Broadcasting every blocked request would cost each peer a store write
+	// per request, so peers and probation only follow the expiry each time
+	// it enters another step of the ban duration
+	action := BanActionExtend
+	step := duration / extendBroadcastDivisor
+	if newBanTime.Truncate(step).Equal(record.ExpiresAt.Truncate(step)) {
+		action = ""
+	}
+	record.ExpiresAt = newBanTime
+	newBanTime = t.storeBan(action, ip, record).ExpiresAt
+	t.logger.Debug("ban extended", "ip", ip, "expires_at", newBanTime)
+	t.emit(Event{Type: EventBanExtended, IP: ip, ExpiresAt: newBanTime})
+}
+
+// Ban manually bans an IP for the given duration
+func (t *IP404Tracker) Ban(ip string, duration time.Duration) {
+	if t.IsWhitelisted(ip) {
+		return
+	}
+
+	ip = t.trackingKey(ip)
+	now := t.clock.Now()
+	geo := t.geoLookup(ip)
+	until := t.ban(ip, BanRecord{
+		BannedAt:  now,
+		ExpiresAt: now.Add(duration),
+		Reason:    BanReasonManual,
+		Source:    BanSourceManual,
+		GeoInfo:   geo,
+	}).ExpiresAt
+	t.counters.bansIssued.Add(1)
+	t.logger.Info("ban issued", "ip", ip, "expires_at", until, "manual", true)
+	t.emit(Event{Type: EventBanned, IP: ip, Reason: BanReasonManual, ExpiresAt: until, GeoInfo: geo})
+}
+
+// Unban lifts the ban on an IP
+func (t *IP404Tracker) Unban(ip string) {
+	t.unban(ip, BanReasonManual)
+}
+
+// unban lifts the ban on an IP, reporting whether it could
+func (t *IP404Tracker) unban(ip, reason string) bool {
+	ip = t.trackingKey(ip)
+	if err := t.store.Unban(ip); err != nil {
+		t.logger.Error("unbanning failed", "ip", ip, "error", err)
+		return false
+	}
+
+	t.publish(BanActionUnban, ip, nil)
+	t.endProbation(ip)
+	t.logger.Info("ban lifted", "ip", ip, "reason", reason)
+	t.emit(Event{Type: EventUnbanned, IP: ip, Reason: reason})
+	return true
+}
+
+// ResetCounts forgets the 404 history, offending paths, banned request
+// counter and past bans of an IP, so a false positive starts again from
+// zero. It doesn't lift an active ban; call Unban for that.
+func (t *IP404Tracker) ResetCounts(ip string) {
+	key := t.trackingKey(ip)
+	t.clearCounters(key)
+	if err := t.store.ClearOffenses(key); err != nil {
+		t.logger.Error("clearing offenses failed", "ip", key, "error", err)
+	}
+	t.logger.Info("counts reset", "ip", key)
+}
 
-	// Extend the ban to the full duration from now
-	newBanTime := time.Now().Add(t.banDuration)
-	t.bannedUntil[ip] = newBanTime
+// clearCounters forgets the 404 counts, paths, banned request counter and
+// probation of a tracking key
+func (t *IP404Tracker) clearCounters(key string) {
+	if prefix, err := parseIPOrCIDR(key); err == nil {
+		t.clearCountersIn(prefix)
+	} else {
+		t.clearKeyCounters(key)
+	}
+}
+
+// ban stores a ban and broadcasts it to the other instances, returning the
+// record with the expiry the retention allows
+func (t *IP404Tracker) ban(ip string, record BanRecord) BanRecord {
+	return t.storeBan(BanActionBan, ip, record)
+}
+
+// extendBroadcastDivisor splits the ban duration into the steps a rolling
+// ban's expiry moves through between broadcasts
+const extendBroadcastDivisor = 10
+
+// storeBan stores a ban and broadcasts it as action, BanActionBan for a
+// new ban or BanActionExtend for a renewed one. An empty action only
+// stores a renewal too small to broadcast, leaving probation alone.
+func (t *IP404Tracker) storeBan(action, ip string, record BanRecord) BanRecord {
+	record.ExpiresAt = t.anonymizer.retainUntil(record.ExpiresAt, t.clock.Now())
+	if err := t.store.Ban(ip, record); err != nil {
+		t.logger.Error("banning failed", "ip", ip, "error", err)
+		return record
+	}
+
+	if action == "" {
+		return record
+	}
+	t.publish(action, ip, &record)
+	t.startProbation(ip, record.ExpiresAt)
+	return record
+}
+
+// blockBanned reports whether a request must be blocked, extending the
+// ban and counting the blocked request when it is
+func (t *IP404Tracker) blockBanned(req clientRequest) (BlockedRequest, bool) {
+	if t.IsWhitelisted(req.ip) || t.crawlers.isVerified(req.ip, req.userAgent, t.clock.Now()) {
+		t.counters.whitelistedHits.Add(1)
+		return BlockedRequest{}, false
+	}
+
+	ip, key := req.ip, req.key
+	var reason string
+	switch {
+	case t.IsBlacklisted(ip):
+		// Permanent bans have no timer to extend
+		reason = "blacklist"
+	case t.isBannedIP(key):
+		// Rolling ban: every attempt restarts the timer
+		t.ExtendBan(key)
+		reason = "ip"
+		if key != ip {
+			reason = "key"
+		}
+	case fingerprinted(key) && t.isBannedIP(ip):
+		// Bans of the address cover every fingerprint behind it
+		t.ExtendBan(ip)
+		reason = "ip"
+	case t.cidrBanned(ip):
+		// Range bans keep their manually chosen expiry
+		reason = "cidr"
+	case t.feedListing(ip) != "":
+		// Feed entries come and go with the feed
+		reason = "feed"
+	case t.crowdSecBanned(ip):
+		reason = "crowdsec"
+	default:
+		// Country and ASN blocks
+		if reason = t.geoBlocked(ip); reason == "" {
+			t.abuseIPDB.enqueueCheck(ip, t.clock.Now())
+			return BlockedRequest{}, false
+		}
+	}
+
+	t.BannedRequestCounter(key)
+	t.counters.blockedRequests.Add(1)
+	t.traceBlocked(req.ctx, key, reason)
+	if t.dryRun {
+		t.logger.Info("request would have been blocked", "ip", ip, "key", key, "path", req.path, "ban_type", reason)
+		return BlockedRequest{}, false
+	}
+	t.logger.Debug("blocked request", "ip", ip, "key", key, "path", req.path, "ban_type", reason)
+
+	blocked := BlockedRequest{IP: ip, Key: key, Method: req.method, Path: req.path, BanType: reason}
+	t.siem.recordBlocked(blocked, t.clock.Now())
+	if t.banResponder != nil || t.challenge != nil || t.sendRateLimit {
+		// Only custom responses, challenges and Retry-After use it; skip the lookup for shadow 404s
+		record := t.banRecord(blocked)
+		blocked.Reason, blocked.ExpiresAt = record.Reason, record.ExpiresAt
+	}
+	return blocked, true
+}
+
+// handle404 records a 404, weight times or by its path when weight is 0,
+// unless it was served to a genuine search engine crawler or a browser that
+// passed the cookie challenge, which follow stale links
+func (t *IP404Tracker) handle404(req clientRequest, weight int) {
+	if t.deferred(req, 0) {
+		return
+	}
+	if req.policy != nil && req.policy.cfg.Exempt || t.excluded(req.path) {
+		return
+	}
+	if t.cookieChallenge.passed(req, t.clock.Now()) {
+		return
+	}
+	if t.crawlers.verify(req.ctx, req.ip, req.userAgent, t.clock.Now(), t.logger) {
+		return
+	}
+	// record404 can only check the whitelist for IP keys
+	if req.key != req.ip && t.IsWhitelisted(req.ip) {
+		return
+	}
+	if req.policy != nil {
+		t.recordPolicy404(req, weight)
+		return
+	}
+	t.record404(req, weight)
 }
 
 // Middleware returns a Gin middleware that tracks 404s and shadow bans IPs
 func (t *IP404Tracker) Middleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		clientIP := c.ClientIP()
+	return t.ginMiddleware(nil)
+}
 
-		// Check if the IP is already banned (whitelisted IPs will return false)
-		if t.IsBanned(clientIP) {
-			t.ExtendBan(clientIP)
-			t.BannedRequestCounter(clientIP)
+// ginRequest describes the client behind a Gin request
+func (t *IP404Tracker) ginRequest(c *gin.Context) clientRequest {
+	req := clientRequest{
+		ctx:            c.Request.Context(),
+		ip:             t.ginClientIP(c),
+		method:         c.Request.Method,
+		path:           c.Request.URL.Path,
+		userAgent:      c.Request.UserAgent(),
+		acceptLanguage: c.GetHeader("Accept-Language"),
+		passCookie:     t.cookieChallenge.cookie(c.Request),
+	}
+	var key string
+	if t.keyFunc != nil {
+		key = t.keyFunc(c)
+	}
+	req.key = t.requestKey(req, key)
+	return req
+}
 
-			// For shadow banning, we don't tell the client they're banned
-			// Instead, we just serve a generic 404 response
-			c.Status(404)
+// ginBlock answers a blocked Gin request
+func (t *IP404Tracker) ginBlock(c *gin.Context, blocked BlockedRequest) {
+	c.Abort()
+	t.holdBanned(c.Request.Context(), blocked)
+	if t.banHandler != nil {
+		for name, value := range t.blockedRateLimitHeaders(blocked) {
+			c.Header(name, value)
+		}
+		c.Set(BlockedRequestKey, blocked)
+		t.banHandler(c)
+		return
+	}
+	// For shadow banning, we don't tell the client they're banned
+	// Instead, we just serve a generic 404 response unless a ban
+	// response is configured
+	writeBanResponse(c.Writer, t.banResponse(blocked))
+}
+
+// ginMiddleware returns the Gin middleware, counting 404s under policy if
+// it's set
+func (t *IP404Tracker) ginMiddleware(policy *routePolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req := t.ginRequest(c)
+		req.policy = policy
+		if t.isChallengePath(req.path) {
+			c.Abort()
+			t.serveChallengePath(c.Writer, c.Request, req)
+			return
+		}
+		// Check if the IP is already banned (whitelisted IPs will return false)
+		if blocked, ok := t.blockBanned(req); ok {
+			t.ginBlock(c, blocked)
+			return
+		}
+		if resp, limited := t.rateLimited(req); limited {
 			c.Abort()
+			writeBanResponse(c.Writer, resp)
 			return
 		}
 
+		for name, value := range t.rateLimitHeaders(req) {
+			c.Header(name, value)
+		}
+
 		// Process the request
+		c.Set(trackedKey, true)
 		c.Next()
-
-		// Check if this was a 404 response
-		if c.Writer.Status() == 404 {
-			// Record the 404 and check if IP should be banned
-			// (whitelisted IPs won't be tracked or banned)
-			if t.Record404(clientIP) {
-				// IP is now banned, but we've already sent the response
-				// so we'll just log it for now
-				// You could add zerolog logging here
-			}
+		if p, ok := c.Get(routePolicyKey); ok {
+			req.policy = p.(*routePolicy)
 		}
+
+		// Record 404s and other tracked statuses and check if IP should be banned
+		// (whitelisted IPs won't be tracked or banned)
+		// IP may now be banned, but we've already sent the response
+		// (ban issued events are logged by banForCount)
+		t.handleResponse(req, c.Writer.Status())
 	}
 }
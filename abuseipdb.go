@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// abuseIPDBBuffer is how many ban events may queue up for reporting
+	abuseIPDBBuffer = 1024
+
+	// abuseIPDBCheckQueue is how many addresses may wait to be checked;
+	// more are skipped until the queue drains
+	abuseIPDBCheckQueue = 256
+
+	// AbuseIPDB refuses reports of the same address within 15 minutes
+	abuseIPDBReportCooldown = 15 * time.Minute
+
+	// How long a confidence score is trusted before the address is checked again
+	abuseIPDBCheckTTL = 24 * time.Hour
+
+	// Longest comment AbuseIPDB accepts
+	abuseIPDBMaxComment = 1024
+)
+
+// AbuseIPDB categories useful for 404 scanners, see https://www.abuseipdb.com/categories
+const (
+	AbuseCategoryHacking      = 15
+	AbuseCategoryBadWebBot    = 19
+	AbuseCategoryWebAppAttack = 21
+)
+
+// AbuseIPDBConfig configures WithAbuseIPDB
+type AbuseIPDBConfig struct {
+	APIKey string
+
+	// Report IPs banned for their 404s, with their offending paths
+	Report           bool
+	Categories       []int // Report categories (default web app attack)
+	MaxReportsPerDay int   // Default 1000, the free plan's limit
+
+	// Check the confidence score of new clients and ban those scoring at
+	// least this much (1-100, 0 disables checks)
+	MinConfidence   int
+	MaxAgeInDays    int // How far back reports count (default 30)
+	MaxChecksPerDay int // Default 1000, the free plan's limit
+
+	BaseURL string // Default https://api.abuseipdb.com/api/v2
+}
+
+// WithAbuseIPDB reports banned IPs to AbuseIPDB and/or bans clients that
+// AbuseIPDB is already confident about before they reach the threshold
+func WithAbuseIPDB(cfg AbuseIPDBConfig) Option {
+	return func(t *IP404Tracker) {
+		if len(cfg.Categories) == 0 {
+			cfg.Categories = []int{AbuseCategoryWebAppAttack}
+		}
+		if cfg.MaxReportsPerDay <= 0 {
+			cfg.MaxReportsPerDay = 1000
+		}
+		if cfg.MaxAgeInDays <= 0 {
+			cfg.MaxAgeInDays = 30
+		}
+		if cfg.MaxChecksPerDay <= 0 {
+			cfg.MaxChecksPerDay = 1000
+		}
+		if cfg.BaseURL == "" {
+			cfg.BaseURL = "https://api.abuseipdb.com/api/v2"
+		}
+		t.abuseIPDB = &abuseIPDB{
+			cfg:      cfg,
+			client:   &http.Client{Timeout: 10 * time.Second},
+			reported: make(map[string]time.Time),
+			checked:  make(map[string]time.Time),
+			queue:    make(chan string, abuseIPDBCheckQueue),
+			reports:  dailyLimit{max: cfg.MaxReportsPerDay},
+			checks:   dailyLimit{max: cfg.MaxChecksPerDay},
+		}
+	}
+}
+
+// dailyLimit caps how often something happens per UTC day
+type dailyLimit struct {
+	day  time.Time
+	used int
+	max  int
+}
+
+// take uses up one of today's allowance, reporting false once it's gone
+func (l *dailyLimit) take(now time.Time) bool {
+	if day := now.UTC().Truncate(24 * time.Hour); !day.Equal(l.day) {
+		l.day, l.used = day, 0
+	}
+	if l.used >= l.max {
+		return false
+	}
+	l.used++
+	return true
+}
+
+// abuseIPDB holds the AbuseIPDB client state
+type abuseIPDB struct {
+	cfg    AbuseIPDBConfig
+	client *http.Client
+	queue  chan string // Addresses waiting for a check
+
+	mu       sync.Mutex
+	reported map[string]time.Time // When each address may be reported again
+	checked  map[string]time.Time // When each address should be checked again
+	reports  dailyLimit
+	checks   dailyLimit
+}
+
+// reportable returns the address a ban key can be reported as
+func reportable(key string) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(key)
+	if err != nil || isInternalAddr(addr) {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// enqueueCheck schedules a confidence check of ip unless it was checked recently
+func (a *abuseIPDB) enqueueCheck(ip string, now time.Time) {
+	if a == nil || a.cfg.MinConfidence <= 0 {
+		return
+	}
+	addr, ok := reportable(ip)
+	if !ok {
+		return
+	}
+	ip = addr.String()
+
+	a.mu.Lock()
+	if now.Before(a.checked[ip]) {
+		a.mu.Unlock()
+		return
+	}
+	// Mark it right away so concurrent requests queue it only once
+	a.checked[ip] = now.Add(abuseIPDBCheckTTL)
+	a.mu.Unlock()
+
+	select {
+	case a.queue <- ip:
+	default:
+		// Try again with a later request
+		a.mu.Lock()
+		delete(a.checked, ip)
+		a.mu.Unlock()
+	}
+}
+
+// do sends an AbuseIPDB API request
+func (a *abuseIPDB) do(req *http.Request, out any) error {
+	req.Header.Set("Key", a.cfg.APIKey)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("AbuseIPDB returned %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// confidence looks up the abuse confidence score of ip
+func (a *abuseIPDB) confidence(ip string) (int, error) {
+	query := url.Values{
+		"ipAddress":    {ip},
+		"maxAgeInDays": {strconv.Itoa(a.cfg.MaxAgeInDays)},
+	}
+	req, err := http.NewRequest(http.MethodGet, a.cfg.BaseURL+"/check?"+query.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var result struct {
+		Data struct {
+			AbuseConfidenceScore int `json:"abuseConfidenceScore"`
+		} `json:"data"`
+	}
+	if err := a.do(req, &result); err != nil {
+		return 0, err
+	}
+	return result.Data.AbuseConfidenceScore, nil
+}
+
+// report reports a banned address
+func (a *abuseIPDB) report(ip string, event Event) error {
+	categories := make([]string, len(a.cfg.Categories))
+	for i, category := range a.cfg.Categories {
+		categories[i] = strconv.Itoa(category)
+	}
+
+	comment := fmt.Sprintf("%d 404s probing for: %s", event.Count, strings.Join(event.Paths, ", "))
+	if len(comment) > abuseIPDBMaxComment {
+		comment = comment[:abuseIPDBMaxComment]
+	}
+
+	form := url.Values{
+		"ip":         {ip},
+		"categories": {strings.Join(categories, ",")},
+		"comment":    {comment},
+	}
+	req, err := http.NewRequest(http.MethodPost, a.cfg.BaseURL+"/report", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return a.do(req, nil)
+}
+
+// allowReport reports whether ip may be reported now, using up quota if so
+func (a *abuseIPDB) allowReport(ip string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if now.Before(a.reported[ip]) || !a.reports.take(now) {
+		return false
+	}
+	a.reported[ip] = now.Add(abuseIPDBReportCooldown)
+	return true
+}
+
+// cleanup forgets cooldowns and scores that ran out
+func (a *abuseIPDB) cleanup(now time.Time) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for ip, until := range a.reported {
+		if !now.Before(until) {
+			delete(a.reported, ip)
+		}
+	}
+	for ip, until := range a.checked {
+		if !now.Before(until) {
+			delete(a.checked, ip)
+		}
+	}
+}
+
+// abuseReportLoop reports automatic bans to AbuseIPDB
+func (t *IP404Tracker) abuseReportLoop(events <-chan Event) {
+	a := t.abuseIPDB
+	for event := range events {
+		if event.Type != EventBanned || event.Reason != BanReasonThreshold && event.Reason != BanReasonRepeatOffender {
+			continue
+		}
+		addr, ok := reportable(event.IP)
+		if !ok {
+			// Aggregated prefixes and client keys can't be reported
+			continue
+		}
+		ip := addr.String()
+		if !a.allowReport(ip, t.clock.Now()) {
+			t.logger.Debug("AbuseIPDB report skipped", "ip", ip)
+			continue
+		}
+		if err := a.report(ip, event); err != nil {
+			t.logger.Error("AbuseIPDB report failed", "ip", ip, "error", err)
+			continue
+		}
+		t.logger.Debug("reported to AbuseIPDB", "ip", ip)
+	}
+}
+
+// abuseCheckLoop checks queued addresses and bans high-confidence offenders
+func (t *IP404Tracker) abuseCheckLoop() {
+	a := t.abuseIPDB
+	for {
+		var ip string
+		select {
+		case ip = <-a.queue:
+		case <-t.done:
+			return
+		}
+
+		a.mu.Lock()
+		allowed := a.checks.take(t.clock.Now())
+		a.mu.Unlock()
+		if !allowed {
+			continue
+		}
+
+		score, err := a.confidence(ip)
+		if err != nil {
+			t.logger.Error("AbuseIPDB check failed", "ip", ip, "error", err)
+			continue
+		}
+		if score < a.cfg.MinConfidence || t.IsBanned(ip) {
+			continue
+		}
+
+		now := t.clock.Now()
+		key := t.trackingKey(ip)
+		record := BanRecord{
+			BannedAt:  now,
+			ExpiresAt: now.Add(t.limits.Load().banDuration),
+			Reason:    BanReasonAbuseIPDB,
+			Source:    BanSourceAutomatic,
+			GeoInfo:   t.geoLookup(key),
+		}
+		record = t.ban(key, record)
+		t.counters.bansIssued.Add(1)
+		t.logger.Info("ban issued", "ip", key, "abuse_confidence", score, "expires_at", record.ExpiresAt, "reason", record.Reason)
+		t.emit(Event{Type: EventBanned, IP: key, Reason: record.Reason, ExpiresAt: record.ExpiresAt, GeoInfo: record.GeoInfo})
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// recentActivitySize is how many tracked 404s are kept for the dashboard
+const recentActivitySize = 100
+
+// defaultOffendingRequests is how many of its latest 404s are kept per IP
+const defaultOffendingRequests = 10
+
+// ipPaths holds the latest requests an IP got a 404 for
+type ipPaths struct {
+	requests []OffendingRequest
+	updated  time.Time
+}
+
+// OffendingRequest is one of the latest requests a client got a 404 for
+type OffendingRequest struct {
+	Path      string    `json:"path"`
+	Time      time.Time `json:"time"`
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+// WithOffendingRequests keeps the latest n 404s of each client for ban
+// records, events and the admin API (default 10)
+func WithOffendingRequests(n int) Option {
+	return func(t *IP404Tracker) {
+		if n > 0 {
+			t.offendingLimit = n
+		}
+	}
+}
+
+// Activity404 is a single tracked 404
+type Activity404 struct {
+	IP   string    `json:"ip"`
+	Path string    `json:"path"`
+	Time time.Time `json:"time"`
+}
+
+// Offender summarizes the activity of one IP
+type Offender struct {
+	IP              string `json:"ip"`
+	Recent404s      int    `json:"recent_404s"`      // 404s within the current window
+	BlockedRequests int    `json:"blocked_requests"` // Requests refused while banned
+	Banned          bool   `json:"banned"`
+
+	// Where the offender is, when GeoIP is enabled
+	GeoInfo
+}
+
+// recordActivity appends a 404 to the recent activity ring buffer
+func (t *IP404Tracker) recordActivity(ip, path string, at time.Time) {
+	t.recentMu.Lock()
+	defer t.recentMu.Unlock()
+
+	if t.anonymizer != nil {
+		ip = t.trackingKey(ip)
+	}
+	entry := Activity404{IP: ip, Path: path, Time: at}
+	if len(t.recent) < recentActivitySize {
+		t.recent = append(t.recent, entry)
+		return
+	}
+	t.recent[t.recentNext] = entry
+	t.recentNext = (t.recentNext + 1) % recentActivitySize
+}
+
+// recordPath remembers req as one of the latest 404s of the tracking key ip
+func (t *IP404Tracker) recordPath(ip string, req clientRequest, at time.Time) {
+	if req.path == "" {
+		return
+	}
+
+	t.paths.update(ip, func(entry *ipPaths, ok bool) *ipPaths {
+		if !ok {
+			entry = &ipPaths{}
+		}
+		entry.requests = append(entry.requests, OffendingRequest{Path: req.path, Time: at, UserAgent: req.userAgent})
+		if len(entry.requests) > t.offendingLimit {
+			entry.requests = entry.requests[len(entry.requests)-t.offendingLimit:]
+		}
+		entry.updated = at
+		return entry
+	})
+}
+
+// offendingRequests returns the latest 404s of ip, oldest first
+func (t *IP404Tracker) offendingRequests(ip string) []OffendingRequest {
+	var requests []OffendingRequest
+	t.paths.read(ip, func(entry *ipPaths) {
+		requests = append(requests, entry.requests...)
+	})
+	return requests
+}
+
+// OffendingRequests returns the latest requests an IP or client key got a
+// 404 for, oldest first, banned or not
+func (t *IP404Tracker) OffendingRequests(ip string) []OffendingRequest {
+	return t.offendingRequests(t.trackingKey(ip))
+}
+
+// requestPaths returns the paths of requests
+func requestPaths(requests []OffendingRequest) []string {
+	if len(requests) == 0 {
+		return nil
+	}
+	paths := make([]string, len(requests))
+	for i, req := range requests {
+		paths[i] = req.Path
+	}
+	return paths
+}
+
+// cleanupPaths forgets the paths of IPs that are neither counting toward a ban nor banned
+func (t *IP404Tracker) cleanupPaths(now time.Time) {
+	cutoff := now.Add(-t.limits.Load().window)
+
+	var stale []string
+	t.paths.each(func(ip string, entry *ipPaths) {
+		if entry.updated.Before(cutoff) {
+			stale = append(stale, ip)
+		}
+	})
+
+	for _, ip := range stale {
+		if t.isBannedIP(ip) {
+			continue
+		}
+		t.paths.deleteIf(ip, func(entry *ipPaths) bool { return entry.updated.Before(cutoff) })
+	}
+}
+
+// RecentActivity returns the most recent tracked 404s, newest first
+func (t *IP404Tracker) RecentActivity() []Activity404 {
+	t.recentMu.Lock()
+	defer t.recentMu.Unlock()
+
+	result := make([]Activity404, 0, len(t.recent))
+	for i := len(t.recent) - 1; i >= 0; i-- {
+		result = append(result, t.recent[(t.recentNext+i)%len(t.recent)])
+	}
+
+	return result
+}
+
+// TopOffenders returns up to n IPs, or all of them when n is 0, ordered by
+// recent 404s and then blocked requests
+func (t *IP404Tracker) TopOffenders(n int) []Offender {
+	now := t.clock.Now()
+
+	counts, err := t.store.ListCounts(now, t.limits.Load().window)
+	if err != nil {
+		t.logger.Error("listing counts failed", "error", err)
+	}
+	bans := t.GetBannedIPs()
+
+	offenders := make(map[string]*Offender)
+	offender := func(ip string) *Offender {
+		if o, ok := offenders[ip]; ok {
+			return o
+		}
+		o := &Offender{IP: ip}
+		offenders[ip] = o
+		return o
+	}
+	for ip, timestamps := range counts {
+		offender(ip).Recent404s = len(timestamps)
+	}
+	for ip := range bans {
+		offender(ip).Banned = true
+	}
+	t.bannedRequest.each(func(ip string, c blockedCount) {
+		offender(ip).BlockedRequests = c.requests
+	})
+
+	result := make([]Offender, 0, len(offenders))
+	for _, o := range offenders {
+		result = append(result, *o)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Recent404s != result[j].Recent404s {
+			return result[i].Recent404s > result[j].Recent404s
+		}
+		if result[i].BlockedRequests != result[j].BlockedRequests {
+			return result[i].BlockedRequests > result[j].BlockedRequests
+		}
+		return result[i].IP < result[j].IP
+	})
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	for i := range result {
+		result[i].GeoInfo = t.geoLookup(result[i].IP)
+	}
+
+	return result
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// banInfo is the admin API representation of an active ban
+type banInfo struct {
+	Target string `json:"target"` // IP or CIDR
+	BanRecord
+}
+
+// banRequest is the body of a manual ban request
+type banRequest struct {
+	Target   string `json:"target" binding:"required"` // IP or CIDR
+	Duration string `json:"duration"`                  // Go duration, defaults to the tracker's ban duration
+}
+
+// whitelistRequest is the body of a whitelist addition
+type whitelistRequest struct {
+	IP       string `json:"ip" binding:"required"` // IP, CIDR or hostname
+	Duration string `json:"duration"`              // Go duration for a temporary entry, permanent when empty
+}
+
+// blacklistRequest is the body of a blacklist addition
+type blacklistRequest struct {
+	Target string `json:"target" binding:"required"` // IP or CIDR
+}
+
+// signatureRequest is the body of a scanner signature addition
+type signatureRequest struct {
+	Pattern string `json:"pattern" binding:"required"` // path.Match pattern
+}
+
+// RegisterAdminRoutes adds endpoints for managing bans and the whitelist at runtime:
+//
+//	GET    /bans                    list active bans with expiry and reason, ?target=... for one
+//	GET    /bans/export             banned IPs and CIDRs as ?format=plain, nginx, apache or csv
+//	POST   /bans                    ban an IP or CIDR: {"target": "10.0.0.0/8", "duration": "48h"}
+//	DELETE /bans?target=...         lift a ban on an IP or CIDR, add &reset=true to clear its 404 history
+//	GET    /whitelist               list whitelist entries and when temporary ones expire
+//	POST   /whitelist               whitelist an IP or CIDR: {"ip": "10.0.0.0/8", "duration": "8h"}
+//	DELETE /whitelist?ip=...        remove an IP or CIDR from the whitelist
+//	GET    /blacklist               list permanently banned IPs and CIDRs
+//	POST   /blacklist               permanently ban an IP or CIDR: {"target": "203.0.113.0/24"}
+//	DELETE /blacklist?target=...    remove an IP or CIDR from the blacklist
+//	GET    /requests?target=...     latest 404s of an IP or client key, with times and user agents
+//	GET    /paths                   top 404 paths, ?window=1h&limit=20
+//	GET    /signatures              list scanner signatures
+//	POST   /signatures              add a scanner signature: {"pattern": "/solr/*"}
+//	DELETE /signatures?pattern=...  remove a scanner signature
+//	GET    /activity                recent 404s and top offenders
+//	GET    /stats                   tracked IPs, active bans and totals
+//	DELETE /banned-requests         reset the banned request counters
+//	GET    /audit                   audit log, ?actor=...&action=ban&target=...&since=...&until=...&limit=100
+//	GET    /events                  live event stream (Server-Sent Events)
+//	GET    /dashboard               HTML dashboard
+//
+// Read endpoints need RoleViewer and the others RoleOperator when the tracker
+// is configured WithAdminAuth.
+func (t *IP404Tracker) RegisterAdminRoutes(r *gin.RouterGroup) {
+	r.Use(t.adminAllowlistMiddleware())
+	r.GET("/dashboard", t.adminDashboard)
+
+	r.Use(t.adminAuthMiddleware())
+	viewer := requireRole(RoleViewer)
+	operator := requireRole(RoleOperator)
+
+	r.GET("/activity", viewer, t.adminActivity)
+	r.GET("/stats", viewer, t.adminStats)
+	r.DELETE("/banned-requests", operator, t.adminResetBannedRequests)
+	r.GET("/audit", viewer, t.adminAudit)
+	r.GET("/events", viewer, t.adminEvents)
+
+	r.GET("/bans", viewer, t.adminListBans)
+	r.GET("/bans/export", viewer, t.adminExportBans)
+	r.POST("/bans", operator, t.adminBan)
+	r.DELETE("/bans", operator, t.adminUnban)
+
+	r.GET("/whitelist", viewer, t.adminListWhitelist)
+	r.POST("/whitelist", operator, t.adminAddWhitelist)
+	r.DELETE("/whitelist", operator, t.adminRemoveWhitelist)
+
+	r.GET("/blacklist", viewer, t.adminListBlacklist)
+	r.POST("/blacklist", operator, t.adminAddBlacklist)
+	r.DELETE("/blacklist", operator, t.adminRemoveBlacklist)
+
+	r.GET("/requests", viewer, t.adminOffendingRequests)
+	r.GET("/paths", viewer, t.adminTopPaths)
+
+	r.GET("/signatures", viewer, t.adminListSignatures)
+	r.POST("/signatures", operator, t.adminAddSignature)
+	r.DELETE("/signatures", operator, t.adminRemoveSignature)
+}
+
+// parseBanTarget parses an IP or CIDR, returning whether it is a range
+func parseBanTarget(target string) (addr netip.Addr, prefix netip.Prefix, isRange bool, err error) {
+	if strings.Contains(target, "/") {
+		prefix, err = netip.ParsePrefix(target)
+		return addr, prefix.Masked(), true, err
+	}
+	addr, err = netip.ParseAddr(target)
+	return addr.Unmap(), prefix, false, err
+}
+
+// Errors of the ban operations shared by the HTTP and gRPC admin APIs
+var (
+	errInvalidTarget = errors.New("invalid target")
+	errWhitelisted   = errors.New("is whitelisted")
+	errNotBanned     = errors.New("is not banned")
+)
+
+// adminBanError describes what went wrong with target
+func adminBanError(target string, err error) error {
+	if errors.Is(err, errInvalidTarget) {
+		return fmt.Errorf("%w: %s", err, target)
+	}
+	return fmt.Errorf("%s %w", target, err)
+}
+
+// listBans returns the active bans of IPs, client keys and CIDRs, sorted
+func (t *IP404Tracker) listBans() []banInfo {
+	bans := []banInfo{}
+	for ip, record := range t.GetBans() {
+		bans = append(bans, banInfo{Target: ip, BanRecord: record})
+	}
+	for prefix := range t.GetBannedCIDRs() {
+		if record, ok := t.GetCIDRBanInfo(prefix); ok {
+			bans = append(bans, banInfo{Target: prefix.String(), BanRecord: record})
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Target < bans[j].Target })
+	return bans
+}
+
+// banTarget bans an IP, CIDR or client key, returning the ban and its audit
+// state before
+func (t *IP404Tracker) banTarget(target string, duration time.Duration) (banInfo, any, error) {
+	addr, prefix, isRange, err := parseBanTarget(target)
+	if err != nil && t.tracksKeys() && target != "" {
+		// Not an address, so a client key
+		before := t.banState(target)
+		t.Ban(target, duration)
+		record, _ := t.GetBanInfo(target)
+		return banInfo{Target: target, BanRecord: record}, before, nil
+	}
+	if err != nil {
+		return banInfo{}, nil, adminBanError(target, errInvalidTarget)
+	}
+
+	if isRange {
+		before := t.cidrBanState(prefix)
+		t.BanCIDR(prefix, duration)
+		record, _ := t.GetCIDRBanInfo(prefix)
+		return banInfo{Target: prefix.String(), BanRecord: record}, before, nil
+	}
+
+	if t.IsWhitelisted(addr.String()) {
+		return banInfo{}, nil, adminBanError(addr.String(), errWhitelisted)
+	}
+	key := t.trackingKey(addr.String())
+	before := t.banState(key)
+	t.Ban(addr.String(), duration)
+	record, _ := t.GetBanInfo(addr.String())
+	return banInfo{Target: key, BanRecord: record}, before, nil
+}
+
+// getBan explains a single ban
+func (t *IP404Tracker) getBan(target string) (banInfo, error) {
+	addr, prefix, isRange, err := parseBanTarget(target)
+	if err != nil && !t.tracksKeys() {
+		return banInfo{}, adminBanError(target, errInvalidTarget)
+	}
+
+	var (
+		record BanRecord
+		ok     bool
+	)
+	if err != nil {
+		record, ok = t.GetBanInfo(target)
+	} else if isRange {
+		record, ok = t.GetCIDRBanInfo(prefix)
+		if !ok {
+			record, ok = t.GetBanInfo(prefix.String())
+		}
+		target = prefix.String()
+	} else {
+		record, ok = t.GetBanInfo(addr.String())
+		target = t.trackingKey(addr.String())
+	}
+	if !ok {
+		return banInfo{}, adminBanError(target, errNotBanned)
+	}
+	return banInfo{Target: target, BanRecord: record}, nil
+}
+
+// unbanTarget lifts the ban on an IP, CIDR or client key, clearing its 404
+// history too with reset. It returns the target as audited and its state
+// before.
+func (t *IP404Tracker) unbanTarget(target string, reset bool) (string, any, error) {
+	addr, prefix, isRange, err := parseBanTarget(target)
+	if err != nil && (!t.tracksKeys() || target == "") {
+		return "", nil, adminBanError(target, errInvalidTarget)
+	}
+
+	var before any
+	if err != nil {
+		before = t.banState(target)
+		t.Unban(target)
+		if reset {
+			t.ResetCounts(target)
+		}
+	} else if isRange {
+		target = prefix.String()
+		before = t.cidrBanState(prefix)
+		t.UnbanCIDR(prefix)
+		// Aggregated prefixes are banned like single IPs
+		if _, ok := t.GetBanInfo(prefix.String()); ok {
+			t.Unban(prefix.String())
+		}
+		if reset {
+			t.clearCountersIn(prefix)
+		}
+	} else {
+		target = t.trackingKey(addr.String())
+		before = t.banState(target)
+		t.Unban(addr.String())
+		if reset {
+			t.ResetCounts(addr.String())
+		}
+	}
+	return target, before, nil
+}
+
+// adminBanStatus is the HTTP status for an error of the ban operations
+func adminBanStatus(err error) int {
+	switch {
+	case errors.Is(err, errWhitelisted):
+		return http.StatusConflict
+	case errors.Is(err, errNotBanned):
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+func (t *IP404Tracker) adminListBans(c *gin.Context) {
+	if target := c.Query("target"); target != "" {
+		t.adminGetBan(c, target)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"bans": t.listBans()})
+}
+
+func (t *IP404Tracker) adminBan(c *gin.Context) {
+	var req banRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	duration := t.limits.Load().banDuration
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid duration: " + req.Duration})
+			return
+		}
+		duration = d
+	}
+
+	ban, before, err := t.banTarget(req.Target, duration)
+	if err != nil {
+		c.JSON(adminBanStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	t.auditAdmin(c, AuditBan, ban.Target, before, &ban.BanRecord)
+	c.JSON(http.StatusOK, ban)
+}
+
+// adminGetBan explains a single ban
+func (t *IP404Tracker) adminGetBan(c *gin.Context, target string) {
+	ban, err := t.getBan(target)
+	if err != nil {
+		c.JSON(adminBanStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ban)
+}
+
+func (t *IP404Tracker) adminUnban(c *gin.Context) {
+	target, before, err := t.unbanTarget(c.Query("target"), c.Query("reset") == "true")
+	if err != nil {
+		c.JSON(adminBanStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	t.auditAdmin(c, AuditUnban, target, before, nil)
+	c.Status(http.StatusNoContent)
+}
+
+func (t *IP404Tracker) adminListWhitelist(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"whitelist": t.GetWhitelist(),
+		"temporary": t.GetTemporaryWhitelist(),
+	})
+}
+
+func (t *IP404Tracker) adminAddWhitelist(c *gin.Context) {
+	var req whitelistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before := t.whitelistEntryState(req.IP)
+	var err error
+	if req.Duration != "" {
+		d, parseErr := time.ParseDuration(req.Duration)
+		if parseErr != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid duration: " + req.Duration})
+			return
+		}
+		err = t.AddToWhitelistFor(req.IP, d)
+	} else {
+		err = t.AddToWhitelist(req.IP)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	t.auditAdmin(c, AuditWhitelistAdd, req.IP, before, t.whitelistEntryState(req.IP))
+	c.Status(http.StatusNoContent)
+}
+
+func (t *IP404Tracker) adminRemoveWhitelist(c *gin.Context) {
+	entry := c.Query("ip")
+	before := t.whitelistEntryState(entry)
+	if err := t.RemoveFromWhitelist(entry); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	t.auditAdmin(c, AuditWhitelistRemove, entry, before, t.whitelistEntryState(entry))
+	c.Status(http.StatusNoContent)
+}
+
+func (t *IP404Tracker) adminListBlacklist(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"blacklist": t.GetBlacklist()})
+}
+
+func (t *IP404Tracker) adminAddBlacklist(c *gin.Context) {
+	var req blacklistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before := t.blacklistState(req.Target)
+	if err := t.AddToBlacklist(req.Target); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	t.auditAdmin(c, AuditBlacklistAdd, req.Target, before, true)
+	c.Status(http.StatusNoContent)
+}
+
+func (t *IP404Tracker) adminRemoveBlacklist(c *gin.Context) {
+	target := c.Query("target")
+	before := t.blacklistState(target)
+	if err := t.RemoveFromBlacklist(target); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	t.auditAdmin(c, AuditBlacklistRemove, target, before, false)
+	c.Status(http.StatusNoContent)
+}
+
+func (t *IP404Tracker) adminAudit(c *gin.Context) {
+	filter := AuditFilter{
+		Actor:  c.Query("actor"),
+		Action: AuditAction(c.Query("action")),
+		Target: c.Query("target"),
+	}
+	for _, bound := range []struct {
+		name  string
+		field *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		if value := c.Query(bound.name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + bound.name + ": " + value})
+				return
+			}
+			*bound.field = parsed
+		}
+	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit: " + limit})
+			return
+		}
+		filter.Limit = n
+	}
+
+	if t.auditLog == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "audit log not enabled"})
+		return
+	}
+	entries, err := t.AuditEntries(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+func (t *IP404Tracker) adminListSignatures(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"signatures": t.GetScannerSignatures()})
+}
+
+func (t *IP404Tracker) adminAddSignature(c *gin.Context) {
+	var req signatureRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := t.AddScannerSignature(req.Pattern); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (t *IP404Tracker) adminRemoveSignature(c *gin.Context) {
+	if !t.RemoveScannerSignature(c.Query("pattern")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no such signature"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (t *IP404Tracker) adminResetBannedRequests(c *gin.Context) {
+	t.ResetBannedRequestCounters()
+	c.Status(http.StatusNoContent)
+}
+
+func (t *IP404Tracker) adminOffendingRequests(c *gin.Context) {
+	target := c.Query("target")
+	if target == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "target is required"})
+		return
+	}
+	requests := t.OffendingRequests(target)
+	if requests == nil {
+		requests = []OffendingRequest{}
+	}
+	c.JSON(http.StatusOK, gin.H{"target": t.trackingKey(target), "requests": requests})
+}
+
+func (t *IP404Tracker) adminTopPaths(c *gin.Context) {
+	if t.pathStats == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "path stats are disabled"})
+		return
+	}
+	window := time.Hour
+	if w := c.Query("window"); w != "" {
+		d, err := time.ParseDuration(w)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid window: " + w})
+			return
+		}
+		window = d
+	}
+	limit := 20
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit: " + l})
+			return
+		}
+		limit = n
+	}
+	window = min(window, t.pathStats.cfg.Retention)
+	c.JSON(http.StatusOK, gin.H{"window": window.String(), "paths": t.TopPaths(window, limit)})
+}
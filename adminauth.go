@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminRole is the level of access granted to an admin API caller
+type AdminRole int
+
+const (
+	// RoleViewer can read bans and the whitelist
+	RoleViewer AdminRole = iota + 1
+	// RoleOperator can also ban, unban and edit the whitelist
+	RoleOperator
+)
+
+// Gin context keys holding the caller's role and who they are
+const (
+	adminRoleKey  = "404blocker.admin_role"
+	adminActorKey = "404blocker.admin_actor"
+)
+
+// AdminAuth protects the admin API. A request is authorized by an API key in
+// the X-API-Key header or by a bearer token in the Authorization header, which
+// may be an API key or an HS256 JWT carrying a "role" claim of "viewer" or "operator".
+// Client certificates verified by a mutual TLS admin listener, see
+// AdminTLSConfig, may grant a role by their subject's common name too.
+// The audit log names JWT callers by their "sub" claim, API key callers by
+// a hash of the key and certificate callers by "cert:" and the common name.
+type AdminAuth struct {
+	// API keys and the role each one grants
+	APIKeys map[string]AdminRole
+
+	// Common names of verified client certificates and the role each one grants
+	ClientCertificates map[string]AdminRole
+
+	// Secret used to verify HS256 JWT bearer tokens (JWT auth is disabled when empty)
+	JWTSecret []byte
+
+	// When set, only clients inside these networks may reach the admin routes
+	AllowedNetworks []netip.Prefix
+}
+
+// WithAdminAuth requires authentication on the routes added by RegisterAdminRoutes
+func WithAdminAuth(auth AdminAuth) Option {
+	return func(t *IP404Tracker) {
+		t.adminAuth = &auth
+	}
+}
+
+// parseAdminRole converts a JWT role claim to an AdminRole
+func parseAdminRole(name string) (AdminRole, bool) {
+	switch name {
+	case "viewer":
+		return RoleViewer, true
+	case "operator":
+		return RoleOperator, true
+	}
+	return 0, false
+}
+
+// allowsIP reports whether ip may reach the admin routes
+func (a *AdminAuth) allowsIP(ip string) bool {
+	if len(a.AllowedNetworks) == 0 {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, network := range a.AllowedNetworks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeyRole returns the role granted by key. Every key is compared in
+// constant time so lookups don't leak timing information.
+func (a *AdminAuth) apiKeyRole(key string) (AdminRole, bool) {
+	var role AdminRole
+	for candidate, candidateRole := range a.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) == 1 {
+			role = candidateRole
+		}
+	}
+	return role, role != 0
+}
+
+// apiKeyActor names the caller using key without giving the key away
+func apiKeyActor(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:6])
+}
+
+// jwtRole verifies an HS256 token at the given time and returns the role it
+// grants and its subject
+func (a *AdminAuth) jwtRole(token string, now time.Time) (AdminRole, string, error) {
+	if len(a.JWTSecret) == 0 {
+		return 0, "", errors.New("jwt auth disabled")
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, "", errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return 0, "", errors.New("unsupported token algorithm")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return 0, "", errors.New("malformed signature")
+	}
+	mac := hmac.New(sha256.New, a.JWTSecret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return 0, "", errors.New("invalid signature")
+	}
+
+	var claims struct {
+		Role      string `json:"role"`
+		Subject   string `json:"sub"`
+		ExpiresAt int64  `json:"exp"`
+		NotBefore int64  `json:"nbf"`
+	}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return 0, "", errors.New("malformed claims")
+	}
+	if claims.ExpiresAt != 0 && now.Unix() >= claims.ExpiresAt {
+		return 0, "", errors.New("token expired")
+	}
+	if claims.NotBefore != 0 && now.Unix() < claims.NotBefore {
+		return 0, "", errors.New("token not yet valid")
+	}
+
+	role, ok := parseAdminRole(claims.Role)
+	if !ok {
+		return 0, "", errors.New("unknown role")
+	}
+	if claims.Subject == "" {
+		claims.Subject = "jwt"
+	}
+	return role, claims.Subject, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// authenticate returns the role granted to the request's credentials at the
+// given time and who they belong to
+func (a *AdminAuth) authenticate(r *http.Request, now time.Time) (AdminRole, string, error) {
+	var chains [][]*x509.Certificate
+	if r.TLS != nil {
+		chains = r.TLS.VerifiedChains
+	}
+	return a.authenticateCredentials(chains, r.Header.Get("X-API-Key"), r.Header.Get("Authorization"), now)
+}
+
+// authenticateCredentials is authenticate for the verified client
+// certificate chains of the connection and the values of the X-API-Key and
+// Authorization headers or metadata
+func (a *AdminAuth) authenticateCredentials(chains [][]*x509.Certificate, key, authorization string, now time.Time) (AdminRole, string, error) {
+	if len(chains) > 0 && len(chains[0]) > 0 {
+		name := chains[0][0].Subject.CommonName
+		if role, ok := a.ClientCertificates[name]; ok {
+			return role, "cert:" + name, nil
+		}
+	}
+	if key != "" {
+		if role, ok := a.apiKeyRole(key); ok {
+			return role, apiKeyActor(key), nil
+		}
+		return 0, "", errors.New("invalid api key")
+	}
+
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || token == "" {
+		return 0, "", errors.New("missing credentials")
+	}
+	if role, ok := a.apiKeyRole(token); ok {
+		return role, apiKeyActor(token), nil
+	}
+	return a.jwtRole(token, now)
+}
+
+// adminAllowlistMiddleware rejects clients outside the admin allowlist
+func (t *IP404Tracker) adminAllowlistMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if t.adminAuth != nil && !unixSocket(c.Request) && !t.adminAuth.allowsIP(t.ginClientIP(c)) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// adminAuthMiddleware authenticates the caller
+func (t *IP404Tracker) adminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if t.adminAuth == nil {
+			// Unprotected admin API: everybody is an operator
+			c.Set(adminRoleKey, RoleOperator)
+			c.Set(adminActorKey, AuditActorAnonymous)
+			c.Next()
+			return
+		}
+
+		role, actor, err := t.adminAuth.authenticate(c.Request, t.clock.Now())
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer realm="404blocker"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Set(adminRoleKey, role)
+		c.Set(adminActorKey, actor)
+		c.Next()
+	}
+}
+
+// requireRole rejects callers without at least the given role
+func requireRole(min AdminRole) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if role, _ := c.Get(adminRoleKey); role.(AdminRole) < min {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient role"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,1085 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        (unknown)
+// source: adminpb/admin.proto
+
+// The 404blocker admin API over gRPC, see RegisterAdminService. Callers
+// authenticate like on the HTTP admin API, with an "x-api-key" or
+// "authorization: Bearer ..." metadata entry.
+
+package adminpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ListBansRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListBansRequest) Reset() {
+	*x = ListBansRequest{}
+	mi := &file_adminpb_admin_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListBansRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListBansRequest) ProtoMessage() {}
+
+func (x *ListBansRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListBansRequest.ProtoReflect.Descriptor instead.
+func (*ListBansRequest) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{0}
+}
+
+type ListBansResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Bans          []*Ban                 `protobuf:"bytes,1,rep,name=bans,proto3" json:"bans,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListBansResponse) Reset() {
+	*x = ListBansResponse{}
+	mi := &file_adminpb_admin_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListBansResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListBansResponse) ProtoMessage() {}
+
+func (x *ListBansResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListBansResponse.ProtoReflect.Descriptor instead.
+func (*ListBansResponse) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ListBansResponse) GetBans() []*Ban {
+	if x != nil {
+		return x.Bans
+	}
+	return nil
+}
+
+type GetBanRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Target        string                 `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"` // IP, CIDR or client key
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetBanRequest) Reset() {
+	*x = GetBanRequest{}
+	mi := &file_adminpb_admin_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetBanRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetBanRequest) ProtoMessage() {}
+
+func (x *GetBanRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetBanRequest.ProtoReflect.Descriptor instead.
+func (*GetBanRequest) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetBanRequest) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+type BanRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Target        string                 `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`     // IP, CIDR or client key
+	Duration      *durationpb.Duration   `protobuf:"bytes,2,opt,name=duration,proto3" json:"duration,omitempty"` // Default: the tracker's ban duration
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BanRequest) Reset() {
+	*x = BanRequest{}
+	mi := &file_adminpb_admin_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BanRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BanRequest) ProtoMessage() {}
+
+func (x *BanRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BanRequest.ProtoReflect.Descriptor instead.
+func (*BanRequest) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *BanRequest) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *BanRequest) GetDuration() *durationpb.Duration {
+	if x != nil {
+		return x.Duration
+	}
+	return nil
+}
+
+type UnbanRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Target        string                 `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	ResetCounts   bool                   `protobuf:"varint,2,opt,name=reset_counts,json=resetCounts,proto3" json:"reset_counts,omitempty"` // Also clear the target's 404 history
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UnbanRequest) Reset() {
+	*x = UnbanRequest{}
+	mi := &file_adminpb_admin_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UnbanRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UnbanRequest) ProtoMessage() {}
+
+func (x *UnbanRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UnbanRequest.ProtoReflect.Descriptor instead.
+func (*UnbanRequest) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *UnbanRequest) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *UnbanRequest) GetResetCounts() bool {
+	if x != nil {
+		return x.ResetCounts
+	}
+	return false
+}
+
+type Ban struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Target        string                 `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	BannedAt      *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=banned_at,json=bannedAt,proto3" json:"banned_at,omitempty"`
+	ExpiresAt     *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	Reason        string                 `protobuf:"bytes,4,opt,name=reason,proto3" json:"reason,omitempty"`
+	Count         int64                  `protobuf:"varint,5,opt,name=count,proto3" json:"count,omitempty"` // 404s in the window when banned
+	Rule          string                 `protobuf:"bytes,6,opt,name=rule,proto3" json:"rule,omitempty"`    // Status rule that issued it; empty for 404s
+	Requests      []*OffendingRequest    `protobuf:"bytes,7,rep,name=requests,proto3" json:"requests,omitempty"`
+	Offense       int64                  `protobuf:"varint,8,opt,name=offense,proto3" json:"offense,omitempty"` // Automatic bans of the target so far
+	Source        string                 `protobuf:"bytes,9,opt,name=source,proto3" json:"source,omitempty"`    // "automatic" or "manual"
+	Geo           *Geo                   `protobuf:"bytes,10,opt,name=geo,proto3" json:"geo,omitempty"`
+	Paths         []string               `protobuf:"bytes,11,rep,name=paths,proto3" json:"paths,omitempty"` // Latest offending paths
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Ban) Reset() {
+	*x = Ban{}
+	mi := &file_adminpb_admin_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Ban) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ban) ProtoMessage() {}
+
+func (x *Ban) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Ban.ProtoReflect.Descriptor instead.
+func (*Ban) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Ban) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *Ban) GetBannedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.BannedAt
+	}
+	return nil
+}
+
+func (x *Ban) GetExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return nil
+}
+
+func (x *Ban) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *Ban) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *Ban) GetRule() string {
+	if x != nil {
+		return x.Rule
+	}
+	return ""
+}
+
+func (x *Ban) GetRequests() []*OffendingRequest {
+	if x != nil {
+		return x.Requests
+	}
+	return nil
+}
+
+func (x *Ban) GetOffense() int64 {
+	if x != nil {
+		return x.Offense
+	}
+	return 0
+}
+
+func (x *Ban) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *Ban) GetGeo() *Geo {
+	if x != nil {
+		return x.Geo
+	}
+	return nil
+}
+
+func (x *Ban) GetPaths() []string {
+	if x != nil {
+		return x.Paths
+	}
+	return nil
+}
+
+type OffendingRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=time,proto3" json:"time,omitempty"`
+	UserAgent     string                 `protobuf:"bytes,3,opt,name=user_agent,json=userAgent,proto3" json:"user_agent,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OffendingRequest) Reset() {
+	*x = OffendingRequest{}
+	mi := &file_adminpb_admin_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OffendingRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OffendingRequest) ProtoMessage() {}
+
+func (x *OffendingRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OffendingRequest.ProtoReflect.Descriptor instead.
+func (*OffendingRequest) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *OffendingRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *OffendingRequest) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *OffendingRequest) GetUserAgent() string {
+	if x != nil {
+		return x.UserAgent
+	}
+	return ""
+}
+
+type Geo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Country       string                 `protobuf:"bytes,1,opt,name=country,proto3" json:"country,omitempty"` // ISO 3166-1 alpha-2 code
+	Asn           uint32                 `protobuf:"varint,2,opt,name=asn,proto3" json:"asn,omitempty"`
+	AsOrg         string                 `protobuf:"bytes,3,opt,name=as_org,json=asOrg,proto3" json:"as_org,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Geo) Reset() {
+	*x = Geo{}
+	mi := &file_adminpb_admin_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Geo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Geo) ProtoMessage() {}
+
+func (x *Geo) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Geo.ProtoReflect.Descriptor instead.
+func (*Geo) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Geo) GetCountry() string {
+	if x != nil {
+		return x.Country
+	}
+	return ""
+}
+
+func (x *Geo) GetAsn() uint32 {
+	if x != nil {
+		return x.Asn
+	}
+	return 0
+}
+
+func (x *Geo) GetAsOrg() string {
+	if x != nil {
+		return x.AsOrg
+	}
+	return ""
+}
+
+type ListWhitelistResponse struct {
+	state         protoimpl.MessageState            `protogen:"open.v1"`
+	Entries       []string                          `protobuf:"bytes,1,rep,name=entries,proto3" json:"entries,omitempty"`
+	Temporary     map[string]*timestamppb.Timestamp `protobuf:"bytes,2,rep,name=temporary,proto3" json:"temporary,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // Expiry of temporary entries
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListWhitelistResponse) Reset() {
+	*x = ListWhitelistResponse{}
+	mi := &file_adminpb_admin_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListWhitelistResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListWhitelistResponse) ProtoMessage() {}
+
+func (x *ListWhitelistResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListWhitelistResponse.ProtoReflect.Descriptor instead.
+func (*ListWhitelistResponse) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ListWhitelistResponse) GetEntries() []string {
+	if x != nil {
+		return x.Entries
+	}
+	return nil
+}
+
+func (x *ListWhitelistResponse) GetTemporary() map[string]*timestamppb.Timestamp {
+	if x != nil {
+		return x.Temporary
+	}
+	return nil
+}
+
+type AddWhitelistRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Entry         string                 `protobuf:"bytes,1,opt,name=entry,proto3" json:"entry,omitempty"`       // IP, CIDR or hostname
+	Duration      *durationpb.Duration   `protobuf:"bytes,2,opt,name=duration,proto3" json:"duration,omitempty"` // Temporary entry when set
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddWhitelistRequest) Reset() {
+	*x = AddWhitelistRequest{}
+	mi := &file_adminpb_admin_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddWhitelistRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddWhitelistRequest) ProtoMessage() {}
+
+func (x *AddWhitelistRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddWhitelistRequest.ProtoReflect.Descriptor instead.
+func (*AddWhitelistRequest) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *AddWhitelistRequest) GetEntry() string {
+	if x != nil {
+		return x.Entry
+	}
+	return ""
+}
+
+func (x *AddWhitelistRequest) GetDuration() *durationpb.Duration {
+	if x != nil {
+		return x.Duration
+	}
+	return nil
+}
+
+type RemoveWhitelistRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Entry         string                 `protobuf:"bytes,1,opt,name=entry,proto3" json:"entry,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RemoveWhitelistRequest) Reset() {
+	*x = RemoveWhitelistRequest{}
+	mi := &file_adminpb_admin_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RemoveWhitelistRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemoveWhitelistRequest) ProtoMessage() {}
+
+func (x *RemoveWhitelistRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemoveWhitelistRequest.ProtoReflect.Descriptor instead.
+func (*RemoveWhitelistRequest) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *RemoveWhitelistRequest) GetEntry() string {
+	if x != nil {
+		return x.Entry
+	}
+	return ""
+}
+
+type Stats struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	TrackedIps      int64                  `protobuf:"varint,1,opt,name=tracked_ips,json=trackedIps,proto3" json:"tracked_ips,omitempty"`
+	ActiveBans      int64                  `protobuf:"varint,2,opt,name=active_bans,json=activeBans,proto3" json:"active_bans,omitempty"`
+	TotalNotFound   uint64                 `protobuf:"varint,3,opt,name=total_not_found,json=totalNotFound,proto3" json:"total_not_found,omitempty"` // 404 responses tracked since startup
+	BlockedRequests uint64                 `protobuf:"varint,4,opt,name=blocked_requests,json=blockedRequests,proto3" json:"blocked_requests,omitempty"`
+	MemoryBytes     int64                  `protobuf:"varint,5,opt,name=memory_bytes,json=memoryBytes,proto3" json:"memory_bytes,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Stats) Reset() {
+	*x = Stats{}
+	mi := &file_adminpb_admin_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Stats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Stats) ProtoMessage() {}
+
+func (x *Stats) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Stats.ProtoReflect.Descriptor instead.
+func (*Stats) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *Stats) GetTrackedIps() int64 {
+	if x != nil {
+		return x.TrackedIps
+	}
+	return 0
+}
+
+func (x *Stats) GetActiveBans() int64 {
+	if x != nil {
+		return x.ActiveBans
+	}
+	return 0
+}
+
+func (x *Stats) GetTotalNotFound() uint64 {
+	if x != nil {
+		return x.TotalNotFound
+	}
+	return 0
+}
+
+func (x *Stats) GetBlockedRequests() uint64 {
+	if x != nil {
+		return x.BlockedRequests
+	}
+	return 0
+}
+
+func (x *Stats) GetMemoryBytes() int64 {
+	if x != nil {
+		return x.MemoryBytes
+	}
+	return 0
+}
+
+type StreamEventsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Types         []string               `protobuf:"bytes,1,rep,name=types,proto3" json:"types,omitempty"` // Event types, e.g. "banned"; empty for all
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamEventsRequest) Reset() {
+	*x = StreamEventsRequest{}
+	mi := &file_adminpb_admin_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamEventsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamEventsRequest) ProtoMessage() {}
+
+func (x *StreamEventsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamEventsRequest.ProtoReflect.Descriptor instead.
+func (*StreamEventsRequest) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *StreamEventsRequest) GetTypes() []string {
+	if x != nil {
+		return x.Types
+	}
+	return nil
+}
+
+type Event struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Ip            string                 `protobuf:"bytes,2,opt,name=ip,proto3" json:"ip,omitempty"` // IP, CIDR or client key
+	Reason        string                 `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
+	Path          string                 `protobuf:"bytes,4,opt,name=path,proto3" json:"path,omitempty"`
+	Requests      []*OffendingRequest    `protobuf:"bytes,5,rep,name=requests,proto3" json:"requests,omitempty"`
+	Count         int64                  `protobuf:"varint,6,opt,name=count,proto3" json:"count,omitempty"`
+	Rule          string                 `protobuf:"bytes,7,opt,name=rule,proto3" json:"rule,omitempty"`
+	ExpiresAt     *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=time,proto3" json:"time,omitempty"`
+	Geo           *Geo                   `protobuf:"bytes,10,opt,name=geo,proto3" json:"geo,omitempty"`
+	Paths         []string               `protobuf:"bytes,11,rep,name=paths,proto3" json:"paths,omitempty"`    // Latest 404 paths of a banned client
+	Weight        int64                  `protobuf:"varint,12,opt,name=weight,proto3" json:"weight,omitempty"` // How much a 404 counted
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_adminpb_admin_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *Event) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Event) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+func (x *Event) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *Event) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *Event) GetRequests() []*OffendingRequest {
+	if x != nil {
+		return x.Requests
+	}
+	return nil
+}
+
+func (x *Event) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *Event) GetRule() string {
+	if x != nil {
+		return x.Rule
+	}
+	return ""
+}
+
+func (x *Event) GetExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return nil
+}
+
+func (x *Event) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Event) GetGeo() *Geo {
+	if x != nil {
+		return x.Geo
+	}
+	return nil
+}
+
+func (x *Event) GetPaths() []string {
+	if x != nil {
+		return x.Paths
+	}
+	return nil
+}
+
+func (x *Event) GetWeight() int64 {
+	if x != nil {
+		return x.Weight
+	}
+	return 0
+}
+
+var File_adminpb_admin_proto protoreflect.FileDescriptor
+
+const file_adminpb_admin_proto_rawDesc = "" +
+	"\n" +
+	"\x13adminpb/admin.proto\x12\x10blocker.admin.v1\x1a\x1egoogle/protobuf/duration.proto\x1a\x1bgoogle/protobuf/empty.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x11\n" +
+	"\x0fListBansRequest\"=\n" +
+	"\x10ListBansResponse\x12)\n" +
+	"\x04bans\x18\x01 \x03(\v2\x15.blocker.admin.v1.BanR\x04bans\"'\n" +
+	"\rGetBanRequest\x12\x16\n" +
+	"\x06target\x18\x01 \x01(\tR\x06target\"[\n" +
+	"\n" +
+	"BanRequest\x12\x16\n" +
+	"\x06target\x18\x01 \x01(\tR\x06target\x125\n" +
+	"\bduration\x18\x02 \x01(\v2\x19.google.protobuf.DurationR\bduration\"I\n" +
+	"\fUnbanRequest\x12\x16\n" +
+	"\x06target\x18\x01 \x01(\tR\x06target\x12!\n" +
+	"\freset_counts\x18\x02 \x01(\bR\vresetCounts\"\x84\x03\n" +
+	"\x03Ban\x12\x16\n" +
+	"\x06target\x18\x01 \x01(\tR\x06target\x127\n" +
+	"\tbanned_at\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\bbannedAt\x129\n" +
+	"\n" +
+	"expires_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\texpiresAt\x12\x16\n" +
+	"\x06reason\x18\x04 \x01(\tR\x06reason\x12\x14\n" +
+	"\x05count\x18\x05 \x01(\x03R\x05count\x12\x12\n" +
+	"\x04rule\x18\x06 \x01(\tR\x04rule\x12>\n" +
+	"\brequests\x18\a \x03(\v2\".blocker.admin.v1.OffendingRequestR\brequests\x12\x18\n" +
+	"\aoffense\x18\b \x01(\x03R\aoffense\x12\x16\n" +
+	"\x06source\x18\t \x01(\tR\x06source\x12'\n" +
+	"\x03geo\x18\n" +
+	" \x01(\v2\x15.blocker.admin.v1.GeoR\x03geo\x12\x14\n" +
+	"\x05paths\x18\v \x03(\tR\x05paths\"u\n" +
+	"\x10OffendingRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12.\n" +
+	"\x04time\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x1d\n" +
+	"\n" +
+	"user_agent\x18\x03 \x01(\tR\tuserAgent\"H\n" +
+	"\x03Geo\x12\x18\n" +
+	"\acountry\x18\x01 \x01(\tR\acountry\x12\x10\n" +
+	"\x03asn\x18\x02 \x01(\rR\x03asn\x12\x15\n" +
+	"\x06as_org\x18\x03 \x01(\tR\x05asOrg\"\xe1\x01\n" +
+	"\x15ListWhitelistResponse\x12\x18\n" +
+	"\aentries\x18\x01 \x03(\tR\aentries\x12T\n" +
+	"\ttemporary\x18\x02 \x03(\v26.blocker.admin.v1.ListWhitelistResponse.TemporaryEntryR\ttemporary\x1aX\n" +
+	"\x0eTemporaryEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x120\n" +
+	"\x05value\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x05value:\x028\x01\"b\n" +
+	"\x13AddWhitelistRequest\x12\x14\n" +
+	"\x05entry\x18\x01 \x01(\tR\x05entry\x125\n" +
+	"\bduration\x18\x02 \x01(\v2\x19.google.protobuf.DurationR\bduration\".\n" +
+	"\x16RemoveWhitelistRequest\x12\x14\n" +
+	"\x05entry\x18\x01 \x01(\tR\x05entry\"\xbf\x01\n" +
+	"\x05Stats\x12\x1f\n" +
+	"\vtracked_ips\x18\x01 \x01(\x03R\n" +
+	"trackedIps\x12\x1f\n" +
+	"\vactive_bans\x18\x02 \x01(\x03R\n" +
+	"activeBans\x12&\n" +
+	"\x0ftotal_not_found\x18\x03 \x01(\x04R\rtotalNotFound\x12)\n" +
+	"\x10blocked_requests\x18\x04 \x01(\x04R\x0fblockedRequests\x12!\n" +
+	"\fmemory_bytes\x18\x05 \x01(\x03R\vmemoryBytes\"+\n" +
+	"\x13StreamEventsRequest\x12\x14\n" +
+	"\x05types\x18\x01 \x03(\tR\x05types\"\x83\x03\n" +
+	"\x05Event\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x0e\n" +
+	"\x02ip\x18\x02 \x01(\tR\x02ip\x12\x16\n" +
+	"\x06reason\x18\x03 \x01(\tR\x06reason\x12\x12\n" +
+	"\x04path\x18\x04 \x01(\tR\x04path\x12>\n" +
+	"\brequests\x18\x05 \x03(\v2\".blocker.admin.v1.OffendingRequestR\brequests\x12\x14\n" +
+	"\x05count\x18\x06 \x01(\x03R\x05count\x12\x12\n" +
+	"\x04rule\x18\a \x01(\tR\x04rule\x129\n" +
+	"\n" +
+	"expires_at\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\texpiresAt\x12.\n" +
+	"\x04time\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12'\n" +
+	"\x03geo\x18\n" +
+	" \x01(\v2\x15.blocker.admin.v1.GeoR\x03geo\x12\x14\n" +
+	"\x05paths\x18\v \x03(\tR\x05paths\x12\x16\n" +
+	"\x06weight\x18\f \x01(\x03R\x06weight2\xa5\x05\n" +
+	"\fAdminService\x12Q\n" +
+	"\bListBans\x12!.blocker.admin.v1.ListBansRequest\x1a\".blocker.admin.v1.ListBansResponse\x12@\n" +
+	"\x06GetBan\x12\x1f.blocker.admin.v1.GetBanRequest\x1a\x15.blocker.admin.v1.Ban\x12:\n" +
+	"\x03Ban\x12\x1c.blocker.admin.v1.BanRequest\x1a\x15.blocker.admin.v1.Ban\x12?\n" +
+	"\x05Unban\x12\x1e.blocker.admin.v1.UnbanRequest\x1a\x16.google.protobuf.Empty\x12P\n" +
+	"\rListWhitelist\x12\x16.google.protobuf.Empty\x1a'.blocker.admin.v1.ListWhitelistResponse\x12M\n" +
+	"\fAddWhitelist\x12%.blocker.admin.v1.AddWhitelistRequest\x1a\x16.google.protobuf.Empty\x12S\n" +
+	"\x0fRemoveWhitelist\x12(.blocker.admin.v1.RemoveWhitelistRequest\x1a\x16.google.protobuf.Empty\x12;\n" +
+	"\bGetStats\x12\x16.google.protobuf.Empty\x1a\x17.blocker.admin.v1.Stats\x12P\n" +
+	"\fStreamEvents\x12%.blocker.admin.v1.StreamEventsRequest\x1a\x17.blocker.admin.v1.Event0\x01B\x18Z\x16404BlockerDemo/adminpbb\x06proto3"
+
+var (
+	file_adminpb_admin_proto_rawDescOnce sync.Once
+	file_adminpb_admin_proto_rawDescData []byte
+)
+
+func file_adminpb_admin_proto_rawDescGZIP() []byte {
+	file_adminpb_admin_proto_rawDescOnce.Do(func() {
+		file_adminpb_admin_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_adminpb_admin_proto_rawDesc), len(file_adminpb_admin_proto_rawDesc)))
+	})
+	return file_adminpb_admin_proto_rawDescData
+}
+
+var file_adminpb_admin_proto_msgTypes = make([]protoimpl.MessageInfo, 15)
+var file_adminpb_admin_proto_goTypes = []any{
+	(*ListBansRequest)(nil),        // 0: blocker.admin.v1.ListBansRequest
+	(*ListBansResponse)(nil),       // 1: blocker.admin.v1.ListBansResponse
+	(*GetBanRequest)(nil),          // 2: blocker.admin.v1.GetBanRequest
+	(*BanRequest)(nil),             // 3: blocker.admin.v1.BanRequest
+	(*UnbanRequest)(nil),           // 4: blocker.admin.v1.UnbanRequest
+	(*Ban)(nil),                    // 5: blocker.admin.v1.Ban
+	(*OffendingRequest)(nil),       // 6: blocker.admin.v1.OffendingRequest
+	(*Geo)(nil),                    // 7: blocker.admin.v1.Geo
+	(*ListWhitelistResponse)(nil),  // 8: blocker.admin.v1.ListWhitelistResponse
+	(*AddWhitelistRequest)(nil),    // 9: blocker.admin.v1.AddWhitelistRequest
+	(*RemoveWhitelistRequest)(nil), // 10: blocker.admin.v1.RemoveWhitelistRequest
+	(*Stats)(nil),                  // 11: blocker.admin.v1.Stats
+	(*StreamEventsRequest)(nil),    // 12: blocker.admin.v1.StreamEventsRequest
+	(*Event)(nil),                  // 13: blocker.admin.v1.Event
+	nil,                            // 14: blocker.admin.v1.ListWhitelistResponse.TemporaryEntry
+	(*durationpb.Duration)(nil),    // 15: google.protobuf.Duration
+	(*timestamppb.Timestamp)(nil),  // 16: google.protobuf.Timestamp
+	(*emptypb.Empty)(nil),          // 17: google.protobuf.Empty
+}
+var file_adminpb_admin_proto_depIdxs = []int32{
+	5,  // 0: blocker.admin.v1.ListBansResponse.bans:type_name -> blocker.admin.v1.Ban
+	15, // 1: blocker.admin.v1.BanRequest.duration:type_name -> google.protobuf.Duration
+	16, // 2: blocker.admin.v1.Ban.banned_at:type_name -> google.protobuf.Timestamp
+	16, // 3: blocker.admin.v1.Ban.expires_at:type_name -> google.protobuf.Timestamp
+	6,  // 4: blocker.admin.v1.Ban.requests:type_name -> blocker.admin.v1.OffendingRequest
+	7,  // 5: blocker.admin.v1.Ban.geo:type_name -> blocker.admin.v1.Geo
+	16, // 6: blocker.admin.v1.OffendingRequest.time:type_name -> google.protobuf.Timestamp
+	14, // 7: blocker.admin.v1.ListWhitelistResponse.temporary:type_name -> blocker.admin.v1.ListWhitelistResponse.TemporaryEntry
+	15, // 8: blocker.admin.v1.AddWhitelistRequest.duration:type_name -> google.protobuf.Duration
+	6,  // 9: blocker.admin.v1.Event.requests:type_name -> blocker.admin.v1.OffendingRequest
+	16, // 10: blocker.admin.v1.Event.expires_at:type_name -> google.protobuf.Timestamp
+	16, // 11: blocker.admin.v1.Event.time:type_name -> google.protobuf.Timestamp
+	7,  // 12: blocker.admin.v1.Event.geo:type_name -> blocker.admin.v1.Geo
+	16, // 13: blocker.admin.v1.ListWhitelistResponse.TemporaryEntry.value:type_name -> google.protobuf.Timestamp
+	0,  // 14: blocker.admin.v1.AdminService.ListBans:input_type -> blocker.admin.v1.ListBansRequest
+	2,  // 15: blocker.admin.v1.AdminService.GetBan:input_type -> blocker.admin.v1.GetBanRequest
+	3,  // 16: blocker.admin.v1.AdminService.Ban:input_type -> blocker.admin.v1.BanRequest
+	4,  // 17: blocker.admin.v1.AdminService.Unban:input_type -> blocker.admin.v1.UnbanRequest
+	17, // 18: blocker.admin.v1.AdminService.ListWhitelist:input_type -> google.protobuf.Empty
+	9,  // 19: blocker.admin.v1.AdminService.AddWhitelist:input_type -> blocker.admin.v1.AddWhitelistRequest
+	10, // 20: blocker.admin.v1.AdminService.RemoveWhitelist:input_type -> blocker.admin.v1.RemoveWhitelistRequest
+	17, // 21: blocker.admin.v1.AdminService.GetStats:input_type -> google.protobuf.Empty
+	12, // 22: blocker.admin.v1.AdminService.StreamEvents:input_type -> blocker.admin.v1.StreamEventsRequest
+	1,  // 23: blocker.admin.v1.AdminService.ListBans:output_type -> blocker.admin.v1.ListBansResponse
+	5,  // 24: blocker.admin.v1.AdminService.GetBan:output_type -> blocker.admin.v1.Ban
+	5,  // 25: blocker.admin.v1.AdminService.Ban:output_type -> blocker.admin.v1.Ban
+	17, // 26: blocker.admin.v1.AdminService.Unban:output_type -> google.protobuf.Empty
+	8,  // 27: blocker.admin.v1.AdminService.ListWhitelist:output_type -> blocker.admin.v1.ListWhitelistResponse
+	17, // 28: blocker.admin.v1.AdminService.AddWhitelist:output_type -> google.protobuf.Empty
+	17, // 29: blocker.admin.v1.AdminService.RemoveWhitelist:output_type -> google.protobuf.Empty
+	11, // 30: blocker.admin.v1.AdminService.GetStats:output_type -> blocker.admin.v1.Stats
+	13, // 31: blocker.admin.v1.AdminService.StreamEvents:output_type -> blocker.admin.v1.Event
+	23, // [23:32] is the sub-list for method output_type
+	14, // [14:23] is the sub-list for method input_type
+	14, // [14:14] is the sub-list for extension type_name
+	14, // [14:14] is the sub-list for extension extendee
+	0,  // [0:14] is the sub-list for field type_name
+}
+
+func init() { file_adminpb_admin_proto_init() }
+func file_adminpb_admin_proto_init() {
+	if File_adminpb_admin_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_adminpb_admin_proto_rawDesc), len(file_adminpb_admin_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   15,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_adminpb_admin_proto_goTypes,
+		DependencyIndexes: file_adminpb_admin_proto_depIdxs,
+		MessageInfos:      file_adminpb_admin_proto_msgTypes,
+	}.Build()
+	File_adminpb_admin_proto = out.File
+	file_adminpb_admin_proto_goTypes = nil
+	file_adminpb_admin_proto_depIdxs = nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// ListenAdmin opens a listener for the admin API apart from the application
+// port: addr is host:port, or "unix:" followed by the path of a Unix socket.
+// A socket left behind by an earlier run is replaced, and the new one is
+// only accessible to the owner and group. Callers over a Unix socket aren't
+// subject to AdminAuth.AllowedNetworks, the socket's permissions are.
+func ListenAdmin(addr string) (net.Listener, error) {
+	path, unix := strings.CutPrefix(addr, "unix:")
+	if !unix {
+		return net.Listen("tcp", addr)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// AdminTLSConfig returns the TLS settings of an admin listener serving the
+// certificate in certFile and keyFile. With clientCAFile, it's mutual TLS:
+// clients must present a certificate signed by one of the CAs in the file,
+// which may also grant them a role by itself, see
+// AdminAuth.ClientCertificates.
+func AdminTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", clientCAFile)
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// AdminHandler serves the routes of RegisterAdminRoutes under /admin, and
+// the Prometheus metrics at /metrics with metrics, for a listener of their
+// own. Metrics need no credentials, but are subject to
+// AdminAuth.AllowedNetworks like the rest.
+func (t *IP404Tracker) AdminHandler(metrics bool) http.Handler {
+	router := gin.New()
+	router.Use(gin.Recovery())
+	if metrics {
+		router.GET("/metrics", t.adminAllowlistMiddleware(), gin.WrapH(t.MetricsHandler()))
+	}
+	t.RegisterAdminRoutes(router.Group("/admin"))
+	return router
+}
+
+// unixSocket reports whether r came in over a Unix socket
+func unixSocket(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+// adminServers are the admin listeners the binary runs for the admin section
+type adminServers struct {
+	http *http.Server
+	grpc *grpc.Server
+
+	// Ends the event streams of the HTTP server, which never go idle
+	// on their own
+	stopStreams context.CancelFunc
+}
+
+// serve starts the admin listeners, logging errors after they started
+func (a *AdminFileConfig) serve(t *IP404Tracker) (*adminServers, error) {
+	var tlsConfig *tls.Config
+	if a.CertFile != "" {
+		var err error
+		if tlsConfig, err = AdminTLSConfig(a.CertFile, a.KeyFile, a.ClientCAFile); err != nil {
+			return nil, fmt.Errorf("admin: %w", err)
+		}
+	}
+
+	s := &adminServers{}
+	if a.Listen != "" {
+		listener, err := ListenAdmin(a.Listen)
+		if err != nil {
+			return nil, fmt.Errorf("admin.listen: %w", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		s.stopStreams = cancel
+		s.http = &http.Server{
+			Handler:     t.AdminHandler(a.Metrics),
+			TLSConfig:   tlsConfig,
+			BaseContext: func(net.Listener) context.Context { return ctx },
+		}
+		go func() {
+			var err error
+			if tlsConfig != nil {
+				err = s.http.ServeTLS(listener, "", "")
+			} else {
+				err = s.http.Serve(listener)
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				t.logger.Error("serving the admin api failed", "addr", a.Listen, "error", err)
+			}
+		}()
+	}
+	if a.GRPCListen != "" {
+		listener, err := ListenAdmin(a.GRPCListen)
+		if err != nil {
+			s.shutdown(context.Background())
+			return nil, fmt.Errorf("admin.grpc_listen: %w", err)
+		}
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		s.grpc = grpc.NewServer(opts...)
+		t.RegisterAdminService(s.grpc)
+		go func() {
+			if err := s.grpc.Serve(listener); err != nil {
+				t.logger.Error("serving the grpc admin service failed", "addr", a.GRPCListen, "error", err)
+			}
+		}()
+	}
+	return s, nil
+}
+
+// shutdown stops the admin listeners. HTTP requests are finished first;
+// gRPC calls still running, such as event streams, are cancelled.
+func (s *adminServers) shutdown(ctx context.Context) error {
+	if s.grpc != nil {
+		s.grpc.Stop()
+	}
+	if s.http == nil {
+		return nil
+	}
+	s.stopStreams()
+	return s.http.Shutdown(ctx)
+}
@@ -0,0 +1,56 @@
+package main
+
+import "net/netip"
+
+// Default prefix lengths 404s and bans are aggregated to. A single IPv6
+// client usually controls a whole /64, so tracking its addresses one by one
+// would let it rotate around the threshold.
+const (
+	defaultIPv4PrefixBits = 32
+	defaultIPv6PrefixBits = 64
+)
+
+// WithPrefixAggregation counts 404s and bans per network instead of per
+// address: every IPv4 address in the same /ipv4Bits and every IPv6 address
+// in the same /ipv6Bits share one counter and one ban. The default is /32
+// for IPv4 (no aggregation) and /64 for IPv6; pass 32 and 128 to track every
+// address separately.
+func WithPrefixAggregation(ipv4Bits, ipv6Bits int) Option {
+	return func(t *IP404Tracker) {
+		t.ipv4Bits = min(max(ipv4Bits, 0), 32)
+		t.ipv6Bits = min(max(ipv6Bits, 0), 128)
+	}
+}
+
+// trackingKey returns the key 404s and bans of ip are kept under: the
+// normalized address, or the aggregated prefix it belongs to, or with
+// WithAnonymization its pseudonym or truncated address. Keys and prefixes
+// map to themselves; anything unparsable is returned unchanged.
+func (t *IP404Tracker) trackingKey(ip string) string {
+	prefix, err := parseIPOrCIDR(ip)
+	if err != nil {
+		return ip
+	}
+
+	bits := t.ipv6Bits
+	if prefix.Addr().Is4() {
+		bits = t.ipv4Bits
+	}
+	if prefix.Bits() > bits {
+		prefix = netip.PrefixFrom(prefix.Addr(), bits).Masked()
+	}
+	if t.anonymizer != nil {
+		return t.anonymizer.key(prefix)
+	}
+	return prefixString(prefix)
+}
+
+// keyInPrefix reports whether every address tracked under key, or under a
+// fingerprint key, lies inside prefix
+func keyInPrefix(key string, prefix netip.Prefix) bool {
+	keyPrefix, err := parseIPOrCIDR(fingerprintAddr(key))
+	if err != nil {
+		return false
+	}
+	return keyPrefix.Bits() >= prefix.Bits() && prefix.Contains(keyPrefix.Addr())
+}
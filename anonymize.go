@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// AnonymizationMode selects how client addresses are anonymized
+type AnonymizationMode string
+
+const (
+	// AnonymizeHash replaces addresses with a keyed HMAC pseudonym
+	AnonymizeHash AnonymizationMode = "hash"
+	// AnonymizeTruncate keeps only the network part of addresses
+	AnonymizeTruncate AnonymizationMode = "truncate"
+)
+
+// pseudonymPrefix starts the keys AnonymizeHash tracks clients under
+const pseudonymPrefix = "anon-"
+
+// AnonymizationConfig keeps client addresses out of what the tracker stores
+// and reports, for deployments that mustn't keep personal data: counters,
+// bans, snapshots, events and the "ip" field of log lines see pseudonyms or
+// truncated addresses instead. Banning still works, as every request of a
+// client maps to the same key. The whitelist, blacklist and range bans are
+// checked against the real address and never stored for a client.
+type AnonymizationConfig struct {
+	Mode AnonymizationMode // Default AnonymizeHash
+
+	// Keys the HMAC; instances sharing a store need the same secret. A
+	// random one is generated when empty, which makes every client a new
+	// one on restart.
+	Secret []byte
+
+	// Network bits AnonymizeTruncate keeps (default 24 and 48)
+	IPv4Bits int
+	IPv6Bits int
+
+	// Longest anything about a client is kept: bans, remembered offenses
+	// and banned request counters end this long after they were last
+	// written at the latest. Zero keeps them as long as they're configured to.
+	Retention time.Duration
+}
+
+// anonymizer holds the anonymization settings
+type anonymizer struct {
+	cfg AnonymizationConfig
+}
+
+// WithAnonymization tracks clients under pseudonyms or truncated addresses
+// rather than their IPs
+func WithAnonymization(cfg AnonymizationConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.Mode == "" {
+			cfg.Mode = AnonymizeHash
+		}
+		if cfg.Mode == AnonymizeHash && len(cfg.Secret) == 0 {
+			t.logger.Warn("no anonymization secret set, pseudonyms change on restart")
+			cfg.Secret = make([]byte, 32)
+			rand.Read(cfg.Secret)
+		}
+		if cfg.IPv4Bits <= 0 {
+			cfg.IPv4Bits = 24
+		}
+		if cfg.IPv6Bits <= 0 {
+			cfg.IPv6Bits = 48
+		}
+		t.anonymizer = &anonymizer{cfg: cfg}
+	}
+}
+
+// truncate shortens prefix to the network bits kept for its family
+func (a *anonymizer) truncate(prefix netip.Prefix) netip.Prefix {
+	bits := a.cfg.IPv6Bits
+	if prefix.Addr().Is4() {
+		bits = a.cfg.IPv4Bits
+	}
+	if a.cfg.Mode != AnonymizeTruncate || prefix.Bits() <= bits {
+		return prefix
+	}
+	return netip.PrefixFrom(prefix.Addr(), bits).Masked()
+}
+
+// key returns the key a client whose address is in prefix is tracked under
+func (a *anonymizer) key(prefix netip.Prefix) string {
+	prefix = a.truncate(prefix)
+	if a.cfg.Mode != AnonymizeHash {
+		return prefixString(prefix)
+	}
+	mac := hmac.New(sha256.New, a.cfg.Secret)
+	mac.Write([]byte(prefixString(prefix)))
+	return pseudonymPrefix + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// pseudonymous reports whether key is a pseudonym of AnonymizeHash
+func pseudonymous(key string) bool {
+	return strings.HasPrefix(key, pseudonymPrefix)
+}
+
+// retain caps how long from now a record may be kept, if the retention says so
+func (a *anonymizer) retain(d time.Duration) time.Duration {
+	if a == nil || a.cfg.Retention <= 0 {
+		return d
+	}
+	return min(d, a.cfg.Retention)
+}
+
+// retainUntil caps when a record written at now expires
+func (a *anonymizer) retainUntil(expires, now time.Time) time.Time {
+	if a == nil || a.cfg.Retention <= 0 {
+		return expires
+	}
+	if limit := now.Add(a.cfg.Retention); expires.After(limit) {
+		return limit
+	}
+	return expires
+}
+
+// anonymizingLogger replaces the addresses in the "ip" fields of log lines
+// with the keys their clients are tracked under
+type anonymizingLogger struct {
+	Logger
+	tracker *IP404Tracker
+}
+
+func (l anonymizingLogger) Debug(msg string, args ...any) { l.Logger.Debug(msg, l.scrub(args)...) }
+func (l anonymizingLogger) Info(msg string, args ...any)  { l.Logger.Info(msg, l.scrub(args)...) }
+func (l anonymizingLogger) Warn(msg string, args ...any)  { l.Logger.Warn(msg, l.scrub(args)...) }
+func (l anonymizingLogger) Error(msg string, args ...any) { l.Logger.Error(msg, l.scrub(args)...) }
+
+// scrub returns args with the "ip" values anonymized
+func (l anonymizingLogger) scrub(args []any) []any {
+	scrubbed := args
+	for i := 0; i+1 < len(args); i += 2 {
+		if key, _ := args[i].(string); key != "ip" {
+			continue
+		}
+		ip, ok := args[i+1].(string)
+		if !ok {
+			continue
+		}
+		if anonymized := l.tracker.trackingKey(ip); anonymized != ip {
+			if &scrubbed[0] == &args[0] {
+				scrubbed = append([]any(nil), args...)
+			}
+			scrubbed[i+1] = anonymized
+		}
+	}
+	return scrubbed
+}
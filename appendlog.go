@@ -0,0 +1,40 @@
+package main
+
+import "os"
+
+// appendLog appends to a log file, reopening it after it was rotated away
+type appendLog struct {
+	path string
+	perm os.FileMode // Of a newly created file
+	file *os.File
+}
+
+// write appends line, opening the file first if needed
+func (l *appendLog) write(line string) error {
+	if l.file != nil {
+		// logrotate moved the file away: start a new one at path
+		current, err := os.Stat(l.path)
+		info, statErr := l.file.Stat()
+		if err != nil || statErr != nil || !os.SameFile(current, info) {
+			l.file.Close()
+			l.file = nil
+		}
+	}
+	if l.file == nil {
+		file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, l.perm)
+		if err != nil {
+			return err
+		}
+		l.file = file
+	}
+	_, err := l.file.WriteString(line)
+	return err
+}
+
+// close closes the file if it's open
+func (l *appendLog) close() {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
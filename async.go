@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// AsyncConfig hands the recording of responses to a background worker, so
+// the time the store takes, e.g. a round trip to Redis, is never added to a
+// request. When the queue is full, further responses aren't recorded;
+// they're counted in blocker_async_dropped_total instead of slowing
+// requests down.
+type AsyncConfig struct {
+	Buffer    int // Responses queued before new ones are dropped (default 4096)
+	BatchSize int // Responses the worker handles at a time (default 128)
+}
+
+// WithAsyncRecording records 404s and status rule responses in the
+// background. Bans take effect as soon as the worker gets to the response
+// crossing the threshold, a little after it was sent. Stores implementing
+// BatchRecordStore write the 404s of each batch in one go.
+func WithAsyncRecording(cfg AsyncConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.Buffer <= 0 {
+			cfg.Buffer = 4096
+		}
+		if cfg.BatchSize <= 0 {
+			cfg.BatchSize = 128
+		}
+		t.async = &asyncRecorder{cfg: cfg, queue: make(chan recordJob, cfg.Buffer)}
+	}
+}
+
+// Record404Entry is a 404 to record, see BatchRecordStore
+type Record404Entry struct {
+	IP     string
+	At     time.Time
+	Weight int
+}
+
+// BatchRecordStore is implemented by stores that can record many 404s at
+// once. Record404Batch is Record404 for each entry in order, returning the
+// count of each.
+type BatchRecordStore interface {
+	Record404Batch(entries []Record404Entry, window time.Duration) ([]int, error)
+}
+
+// asyncRecorder holds the queue of the asynchronous recording
+type asyncRecorder struct {
+	cfg   AsyncConfig
+	queue chan recordJob
+}
+
+// recordJob is a response waiting for the worker. A status of 0 marks a 404
+// counted without looking at a response, like those of Record404 and the
+// warning threshold.
+type recordJob struct {
+	req    clientRequest
+	status int
+}
+
+// recordBatch collects the 404s of the responses the worker handles at a
+// time until they're written to the store together
+type recordBatch struct {
+	pending []pending404
+}
+
+// enqueue hands a response to the worker, reporting false when the queue
+// has no room for it
+func (t *IP404Tracker) enqueue(req clientRequest, status int) bool {
+	// The request is over by the time the worker gets to it
+	if req.ctx != nil {
+		req.ctx = context.WithoutCancel(req.ctx)
+	}
+	select {
+	case t.async.queue <- recordJob{req: req, status: status}:
+		return true
+	default:
+		t.counters.asyncDropped.Add(1)
+		return false
+	}
+}
+
+// deferred reports whether req is left to the worker, queueing it with
+// status unless there's nothing to record for it; requests the worker
+// handles itself carry their batch
+func (t *IP404Tracker) deferred(req clientRequest, status int) bool {
+	if t.async == nil || req.batch != nil {
+		return false
+	}
+	if status == 0 || t.recordable(req, status) {
+		t.enqueue(req, status)
+	}
+	return true
+}
+
+// recordable reports whether handleResponse has anything to record for a
+// response with status
+func (t *IP404Tracker) recordable(req clientRequest, status int) bool {
+	if status == 404 || status >= 400 && t.methodRules.anomalous(req.method) {
+		return true
+	}
+	for _, counter := range t.statusRules {
+		if counter.rule.matches(status) {
+			return true
+		}
+	}
+	return false
+}
+
+// asyncLoop handles queued responses until the tracker shuts down, then
+// those still queued
+func (t *IP404Tracker) asyncLoop() {
+	for {
+		select {
+		case job := <-t.async.queue:
+			t.handleBatch(job)
+		case <-t.done:
+			for {
+				select {
+				case job := <-t.async.queue:
+					t.handleBatch(job)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// handleBatch handles job and whatever else is queued, up to the batch
+// size, writing their 404s to the store together
+func (t *IP404Tracker) handleBatch(job recordJob) {
+	batch := &recordBatch{}
+	for n := 0; ; n++ {
+		job.req.batch = batch
+		if job.status == 0 {
+			t.handle404(job.req, 0)
+		} else {
+			t.handleResponse(job.req, job.status)
+		}
+
+		if n+1 == t.async.cfg.BatchSize {
+			break
+		}
+		var more bool
+		select {
+		case job, more = <-t.async.queue:
+		default:
+		}
+		if !more {
+			break
+		}
+	}
+	t.flush404s(batch.pending)
+}
+
+// flush404s writes the 404s of a batch to the store and judges them
+func (t *IP404Tracker) flush404s(pending []pending404) {
+	if len(pending) == 0 {
+		return
+	}
+	retention := t.countRetention()
+
+	store, ok := t.store.(BatchRecordStore)
+	if !ok {
+		for _, p := range pending {
+			count, err := t.store.Record404(p.key, p.at, retention, p.weight)
+			t.counted404(p, count, err)
+		}
+		return
+	}
+
+	entries := make([]Record404Entry, len(pending))
+	for i, p := range pending {
+		entries[i] = Record404Entry{IP: p.key, At: p.at, Weight: p.weight}
+	}
+	counts, err := store.Record404Batch(entries, retention)
+	if err != nil {
+		t.logger.Error("recording 404s failed", "count", len(pending), "error", err)
+		return
+	}
+	for i, p := range pending {
+		t.counted404(p, counts[i], nil)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditAction is a kind of change recorded in the audit log
+type AuditAction string
+
+const (
+	// AuditBan is a manual ban of an address, range or client key
+	AuditBan AuditAction = "ban"
+	// AuditUnban lifts a ban
+	AuditUnban AuditAction = "unban"
+	// AuditWhitelistAdd adds or extends a whitelist entry
+	AuditWhitelistAdd AuditAction = "whitelist_add"
+	// AuditWhitelistRemove removes a whitelist entry
+	AuditWhitelistRemove AuditAction = "whitelist_remove"
+	// AuditBlacklistAdd blacklists an address or range
+	AuditBlacklistAdd AuditAction = "blacklist_add"
+	// AuditBlacklistRemove takes an address or range off the blacklist
+	AuditBlacklistRemove AuditAction = "blacklist_remove"
+	// AuditReload applies a new configuration
+	AuditReload AuditAction = "reload"
+)
+
+// AuditEntry is a line of the audit log. Before and After hold the state
+// of Target around the change, null when there was none: the ban record,
+// the whitelist entry, whether it was blacklisted, or the reloaded limits.
+type AuditEntry struct {
+	Time   time.Time   `json:"time"`
+	Actor  string      `json:"actor"`            // Who made the change
+	Source string      `json:"source,omitempty"` // Address the change came from
+	Action AuditAction `json:"action"`
+	Target string      `json:"target,omitempty"`
+	Before any         `json:"before"`
+	After  any         `json:"after"`
+}
+
+// AuditFilter selects audit log entries; zero fields match everything
+type AuditFilter struct {
+	Actor  string
+	Action AuditAction
+	Target string
+	Since  time.Time
+	Until  time.Time
+	Limit  int // Latest entries returned (default 100)
+}
+
+// matches reports whether entry is selected by f
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	switch {
+	case f.Actor != "" && entry.Actor != f.Actor,
+		f.Action != "" && entry.Action != f.Action,
+		f.Target != "" && entry.Target != f.Target,
+		!f.Since.IsZero() && entry.Time.Before(f.Since),
+		!f.Until.IsZero() && !entry.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// Actors of the changes the tracker audits without an admin API caller
+const (
+	AuditActorAnonymous = "anonymous" // Unauthenticated admin API
+	AuditActorReload    = "reload"
+)
+
+// auditLog appends entries to the audit log file
+type auditLog struct {
+	mu  sync.Mutex
+	log appendLog
+}
+
+// WithAuditLog appends every manual ban, unban, blacklist and whitelist
+// change made through the admin API and every Reload to path, as JSON lines.
+// The file is only ever appended to; logrotate may move it away.
+func WithAuditLog(path string) Option {
+	return func(t *IP404Tracker) {
+		t.auditLog = &auditLog{log: appendLog{path: path, perm: 0o600}}
+	}
+}
+
+// Audit appends entry to the audit log, setting its time when it has none.
+// Use it to record changes the application makes through the Go API.
+func (t *IP404Tracker) Audit(entry AuditEntry) {
+	a := t.auditLog
+	if a == nil {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = t.clock.Now()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		t.logger.Error("encoding audit entry failed", "action", entry.Action, "error", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.log.write(string(line) + "\n"); err != nil {
+		t.logger.Error("writing audit log failed", "path", a.log.path, "error", err)
+	}
+}
+
+// AuditEntries returns the latest entries of the audit log matching filter,
+// oldest first. Only the current file is read, not rotated ones.
+func (t *IP404Tracker) AuditEntries(filter AuditFilter) ([]AuditEntry, error) {
+	a := t.auditLog
+	if a == nil {
+		return nil, errors.New("audit log not enabled")
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = 100
+	}
+
+	file, err := os.Open(a.log.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil || !filter.matches(entry) {
+			// A line cut short by a crash is skipped
+			continue
+		}
+		entries = append(entries, entry)
+		if len(entries) > filter.Limit {
+			entries = entries[1:]
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// closeAudit closes the audit log file
+func (t *IP404Tracker) closeAudit() {
+	if a := t.auditLog; a != nil {
+		a.mu.Lock()
+		a.log.close()
+		a.mu.Unlock()
+	}
+}
+
+// auditWhitelistEntry is the state of a whitelist entry in the audit log
+type auditWhitelistEntry struct {
+	Until *time.Time `json:"until,omitempty"` // Temporary entries
+}
+
+// whitelistEntryState returns the audit state of the whitelist entry entry,
+// nil when there is none
+func (t *IP404Tracker) whitelistEntryState(entry string) any {
+	key := entry
+	if !isHostname(entry) {
+		prefix, err := parseIPOrCIDR(entry)
+		if err != nil {
+			return nil
+		}
+		key = prefixString(prefix)
+	}
+	if until, ok := t.GetTemporaryWhitelist()[key]; ok {
+		return &auditWhitelistEntry{Until: &until}
+	}
+	for _, listed := range t.GetWhitelist() {
+		if listed == key {
+			return &auditWhitelistEntry{}
+		}
+	}
+	return nil
+}
+
+// auditLimits is the state of the reloadable limits in the audit log
+type auditLimits struct {
+	Threshold   int    `json:"threshold"`
+	Window      string `json:"window"`
+	BanDuration string `json:"ban_duration"`
+
+	// Thresholds of the extra windows by window
+	Windows map[string]int `json:"windows,omitempty"`
+}
+
+// auditState returns limits as they're recorded in the audit log
+func (l *trackerLimits) auditState() auditLimits {
+	state := auditLimits{Threshold: l.threshold, Window: l.window.String(), BanDuration: l.banDuration.String()}
+	for _, limit := range l.windows {
+		if state.Windows == nil {
+			state.Windows = make(map[string]int)
+		}
+		state.Windows[limit.Window.String()] = limit.Threshold
+	}
+	return state
+}
+
+// banState returns the audit state of the ban on key, nil when there is none
+func (t *IP404Tracker) banState(key string) any {
+	if record, ok := t.GetBanInfo(key); ok {
+		return &record
+	}
+	return nil
+}
+
+// cidrBanState returns the audit state of the range ban on prefix, or of
+// the ban on the aggregated prefix it is
+func (t *IP404Tracker) cidrBanState(prefix netip.Prefix) any {
+	if record, ok := t.GetCIDRBanInfo(prefix); ok {
+		return &record
+	}
+	return t.banState(prefix.String())
+}
+
+// blacklistState returns the audit state of the blacklist entry entry
+func (t *IP404Tracker) blacklistState(entry string) bool {
+	prefix, err := parseIPOrCIDR(entry)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(t.GetBlacklist(), prefixString(prefix))
+}
+
+// auditAdmin records a change made by the admin API caller of c
+func (t *IP404Tracker) auditAdmin(c *gin.Context, action AuditAction, target string, before, after any) {
+	actor, _ := c.Get(adminActorKey)
+	name, _ := actor.(string)
+	t.Audit(AuditEntry{Actor: name, Source: t.ginClientIP(c), Action: action, Target: target, Before: before, After: after})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// BanSource tells whether a ban was issued automatically or by an operator
+type BanSource string
+
+const (
+	BanSourceAutomatic BanSource = "automatic"
+	BanSourceManual    BanSource = "manual"
+)
+
+// BanRecord describes a ban and why it was issued
+type BanRecord struct {
+	BannedAt  time.Time `json:"banned_at,omitzero"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Reason    string    `json:"reason,omitempty"`
+	Count     int       `json:"count,omitempty"` // 404s in the window when banned
+	Rule      string    `json:"rule,omitempty"`  // StatusRule that issued it; empty for 404s
+	Paths     []string  `json:"paths,omitempty"` // Latest offending paths
+
+	// The latest offending requests, with when they were made and by what
+	Requests []OffendingRequest `json:"requests,omitempty"`
+
+	Offense int       `json:"offense,omitempty"` // Automatic bans of this IP so far, including this one
+	Source  BanSource `json:"source,omitempty"`
+
+	// Where the client is, when GeoIP is enabled
+	GeoInfo
+}
+
+// activeAt reports whether the ban is still in force at now
+func (r BanRecord) activeAt(now time.Time) bool {
+	return r.ExpiresAt.After(now)
+}
+
+// UnmarshalJSON also accepts a bare expiry timestamp, as written by
+// snapshots from before ban records existed
+func (r *BanRecord) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		*r = BanRecord{}
+		return json.Unmarshal(data, &r.ExpiresAt)
+	}
+
+	type plain BanRecord
+	return json.Unmarshal(data, (*plain)(r))
+}
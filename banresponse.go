@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"html/template"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofiber/fiber/v2"
+)
+
+// BlockedRequest describes a request refused because its client is banned
+type BlockedRequest struct {
+	IP        string    // Client address
+	Key       string    // What the client is tracked under; the IP unless a KeyFunc is set
+	Method    string    // Request method
+	Path      string    // Requested path
+	BanType   string    // "ip", "key", "blacklist", "cidr", "feed", "crowdsec", "country" or "asn"
+	Reason    string    // Why an IP or key ban was issued, e.g. BanReasonRepeatOffender
+	ExpiresAt time.Time // When the ban ends; zero when it has no end of its own
+}
+
+// BanResponse is what banned clients are sent. The zero value is the
+// default shadow ban: an empty 404, as if the page didn't exist.
+type BanResponse struct {
+	Status      int               // Default 404
+	Body        string            // Sent as is unless Template is set
+	ContentType string            // Default text/plain, or text/html for a Template
+	Headers     map[string]string // Extra response headers
+	RetryAfter  bool              // Send Retry-After with the time left on the ban, e.g. for 429
+	Drop        bool              // Reset the connection without answering; for severe bans
+
+	// Rendered with the BlockedRequest as data to produce the body
+	Template *template.Template
+}
+
+// WithBanResponse sets the response banned clients get instead of a 404,
+// e.g. BanResponse{Status: 403, Body: "Access denied"} or
+// BanResponse{Status: 429, RetryAfter: true}
+func WithBanResponse(resp BanResponse) Option {
+	return func(t *IP404Tracker) {
+		t.banResponder = func(BlockedRequest) BanResponse { return resp }
+	}
+}
+
+// WithBanResponder picks the response for each blocked request, e.g. a 403
+// for blacklisted clients and a shadow 404 for everyone else
+func WithBanResponder(fn func(BlockedRequest) BanResponse) Option {
+	return func(t *IP404Tracker) {
+		t.banResponder = fn
+	}
+}
+
+// WithBanHandler lets h answer banned clients in Middleware, replacing the
+// ban response there; the BlockedRequest is stored in the Gin context under
+// BlockedRequestKey. Handler and FiberMiddleware keep using the ban response.
+func WithBanHandler(h gin.HandlerFunc) Option {
+	return func(t *IP404Tracker) {
+		t.banHandler = h
+	}
+}
+
+// BlockedRequestKey is the Gin context key holding the BlockedRequest for a
+// WithBanHandler handler
+const BlockedRequestKey = "404blocker.blocked"
+
+// banResponse resolves the response for a blocked request, rendering its
+// template and filling in its headers
+func (t *IP404Tracker) banResponse(blocked BlockedRequest) BanResponse {
+	if resp, ok := t.decoyResponse(blocked); ok {
+		return resp
+	}
+	if t.challengeable(blocked) {
+		return t.challengeRedirect(blocked)
+	}
+	if t.banResponder == nil {
+		return BanResponse{Status: http.StatusNotFound}
+	}
+
+	resp := t.banResponder(blocked)
+	if resp.Status == 0 {
+		resp.Status = http.StatusNotFound
+	}
+	headers := make(map[string]string, len(resp.Headers)+2)
+	for name, value := range resp.Headers {
+		headers[name] = value
+	}
+	resp.Headers = headers
+
+	if resp.Template != nil {
+		var body bytes.Buffer
+		if err := resp.Template.Execute(&body, blocked); err != nil {
+			t.logger.Error("rendering ban page failed", "error", err)
+		}
+		resp.Body = body.String()
+		if resp.ContentType == "" {
+			resp.ContentType = "text/html; charset=utf-8"
+		}
+	}
+	if resp.Body != "" && resp.ContentType == "" {
+		resp.ContentType = "text/plain; charset=utf-8"
+	}
+	if resp.ContentType != "" {
+		resp.Headers["Content-Type"] = resp.ContentType
+	}
+	if resp.RetryAfter {
+		resp.Headers["Retry-After"] = strconv.Itoa(t.retryAfter(blocked))
+	}
+	for name, value := range t.blockedRateLimitHeaders(blocked) {
+		resp.Headers[name] = value
+	}
+	return resp
+}
+
+// retryAfter returns how many seconds are left on the ban, or the ban
+// duration for bans that don't end on their own
+func (t *IP404Tracker) retryAfter(blocked BlockedRequest) int {
+	left := t.limits.Load().banDuration
+	if !blocked.ExpiresAt.IsZero() {
+		left = blocked.ExpiresAt.Sub(t.clock.Now())
+	}
+	return max(1, int(left.Round(time.Second)/time.Second))
+}
+
+// banRecord returns the ban behind a blocked request, for IP and key bans
+func (t *IP404Tracker) banRecord(blocked BlockedRequest) BanRecord {
+	if blocked.BanType != "ip" && blocked.BanType != "key" {
+		return BanRecord{}
+	}
+	record, ok := t.GetBanInfo(blocked.Key)
+	if !ok {
+		// Fingerprints are covered by bans of their address
+		record, _ = t.GetBanInfo(blocked.IP)
+	}
+	return record
+}
+
+// writeBanResponse sends resp through a net/http ResponseWriter
+func writeBanResponse(w http.ResponseWriter, resp BanResponse) {
+	// HTTP/2 connections can't be hijacked; they get the response instead
+	if resp.Drop && dropConnection(w) {
+		return
+	}
+	for name, value := range resp.Headers {
+		w.Header().Set(name, value)
+	}
+	w.WriteHeader(resp.Status)
+	if resp.Body != "" {
+		w.Write([]byte(resp.Body))
+	}
+}
+
+// sendFiberBanResponse sends resp through a Fiber context
+func sendFiberBanResponse(c *fiber.Ctx, resp BanResponse) error {
+	if resp.Drop {
+		// Fiber wraps hijacked connections, so set the linger up front
+		setNoLinger(c.Context().Conn())
+		c.Context().HijackSetNoResponse(true)
+		c.Context().Hijack(func(conn net.Conn) {
+			conn.Close()
+		})
+		return nil
+	}
+	for name, value := range resp.Headers {
+		c.Set(name, value)
+	}
+	return c.Status(resp.Status).SendString(resp.Body)
+}
+
+// dropConnection hijacks the connection behind w and resets it, reporting
+// whether it could
+func dropConnection(w http.ResponseWriter) bool {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return false
+	}
+	setNoLinger(conn)
+	conn.Close()
+	return true
+}
+
+// setNoLinger makes closing conn discard unsent data and send a TCP reset
+// instead of a graceful FIN, which leaves scanners with a broken connection
+// rather than an answer
+func setNoLinger(conn net.Conn) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/netip"
+	"sort"
+)
+
+// AddToBlacklist permanently bans an IP or CIDR, whatever its 404 count.
+// Blacklisted clients are blocked before any 404 tracking happens, and stay
+// blocked until removed with RemoveFromBlacklist. Whitelisted addresses are
+// still let through.
+func (t *IP404Tracker) AddToBlacklist(ipOrCIDR string) error {
+	prefix, err := parseIPOrCIDR(ipOrCIDR)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.blacklist.insert(prefix, struct{}{})
+	t.listsChanged()
+	t.mu.Unlock()
+	t.counters.bansIssued.Add(1)
+
+	t.logger.Info("ban issued", "ip", prefixString(prefix), "blacklist", true)
+	t.emit(Event{Type: EventBanned, IP: prefixString(prefix), Reason: BanReasonBlacklist})
+	return nil
+}
+
+// RemoveFromBlacklist lifts a permanent ban placed with AddToBlacklist
+func (t *IP404Tracker) RemoveFromBlacklist(ipOrCIDR string) error {
+	prefix, err := parseIPOrCIDR(ipOrCIDR)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	removed := t.blacklist.remove(prefix)
+	t.listsChanged()
+	t.mu.Unlock()
+
+	if removed {
+		t.logger.Info("ban lifted", "ip", prefixString(prefix), "blacklist", true)
+		t.emit(Event{Type: EventUnbanned, IP: prefixString(prefix), Reason: BanReasonBlacklist})
+	}
+	return nil
+}
+
+// GetBlacklist returns the blacklist entries in sorted order; single IPs are
+// listed without their /32 or /128 suffix
+func (t *IP404Tracker) GetBlacklist() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	result := make([]string, 0, t.blacklist.len())
+	t.blacklist.walk(func(prefix netip.Prefix, _ struct{}) {
+		result = append(result, prefixString(prefix))
+	})
+	sort.Strings(result)
+
+	return result
+}
+
+// IsBlacklisted checks if an IP is on the blacklist or in a blacklisted range
+func (t *IP404Tracker) IsBlacklisted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+
+	_, ok := t.lists().blacklist.lookup(addr)
+	return ok
+}
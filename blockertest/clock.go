@@ -0,0 +1,105 @@
+// Package blockertest provides helpers for testing code that uses the tracker
+// deterministically.
+package blockertest
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a Clock that only moves when told to. Pass it to WithClock
+// and call Advance to expire 404 windows and bans or fire the tracker's
+// tickers without waiting.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+	added   *sync.Cond
+}
+
+// fakeTicker is a ticker created by FakeClock.NewTicker
+type fakeTicker struct {
+	ch     chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+// NewFakeClock returns a FakeClock set to start
+func NewFakeClock(start time.Time) *FakeClock {
+	c := &FakeClock{now: start}
+	c.added = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the clock's current time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTicker returns a channel that receives the clock's time each time
+// Advance moves it past another period, and a function that stops it. Like
+// a time.Ticker, ticks that aren't received in time are dropped.
+func (c *FakeClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	if d <= 0 {
+		panic("blockertest: non-positive interval for NewTicker")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ticker := &fakeTicker{ch: make(chan time.Time, 1), period: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, ticker)
+	c.added.Broadcast()
+
+	stop := func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for i, other := range c.tickers {
+			if other == ticker {
+				c.tickers = append(c.tickers[:i], c.tickers[i+1:]...)
+				break
+			}
+		}
+	}
+	return ticker.ch, stop
+}
+
+// Advance moves the clock forward by d, firing every ticker that comes due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	for _, ticker := range c.tickers {
+		if ticker.next.After(c.now) {
+			continue
+		}
+		select {
+		case ticker.ch <- c.now:
+		default:
+		}
+		// Skip the periods that passed in one go, as a real ticker would
+		for !ticker.next.After(c.now) {
+			ticker.next = ticker.next.Add(ticker.period)
+		}
+	}
+}
+
+// Tickers returns how many tickers are running
+func (c *FakeClock) Tickers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.tickers)
+}
+
+// BlockUntil waits until at least n tickers are running, so a test can be
+// sure a background loop is waiting before it calls Advance
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.tickers) < n {
+		c.added.Wait()
+	}
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltMetaBucket     = []byte("meta")
+	boltBansBucket     = []byte("bans")
+	boltCountsBucket   = []byte("counts")
+	boltOffensesBucket = []byte("offenses")
+
+	boltSchemaKey = []byte("schema_version")
+)
+
+// boltMigrations upgrade the database schema one version at a time.
+// Append new migrations to the end; never edit an existing one.
+var boltMigrations = []func(tx *bolt.Tx) error{
+	// v1: bans and 404 counts keyed by IP
+	func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltBansBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltCountsBucket)
+		return err
+	},
+	// v2: bans hold JSON ban records instead of a bare expiry time
+	func(tx *bolt.Tx) error {
+		bans := tx.Bucket(boltBansBucket)
+		records := make(map[string][]byte)
+		err := bans.ForEach(func(k, v []byte) error {
+			if len(v) != 8 {
+				return nil
+			}
+			record, err := json.Marshal(BanRecord{ExpiresAt: decodeBoltTimes(v)[0]})
+			records[string(k)] = record
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for ip, record := range records {
+			if err := bans.Put([]byte(ip), record); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// v3: when each past ban of an IP will be forgotten
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltOffensesBucket)
+		return err
+	},
+	// v4: counts hold (time, weight) pairs instead of a timestamp per 404
+	func(tx *bolt.Tx) error {
+		counts := tx.Bucket(boltCountsBucket)
+		converted := make(map[string][]byte)
+		err := counts.ForEach(func(k, v []byte) error {
+			var hits []boltHit
+			for _, ts := range decodeBoltTimes(v) {
+				hits = addBoltHit(hits, ts, 1)
+			}
+			converted[string(k)] = encodeBoltHits(hits)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for ip, hits := range converted {
+			if err := counts.Put([]byte(ip), hits); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// BoltStore is a BanStore persisted to an embedded bbolt database file so
+// bans and counts survive process restarts
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens (or creates) the database at path and migrates it to
+// the current schema
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+
+	store := &BoltStore{db: db}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating %s: %w", path, err)
+	}
+
+	return store, nil
+}
+
+// Close closes the database file
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// Ping implements PingStore, failing once the database is closed
+func (s *BoltStore) Ping(ctx context.Context) error {
+	return s.db.View(func(*bolt.Tx) error { return nil })
+}
+
+// migrate applies every migration newer than the stored schema version
+func (s *BoltStore) migrate() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		if err != nil {
+			return err
+		}
+
+		version := 0
+		if raw := meta.Get(boltSchemaKey); len(raw) == 8 {
+			version = int(binary.BigEndian.Uint64(raw))
+		}
+		if version > len(boltMigrations) {
+			return fmt.Errorf("schema version %d is newer than supported version %d", version, len(boltMigrations))
+		}
+
+		for ; version < len(boltMigrations); version++ {
+			if err := boltMigrations[version](tx); err != nil {
+				return fmt.Errorf("migration %d: %w", version+1, err)
+			}
+		}
+
+		return meta.Put(boltSchemaKey, encodeBoltUint(uint64(version)))
+	})
+}
+
+func encodeBoltUint(v uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, v)
+	return buf
+}
+
+func encodeBoltTime(ts time.Time) []byte {
+	return encodeBoltUint(uint64(ts.UnixNano()))
+}
+
+func decodeBoltTimes(raw []byte) []time.Time {
+	timestamps := make([]time.Time, 0, len(raw)/8)
+	for i := 0; i+8 <= len(raw); i += 8 {
+		timestamps = append(timestamps, time.Unix(0, int64(binary.BigEndian.Uint64(raw[i:]))))
+	}
+	return timestamps
+}
+
+func encodeBoltTimes(timestamps []time.Time) []byte {
+	buf := make([]byte, 0, len(timestamps)*8)
+	for _, ts := range timestamps {
+		buf = append(buf, encodeBoltTime(ts)...)
+	}
+	return buf
+}
+
+// boltHit is a 404 of a given weight, stored as 8 bytes of time followed by
+// 8 bytes of weight
+type boltHit struct {
+	at     time.Time
+	weight int
+}
+
+const boltHitSize = 16
+
+func decodeBoltHits(raw []byte) []boltHit {
+	hits := make([]boltHit, 0, len(raw)/boltHitSize)
+	for i := 0; i+boltHitSize <= len(raw); i += boltHitSize {
+		hits = append(hits, boltHit{
+			at:     time.Unix(0, int64(binary.BigEndian.Uint64(raw[i:]))),
+			weight: int(binary.BigEndian.Uint64(raw[i+8:])),
+		})
+	}
+	return hits
+}
+
+func encodeBoltHits(hits []boltHit) []byte {
+	buf := make([]byte, 0, len(hits)*boltHitSize)
+	for _, hit := range hits {
+		buf = append(buf, encodeBoltTime(hit.at)...)
+		buf = append(buf, encodeBoltUint(uint64(hit.weight))...)
+	}
+	return buf
+}
+
+// addBoltHit appends a hit, folding it into the last one when they share a time
+func addBoltHit(hits []boltHit, at time.Time, weight int) []boltHit {
+	if n := len(hits); n > 0 && hits[n-1].at.Equal(at) {
+		hits[n-1].weight += weight
+		return hits
+	}
+	return append(hits, boltHit{at: at, weight: weight})
+}
+
+// recentBoltHits returns the hits after windowStart and their total weight
+func recentBoltHits(raw []byte, windowStart time.Time) ([]boltHit, int) {
+	var (
+		recent []boltHit
+		total  int
+	)
+	for _, hit := range decodeBoltHits(raw) {
+		if hit.at.After(windowStart) {
+			recent = append(recent, hit)
+			total += hit.weight
+		}
+	}
+	return recent, total
+}
+
+// record404 adds a 404 to the counts of ip within tx and returns its count
+func record404(tx *bolt.Tx, ip string, at time.Time, window time.Duration, weight int) (int, error) {
+	counts := tx.Bucket(boltCountsBucket)
+	hits, count := recentBoltHits(counts.Get([]byte(ip)), at.Add(-window))
+	hits = addBoltHit(hits, at, weight)
+	return count + weight, counts.Put([]byte(ip), encodeBoltHits(hits))
+}
+
+// Record404 implements BanStore. Concurrent calls share one transaction,
+// so a burst of 404s costs one write to disk rather than one each.
+func (s *BoltStore) Record404(ip string, at time.Time, window time.Duration, weight int) (int, error) {
+	count := 0
+
+	err := s.db.Batch(func(tx *bolt.Tx) error {
+		var err error
+		count, err = record404(tx, ip, at, window, weight)
+		return err
+	})
+
+	return count, err
+}
+
+// Record404Batch implements BatchRecordStore, writing the whole batch in
+// one transaction
+func (s *BoltStore) Record404Batch(entries []Record404Entry, window time.Duration) ([]int, error) {
+	counts := make([]int, len(entries))
+
+	err := s.db.Batch(func(tx *bolt.Tx) error {
+		for i, entry := range entries {
+			count, err := record404(tx, entry.IP, entry.At, window, entry.Weight)
+			if err != nil {
+				return err
+			}
+			counts[i] = count
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// Count404s implements BanStore
+func (s *BoltStore) Count404s(ip string, now time.Time, window time.Duration) (int, error) {
+	count := 0
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		_, count = recentBoltHits(tx.Bucket(boltCountsBucket).Get([]byte(ip)), now.Add(-window))
+		return nil
+	})
+
+	return count, err
+}
+
+// IsBanned implements BanStore
+func (s *BoltStore) IsBanned(ip string, now time.Time) (bool, error) {
+	_, banned, err := s.GetBan(ip, now)
+	return banned, err
+}
+
+// GetBan implements BanStore
+func (s *BoltStore) GetBan(ip string, now time.Time) (BanRecord, bool, error) {
+	var (
+		record BanRecord
+		found  bool
+	)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(boltBansBucket).Get([]byte(ip))
+		if raw == nil {
+			return nil
+		}
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return err
+		}
+		found = record.activeAt(now)
+		return nil
+	})
+	if !found {
+		record = BanRecord{}
+	}
+
+	return record, found, err
+}
+
+// Ban implements BanStore
+func (s *BoltStore) Ban(ip string, record BanRecord) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBansBucket).Put([]byte(ip), value)
+	})
+}
+
+// Unban implements BanStore
+func (s *BoltStore) Unban(ip string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBansBucket).Delete([]byte(ip))
+	})
+}
+
+// ListBans implements BanStore
+func (s *BoltStore) ListBans(now time.Time) (map[string]BanRecord, error) {
+	result := make(map[string]BanRecord)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBansBucket).ForEach(func(k, v []byte) error {
+			var record BanRecord
+			if json.Unmarshal(v, &record) != nil {
+				return nil
+			}
+			if record.activeAt(now) {
+				result[string(k)] = record
+			}
+			return nil
+		})
+	})
+
+	return result, err
+}
+
+// ClearCounts implements BanStore
+func (s *BoltStore) ClearCounts(ip string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltCountsBucket).Delete([]byte(ip))
+	})
+}
+
+// ListCounts implements BanStore
+func (s *BoltStore) ListCounts(now time.Time, window time.Duration) (map[string][]time.Time, error) {
+	windowStart := now.Add(-window)
+	result := make(map[string][]time.Time)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltCountsBucket).ForEach(func(k, v []byte) error {
+			// Callers count timestamps, so a weighted 404 appears weight times
+			hits, _ := recentBoltHits(v, windowStart)
+			for _, hit := range hits {
+				for range hit.weight {
+					result[string(k)] = append(result[string(k)], hit.at)
+				}
+			}
+			return nil
+		})
+	})
+
+	return result, err
+}
+
+// RecordOffense implements BanStore
+func (s *BoltStore) RecordOffense(ip string, at time.Time, memory time.Duration) (int, error) {
+	count := 0
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		offenses := tx.Bucket(boltOffensesBucket)
+		remembered := rememberedOffenses(decodeBoltTimes(offenses.Get([]byte(ip))), at)
+		remembered = append(remembered, at.Add(memory))
+		count = len(remembered)
+		return offenses.Put([]byte(ip), encodeBoltTimes(remembered))
+	})
+
+	return count, err
+}
+
+// ClearOffenses implements BanStore
+func (s *BoltStore) ClearOffenses(ip string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltOffensesBucket).Delete([]byte(ip))
+	})
+}
+
+// Cleanup implements BanStore
+func (s *BoltStore) Cleanup(now time.Time, window time.Duration) ([]string, error) {
+	windowCutoff := now.Add(-window)
+	var expired []string
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		// Clean up expired 404 counts
+		// (buckets can't be modified while iterating, so collect changes first)
+		counts := tx.Bucket(boltCountsBucket)
+		trimmed := make(map[string][]byte)
+		err := counts.ForEach(func(k, v []byte) error {
+			if valid, _ := recentBoltHits(v, windowCutoff); len(valid) < len(v)/boltHitSize {
+				trimmed[string(k)] = encodeBoltHits(valid)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for k, v := range trimmed {
+			if len(v) == 0 {
+				err = counts.Delete([]byte(k))
+			} else {
+				err = counts.Put([]byte(k), v)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		// Forget old offenses
+		offenses := tx.Bucket(boltOffensesBucket)
+		trimmed = make(map[string][]byte)
+		err = offenses.ForEach(func(k, v []byte) error {
+			if remembered := rememberedOffenses(decodeBoltTimes(v), now); len(remembered) < len(v)/8 {
+				trimmed[string(k)] = encodeBoltTimes(remembered)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for k, v := range trimmed {
+			if len(v) == 0 {
+				err = offenses.Delete([]byte(k))
+			} else {
+				err = offenses.Put([]byte(k), v)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		// Clean up expired bans
+		bans := tx.Bucket(boltBansBucket)
+		var expiredBans [][]byte
+		err = bans.ForEach(func(k, v []byte) error {
+			var record BanRecord
+			if json.Unmarshal(v, &record) != nil || record.ExpiresAt.Before(now) {
+				expiredBans = append(expiredBans, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expiredBans {
+			if err := bans.Delete(k); err != nil {
+				return err
+			}
+			expired = append(expired, string(k))
+		}
+
+		return nil
+	})
+
+	return expired, err
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math"
+	"slices"
+)
+
+// EventThresholdApproach is passed to OnThresholdApproach callbacks when an
+// IP's 404 count reaches the registered fraction of the threshold
+const EventThresholdApproach EventType = "threshold_approach"
+
+// thresholdCallback is a callback registered with OnThresholdApproach
+type thresholdCallback struct {
+	ratio float64
+	fn    func(Event)
+}
+
+// eventCallbacks holds functions registered by the application. It's never
+// modified once published; registering a callback swaps in a copy.
+type eventCallbacks struct {
+	onBan     []func(Event)
+	onUnban   []func(Event)
+	threshold []thresholdCallback
+}
+
+// addCallback publishes a copy of the callbacks changed by add
+func (t *IP404Tracker) addCallback(add func(c *eventCallbacks)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var c eventCallbacks
+	if current := t.callbacks.Load(); current != nil {
+		c = eventCallbacks{
+			onBan:     slices.Clone(current.onBan),
+			onUnban:   slices.Clone(current.onUnban),
+			threshold: slices.Clone(current.threshold),
+		}
+	}
+	add(&c)
+	t.callbacks.Store(&c)
+}
+
+// OnBan registers fn to be called whenever an IP or CIDR is banned.
+// Callbacks run synchronously on the goroutine issuing the ban, so slow work
+// should be handed off to another goroutine.
+func (t *IP404Tracker) OnBan(fn func(Event)) {
+	t.addCallback(func(c *eventCallbacks) { c.onBan = append(c.onBan, fn) })
+}
+
+// OnUnban registers fn to be called when a ban is lifted or expires
+func (t *IP404Tracker) OnUnban(fn func(Event)) {
+	t.addCallback(func(c *eventCallbacks) { c.onUnban = append(c.onUnban, fn) })
+}
+
+// OnThresholdApproach registers fn to be called when an IP's 404 count within
+// the window reaches ratio of the threshold that applies to it (e.g. 0.8 for
+// 80%), before it is banned
+func (t *IP404Tracker) OnThresholdApproach(ratio float64, fn func(Event)) {
+	t.addCallback(func(c *eventCallbacks) {
+		c.threshold = append(c.threshold, thresholdCallback{ratio: ratio, fn: fn})
+	})
+}
+
+// runCallbacks calls the callbacks registered for event
+func (t *IP404Tracker) runCallbacks(event Event) {
+	c := t.callbacks.Load()
+	if c == nil {
+		return
+	}
+
+	var fns []func(Event)
+	switch event.Type {
+	case EventBanned:
+		fns = c.onBan
+	case EventUnbanned, EventBanExpired:
+		fns = c.onUnban
+	case Event404Recorded:
+		threshold := event.Threshold
+		for _, cb := range c.threshold {
+			// Fire once, on the 404 that reaches the fraction, and never for the
+			// banning one; weighted 404s can jump past the fraction. Route
+			// policies have thresholds of their own.
+			limit := max(1, int(math.Ceil(cb.ratio*float64(threshold))))
+			if event.Rule == "" && event.Count >= limit && event.Count-max(1, event.Weight) < limit && event.Count <= threshold {
+				fns = append(fns, cb.fn)
+			}
+		}
+		event.Type = EventThresholdApproach
+	}
+
+	for _, fn := range fns {
+		fn(event)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EventCampaignDetected is emitted when enough distinct clients get a 404
+// for the same path to make it a campaign
+const EventCampaignDetected EventType = "campaign_detected"
+
+// Most paths tracked for campaigns at once; more wait for cleanup
+const maxCampaignPaths = 10000
+
+// CampaignConfig catches botnets spreading a scan over many addresses, each
+// staying under the threshold, by the paths they have in common
+type CampaignConfig struct {
+	Clients int           // Distinct clients that make a path a campaign
+	Window  time.Duration // Within which they have to ask for it (default 10m)
+
+	// How long a campaign stays active after its last 404 (default Window)
+	Duration time.Duration
+
+	// Ban every client asking for the path while the campaign is active
+	Ban bool
+
+	// Multiply every client's threshold while any campaign is active,
+	// e.g. 0.5 to halve it; 0 leaves thresholds alone
+	Tighten float64
+}
+
+// campaigns tracks which clients got a 404 for each path
+type campaigns struct {
+	cfg CampaignConfig
+
+	mu    sync.Mutex
+	paths map[string]*campaignPath
+	until time.Time // When the latest campaign ends
+}
+
+// campaignPath holds the recent clients of one path
+type campaignPath struct {
+	clients map[string]time.Time
+	until   time.Time // When the campaign ends; zero when there's none
+}
+
+// WithCampaignDetection emits an EventCampaignDetected when more than
+// cfg.Clients distinct clients get a 404 for the same path within
+// cfg.Window, and optionally bans the clients that follow or tightens
+// thresholds for everybody
+func WithCampaignDetection(cfg CampaignConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.Window <= 0 {
+			cfg.Window = 10 * time.Minute
+		}
+		if cfg.Duration <= 0 {
+			cfg.Duration = cfg.Window
+		}
+		t.campaigns = &campaigns{cfg: cfg, paths: make(map[string]*campaignPath)}
+	}
+}
+
+// record adds a 404 for path by key, reporting whether path is an active
+// campaign and whether this 404 started it and with how many clients
+func (c *campaigns) record(key, path string, now time.Time) (active, started bool, clients int) {
+	windowStart := now.Add(-c.cfg.Window)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.paths[path]
+	if p == nil {
+		if len(c.paths) >= maxCampaignPaths {
+			return false, false, 0
+		}
+		p = &campaignPath{clients: make(map[string]time.Time)}
+		c.paths[path] = p
+	}
+
+	if now.Before(p.until) {
+		p.until = now.Add(c.cfg.Duration)
+		c.until = later(c.until, p.until)
+		return true, false, len(p.clients)
+	}
+	p.clients[key] = now
+	if len(p.clients) <= c.cfg.Clients {
+		return false, false, len(p.clients)
+	}
+	for client, at := range p.clients {
+		if !at.After(windowStart) {
+			delete(p.clients, client)
+		}
+	}
+	if len(p.clients) <= c.cfg.Clients {
+		return false, false, len(p.clients)
+	}
+	p.until = now.Add(c.cfg.Duration)
+	c.until = later(c.until, p.until)
+	return true, true, len(p.clients)
+}
+
+// later returns the later of a and b
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// tighten applies the campaign factor to threshold while a campaign is on
+func (c *campaigns) tighten(threshold int, now time.Time) int {
+	if c == nil || c.cfg.Tighten <= 0 {
+		return threshold
+	}
+	c.mu.Lock()
+	until := c.until
+	c.mu.Unlock()
+	if !now.Before(until) {
+		return threshold
+	}
+	return max(1, int(float64(threshold)*c.cfg.Tighten))
+}
+
+// cleanup forgets clients that left the window and campaigns that ended
+func (c *campaigns) cleanup(now time.Time) {
+	if c == nil {
+		return
+	}
+	windowStart := now.Add(-c.cfg.Window)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path, p := range c.paths {
+		for client, at := range p.clients {
+			if !at.After(windowStart) {
+				delete(p.clients, client)
+			}
+		}
+		if len(p.clients) == 0 && !now.Before(p.until) {
+			delete(c.paths, path)
+		}
+	}
+}
+
+// Campaigns returns the paths of the active campaigns
+func (t *IP404Tracker) Campaigns() []string {
+	c := t.campaigns
+	if c == nil {
+		return nil
+	}
+	now := t.clock.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var paths []string
+	for path, p := range c.paths {
+		if now.Before(p.until) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// checkCampaign records a 404 for path by the tracking key for campaign
+// detection, and bans the client when it joins an active campaign that
+// bans, reporting whether it did
+func (t *IP404Tracker) checkCampaign(key, path string, now time.Time) bool {
+	c := t.campaigns
+	if c == nil || path == "" {
+		return false
+	}
+	active, started, clients := c.record(key, path, now)
+	if started {
+		reason := fmt.Sprintf("%d clients within %s", clients, c.cfg.Window)
+		t.logger.Warn("campaign detected", "path", path, "clients", clients, "window", c.cfg.Window)
+		t.emit(Event{Type: EventCampaignDetected, Reason: reason, Path: path, Paths: []string{path}, Count: clients})
+	}
+	if !active || started || !c.cfg.Ban {
+		return false
+	}
+	t.banAutomatic(key, path, 0, "", BanReasonCampaign+" "+path, now)
+	return true
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How often published CDN ranges are fetched again by default
+	cdnDefaultRefresh = 24 * time.Hour
+
+	// Delay before retrying a failed range fetch
+	cdnRetryInterval = 5 * time.Minute
+
+	// Upper bound for fetching one range list
+	cdnFetchTimeout = 10 * time.Second
+
+	// Largest range list read from a provider
+	cdnMaxListSize = 1 << 20
+)
+
+// CDNProvider describes a CDN that reports the client address in a request
+// header. The header is only believed on requests coming from the
+// provider's edge ranges.
+type CDNProvider struct {
+	Name   string // Used in logs
+	Header string // Header holding the client address
+
+	// Edge ranges known up front
+	Ranges []netip.Prefix
+
+	// URLs publishing the edge ranges, fetched on startup and refreshed
+	// periodically. Any text or JSON listing CIDRs works.
+	RangeURLs []string
+}
+
+var (
+	// CDNCloudflare trusts CF-Connecting-IP from Cloudflare's published ranges
+	CDNCloudflare = CDNProvider{
+		Name:      "cloudflare",
+		Header:    "CF-Connecting-IP",
+		RangeURLs: []string{"https://www.cloudflare.com/ips-v4", "https://www.cloudflare.com/ips-v6"},
+	}
+
+	// CDNFastly trusts Fastly-Client-IP from Fastly's published ranges
+	CDNFastly = CDNProvider{
+		Name:      "fastly",
+		Header:    "Fastly-Client-IP",
+		RangeURLs: []string{"https://api.fastly.com/public-ip-list"},
+	}
+)
+
+// CDNAkamai trusts True-Client-IP from the given Akamai ranges. Akamai doesn't
+// publish its edge ranges, so pass the Site Shield map of your property.
+func CDNAkamai(ranges ...netip.Prefix) CDNProvider {
+	return CDNProvider{Name: "akamai", Header: "True-Client-IP", Ranges: ranges}
+}
+
+// CDNConfig configures WithCDN
+type CDNConfig struct {
+	Providers []CDNProvider
+	Refresh   time.Duration // How often RangeURLs are fetched again (default 24h)
+	Client    *http.Client  // Client used for fetching (default has a 10s timeout)
+}
+
+// WithCDN takes the client address from the header of the CDN the request
+// came through. Requests whose peer isn't inside a provider's edge ranges
+// are tracked as usual, so a forged CF-Connecting-IP sent straight to the
+// origin is ignored. Combine with WithTrustedProxies when a load balancer
+// sits between the CDN and the application.
+func WithCDN(cfg CDNConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.Refresh <= 0 {
+			cfg.Refresh = cdnDefaultRefresh
+		}
+		if cfg.Client == nil {
+			cfg.Client = &http.Client{Timeout: cdnFetchTimeout}
+		}
+		t.cdn = &cdnResolver{
+			cfg:    cfg,
+			ranges: make([]*prefixTrie[struct{}], len(cfg.Providers)),
+		}
+	}
+}
+
+// cdnResolver holds the current edge ranges of every configured provider
+type cdnResolver struct {
+	cfg CDNConfig
+
+	mu     sync.RWMutex
+	ranges []*prefixTrie[struct{}] // Indexed like cfg.Providers
+}
+
+// clientIP returns the client address reported by the CDN peer is an edge
+// server of, if any
+func (c *cdnResolver) clientIP(peer netip.Addr, header func(name string) []string) (netip.Addr, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for i, provider := range c.cfg.Providers {
+		if c.ranges[i] == nil {
+			continue
+		}
+		if _, ok := c.ranges[i].lookup(peer); !ok {
+			continue
+		}
+		values := header(provider.Header)
+		if len(values) == 0 {
+			return netip.Addr{}, false
+		}
+		addr, err := netip.ParseAddr(strings.TrimSpace(values[0]))
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		return addr.Unmap(), true
+	}
+
+	return netip.Addr{}, false
+}
+
+// refreshAll fetches the ranges of every provider, keeping the previous
+// ranges of a provider whose fetch fails. It reports whether all succeeded.
+func (c *cdnResolver) refreshAll(logger Logger) bool {
+	ok := true
+	for i, provider := range c.cfg.Providers {
+		ranges := &prefixTrie[struct{}]{}
+		for _, prefix := range provider.Ranges {
+			ranges.insert(prefix.Masked(), struct{}{})
+		}
+
+		var errs []error
+		for _, url := range provider.RangeURLs {
+			prefixes, err := fetchRangeList(c.cfg.Client, url)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			for _, prefix := range prefixes {
+				ranges.insert(prefix.Masked(), struct{}{})
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			logger.Error("fetching CDN ranges failed", "provider", provider.Name, "error", err)
+			ok = false
+			c.mu.RLock()
+			stale := c.ranges[i] != nil
+			c.mu.RUnlock()
+			if stale {
+				continue
+			}
+		}
+
+		c.mu.Lock()
+		c.ranges[i] = ranges
+		c.mu.Unlock()
+		logger.Debug("CDN ranges loaded", "provider", provider.Name, "ranges", ranges.len())
+	}
+	return ok
+}
+
+// fetchRangeList downloads a published list of IPs and ranges
+func fetchRangeList(client *http.Client, url string) ([]netip.Prefix, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cdnFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: unexpected status %s", url, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, cdnMaxListSize))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	prefixes := parseRangeList(string(body))
+	if len(prefixes) == 0 {
+		return nil, fmt.Errorf("%s: no ranges found", url)
+	}
+	return prefixes, nil
+}
+
+// parseRangeList picks every IP and CIDR out of a text or JSON list,
+// skipping anything after a '#' or ';' on a line as a comment
+func parseRangeList(body string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, line := range strings.Split(body, "\n") {
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			hex := r >= '0' && r <= '9' || r >= 'a' && r <= 'f' || r >= 'A' && r <= 'F'
+			return !hex && r != '.' && r != ':' && r != '/'
+		})
+		for _, field := range fields {
+			// Bare numbers are IDs and dates, not addresses
+			if !strings.ContainsAny(field, ".:") {
+				continue
+			}
+			if prefix, err := parseIPOrCIDR(field); err == nil {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+	}
+	return prefixes
+}
+
+// cdnRefreshLoop keeps the CDN ranges up to date; loaded tells whether the
+// initial fetch succeeded
+func (t *IP404Tracker) cdnRefreshLoop(loaded bool) {
+	for {
+		delay := t.cdn.cfg.Refresh
+		if !loaded {
+			delay = min(delay, cdnRetryInterval)
+		}
+		if !t.sleep(delay) {
+			return
+		}
+		loaded = t.cdn.refreshAll(t.logger)
+	}
+}
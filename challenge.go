@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ChallengeProvider selects the CAPTCHA service banned clients solve
+type ChallengeProvider string
+
+const (
+	// ChallengeTurnstile uses Cloudflare Turnstile
+	ChallengeTurnstile ChallengeProvider = "turnstile"
+	// ChallengeRecaptcha uses Google reCAPTCHA v2
+	ChallengeRecaptcha ChallengeProvider = "recaptcha"
+)
+
+// defaultChallengePath is where the challenge page is served
+const defaultChallengePath = "/.well-known/404blocker/challenge"
+
+// ChallengeConfig lets clients that were banned for their own 404s lift the
+// ban by solving a CAPTCHA, for real users caught behind a shared address.
+// Manual bans and blacklist, range, feed and GeoIP blocks can't be solved away.
+type ChallengeConfig struct {
+	Provider ChallengeProvider // Default ChallengeTurnstile
+	SiteKey  string
+	Secret   string
+
+	// Path of the challenge page (default "/.well-known/404blocker/challenge")
+	Path string
+
+	// Also put the client on probation once the ban is lifted; needs
+	// WithProbation
+	Probation bool
+
+	Timeout   time.Duration // Verification request timeout (default 10s)
+	VerifyURL string        // Override the provider's siteverify endpoint
+}
+
+// challenge holds the challenge settings and verification client
+type challenge struct {
+	cfg    ChallengeConfig
+	client *http.Client
+}
+
+// WithChallenge redirects banned browsers to a CAPTCHA that lifts their ban
+func WithChallenge(cfg ChallengeConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.Provider == "" {
+			cfg.Provider = ChallengeTurnstile
+		}
+		if cfg.Path == "" {
+			cfg.Path = defaultChallengePath
+		}
+		if cfg.Timeout <= 0 {
+			cfg.Timeout = 10 * time.Second
+		}
+		if cfg.VerifyURL == "" {
+			cfg.VerifyURL = challengeWidgets[cfg.Provider].verifyURL
+		}
+		t.challenge = &challenge{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+	}
+}
+
+// challengeWidget holds what differs between the CAPTCHA providers
+type challengeWidget struct {
+	script    string // Widget script
+	class     string // Class of the widget element
+	field     string // Form field holding the solved token
+	verifyURL string
+}
+
+// challengeWidgets are the supported providers
+var challengeWidgets = map[ChallengeProvider]challengeWidget{
+	ChallengeTurnstile: {
+		script:    "https://challenges.cloudflare.com/turnstile/v0/api.js",
+		class:     "cf-turnstile",
+		field:     "cf-turnstile-response",
+		verifyURL: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+	},
+	ChallengeRecaptcha: {
+		script:    "https://www.google.com/recaptcha/api.js",
+		class:     "g-recaptcha",
+		field:     "g-recaptcha-response",
+		verifyURL: "https://www.google.com/recaptcha/api/siteverify",
+	},
+}
+
+// challengeable reports whether a blocked request can be sent to the
+// challenge: only page loads of clients banned for their own 404s, not by
+// an operator
+func (t *IP404Tracker) challengeable(blocked BlockedRequest) bool {
+	if t.challenge == nil || blocked.BanType != "ip" && blocked.BanType != "key" || !solvableBan(blocked.Reason) {
+		return false
+	}
+	return blocked.Method == http.MethodGet || blocked.Method == http.MethodHead
+}
+
+// solvableBan reports whether a ban with the given reason may be lifted by
+// solving the challenge. Manual bans are an operator's call, and the
+// permanent bans of repeat offenders mustn't be worth a solving service's
+// few cents.
+func solvableBan(reason string) bool {
+	return reason != BanReasonManual && reason != BanReasonRepeatOffender
+}
+
+// challengeRedirect sends a blocked request to the challenge page
+func (t *IP404Tracker) challengeRedirect(blocked BlockedRequest) BanResponse {
+	location := t.challenge.cfg.Path + "?return=" + url.QueryEscape(blocked.Path)
+	return BanResponse{
+		Status:  http.StatusSeeOther,
+		Headers: map[string]string{"Location": location, "Cache-Control": "no-store"},
+	}
+}
+
+// isChallengePath reports whether a request is for the CAPTCHA or the
+// cookie challenge, which the adapters hand to serveChallengePath
+func (t *IP404Tracker) isChallengePath(path string) bool {
+	return t.challenge != nil && path == t.challenge.cfg.Path ||
+		t.cookieChallenge != nil && path == t.cookieChallenge.cfg.Path
+}
+
+// serveChallengePath answers requests for the challenge endpoints
+func (t *IP404Tracker) serveChallengePath(w http.ResponseWriter, r *http.Request, req clientRequest) {
+	if t.challenge != nil && req.path == t.challenge.cfg.Path {
+		t.serveChallenge(w, r, req)
+		return
+	}
+	t.serveCookieChallenge(w, r, req)
+}
+
+// serveChallenge shows the challenge page and checks the solutions posted
+// back to it
+func (t *IP404Tracker) serveChallenge(w http.ResponseWriter, r *http.Request, req clientRequest) {
+	c := t.challenge
+	returnTo := safeReturnPath(r.FormValue("return"))
+
+	// Which ban the client would be lifting; fingerprints are covered by bans of their address
+	banKey := req.key
+	record, banned := t.GetBanInfo(banKey)
+	if !banned && fingerprinted(req.key) {
+		banKey = req.ip
+		record, banned = t.GetBanInfo(banKey)
+	}
+	if !banned || !solvableBan(record.Reason) {
+		http.Redirect(w, r, returnTo, http.StatusSeeOther)
+		return
+	}
+
+	failed := false
+	if r.Method == http.MethodPost {
+		ok, err := c.verify(r.FormValue(challengeWidgets[c.cfg.Provider].field), req.ip)
+		if err != nil {
+			t.logger.Error("verifying challenge failed", "ip", req.ip, "error", err)
+		}
+		if ok {
+			t.solveChallenge(banKey)
+			http.Redirect(w, r, returnTo, http.StatusSeeOther)
+			return
+		}
+		failed = true
+	}
+
+	widget := challengeWidgets[c.cfg.Provider]
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusForbidden)
+	err := challengePage.Execute(w, map[string]any{
+		"Action":  c.cfg.Path,
+		"Return":  returnTo,
+		"SiteKey": c.cfg.SiteKey,
+		"Script":  widget.script,
+		"Class":   widget.class,
+		"Failed":  failed,
+	})
+	if err != nil {
+		t.logger.Error("rendering challenge page failed", "error", err)
+	}
+}
+
+// solveChallenge lifts the ban on key, or turns it into probation. Past
+// offenses are kept either way, so escalation still catches a scanner that
+// pays for its solutions.
+func (t *IP404Tracker) solveChallenge(key string) {
+	if !t.unban(key, BanReasonChallenge) {
+		return
+	}
+
+	// Let the 404 count start again from zero
+	key = t.trackingKey(key)
+	t.clearCounters(key)
+	if t.challenge.cfg.Probation {
+		t.startProbation(key, t.clock.Now())
+	}
+}
+
+// verify asks the provider whether token is a valid solution
+func (c *challenge) verify(token, remoteIP string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+	resp, err := c.client.PostForm(c.cfg.VerifyURL, url.Values{
+		"secret":   {c.cfg.Secret},
+		"response": {token},
+		"remoteip": {remoteIP},
+	})
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("siteverify returned %s", resp.Status)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	// Wrong or stale solutions are the visitor's problem; anything else is ours
+	for _, code := range result.ErrorCodes {
+		if !challengeClientErrors[code] {
+			return false, fmt.Errorf("siteverify: %s", strings.Join(result.ErrorCodes, ", "))
+		}
+	}
+	return result.Success, nil
+}
+
+// challengeClientErrors are the siteverify error codes caused by the visitor
+var challengeClientErrors = map[string]bool{
+	"missing-input-response": true,
+	"invalid-input-response": true,
+	"timeout-or-duplicate":   true,
+}
+
+// safeReturnPath keeps redirects after the challenge on this site
+func safeReturnPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
+	}
+	return path
+}
+
+// challengePage is the page banned clients solve the CAPTCHA on
+var challengePage = template.Must(template.New("challenge").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Checking your browser</title>
+<script src="{{.Script}}" async defer></script>
+</head>
+<body>
+<h2>One more step</h2>
+<p>Too many requests from your network went to pages that don't exist. Complete the check below to continue.</p>
+{{if .Failed}}<p><strong>That didn't work, please try again.</strong></p>{{end}}
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="return" value="{{.Return}}">
+<div class="{{.Class}}" data-sitekey="{{.SiteKey}}"></div>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// chatBuffer is how many events a chat notifier may lag behind
+const chatBuffer = 1024
+
+// chatEvents are the event types a chat notifier sends messages for
+var chatEvents = []EventType{EventBanned, EventThresholdExceeded, EventCampaignDetected, EventCircuitTripped}
+
+// ChatConfig configures a Slack or Discord incoming webhook notifier
+type ChatConfig struct {
+	WebhookURL string // Incoming webhook URL
+
+	// Alert when at least this many requests are blocked within
+	// BlockedRequestWindow (0 disables volume alerts)
+	BlockedRequestThreshold int
+	BlockedRequestWindow    time.Duration // Default 1m
+
+	// Minimum time between two messages (default 1m). Bans during the cooldown
+	// are summarized in the next message instead of being sent one by one.
+	MinInterval time.Duration
+}
+
+// WithSlack posts a message to a Slack incoming webhook when an IP is banned
+// or banned-request volume crosses the configured threshold
+func WithSlack(cfg ChatConfig) Option {
+	return withChat("slack", cfg, func(text string) any {
+		return map[string]string{"text": text}
+	})
+}
+
+// WithDiscord posts a message to a Discord webhook when an IP is banned or
+// banned-request volume crosses the configured threshold
+func WithDiscord(cfg ChatConfig) Option {
+	return withChat("discord", cfg, func(text string) any {
+		return map[string]string{"content": text}
+	})
+}
+
+func withChat(name string, cfg ChatConfig, payload func(string) any) Option {
+	return func(t *IP404Tracker) {
+		if cfg.BlockedRequestWindow <= 0 {
+			cfg.BlockedRequestWindow = time.Minute
+		}
+		if cfg.MinInterval <= 0 {
+			cfg.MinInterval = time.Minute
+		}
+		t.chatNotifiers = append(t.chatNotifiers, &chatNotifier{
+			name:    name,
+			cfg:     cfg,
+			payload: payload,
+			client:  &http.Client{Timeout: 5 * time.Second},
+		})
+	}
+}
+
+// chatNotifier sends rate-limited messages to one chat webhook
+type chatNotifier struct {
+	name    string
+	cfg     ChatConfig
+	payload func(text string) any
+	client  *http.Client
+
+	lastSent time.Time
+	pending  []string // Messages held back by the rate limit
+}
+
+// queue adds a message and sends everything pending if the rate limit allows
+func (n *chatNotifier) queue(t *IP404Tracker, text string) {
+	if text != "" {
+		n.pending = append(n.pending, text)
+	}
+	now := t.clock.Now()
+	if len(n.pending) == 0 || now.Sub(n.lastSent) < n.cfg.MinInterval {
+		return
+	}
+
+	message := n.pending[0]
+	if len(n.pending) > 1 {
+		message = fmt.Sprintf("%s\n…and %d more alerts since the last message", n.pending[len(n.pending)-1], len(n.pending)-1)
+	}
+	n.pending = nil
+	n.lastSent = now
+
+	body, err := json.Marshal(n.payload(message))
+	if err != nil {
+		t.logger.Error("encoding chat message failed", "notifier", n.name, "error", err)
+		return
+	}
+	resp, err := n.client.Post(n.cfg.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.logger.Error("sending chat message failed", "notifier", n.name, "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		t.logger.Error("sending chat message failed", "notifier", n.name, "status", resp.Status)
+	}
+}
+
+// banMessage describes a ban for humans
+func banMessage(event Event) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, ":no_entry: Banned %s until %s", event.IP, event.ExpiresAt.Format(time.RFC1123))
+	if event.Reason != "" {
+		fmt.Fprintf(&b, " (%s", event.Reason)
+		if event.Count > 0 {
+			fmt.Fprintf(&b, ", %d %s", event.Count, countUnit(event))
+		}
+		b.WriteString(")")
+	}
+	if len(event.Paths) > 0 {
+		fmt.Fprintf(&b, "\nPaths: %s", strings.Join(event.Paths, ", "))
+	}
+	return b.String()
+}
+
+// alertMessage describes a status rule alert for humans
+func alertMessage(event Event) string {
+	return fmt.Sprintf(":warning: %s: %s (%d responses, last %s)", event.IP, event.Reason, event.Count, event.Path)
+}
+
+// campaignMessage formats a campaign alert
+func campaignMessage(event Event) string {
+	return fmt.Sprintf(":rotating_light: campaign against %s: %s", event.Path, event.Reason)
+}
+
+// circuitMessage formats a circuit breaker alert
+func circuitMessage(event Event) string {
+	return fmt.Sprintf(":rotating_light: 404 surge across all clients: %s, tightening thresholds", event.Reason)
+}
+
+// countUnit names what an event's count counted: 404s, or the responses a
+// status rule weighed
+func countUnit(event Event) string {
+	if event.Rule == "" {
+		return "404s"
+	}
+	return "responses"
+}
+
+// chatLoop forwards bans, status rule, campaign and circuit breaker alerts and
+// blocked-request volume alerts to one notifier
+func (t *IP404Tracker) chatLoop(n *chatNotifier, events <-chan Event) {
+	ticker, stop := t.clock.NewTicker(n.cfg.BlockedRequestWindow)
+	defer stop()
+
+	lastBlocked := t.counters.blockedRequests.Load()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// Shutting down: send what the rate limit held back
+				n.lastSent = time.Time{}
+				n.queue(t, "")
+				return
+			}
+			switch event.Type {
+			case EventBanned:
+				n.queue(t, banMessage(event))
+			case EventThresholdExceeded:
+				n.queue(t, alertMessage(event))
+			case EventCampaignDetected:
+				n.queue(t, campaignMessage(event))
+			case EventCircuitTripped:
+				n.queue(t, circuitMessage(event))
+			}
+
+		case <-ticker:
+			blocked := t.counters.blockedRequests.Load()
+			delta := blocked - lastBlocked
+			lastBlocked = blocked
+			if n.cfg.BlockedRequestThreshold > 0 && delta >= uint64(n.cfg.BlockedRequestThreshold) {
+				n.queue(t, fmt.Sprintf(":warning: %d requests from banned clients in the last %s", delta, n.cfg.BlockedRequestWindow))
+			} else {
+				n.queue(t, "") // Flush anything held back by the rate limit
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import "net/http"
+
+// CheckRoutes serves CheckHandler at path, e.g. "/check", in front of next.
+// Checks never reach next, so they aren't tracked as requests of the proxy.
+func (t *IP404Tracker) CheckRoutes(next http.Handler, path string) http.Handler {
+	check := t.CheckHandler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == path {
+			check.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CheckHandler answers access checks from a reverse proxy in front of
+// services that can't use the middleware: 200 when the client may pass and
+// 403 when it's banned. It works as an nginx auth_request or a Traefik
+// ForwardAuth target. The client address comes from the forwarding headers
+// of the proxy, which must be listed in WithTrustedProxies; the original
+// path is read from X-Original-URI (nginx) or X-Forwarded-Uri (Traefik).
+//
+// Like Middleware, every blocked check renews a rolling ban and counts as a
+// blocked request.
+func (t *IP404Tracker) CheckHandler() http.Handler {
+	if !t.resolvesClientIP() {
+		t.logger.Warn("check endpoint used without trusted proxies; it will check the proxy's own address")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientIP := remoteIP(r)
+		if t.resolvesClientIP() {
+			clientIP = t.clientIP(clientIP, r.Header.Values)
+		}
+
+		path := r.Header.Get("X-Original-URI")
+		if path == "" {
+			path = r.Header.Get("X-Forwarded-Uri")
+		}
+		req := clientRequest{
+			ctx:            r.Context(),
+			ip:             clientIP,
+			path:           path,
+			userAgent:      r.UserAgent(),
+			acceptLanguage: r.Header.Get("Accept-Language"),
+		}
+		var key string
+		if t.httpKeyFunc != nil {
+			key = t.httpKeyFunc(r)
+		}
+		req.key = t.requestKey(req, key)
+
+		// No body: the proxy only looks at the status
+		if _, blocked := t.blockBanned(req); blocked {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
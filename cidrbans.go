@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net/netip"
+	"time"
+)
+
+// normalizePrefix masks prefix and turns IPv4-mapped IPv6 ranges into IPv4
+func normalizePrefix(prefix netip.Prefix) netip.Prefix {
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked()
+}
+
+// BanCIDR manually bans every address in prefix for the given duration.
+// Range bans are matched with a prefix trie, so even many large ranges
+// don't slow down the per-request ban check.
+func (t *IP404Tracker) BanCIDR(prefix netip.Prefix, duration time.Duration) {
+	prefix = normalizePrefix(prefix)
+	now := t.clock.Now()
+	until := now.Add(duration)
+	geo := t.geoLookup(prefix.String())
+
+	t.banCIDR(prefix, BanRecord{
+		BannedAt:  now,
+		ExpiresAt: until,
+		Reason:    BanReasonManual,
+		Source:    BanSourceManual,
+		GeoInfo:   geo,
+	})
+	t.counters.bansIssued.Add(1)
+
+	t.logger.Info("ban issued", "ip", prefix.String(), "expires_at", until, "manual", true)
+	t.emit(Event{Type: EventBanned, IP: prefix.String(), Reason: BanReasonManual, ExpiresAt: until, GeoInfo: geo})
+}
+
+// UnbanCIDR lifts a ban previously placed with BanCIDR
+func (t *IP404Tracker) UnbanCIDR(prefix netip.Prefix) {
+	prefix = normalizePrefix(prefix)
+
+	t.mu.Lock()
+	removed := t.cidrBans.remove(prefix)
+	t.listsChanged()
+	t.mu.Unlock()
+
+	t.publish(BanActionUnbanCIDR, prefix.String(), nil)
+	if removed {
+		t.logger.Info("ban lifted", "ip", prefix.String())
+		t.emit(Event{Type: EventUnbanned, IP: prefix.String(), Reason: BanReasonManual})
+	}
+}
+
+// banCIDR stores a range ban and broadcasts it to the other instances
+func (t *IP404Tracker) banCIDR(prefix netip.Prefix, record BanRecord) {
+	t.mu.Lock()
+	t.cidrBans.insert(prefix, record)
+	t.listsChanged()
+	t.mu.Unlock()
+
+	t.publish(BanActionBanCIDR, prefix.String(), &record)
+}
+
+// applyCIDRBan stores a range ban received from another instance unless
+// ours already lasts longer, reporting whether it did
+func (t *IP404Tracker) applyCIDRBan(event BanEvent) bool {
+	prefix, err := netip.ParsePrefix(event.IP)
+	if err != nil || !event.Until.After(t.clock.Now()) {
+		return false
+	}
+	prefix = normalizePrefix(prefix)
+	record := BanRecord{ExpiresAt: event.Until}
+	if event.Record != nil {
+		record = *event.Record
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if current, ok := t.cidrBans.get(prefix); ok && !record.ExpiresAt.After(current.ExpiresAt) {
+		return false
+	}
+	t.cidrBans.insert(prefix, record)
+	t.listsChanged()
+	return true
+}
+
+// applyCIDRUnban lifts a range ban on behalf of another instance,
+// reporting whether there was one
+func (t *IP404Tracker) applyCIDRUnban(event BanEvent) bool {
+	prefix, err := netip.ParsePrefix(event.IP)
+	if err != nil {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	removed := t.cidrBans.remove(normalizePrefix(prefix))
+	t.listsChanged()
+	return removed
+}
+
+// GetBannedCIDRs returns the currently banned ranges and their ban expiry times
+func (t *IP404Tracker) GetBannedCIDRs() map[netip.Prefix]time.Time {
+	now := t.clock.Now()
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	result := make(map[netip.Prefix]time.Time)
+	t.cidrBans.walk(func(prefix netip.Prefix, record BanRecord) {
+		if record.activeAt(now) {
+			result[prefix] = record.ExpiresAt
+		}
+	})
+
+	return result
+}
+
+// GetCIDRBanInfo returns why and until when a range is banned
+func (t *IP404Tracker) GetCIDRBanInfo(prefix netip.Prefix) (BanRecord, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	record, ok := t.cidrBans.get(normalizePrefix(prefix))
+	if !ok || !record.activeAt(t.clock.Now()) {
+		return BanRecord{}, false
+	}
+	return record, true
+}
+
+// cidrBanned checks if ip falls inside a banned range
+func (t *IP404Tracker) cidrBanned(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	now := t.clock.Now()
+
+	// A longer range may have expired while a shorter one still applies
+	banned := false
+	t.lists().cidrBans.lookupAll(addr, func(_ netip.Prefix, record BanRecord) bool {
+		banned = record.activeAt(now)
+		return !banned
+	})
+
+	return banned
+}
+
+// cleanupCIDRBans removes expired range bans and returns them
+func (t *IP404Tracker) cleanupCIDRBans() []string {
+	now := t.clock.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var expired []netip.Prefix
+	t.cidrBans.walk(func(prefix netip.Prefix, record BanRecord) {
+		if record.ExpiresAt.Before(now) {
+			expired = append(expired, prefix)
+		}
+	})
+
+	result := make([]string, 0, len(expired))
+	for _, prefix := range expired {
+		t.cidrBans.remove(prefix)
+		result = append(result, prefix.String())
+	}
+	if len(expired) > 0 {
+		t.listsChanged()
+	}
+
+	return result
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EventCircuitTripped is emitted when the 404 rate across all clients trips
+// the circuit breaker
+const EventCircuitTripped EventType = "circuit_tripped"
+
+// circuitBuckets is how many slices the breaker's window is counted in
+const circuitBuckets = 10
+
+// CircuitBreakerConfig switches every client to a stricter policy while the
+// site as a whole gets more 404s than usual, which is what a scan spread
+// over many addresses looks like
+type CircuitBreakerConfig struct {
+	Rate   int           // 404s across all clients within Window that trip the breaker
+	Window time.Duration // Default 1m
+
+	// How long the stricter policy stays after the rate falls back (default 10m)
+	Cooldown time.Duration
+
+	Threshold   int           // Per-client threshold while tripped (default half the usual one)
+	BanDuration time.Duration // Shortest ban while tripped (default twice the usual one)
+}
+
+// circuitBreaker counts the 404s of all clients in slices of its window
+type circuitBreaker struct {
+	cfg CircuitBreakerConfig
+
+	mu     sync.Mutex
+	counts [circuitBuckets]int
+	starts [circuitBuckets]time.Time
+	until  time.Time // When the stricter policy ends
+}
+
+// WithCircuitBreaker lowers every client's threshold and lengthens bans for
+// cfg.Cooldown once more than cfg.Rate 404s arrive within cfg.Window,
+// emitting an EventCircuitTripped when it trips
+func WithCircuitBreaker(cfg CircuitBreakerConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.Window <= 0 {
+			cfg.Window = time.Minute
+		}
+		if cfg.Cooldown <= 0 {
+			cfg.Cooldown = 10 * time.Minute
+		}
+		t.breaker = &circuitBreaker{cfg: cfg}
+	}
+}
+
+// record counts a 404 at now, reporting whether it tripped the breaker and
+// how many 404s the window holds
+func (b *circuitBreaker) record(now time.Time) (tripped bool, rate int) {
+	width := b.cfg.Window / circuitBuckets
+	start := now.Truncate(width)
+	i := int(start.UnixNano()/int64(width)) % circuitBuckets
+	windowStart := now.Add(-b.cfg.Window)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.starts[i].Equal(start) {
+		b.starts[i] = start
+		b.counts[i] = 0
+	}
+	b.counts[i]++
+	for j, count := range b.counts {
+		if b.starts[j].After(windowStart) {
+			rate += count
+		}
+	}
+	if rate <= b.cfg.Rate {
+		return false, rate
+	}
+	tripped = !now.Before(b.until)
+	b.until = now.Add(b.cfg.Cooldown)
+	return tripped, rate
+}
+
+// open reports whether the stricter policy is in force at now
+func (b *circuitBreaker) open(now time.Time) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return now.Before(b.until)
+}
+
+// tighten returns the threshold that applies while the breaker is tripped
+func (b *circuitBreaker) tighten(threshold int, now time.Time) int {
+	if !b.open(now) {
+		return threshold
+	}
+	if b.cfg.Threshold > 0 {
+		return min(threshold, b.cfg.Threshold)
+	}
+	return max(1, threshold/2)
+}
+
+// lengthen returns the duration of a ban issued at now
+func (b *circuitBreaker) lengthen(duration time.Duration, now time.Time) time.Duration {
+	if !b.open(now) {
+		return duration
+	}
+	if b.cfg.BanDuration > 0 {
+		return max(duration, b.cfg.BanDuration)
+	}
+	return 2 * duration
+}
+
+// CircuitTripped reports whether the circuit breaker's stricter policy is in force
+func (t *IP404Tracker) CircuitTripped() bool {
+	return t.breaker.open(t.clock.Now())
+}
+
+// checkCircuit counts a 404 toward the site-wide rate
+func (t *IP404Tracker) checkCircuit(path string, now time.Time) {
+	b := t.breaker
+	if b == nil {
+		return
+	}
+	tripped, rate := b.record(now)
+	if !tripped {
+		return
+	}
+	reason := fmt.Sprintf("%d 404s within %s", rate, b.cfg.Window)
+	t.logger.Warn("circuit breaker tripped", "rate", rate, "window", b.cfg.Window, "cooldown", b.cfg.Cooldown)
+	t.emit(Event{Type: EventCircuitTripped, Reason: reason, Path: path, Count: rate})
+}
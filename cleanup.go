@@ -0,0 +1,52 @@
+package main
+
+import "time"
+
+// CleanupConfig sets how often expired 404 counts, bans and other per-client
+// state are removed. The interval shrinks as more clients are tracked, so a
+// busy instance sweeps more often and never holds much stale state.
+type CleanupConfig struct {
+	Interval    time.Duration // Time between cleanups when few clients are tracked (default 5m)
+	MinInterval time.Duration // Time between cleanups at HighWater or above (default 30s); Interval keeps it fixed
+	HighWater   int           // Tracked clients at which MinInterval is reached (default 10000)
+}
+
+// Default cleanup timings
+const (
+	defaultCleanupInterval    = 5 * time.Minute
+	defaultCleanupMinInterval = 30 * time.Second
+	defaultCleanupHighWater   = 10000
+)
+
+// WithCleanup configures the background cleanup
+func WithCleanup(cfg CleanupConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.Interval <= 0 {
+			cfg.Interval = defaultCleanupInterval
+		}
+		if cfg.MinInterval <= 0 {
+			cfg.MinInterval = min(defaultCleanupMinInterval, cfg.Interval)
+		}
+		cfg.MinInterval = min(cfg.MinInterval, cfg.Interval)
+		if cfg.HighWater <= 0 {
+			cfg.HighWater = defaultCleanupHighWater
+		}
+		t.cleanupCfg = cfg
+	}
+}
+
+// cleanupDelay returns how long to wait before the next cleanup, scaling
+// linearly from Interval with nothing tracked down to MinInterval at HighWater
+func (t *IP404Tracker) cleanupDelay() time.Duration {
+	cfg := t.cleanupCfg
+	if cfg.MinInterval == cfg.Interval {
+		return cfg.Interval
+	}
+
+	tracked := t.trackedIPs()
+	if tracked >= cfg.HighWater {
+		return cfg.MinInterval
+	}
+	span := cfg.Interval - cfg.MinInterval
+	return cfg.Interval - time.Duration(float64(span)*float64(tracked)/float64(cfg.HighWater))
+}
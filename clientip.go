@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProxyConfig tells the tracker which reverse proxies may report the client
+// address, so it bans the client rather than the proxy and can't be fooled by
+// a forged header
+type ProxyConfig struct {
+	// Proxies whose forwarding headers are believed, e.g. the load balancer's subnet
+	TrustedProxies []netip.Prefix
+
+	// Headers carrying the client address, checked in order (defaults to
+	// X-Forwarded-For, then X-Real-IP)
+	Headers []string
+}
+
+// WithTrustedProxies makes every middleware take the client address from the
+// forwarding headers set by the trusted proxies in cfg, instead of relying on
+// the web framework's own (often trust-everyone) defaults. Requests arriving
+// directly from anywhere else are tracked by their connection address.
+func WithTrustedProxies(cfg ProxyConfig) Option {
+	return func(t *IP404Tracker) {
+		if len(cfg.Headers) == 0 {
+			cfg.Headers = []string{"X-Forwarded-For", "X-Real-IP"}
+		}
+		t.proxies = &cfg
+		t.trustedProxies = &prefixTrie[struct{}]{}
+		for _, prefix := range cfg.TrustedProxies {
+			t.trustedProxies.insert(prefix.Masked(), struct{}{})
+		}
+	}
+}
+
+// resolvesClientIP reports whether the tracker rather than the web framework
+// decides the client address
+func (t *IP404Tracker) resolvesClientIP() bool {
+	return t.proxies != nil || t.cdn != nil
+}
+
+// isTrustedProxy reports whether addr belongs to a trusted proxy
+func (t *IP404Tracker) isTrustedProxy(addr netip.Addr) bool {
+	if t.trustedProxies == nil {
+		return false
+	}
+	_, ok := t.trustedProxies.lookup(addr)
+	return ok
+}
+
+// clientIP works out the client address of a request that arrived from
+// remoteIP. header returns every value of a request header.
+func (t *IP404Tracker) clientIP(remoteIP string, header func(name string) []string) string {
+	peer, err := netip.ParseAddr(remoteIP)
+	if err != nil {
+		return remoteIP
+	}
+	peer = peer.Unmap()
+
+	// Look past our own proxies first, then past the CDN edge they talked to
+	if t.isTrustedProxy(peer) {
+		if hop, ok := t.forwardedFor(header); ok {
+			peer = hop
+		}
+	}
+	if t.cdn != nil {
+		if addr, ok := t.cdn.clientIP(peer, header); ok {
+			return addr.String()
+		}
+	}
+
+	return peer.String()
+}
+
+// forwardedFor returns the nearest untrusted hop in the forwarding
+// headers of a request that came from a trusted proxy
+func (t *IP404Tracker) forwardedFor(header func(name string) []string) (netip.Addr, bool) {
+	for _, name := range t.proxies.Headers {
+		var hops []netip.Addr
+		for _, value := range header(name) {
+			for _, hop := range strings.Split(value, ",") {
+				if addr, err := netip.ParseAddr(strings.TrimSpace(hop)); err == nil {
+					hops = append(hops, addr.Unmap())
+				}
+			}
+		}
+		if len(hops) == 0 {
+			continue
+		}
+
+		// Each proxy appends the address it received the request from, so
+		// the nearest untrusted hop is the client; anything further left
+		// could have been made up by the client itself
+		for i := len(hops) - 1; i >= 0; i-- {
+			if !t.isTrustedProxy(hops[i]) {
+				return hops[i], true
+			}
+		}
+		return hops[0], true
+	}
+
+	return netip.Addr{}, false
+}
+
+// ginClientIP returns the client address of a Gin request
+func (t *IP404Tracker) ginClientIP(c *gin.Context) string {
+	if !t.resolvesClientIP() {
+		return c.ClientIP()
+	}
+	return t.clientIP(c.RemoteIP(), c.Request.Header.Values)
+}
@@ -0,0 +1,43 @@
+package main
+
+import "time"
+
+// Clock tells the tracker the time and drives its periodic work. The default
+// reads the system clock; tests can swap in blockertest.FakeClock to move
+// windows and ban expiry forward without waiting.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// NewTicker returns a channel receiving the time every d, and a function
+	// that stops it
+	NewTicker(d time.Duration) (<-chan time.Time, func())
+}
+
+// systemClock is the Clock backed by the time package
+type systemClock struct{}
+
+// Now implements Clock
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTicker implements Clock
+func (systemClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
+
+// clockUser is implemented by stores that need the tracker's clock, e.g.
+// to turn a ban's expiry into a TTL
+type clockUser interface {
+	useClock(clock Clock)
+}
+
+// WithClock makes the tracker read the time from clock instead of the
+// system clock
+func WithClock(clock Clock) Option {
+	return func(t *IP404Tracker) {
+		t.clock = clock
+	}
+}
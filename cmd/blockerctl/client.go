@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Ban is an active ban as the admin APIs describe it
+type Ban struct {
+	Target    string    `json:"target"`
+	BannedAt  time.Time `json:"banned_at,omitzero"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Reason    string    `json:"reason,omitempty"`
+	Count     int64     `json:"count,omitempty"`
+	Rule      string    `json:"rule,omitempty"`
+	Offense   int64     `json:"offense,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	Country   string    `json:"country,omitempty"`
+}
+
+// Whitelist holds the whitelist entries and when temporary ones expire
+type Whitelist struct {
+	Entries   []string             `json:"whitelist"`
+	Temporary map[string]time.Time `json:"temporary"`
+}
+
+// Stats are the tracker's current totals
+type Stats struct {
+	TrackedIPs      int64  `json:"tracked_ips"`
+	ActiveBans      int64  `json:"active_bans"`
+	Total404s       uint64 `json:"total_404s"`
+	BlockedRequests uint64 `json:"blocked_requests"`
+	MemoryBytes     int64  `json:"memory_bytes"`
+}
+
+// Event is a tracker event
+type Event struct {
+	Type      string    `json:"type"`
+	IP        string    `json:"ip"`
+	Reason    string    `json:"reason,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Count     int64     `json:"count,omitempty"`
+	Rule      string    `json:"rule,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	Time      time.Time `json:"time"`
+}
+
+// Client talks to a tracker's admin API, over gRPC or REST
+type Client interface {
+	ListBans(ctx context.Context) ([]Ban, error)
+	Ban(ctx context.Context, target string, duration time.Duration) (Ban, error)
+	Unban(ctx context.Context, target string, reset bool) error
+
+	Whitelist(ctx context.Context) (Whitelist, error)
+	AddWhitelist(ctx context.Context, entry string, duration time.Duration) error
+	RemoveWhitelist(ctx context.Context, entry string) error
+
+	Stats(ctx context.Context) (Stats, error)
+
+	// TailEvents calls handle for every event of the given types, or all of
+	// them, until ctx is done or the stream ends
+	TailEvents(ctx context.Context, types []string, handle func(Event)) error
+
+	Close() error
+}
+
+// Credentials authenticate to the admin API
+type Credentials struct {
+	APIKey string // Sent as X-API-Key
+	Token  string // Sent as a bearer token, an API key or a JWT
+}
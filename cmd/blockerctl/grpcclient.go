@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"time"
+
+	"404BlockerDemo/adminpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcClient uses the gRPC admin service
+type grpcClient struct {
+	conn  *grpc.ClientConn
+	admin adminpb.AdminServiceClient
+	creds Credentials
+}
+
+// newGRPCClient connects to addr, over TLS unless tlsConfig is nil
+func newGRPCClient(addr string, tlsConfig *tls.Config, creds Credentials) (*grpcClient, error) {
+	transport := insecure.NewCredentials()
+	if tlsConfig != nil {
+		transport = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(transport))
+	if err != nil {
+		return nil, err
+	}
+	return &grpcClient{conn: conn, admin: adminpb.NewAdminServiceClient(conn), creds: creds}, nil
+}
+
+// auth adds the credentials to the call's metadata
+func (c *grpcClient) auth(ctx context.Context) context.Context {
+	if c.creds.APIKey != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", c.creds.APIKey)
+	}
+	if c.creds.Token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+c.creds.Token)
+	}
+	return ctx
+}
+
+// goTime converts t, with the zero time for unset ones
+func goTime(t *timestamppb.Timestamp) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.AsTime()
+}
+
+// grpcDuration converts d, leaving zero durations unset
+func grpcDuration(d time.Duration) *durationpb.Duration {
+	if d == 0 {
+		return nil
+	}
+	return durationpb.New(d)
+}
+
+// fromProtoBan converts a ban
+func fromProtoBan(b *adminpb.Ban) Ban {
+	return Ban{
+		Target:    b.GetTarget(),
+		BannedAt:  goTime(b.GetBannedAt()),
+		ExpiresAt: goTime(b.GetExpiresAt()),
+		Reason:    b.GetReason(),
+		Count:     b.GetCount(),
+		Rule:      b.GetRule(),
+		Offense:   b.GetOffense(),
+		Source:    b.GetSource(),
+		Country:   b.GetGeo().GetCountry(),
+	}
+}
+
+func (c *grpcClient) ListBans(ctx context.Context) ([]Ban, error) {
+	resp, err := c.admin.ListBans(c.auth(ctx), &adminpb.ListBansRequest{})
+	if err != nil {
+		return nil, err
+	}
+	var bans []Ban
+	for _, b := range resp.GetBans() {
+		bans = append(bans, fromProtoBan(b))
+	}
+	return bans, nil
+}
+
+func (c *grpcClient) Ban(ctx context.Context, target string, duration time.Duration) (Ban, error) {
+	b, err := c.admin.Ban(c.auth(ctx), &adminpb.BanRequest{Target: target, Duration: grpcDuration(duration)})
+	if err != nil {
+		return Ban{}, err
+	}
+	return fromProtoBan(b), nil
+}
+
+func (c *grpcClient) Unban(ctx context.Context, target string, reset bool) error {
+	_, err := c.admin.Unban(c.auth(ctx), &adminpb.UnbanRequest{Target: target, ResetCounts: reset})
+	return err
+}
+
+func (c *grpcClient) Whitelist(ctx context.Context) (Whitelist, error) {
+	resp, err := c.admin.ListWhitelist(c.auth(ctx), &emptypb.Empty{})
+	if err != nil {
+		return Whitelist{}, err
+	}
+	w := Whitelist{Entries: resp.GetEntries(), Temporary: make(map[string]time.Time)}
+	for entry, until := range resp.GetTemporary() {
+		w.Temporary[entry] = goTime(until)
+	}
+	return w, nil
+}
+
+func (c *grpcClient) AddWhitelist(ctx context.Context, entry string, duration time.Duration) error {
+	_, err := c.admin.AddWhitelist(c.auth(ctx), &adminpb.AddWhitelistRequest{Entry: entry, Duration: grpcDuration(duration)})
+	return err
+}
+
+func (c *grpcClient) RemoveWhitelist(ctx context.Context, entry string) error {
+	_, err := c.admin.RemoveWhitelist(c.auth(ctx), &adminpb.RemoveWhitelistRequest{Entry: entry})
+	return err
+}
+
+func (c *grpcClient) Stats(ctx context.Context) (Stats, error) {
+	s, err := c.admin.GetStats(c.auth(ctx), &emptypb.Empty{})
+	if err != nil {
+		return Stats{}, err
+	}
+	return Stats{
+		TrackedIPs:      s.GetTrackedIps(),
+		ActiveBans:      s.GetActiveBans(),
+		Total404s:       s.GetTotalNotFound(),
+		BlockedRequests: s.GetBlockedRequests(),
+		MemoryBytes:     s.GetMemoryBytes(),
+	}, nil
+}
+
+func (c *grpcClient) TailEvents(ctx context.Context, types []string, handle func(Event)) error {
+	stream, err := c.admin.StreamEvents(c.auth(ctx), &adminpb.StreamEventsRequest{Types: types})
+	if err != nil {
+		return err
+	}
+	for {
+		e, err := stream.Recv()
+		if errors.Is(err, io.EOF) || ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		handle(Event{
+			Type:      e.GetType(),
+			IP:        e.GetIp(),
+			Reason:    e.GetReason(),
+			Path:      e.GetPath(),
+			Count:     e.GetCount(),
+			Rule:      e.GetRule(),
+			ExpiresAt: goTime(e.GetExpiresAt()),
+			Time:      goTime(e.GetTime()),
+		})
+	}
+}
+
+func (c *grpcClient) Close() error {
+	return c.conn.Close()
+}
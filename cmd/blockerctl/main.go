@@ -0,0 +1,380 @@
+// blockerctl manages a running 404blocker through its admin API:
+//
+//	blockerctl [flags] list-bans
+//	blockerctl [flags] ban <ip|cidr|key> [--duration 48h]
+//	blockerctl [flags] unban <ip|cidr|key> [--reset]
+//	blockerctl [flags] whitelist [list | add <ip|cidr|host> [--duration 8h] | remove <ip|cidr|host>]
+//	blockerctl [flags] stats
+//	blockerctl [flags] tail-events [--type banned ...]
+//
+// An --addr of host:port talks to the gRPC admin service, an http:// or
+// https:// URL to the REST admin API mounted at that path.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// usage is printed for -h and bad command lines
+const usage = `Usage: blockerctl [flags] <command> [arguments]
+
+Commands:
+  list-bans                                    list active bans
+  ban <ip|cidr|key> [--duration 48h]           ban a client
+  unban <ip|cidr|key> [--reset]                lift a ban, --reset clears its 404s too
+  whitelist [list]                             list whitelist entries
+  whitelist add <ip|cidr|host> [--duration 8h] whitelist a client, temporarily with --duration
+  whitelist remove <ip|cidr|host>              remove a whitelist entry
+  stats                                        tracked IPs, active bans and totals
+  tail-events [--type banned ...]              print events as they happen
+
+Flags:
+`
+
+// options are the global flags
+type options struct {
+	addr       string
+	creds      Credentials
+	useTLS     bool
+	caFile     string
+	certFile   string
+	keyFile    string
+	serverName string
+	json       bool
+	timeout    time.Duration
+}
+
+func main() {
+	var opts options
+	flags := flag.NewFlagSet("blockerctl", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&opts.addr, "addr", envOr("BLOCKER_ADDR", "localhost:9090"), "gRPC address, or URL of the REST admin API, e.g. https://blocker:8080/admin")
+	flags.StringVar(&opts.creds.APIKey, "api-key", os.Getenv("BLOCKER_API_KEY"), "API key")
+	flags.StringVar(&opts.creds.Token, "token", os.Getenv("BLOCKER_TOKEN"), "bearer token, an API key or a JWT")
+	flags.BoolVar(&opts.useTLS, "tls", false, "connect to the gRPC address over TLS")
+	flags.StringVar(&opts.caFile, "ca-file", os.Getenv("BLOCKER_CA_FILE"), "PEM certificates to verify the server with instead of the system roots")
+	flags.StringVar(&opts.certFile, "cert-file", os.Getenv("BLOCKER_CERT_FILE"), "client certificate for mutual TLS")
+	flags.StringVar(&opts.keyFile, "key-file", os.Getenv("BLOCKER_KEY_FILE"), "key of the client certificate")
+	flags.StringVar(&opts.serverName, "server-name", "", "name to verify the server certificate against")
+	flags.BoolVar(&opts.json, "json", false, "print JSON instead of tables")
+	flags.DurationVar(&opts.timeout, "timeout", 10*time.Second, "how long a command may take; tail-events runs until interrupted")
+	flags.Parse(os.Args[1:])
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	client, err := opts.client()
+	if err != nil {
+		fatal(err)
+	}
+	defer client.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, client, opts, flags.Arg(0), flags.Args()[1:]); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "blockerctl: %v\nRun 'blockerctl -h' for help.\n", err)
+			os.Exit(2)
+		}
+		fatal(err)
+	}
+}
+
+// envOr returns the environment variable called name, or fallback if it's unset
+func envOr(name, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
+
+// fatal prints err and exits
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "blockerctl:", err)
+	os.Exit(1)
+}
+
+// client connects to the admin API the options point at
+func (o options) client() (Client, error) {
+	rest := strings.HasPrefix(o.addr, "http://") || strings.HasPrefix(o.addr, "https://")
+	var tlsConfig *tls.Config
+	if o.useTLS || o.caFile != "" || o.certFile != "" || o.serverName != "" || strings.HasPrefix(o.addr, "https://") {
+		tlsConfig = &tls.Config{ServerName: o.serverName, MinVersion: tls.VersionTLS12}
+		if o.caFile != "" {
+			pem, err := os.ReadFile(o.caFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("%s: no certificates found", o.caFile)
+			}
+		}
+		if o.certFile != "" {
+			cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+	}
+	if rest {
+		return newRESTClient(o.addr, tlsConfig, o.creds), nil
+	}
+	return newGRPCClient(o.addr, tlsConfig, o.creds)
+}
+
+// errUsage marks a bad command line
+var errUsage = errors.New("usage")
+
+// usageError reports a bad command line
+func usageError(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", errUsage, fmt.Sprintf(format, args...))
+}
+
+// parseArgs parses flags wherever they appear among args, so they may
+// follow the arguments as in "ban 1.2.3.4 --duration 48h", and returns the
+// rest
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	flags.SetOutput(io.Discard)
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, usageError("%v", err)
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+// run executes a command
+func run(ctx context.Context, client Client, opts options, command string, args []string) error {
+	if command != "tail-events" {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	switch command {
+	case "list-bans":
+		if _, err := exactArgs(flags, args, 0); err != nil {
+			return err
+		}
+		bans, err := client.ListBans(ctx)
+		if err != nil {
+			return err
+		}
+		return opts.print(bans, func(w io.Writer) {
+			fmt.Fprintln(w, "TARGET\tEXPIRES\tREASON\tSOURCE\tCOUNT")
+			for _, b := range bans {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", b.Target, formatTime(b.ExpiresAt), b.Reason, b.Source, b.Count)
+			}
+		})
+
+	case "ban":
+		duration := flags.Duration("duration", 0, "how long the ban lasts (default: the tracker's ban duration)")
+		rest, err := exactArgs(flags, args, 1)
+		if err != nil {
+			return err
+		}
+		ban, err := client.Ban(ctx, rest[0], *duration)
+		if err != nil {
+			return err
+		}
+		return opts.print(ban, func(w io.Writer) {
+			fmt.Fprintf(w, "banned %s until %s\n", ban.Target, formatTime(ban.ExpiresAt))
+		})
+
+	case "unban":
+		reset := flags.Bool("reset", false, "also clear the target's 404 history")
+		rest, err := exactArgs(flags, args, 1)
+		if err != nil {
+			return err
+		}
+		if err := client.Unban(ctx, rest[0], *reset); err != nil {
+			return err
+		}
+		return opts.print(map[string]string{"unbanned": rest[0]}, func(w io.Writer) {
+			fmt.Fprintf(w, "unbanned %s\n", rest[0])
+		})
+
+	case "whitelist":
+		return whitelistCommand(ctx, client, opts, flags, args)
+
+	case "stats":
+		if _, err := exactArgs(flags, args, 0); err != nil {
+			return err
+		}
+		stats, err := client.Stats(ctx)
+		if err != nil {
+			return err
+		}
+		return opts.print(stats, func(w io.Writer) {
+			fmt.Fprintf(w, "tracked IPs\t%d\n", stats.TrackedIPs)
+			fmt.Fprintf(w, "active bans\t%d\n", stats.ActiveBans)
+			fmt.Fprintf(w, "404s\t%d\n", stats.Total404s)
+			fmt.Fprintf(w, "blocked requests\t%d\n", stats.BlockedRequests)
+			fmt.Fprintf(w, "memory\t%d bytes\n", stats.MemoryBytes)
+		})
+
+	case "tail-events":
+		var types stringList
+		flags.Var(&types, "type", "only print events of this type, e.g. banned; repeatable")
+		if _, err := exactArgs(flags, args, 0); err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		return client.TailEvents(ctx, types, func(e Event) {
+			if opts.json {
+				encoder.Encode(e)
+				return
+			}
+			fmt.Println(formatEvent(e))
+		})
+	}
+	return usageError("unknown command %q", command)
+}
+
+// whitelistCommand runs the whitelist subcommands
+func whitelistCommand(ctx context.Context, client Client, opts options, flags *flag.FlagSet, args []string) error {
+	duration := flags.Duration("duration", 0, "how long the entry lasts (default: permanent)")
+	rest, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	action := "list"
+	if len(rest) > 0 {
+		action, rest = rest[0], rest[1:]
+	}
+	want := 1
+	switch action {
+	case "list":
+		want = 0
+	case "add", "remove":
+	default:
+		return usageError("unknown whitelist command %q", action)
+	}
+	if len(rest) != want {
+		return usageError("whitelist %s takes %d argument(s)", action, want)
+	}
+
+	switch action {
+	case "list":
+		w, err := client.Whitelist(ctx)
+		if err != nil {
+			return err
+		}
+		sort.Strings(w.Entries)
+		return opts.print(w, func(out io.Writer) {
+			fmt.Fprintln(out, "ENTRY\tEXPIRES")
+			for _, entry := range w.Entries {
+				expires := "never"
+				if until, ok := w.Temporary[entry]; ok {
+					expires = formatTime(until)
+				}
+				fmt.Fprintf(out, "%s\t%s\n", entry, expires)
+			}
+		})
+	case "add":
+		if err := client.AddWhitelist(ctx, rest[0], *duration); err != nil {
+			return err
+		}
+		return opts.print(map[string]string{"whitelisted": rest[0]}, func(w io.Writer) {
+			fmt.Fprintf(w, "whitelisted %s\n", rest[0])
+		})
+	case "remove":
+		if err := client.RemoveWhitelist(ctx, rest[0]); err != nil {
+			return err
+		}
+		return opts.print(map[string]string{"removed": rest[0]}, func(w io.Writer) {
+			fmt.Fprintf(w, "removed %s from the whitelist\n", rest[0])
+		})
+	}
+	return nil
+}
+
+// exactArgs parses args and checks that n arguments are left
+func exactArgs(flags *flag.FlagSet, args []string, n int) ([]string, error) {
+	rest, err := parseArgs(flags, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != n {
+		return nil, usageError("%s takes %d argument(s)", flags.Name(), n)
+	}
+	return rest, nil
+}
+
+// print writes v as JSON with --json, and as an aligned table otherwise
+func (o options) print(v any, table func(w io.Writer)) error {
+	if o.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// formatTime formats t in local time
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
+
+// formatEvent formats an event as a log line
+func formatEvent(e Event) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-18s %s", e.Time.Local().Format(time.DateTime), e.Type, e.IP)
+	if e.Path != "" {
+		fmt.Fprintf(&b, " path=%s", e.Path)
+	}
+	if e.Count != 0 {
+		fmt.Fprintf(&b, " count=%d", e.Count)
+	}
+	if e.Rule != "" {
+		fmt.Fprintf(&b, " rule=%s", e.Rule)
+	}
+	if e.Reason != "" {
+		fmt.Fprintf(&b, " reason=%q", e.Reason)
+	}
+	if !e.ExpiresAt.IsZero() {
+		fmt.Fprintf(&b, " expires=%s", formatTime(e.ExpiresAt))
+	}
+	return b.String()
+}
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
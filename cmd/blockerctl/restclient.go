@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// restClient uses the HTTP admin API mounted at base, e.g.
+// https://blocker.internal:8080/admin
+type restClient struct {
+	base  string
+	http  *http.Client
+	creds Credentials
+}
+
+// newRESTClient returns a client for the admin API at base
+func newRESTClient(base string, tlsConfig *tls.Config, creds Credentials) *restClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &restClient{
+		base:  strings.TrimSuffix(base, "/"),
+		http:  &http.Client{Transport: transport},
+		creds: creds,
+	}
+}
+
+// do sends a request and decodes the JSON response into out, if any
+func (c *restClient) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	resp, err := c.send(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send sends a request, turning error responses into errors
+func (c *restClient) send(ctx context.Context, method, path string, query url.Values, body any) (*http.Response, error) {
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		payload = bytes.NewReader(data)
+	}
+	target := c.base + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, payload)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.creds.APIKey != "" {
+		req.Header.Set("X-API-Key", c.creds.APIKey)
+	}
+	if c.creds.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.creds.Token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&failure) == nil && failure.Error != "" {
+			return nil, fmt.Errorf("%s: %s", resp.Status, failure.Error)
+		}
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return resp, nil
+}
+
+// restDuration formats d for a request, leaving zero durations unset
+func restDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+func (c *restClient) ListBans(ctx context.Context) ([]Ban, error) {
+	var resp struct {
+		Bans []Ban `json:"bans"`
+	}
+	err := c.do(ctx, http.MethodGet, "/bans", nil, nil, &resp)
+	return resp.Bans, err
+}
+
+func (c *restClient) Ban(ctx context.Context, target string, duration time.Duration) (Ban, error) {
+	var ban Ban
+	body := map[string]string{"target": target, "duration": restDuration(duration)}
+	err := c.do(ctx, http.MethodPost, "/bans", nil, body, &ban)
+	return ban, err
+}
+
+func (c *restClient) Unban(ctx context.Context, target string, reset bool) error {
+	query := url.Values{"target": {target}}
+	if reset {
+		query.Set("reset", "true")
+	}
+	return c.do(ctx, http.MethodDelete, "/bans", query, nil, nil)
+}
+
+func (c *restClient) Whitelist(ctx context.Context) (Whitelist, error) {
+	var w Whitelist
+	err := c.do(ctx, http.MethodGet, "/whitelist", nil, nil, &w)
+	return w, err
+}
+
+func (c *restClient) AddWhitelist(ctx context.Context, entry string, duration time.Duration) error {
+	body := map[string]string{"ip": entry, "duration": restDuration(duration)}
+	return c.do(ctx, http.MethodPost, "/whitelist", nil, body, nil)
+}
+
+func (c *restClient) RemoveWhitelist(ctx context.Context, entry string) error {
+	return c.do(ctx, http.MethodDelete, "/whitelist", url.Values{"ip": {entry}}, nil, nil)
+}
+
+func (c *restClient) Stats(ctx context.Context) (Stats, error) {
+	var s Stats
+	err := c.do(ctx, http.MethodGet, "/stats", nil, nil, &s)
+	return s, err
+}
+
+// TailEvents reads the Server-Sent Events stream, skipping heartbeats and
+// the types not asked for
+func (c *restClient) TailEvents(ctx context.Context, types []string, handle func(Event)) error {
+	resp, err := c.send(ctx, http.MethodGet, "/events", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var name string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if value, ok := strings.CutPrefix(line, "event:"); ok {
+			name = strings.TrimSpace(value)
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok || name == "heartbeat" || (len(types) > 0 && !slices.Contains(types, name)) {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+			return fmt.Errorf("malformed event: %w", err)
+		}
+		handle(event)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
+
+func (c *restClient) Close() error {
+	c.http.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,1679 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Config is the YAML configuration of the 404blocker binary. Every section
+// is optional; a feature is turned on by including its section. See
+// config.example.yaml for a commented example.
+type Config struct {
+	Listen   string `yaml:"listen"`   // Address to serve on (default ":8080")
+	Upstream string `yaml:"upstream"` // Run as a reverse proxy in front of this URL
+
+	Health *HealthFileConfig `yaml:"health"` // Liveness and readiness probes
+	Check  *CheckFileConfig  `yaml:"check"`  // Access checks for nginx auth_request or Traefik ForwardAuth
+	Admin  *AdminFileConfig  `yaml:"admin"`  // Admin API and metrics on a listener of their own
+
+	Threshold   int           `yaml:"threshold"`    // 404s tolerated within Window (default 3)
+	Window      time.Duration `yaml:"window"`       // Default 1m
+	BanDuration time.Duration `yaml:"ban_duration"` // Default 24h
+
+	WarningThreshold int `yaml:"warning_threshold"` // 404s after which clients get 429s before the ban
+
+	Windows []WindowFileConfig `yaml:"windows"` // Further thresholds over windows of their own
+
+	Whitelist           []string `yaml:"whitelist"`      // IPs, CIDRs and hostnames
+	WhitelistFile       string   `yaml:"whitelist_file"` // Default "whitelist.txt"
+	PrivateExemption    bool     `yaml:"private_exemption"`
+	CrawlerVerification bool     `yaml:"crawler_verification"`
+	OffendingRequests   int      `yaml:"offending_requests"` // 404s kept per client for ban records (default 10)
+	MaxTrackedIPs       int      `yaml:"max_tracked_ips"`    // Clients kept before the least recently seen is forgotten
+
+	DryRun      bool                   `yaml:"dry_run"`            // Log banned requests instead of blocking them
+	RateLimit   bool                   `yaml:"rate_limit_headers"` // Needs ban_response
+	BanResponse *BanResponseFileConfig `yaml:"ban_response"`       // Default: an empty 404
+	Tarpit      *TarpitFileConfig      `yaml:"tarpit"`
+	Decoys      *DecoyFileConfig       `yaml:"decoys"`
+	Challenge   *ChallengeFileConfig   `yaml:"challenge"`
+
+	CookieChallenge *CookieChallengeFileConfig `yaml:"cookie_challenge"`
+
+	TrustedProxies []string `yaml:"trusted_proxies"` // IPs and CIDRs
+	ProxyHeaders   []string `yaml:"proxy_headers"`
+	CDN            []string `yaml:"cdn"`           // "cloudflare", "fastly"
+	AkamaiRanges   []string `yaml:"akamai_ranges"` // Site Shield map for the Akamai CDN
+
+	Aggregation   *AggregationFileConfig   `yaml:"aggregation"`
+	Anonymization *AnonymizationFileConfig `yaml:"anonymization"`
+	Fingerprint   *FingerprintFileConfig   `yaml:"fingerprint"`
+	Escalation    *EscalationFileConfig    `yaml:"escalation"`
+	PermanentBan  *PermanentBanFileConfig  `yaml:"permanent_ban"`
+	Probation     *ProbationFileConfig     `yaml:"probation"`
+	StatusRules   []StatusRuleFileConfig   `yaml:"status_rules"`
+	ServerErrors  *ServerErrorFileConfig   `yaml:"server_errors"`
+	Exclusions    *ExclusionFileConfig     `yaml:"exclusions"`
+	Methods       *MethodFileConfig        `yaml:"method_rules"`
+	UniquePaths   *UniquePathFileConfig    `yaml:"unique_paths"`
+	Campaigns     *CampaignFileConfig      `yaml:"campaigns"`
+	Circuit       *CircuitFileConfig       `yaml:"circuit_breaker"`
+	RequestLimit  *RateLimitFileConfig     `yaml:"rate_limit"`
+	PathStats     *PathStatsFileConfig     `yaml:"path_stats"`
+	PathWeights   []PathWeightFileConfig   `yaml:"path_weights"`
+	Signatures    *SignatureFileConfig     `yaml:"scanner_signatures"`
+
+	Store       StoreFileConfig        `yaml:"store"`
+	Async       *AsyncFileConfig       `yaml:"async"` // Record responses in the background
+	Propagation *PropagationFileConfig `yaml:"propagation"`
+	Snapshot    *SnapshotFileConfig    `yaml:"snapshot"`
+	Import      *ImportFileConfig      `yaml:"import"`
+	Report      *ReportFileConfig      `yaml:"report"`
+	Cleanup     *CleanupFileConfig     `yaml:"cleanup"`
+	Audit       *AuditFileConfig       `yaml:"audit"`
+
+	Webhook   *WebhookFileConfig   `yaml:"webhook"`
+	Slack     *ChatFileConfig      `yaml:"slack"`
+	Discord   *ChatFileConfig      `yaml:"discord"`
+	StatsD    *StatsDFileConfig    `yaml:"statsd"`
+	Fail2Ban  *Fail2BanFileConfig  `yaml:"fail2ban"`
+	SIEM      *SIEMFileConfig      `yaml:"siem"`
+	EventLog  *EventLogFileConfig  `yaml:"event_log"`
+	Kafka     *KafkaFileConfig     `yaml:"kafka"`
+	NATS      *NATSFileConfig      `yaml:"nats"`
+	Firewall  *FirewallFileConfig  `yaml:"firewall"`
+	Feeds     []FeedFileConfig     `yaml:"feeds"`
+	AbuseIPDB *AbuseIPDBFileConfig `yaml:"abuseipdb"`
+	CrowdSec  *CrowdSecFileConfig  `yaml:"crowdsec"`
+	GeoIP     *GeoIPFileConfig     `yaml:"geoip"`
+}
+
+// HealthFileConfig is the health section, see HealthRoutes and
+// WithHealthLimits
+type HealthFileConfig struct {
+	LivenessPath   string `yaml:"liveness_path"`    // Default "/healthz"
+	ReadinessPath  string `yaml:"readiness_path"`   // Default "/readyz"
+	MaxMemoryBytes int64  `yaml:"max_memory_bytes"` // Not ready past this memory estimate
+	MaxTrackedIPs  int    `yaml:"max_tracked_ips"`  // Not ready past this many tracked IPs
+}
+
+// paths returns the probe paths, with the defaults filled in
+func (h *HealthFileConfig) paths() (liveness, readiness string) {
+	liveness, readiness = h.LivenessPath, h.ReadinessPath
+	if liveness == "" {
+		liveness = "/healthz"
+	}
+	if readiness == "" {
+		readiness = "/readyz"
+	}
+	return liveness, readiness
+}
+
+// CheckFileConfig is the check section, see CheckHandler
+type CheckFileConfig struct {
+	Path string `yaml:"path"` // Default "/check"
+}
+
+// path returns the check path, with the default filled in
+func (ch *CheckFileConfig) path() string {
+	if ch.Path == "" {
+		return "/check"
+	}
+	return ch.Path
+}
+
+// AdminFileConfig is the admin section: the admin API, the gRPC admin
+// service and metrics, served apart from the application port
+type AdminFileConfig struct {
+	Listen     string `yaml:"listen"`      // host:port or unix:/path, serving /admin
+	GRPCListen string `yaml:"grpc_listen"` // host:port or unix:/path for the gRPC service
+	Metrics    bool   `yaml:"metrics"`     // Serve Prometheus metrics at /metrics on listen
+
+	CertFile     string `yaml:"cert_file"` // Serve over TLS
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"` // Require client certificates signed by these CAs
+
+	// See AdminAuth; roles are "viewer" or "operator"
+	APIKeys            map[string]string `yaml:"api_keys"`
+	JWTSecret          string            `yaml:"jwt_secret"`
+	AllowedNetworks    []string          `yaml:"allowed_networks"`
+	ClientCertificates map[string]string `yaml:"client_certificates"` // Common name to role
+}
+
+// auth returns the admin authentication settings, nil when there are none
+func (a *AdminFileConfig) auth() *AdminAuth {
+	if len(a.APIKeys) == 0 && a.JWTSecret == "" && len(a.AllowedNetworks) == 0 && len(a.ClientCertificates) == 0 {
+		return nil
+	}
+	auth := &AdminAuth{
+		APIKeys:            make(map[string]AdminRole),
+		ClientCertificates: make(map[string]AdminRole),
+		JWTSecret:          []byte(a.JWTSecret),
+		AllowedNetworks:    mustPrefixes(a.AllowedNetworks),
+	}
+	for key, name := range a.APIKeys {
+		auth.APIKeys[key], _ = parseAdminRole(name)
+	}
+	for cn, name := range a.ClientCertificates {
+		auth.ClientCertificates[cn], _ = parseAdminRole(name)
+	}
+	return auth
+}
+
+// BanResponseFileConfig is the ban_response section, see WithBanResponse
+type BanResponseFileConfig struct {
+	Status      int               `yaml:"status"`
+	Body        string            `yaml:"body"`
+	ContentType string            `yaml:"content_type"`
+	Headers     map[string]string `yaml:"headers"`
+	RetryAfter  bool              `yaml:"retry_after"`
+	Drop        bool              `yaml:"drop"`
+	Template    string            `yaml:"template"` // Path of an html/template file
+}
+
+// TarpitFileConfig is the tarpit section, see WithTarpit
+type TarpitFileConfig struct {
+	Base          time.Duration `yaml:"base"`
+	Multiplier    float64       `yaml:"multiplier"`
+	Max           time.Duration `yaml:"max"`
+	MaxConcurrent int           `yaml:"max_concurrent"`
+}
+
+// DecoyFileConfig is the decoys section, see WithDecoys
+type DecoyFileConfig struct {
+	Rules   []DecoyRuleFileConfig `yaml:"rules"`
+	Default string                `yaml:"default"` // "listing" or "login"
+	Status  int                   `yaml:"status"`
+}
+
+// DecoyRuleFileConfig is an entry of decoys.rules, see DecoyRule
+type DecoyRuleFileConfig struct {
+	Pattern  string `yaml:"pattern"`
+	Kind     string `yaml:"kind"`     // "listing" or "login"
+	Template string `yaml:"template"` // Path of an html/template file
+}
+
+// ChallengeFileConfig is the challenge section, see WithChallenge
+type ChallengeFileConfig struct {
+	Provider  string        `yaml:"provider"` // "turnstile" or "recaptcha"
+	SiteKey   string        `yaml:"site_key"`
+	Secret    string        `yaml:"secret"`
+	Path      string        `yaml:"path"`
+	Probation bool          `yaml:"probation"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+// CookieChallengeFileConfig is the cookie_challenge section, see
+// WithCookieChallenge
+type CookieChallengeFileConfig struct {
+	Secret     string        `yaml:"secret"`
+	Difficulty int           `yaml:"difficulty"`
+	TTL        time.Duration `yaml:"ttl"`
+	CookieName string        `yaml:"cookie_name"`
+	Path       string        `yaml:"path"`
+}
+
+// WindowFileConfig is an entry of windows, see WithWindows
+type WindowFileConfig struct {
+	Threshold int           `yaml:"threshold"`
+	Window    time.Duration `yaml:"window"`
+}
+
+// windowLimits converts windows entries
+func windowLimits(windows []WindowFileConfig) []WindowLimit {
+	limits := make([]WindowLimit, 0, len(windows))
+	for _, w := range windows {
+		limits = append(limits, WindowLimit{Threshold: w.Threshold, Window: w.Window})
+	}
+	return limits
+}
+
+// statusRules converts the status_rules section
+func (c *Config) statusRules() []StatusRule {
+	rules := make([]StatusRule, 0, len(c.StatusRules))
+	for _, rule := range c.StatusRules {
+		rules = append(rules, StatusRule{
+			Status:      rule.Status,
+			Min:         rule.Min,
+			Max:         rule.Max,
+			Name:        rule.Name,
+			Weight:      rule.Weight,
+			Threshold:   rule.Threshold,
+			Window:      rule.Window,
+			BanDuration: rule.BanDuration,
+			Action:      RuleAction(rule.Action),
+			Windows:     windowLimits(rule.Windows),
+		})
+	}
+	return rules
+}
+
+// StatusRuleFileConfig is an entry of status_rules, see StatusRule
+type StatusRuleFileConfig struct {
+	Status      int           `yaml:"status"`
+	Min         int           `yaml:"min"` // Match the range min to max instead
+	Max         int           `yaml:"max"`
+	Name        string        `yaml:"name"`
+	Weight      int           `yaml:"weight"`
+	Threshold   int           `yaml:"threshold"`
+	Window      time.Duration `yaml:"window"`
+	BanDuration time.Duration `yaml:"ban_duration"`
+	Action      string        `yaml:"action"` // "ban" or "alert"
+
+	Windows []WindowFileConfig `yaml:"windows"`
+}
+
+// ExclusionFileConfig is the exclusions section, see WithExcludedPaths
+type ExclusionFileConfig struct {
+	Paths      []string `yaml:"paths"`       // Globs
+	Regexps    []string `yaml:"regexps"`     // Regular expressions
+	NoDefaults bool     `yaml:"no_defaults"` // Count favicon.ico, robots.txt and the like too
+}
+
+// UniquePathFileConfig is the unique_paths section, see
+// WithUniquePathLimit
+type UniquePathFileConfig struct {
+	Limit  int           `yaml:"limit"`
+	Window time.Duration `yaml:"window"` // Default 1h
+}
+
+// PathStatsFileConfig is the path_stats section, see WithPathStats
+type PathStatsFileConfig struct {
+	Retention time.Duration `yaml:"retention"` // Default 24h
+	MaxPaths  int           `yaml:"max_paths"` // Default 10000
+}
+
+// CampaignFileConfig is the campaigns section, see WithCampaignDetection
+type CampaignFileConfig struct {
+	Clients  int           `yaml:"clients"`
+	Window   time.Duration `yaml:"window"`   // Default 10m
+	Duration time.Duration `yaml:"duration"` // Default: window
+	Ban      bool          `yaml:"ban"`
+	Tighten  float64       `yaml:"tighten"` // Threshold factor, e.g. 0.5
+}
+
+// CircuitFileConfig is the circuit_breaker section, see WithCircuitBreaker
+type CircuitFileConfig struct {
+	Rate        int           `yaml:"rate"`
+	Window      time.Duration `yaml:"window"`       // Default 1m
+	Cooldown    time.Duration `yaml:"cooldown"`     // Default 10m
+	Threshold   int           `yaml:"threshold"`    // Default: half the usual threshold
+	BanDuration time.Duration `yaml:"ban_duration"` // Default: twice the usual ban
+}
+
+// RateLimitFileConfig is the rate_limit section, see WithRateLimit
+type RateLimitFileConfig struct {
+	Rate  float64 `yaml:"rate"`  // Requests per second
+	Burst int     `yaml:"burst"` // Default: rate
+}
+
+// MethodFileConfig is the method_rules section, see WithMethodRules
+type MethodFileConfig struct {
+	Methods []string `yaml:"methods"` // Default TRACE, TRACK, CONNECT, DEBUG
+	Allow   []string `yaml:"allow"`
+	Weight  int      `yaml:"weight"` // 0 bans on the spot
+}
+
+// PathWeightFileConfig is an entry of path_weights, see PathWeight
+type PathWeightFileConfig struct {
+	Pattern string `yaml:"pattern"`
+	Weight  int    `yaml:"weight"`
+	Ban     bool   `yaml:"ban"` // Ban on the first hit instead
+}
+
+// SignatureFileConfig is the scanner_signatures section, see
+// WithScannerSignatures
+type SignatureFileConfig struct {
+	Weight int      `yaml:"weight"` // 0 bans on the first hit
+	Extra  []string `yaml:"extra"`  // Patterns on top of the built-in list
+}
+
+// ServerErrorFileConfig is the server_errors section, see
+// WithServerErrorTracking
+type ServerErrorFileConfig struct {
+	Threshold   int           `yaml:"threshold"`
+	Window      time.Duration `yaml:"window"`
+	BanDuration time.Duration `yaml:"ban_duration"`
+	Action      string        `yaml:"action"` // "alert" (default) or "ban"
+}
+
+// AggregationFileConfig is the aggregation section, see WithPrefixAggregation
+type AggregationFileConfig struct {
+	IPv4Bits int `yaml:"ipv4_bits"`
+	IPv6Bits int `yaml:"ipv6_bits"`
+}
+
+// AnonymizationFileConfig is the anonymization section, see WithAnonymization
+type AnonymizationFileConfig struct {
+	Mode      string        `yaml:"mode"` // "hash" (default) or "truncate"
+	Secret    string        `yaml:"secret"`
+	IPv4Bits  int           `yaml:"ipv4_bits"`
+	IPv6Bits  int           `yaml:"ipv6_bits"`
+	Retention time.Duration `yaml:"retention"`
+}
+
+// FingerprintFileConfig is the fingerprint section, see WithFingerprinting
+type FingerprintFileConfig struct {
+	AcceptLanguage bool `yaml:"accept_language"`
+}
+
+// EscalationFileConfig is the escalation section, see WithBanEscalation
+type EscalationFileConfig struct {
+	Schedule   []time.Duration `yaml:"schedule"`
+	Multiplier float64         `yaml:"multiplier"`
+	Max        time.Duration   `yaml:"max"`
+	Memory     time.Duration   `yaml:"memory"`
+}
+
+// PermanentBanFileConfig is the permanent_ban section, see WithPermanentBan
+type PermanentBanFileConfig struct {
+	Offenses int           `yaml:"offenses"`
+	Lookback time.Duration `yaml:"lookback"`
+	Duration time.Duration `yaml:"duration"`
+}
+
+// ProbationFileConfig is the probation section, see WithProbation
+type ProbationFileConfig struct {
+	Period    time.Duration `yaml:"period"`
+	Allowance int           `yaml:"allowance"`
+}
+
+// StoreFileConfig is the store section
+type StoreFileConfig struct {
+	Type   string                `yaml:"type"` // "memory" (default), "redis" or "bolt"
+	Memory MemoryStoreFileConfig `yaml:"memory"`
+	Redis  RedisStoreFileConfig  `yaml:"redis"`
+	Bolt   BoltStoreFileConfig   `yaml:"bolt"`
+}
+
+// MemoryStoreFileConfig is the store.memory section, see
+// NewMemoryStoreWithResolution
+type MemoryStoreFileConfig struct {
+	Resolution time.Duration `yaml:"resolution"` // Default 1s
+}
+
+// RedisStoreFileConfig is the store.redis section, see RedisStoreConfig
+type RedisStoreFileConfig struct {
+	Addr         string        `yaml:"addr"`
+	Password     string        `yaml:"password"`
+	DB           int           `yaml:"db"`
+	KeyPrefix    string        `yaml:"key_prefix"`
+	PoolSize     int           `yaml:"pool_size"`
+	MinIdleConns int           `yaml:"min_idle_conns"`
+	DialTimeout  time.Duration `yaml:"dial_timeout"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	FailClosed   bool          `yaml:"fail_closed"`
+}
+
+// BoltStoreFileConfig is the store.bolt section, see NewBoltStore
+type BoltStoreFileConfig struct {
+	Path string `yaml:"path"`
+}
+
+// AsyncFileConfig is the async section, see WithAsyncRecording
+type AsyncFileConfig struct {
+	Buffer    int `yaml:"buffer"`     // Default 4096
+	BatchSize int `yaml:"batch_size"` // Default 128
+}
+
+// PropagationFileConfig is the propagation section. Bans are shared over
+// Redis pub/sub using the store.redis connection settings, or over NATS
+// using the nats section's.
+type PropagationFileConfig struct {
+	Transport string `yaml:"transport"` // "redis" (default) or "nats"
+	Channel   string `yaml:"channel"`   // Redis channel or NATS subject
+}
+
+// SnapshotFileConfig is the snapshot section, see WithSnapshotFile
+type SnapshotFileConfig struct {
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// ImportFileConfig is the import section, see WithBanImport
+type ImportFileConfig struct {
+	Path   string `yaml:"path"`
+	Format string `yaml:"format"` // "plain" (default), "csv" or "snapshot"
+}
+
+// ReportFileConfig is the report section, see WithReporter
+type ReportFileConfig struct {
+	Interval time.Duration `yaml:"interval"`
+	Disabled bool          `yaml:"disabled"`
+	Format   string        `yaml:"format"` // "text" (default) or "json", written to stdout
+
+	Retention time.Duration `yaml:"retention"` // Default 24h
+}
+
+// CleanupFileConfig is the cleanup section, see WithCleanup
+type CleanupFileConfig struct {
+	Interval    time.Duration `yaml:"interval"`
+	MinInterval time.Duration `yaml:"min_interval"`
+	HighWater   int           `yaml:"high_water"`
+}
+
+// WebhookFileConfig is the webhook section, see WithWebhook
+type WebhookFileConfig struct {
+	URL        string        `yaml:"url"`
+	Secret     string        `yaml:"secret"`
+	MaxRetries int           `yaml:"max_retries"`
+	Timeout    time.Duration `yaml:"timeout"`
+}
+
+// ChatFileConfig is the slack and discord section, see WithSlack
+type ChatFileConfig struct {
+	WebhookURL              string        `yaml:"webhook_url"`
+	BlockedRequestThreshold int           `yaml:"blocked_request_threshold"`
+	BlockedRequestWindow    time.Duration `yaml:"blocked_request_window"`
+	MinInterval             time.Duration `yaml:"min_interval"`
+}
+
+// StatsDFileConfig is the statsd section, see WithStatsD
+type StatsDFileConfig struct {
+	Addr          string        `yaml:"addr"`
+	Prefix        string        `yaml:"prefix"`
+	Tags          []string      `yaml:"tags"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+// AuditFileConfig is the audit section, see WithAuditLog
+type AuditFileConfig struct {
+	Path string `yaml:"path"`
+}
+
+// Fail2BanFileConfig is the fail2ban section, see WithFail2BanLog
+type Fail2BanFileConfig struct {
+	Path string `yaml:"path"`
+}
+
+// SIEMFileConfig is the siem section, see WithSIEM
+type SIEMFileConfig struct {
+	Format      string `yaml:"format"`  // "syslog" (default), "cef" or "leef"
+	Network     string `yaml:"network"` // "udp" (default), "tcp" or "tls"
+	Addr        string `yaml:"addr"`
+	Facility    int    `yaml:"facility"`
+	AppName     string `yaml:"app_name"`
+	Hostname    string `yaml:"hostname"`
+	SkipBlocked bool   `yaml:"skip_blocked"`
+}
+
+// EventLogFileConfig is the event_log section, see WithEventLog
+type EventLogFileConfig struct {
+	Path        string        `yaml:"path"`
+	MaxSizeMB   int64         `yaml:"max_size_mb"`
+	RotateEvery time.Duration `yaml:"rotate_every"`
+	MaxBackups  int           `yaml:"max_backups"`
+	Compress    bool          `yaml:"compress"`
+	Types       []string      `yaml:"types"` // Event types, e.g. "banned"; empty for all
+}
+
+// KafkaFileConfig is the kafka section, see WithKafka
+type KafkaFileConfig struct {
+	Brokers      []string      `yaml:"brokers"`
+	Topic        string        `yaml:"topic"`
+	Types        []string      `yaml:"types"` // Event types, e.g. "banned"; empty for all
+	BatchSize    int           `yaml:"batch_size"`
+	BatchTimeout time.Duration `yaml:"batch_timeout"`
+	Buffer       int           `yaml:"buffer"`
+	SpoolPath    string        `yaml:"spool_path"`
+	TLS          bool          `yaml:"tls"`
+	Username     string        `yaml:"username"`
+	Password     string        `yaml:"password"`
+}
+
+// NATSFileConfig is the nats section: the connection settings, and whether
+// to publish events, see WithNATS
+type NATSFileConfig struct {
+	URL       string   `yaml:"url"`
+	Events    bool     `yaml:"events"` // Publish events on <subject>.<event type>
+	Subject   string   `yaml:"subject"`
+	Types     []string `yaml:"types"` // Event types, e.g. "banned"; empty for all
+	Token     string   `yaml:"token"`
+	User      string   `yaml:"user"`
+	Password  string   `yaml:"password"`
+	CredsFile string   `yaml:"creds_file"`
+	TLS       bool     `yaml:"tls"`
+}
+
+// config returns the connection settings
+func (n *NATSFileConfig) config() NATSConfig {
+	cfg := NATSConfig{
+		URL:       n.URL,
+		Subject:   n.Subject,
+		Token:     n.Token,
+		User:      n.User,
+		Password:  n.Password,
+		CredsFile: n.CredsFile,
+	}
+	for _, name := range n.Types {
+		cfg.Types = append(cfg.Types, EventType(name))
+	}
+	if n.TLS {
+		cfg.TLS = &tls.Config{}
+	}
+	return cfg
+}
+
+// FirewallFileConfig is the firewall section, see WithFirewallSync
+type FirewallFileConfig struct {
+	Backend string `yaml:"backend"` // "ipset" (default) or "nftables"
+	Set     string `yaml:"set"`
+	Set6    string `yaml:"set6"`
+	Table   string `yaml:"table"`
+}
+
+// FeedFileConfig is an entry of the feeds section, see FeedConfig
+type FeedFileConfig struct {
+	Name     string        `yaml:"name"`
+	URL      string        `yaml:"url"`
+	Refresh  time.Duration `yaml:"refresh"`
+	Disabled bool          `yaml:"disabled"`
+}
+
+// AbuseIPDBFileConfig is the abuseipdb section, see AbuseIPDBConfig
+type AbuseIPDBFileConfig struct {
+	APIKey           string `yaml:"api_key"`
+	Report           bool   `yaml:"report"`
+	Categories       []int  `yaml:"categories"`
+	MaxReportsPerDay int    `yaml:"max_reports_per_day"`
+	MinConfidence    int    `yaml:"min_confidence"`
+	MaxAgeInDays     int    `yaml:"max_age_in_days"`
+	MaxChecksPerDay  int    `yaml:"max_checks_per_day"`
+}
+
+// CrowdSecFileConfig is the crowdsec section, see CrowdSecConfig
+type CrowdSecFileConfig struct {
+	URL          string        `yaml:"url"`
+	BouncerKey   string        `yaml:"bouncer_key"`
+	PollInterval time.Duration `yaml:"poll_interval"`
+	MachineID    string        `yaml:"machine_id"`
+	Password     string        `yaml:"password"`
+	Scenario     string        `yaml:"scenario"`
+}
+
+// GeoIPFileConfig is the geoip section, see GeoIPConfig
+type GeoIPFileConfig struct {
+	CountryDB         string         `yaml:"country_db"`
+	ASNDB             string         `yaml:"asn_db"`
+	BlockedCountries  []string       `yaml:"blocked_countries"`
+	CountryThresholds map[string]int `yaml:"country_thresholds"`
+	BlockedASNs       []uint         `yaml:"blocked_asns"`
+	ASNThresholds     map[uint]int   `yaml:"asn_thresholds"`
+	ExemptASNs        []uint         `yaml:"exempt_asns"`
+}
+
+// DefaultConfig returns the settings the binary runs with when no
+// configuration file is given
+func DefaultConfig() *Config {
+	return &Config{
+		Listen:        ":8080",
+		Threshold:     3,
+		Window:        time.Minute,
+		BanDuration:   24 * time.Hour,
+		WhitelistFile: "whitelist.txt",
+	}
+}
+
+// LoadConfig reads a YAML configuration file on top of DefaultConfig and
+// validates it. Unknown keys are errors, so typos don't go unnoticed.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := DefaultConfig()
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks the configuration, naming every bad field in the error
+func (c *Config) Validate() error {
+	var errs []error
+	bad := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+	nonNegative := func(field string, d time.Duration) {
+		if d < 0 {
+			bad(field, "must not be negative")
+		}
+	}
+	prefixes := func(field string, entries []string) {
+		for i, entry := range entries {
+			if _, err := parseIPOrCIDR(entry); err != nil {
+				bad(fmt.Sprintf("%s[%d]", field, i), "%v", err)
+			}
+		}
+	}
+	absoluteURL := func(field, value string) {
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			bad(field, "invalid URL %q", value)
+		}
+	}
+
+	if c.Listen == "" {
+		bad("listen", "must not be empty")
+	}
+	if c.Upstream != "" {
+		absoluteURL("upstream", c.Upstream)
+	}
+	if a := c.Admin; a != nil {
+		if a.Listen == "" && a.GRPCListen == "" {
+			bad("admin", "needs listen or grpc_listen")
+		}
+		if a.Listen != "" && a.Listen == c.Listen {
+			bad("admin.listen", "must differ from listen")
+		}
+		if a.GRPCListen != "" && (a.GRPCListen == c.Listen || a.GRPCListen == a.Listen) {
+			bad("admin.grpc_listen", "must differ from listen and admin.listen")
+		}
+		if a.Metrics && a.Listen == "" {
+			bad("admin.metrics", "needs admin.listen")
+		}
+		if (a.CertFile == "") != (a.KeyFile == "") {
+			bad("admin.key_file", "must be set together with admin.cert_file")
+		}
+		if a.ClientCAFile != "" && a.CertFile == "" {
+			bad("admin.client_ca_file", "needs admin.cert_file")
+		}
+		if len(a.ClientCertificates) > 0 && a.ClientCAFile == "" {
+			bad("admin.client_certificates", "needs admin.client_ca_file")
+		}
+		for _, roles := range []struct {
+			field string
+			roles map[string]string
+		}{{"admin.api_keys", a.APIKeys}, {"admin.client_certificates", a.ClientCertificates}} {
+			// Without the keys, which are secrets
+			for _, role := range roles.roles {
+				if _, ok := parseAdminRole(role); !ok {
+					bad(roles.field, "unknown role %q (want viewer or operator)", role)
+				}
+			}
+		}
+		prefixes("admin.allowed_networks", a.AllowedNetworks)
+	}
+	if h := c.Health; h != nil {
+		liveness, readiness := h.paths()
+		if !strings.HasPrefix(liveness, "/") {
+			bad("health.liveness_path", "must start with /")
+		}
+		if !strings.HasPrefix(readiness, "/") {
+			bad("health.readiness_path", "must start with /")
+		}
+		if liveness == readiness {
+			bad("health.readiness_path", "must differ from health.liveness_path")
+		}
+		if h.MaxMemoryBytes < 0 {
+			bad("health.max_memory_bytes", "must not be negative")
+		}
+		if h.MaxTrackedIPs < 0 {
+			bad("health.max_tracked_ips", "must not be negative")
+		}
+	}
+	if ch := c.Check; ch != nil {
+		path := ch.path()
+		if !strings.HasPrefix(path, "/") {
+			bad("check.path", "must start with /")
+		}
+		if h := c.Health; h != nil {
+			if liveness, readiness := h.paths(); path == liveness || path == readiness {
+				bad("check.path", "must differ from the health paths")
+			}
+		}
+		if len(c.TrustedProxies) == 0 {
+			// Otherwise every check is about the proxy's own address
+			bad("check", "needs trusted_proxies")
+		}
+	}
+	if c.Threshold <= 0 {
+		bad("threshold", "must be positive")
+	}
+	if c.Window <= 0 {
+		bad("window", "must be positive")
+	}
+	if c.BanDuration <= 0 {
+		bad("ban_duration", "must be positive")
+	}
+	if c.WarningThreshold < 0 || c.WarningThreshold > 0 && c.WarningThreshold >= c.Threshold {
+		bad("warning_threshold", "must be below threshold")
+	}
+	validWindows := func(field string, windows []WindowFileConfig) {
+		for i, w := range windows {
+			if w.Threshold <= 0 {
+				bad(fmt.Sprintf("%s[%d].threshold", field, i), "must be positive")
+			}
+			if w.Window <= 0 {
+				bad(fmt.Sprintf("%s[%d].window", field, i), "must be positive")
+			}
+		}
+	}
+	validWindows("windows", c.Windows)
+	if c.OffendingRequests < 0 {
+		bad("offending_requests", "must not be negative")
+	}
+	if c.MaxTrackedIPs < 0 {
+		bad("max_tracked_ips", "must not be negative")
+	}
+	for i, entry := range c.Whitelist {
+		if strings.TrimSpace(entry) == "" {
+			bad(fmt.Sprintf("whitelist[%d]", i), "must not be empty")
+		}
+	}
+	if c.RateLimit && c.BanResponse == nil {
+		bad("rate_limit_headers", "needs the ban_response section")
+	}
+	if r := c.BanResponse; r != nil && r.Status != 0 && (r.Status < 100 || r.Status > 599) {
+		bad("ban_response.status", "invalid HTTP status %d", r.Status)
+	}
+	if tp := c.Tarpit; tp != nil {
+		nonNegative("tarpit.base", tp.Base)
+		nonNegative("tarpit.max", tp.Max)
+		if tp.Multiplier != 0 && tp.Multiplier < 1 {
+			bad("tarpit.multiplier", "must be at least 1")
+		}
+		if tp.MaxConcurrent < 0 {
+			bad("tarpit.max_concurrent", "must not be negative")
+		}
+	}
+	if d := c.Decoys; d != nil {
+		decoyKind := func(field, kind string) {
+			if kind != "" && decoyTemplates[DecoyKind(kind)] == nil {
+				bad(field, "unknown decoy %q (want listing or login)", kind)
+			}
+		}
+		for i, rule := range d.Rules {
+			field := fmt.Sprintf("decoys.rules[%d]", i)
+			if _, err := path.Match(rule.Pattern, ""); err != nil || rule.Pattern == "" {
+				bad(field+".pattern", "invalid pattern %q", rule.Pattern)
+			}
+			if rule.Kind == "" && rule.Template == "" {
+				bad(field, "needs a kind or a template")
+			}
+			decoyKind(field+".kind", rule.Kind)
+		}
+		decoyKind("decoys.default", d.Default)
+		if d.Status != 0 && (d.Status < 100 || d.Status > 599) {
+			bad("decoys.status", "invalid HTTP status %d", d.Status)
+		}
+	}
+	if ch := c.Challenge; ch != nil {
+		if _, ok := challengeWidgets[ChallengeProvider(ch.Provider)]; !ok && ch.Provider != "" {
+			bad("challenge.provider", "unknown provider %q (want turnstile or recaptcha)", ch.Provider)
+		}
+		if ch.SiteKey == "" {
+			bad("challenge.site_key", "is required")
+		}
+		if ch.Secret == "" {
+			bad("challenge.secret", "is required")
+		}
+		if ch.Path != "" && !strings.HasPrefix(ch.Path, "/") {
+			bad("challenge.path", "must start with /")
+		}
+		if ch.Probation && c.Probation == nil {
+			bad("challenge.probation", "needs the probation section")
+		}
+		nonNegative("challenge.timeout", ch.Timeout)
+	}
+	if cc := c.CookieChallenge; cc != nil {
+		if cc.Difficulty < 0 || cc.Difficulty > 32 {
+			bad("cookie_challenge.difficulty", "must be between 0 and 32")
+		}
+		nonNegative("cookie_challenge.ttl", cc.TTL)
+		if cc.Path != "" && !strings.HasPrefix(cc.Path, "/") {
+			bad("cookie_challenge.path", "must start with /")
+		}
+		if ch := c.Challenge; ch != nil && cc.Path != "" && cc.Path == ch.Path {
+			bad("cookie_challenge.path", "must differ from challenge.path")
+		}
+	}
+	ruleNames := make(map[string]bool)
+	for i, rule := range c.StatusRules {
+		field := fmt.Sprintf("status_rules[%d]", i)
+		validStatus := func(name string, status int) {
+			if status < 100 || status > 599 {
+				bad(field+"."+name, "invalid HTTP status %d", status)
+			}
+		}
+		switch {
+		case rule.Status != 0 && rule.Min+rule.Max != 0:
+			bad(field, "needs a status or a range, not both")
+		case rule.Status != 0:
+			validStatus("status", rule.Status)
+		case rule.Min > rule.Max:
+			bad(field+".max", "must not be below min")
+		default:
+			validStatus("min", rule.Min)
+			validStatus("max", rule.Max)
+		}
+		name := rule.Name
+		if name == "" {
+			name = StatusRule{Status: rule.Status, Min: rule.Min, Max: rule.Max}.defaultName()
+		}
+		if ruleNames[name] {
+			bad(field+".name", "duplicate rule name %q", name)
+		}
+		ruleNames[name] = true
+		if rule.Weight < 0 {
+			bad(field+".weight", "must not be negative")
+		}
+		if rule.Threshold < 0 {
+			bad(field+".threshold", "must not be negative")
+		}
+		nonNegative(field+".window", rule.Window)
+		nonNegative(field+".ban_duration", rule.BanDuration)
+		validWindows(field+".windows", rule.Windows)
+		if rule.Action != "" && rule.Action != string(RuleBan) && rule.Action != string(RuleAlert) {
+			bad(field+".action", "unknown action %q (want ban or alert)", rule.Action)
+		}
+	}
+	if se := c.ServerErrors; se != nil {
+		if se.Threshold < 0 {
+			bad("server_errors.threshold", "must not be negative")
+		}
+		nonNegative("server_errors.window", se.Window)
+		nonNegative("server_errors.ban_duration", se.BanDuration)
+		if se.Action != "" && se.Action != string(RuleBan) && se.Action != string(RuleAlert) {
+			bad("server_errors.action", "unknown action %q (want alert or ban)", se.Action)
+		}
+		if ruleNames["5xx"] {
+			bad("server_errors", "clashes with the status rule named 5xx")
+		}
+	}
+	if e := c.Exclusions; e != nil {
+		for i, pattern := range e.Paths {
+			if _, err := path.Match(pattern, ""); pattern == "" || err != nil {
+				bad(fmt.Sprintf("exclusions.paths[%d]", i), "invalid pattern %q", pattern)
+			}
+		}
+		for i, expr := range e.Regexps {
+			if _, err := regexp.Compile(expr); err != nil {
+				bad(fmt.Sprintf("exclusions.regexps[%d]", i), "%v", err)
+			}
+		}
+	}
+	if u := c.UniquePaths; u != nil {
+		if u.Limit <= 0 {
+			bad("unique_paths.limit", "must be positive")
+		}
+		nonNegative("unique_paths.window", u.Window)
+	}
+	if ps := c.PathStats; ps != nil {
+		nonNegative("path_stats.retention", ps.Retention)
+		if ps.MaxPaths < 0 {
+			bad("path_stats.max_paths", "must not be negative")
+		}
+	}
+	if cp := c.Campaigns; cp != nil {
+		if cp.Clients <= 0 {
+			bad("campaigns.clients", "must be positive")
+		}
+		nonNegative("campaigns.window", cp.Window)
+		nonNegative("campaigns.duration", cp.Duration)
+		if cp.Tighten < 0 || cp.Tighten > 1 {
+			bad("campaigns.tighten", "must be between 0 and 1")
+		}
+	}
+	if rl := c.RequestLimit; rl != nil {
+		if rl.Rate <= 0 {
+			bad("rate_limit.rate", "must be positive")
+		}
+		if rl.Burst < 0 {
+			bad("rate_limit.burst", "must not be negative")
+		}
+	}
+	if cb := c.Circuit; cb != nil {
+		if cb.Rate <= 0 {
+			bad("circuit_breaker.rate", "must be positive")
+		}
+		nonNegative("circuit_breaker.window", cb.Window)
+		nonNegative("circuit_breaker.cooldown", cb.Cooldown)
+		nonNegative("circuit_breaker.ban_duration", cb.BanDuration)
+		if cb.Threshold < 0 {
+			bad("circuit_breaker.threshold", "must not be negative")
+		}
+	}
+	if m := c.Methods; m != nil {
+		if m.Weight < 0 {
+			bad("method_rules.weight", "must not be negative")
+		}
+		methods := func(name string, list []string) {
+			for i, method := range list {
+				if method == "" || strings.ContainsAny(method, " \t/") {
+					bad(fmt.Sprintf("method_rules.%s[%d]", name, i), "invalid method %q", method)
+				}
+			}
+		}
+		methods("methods", m.Methods)
+		methods("allow", m.Allow)
+	}
+	for i, w := range c.PathWeights {
+		field := fmt.Sprintf("path_weights[%d]", i)
+		if _, err := path.Match(w.Pattern, ""); w.Pattern == "" || err != nil {
+			bad(field+".pattern", "invalid pattern %q", w.Pattern)
+		}
+		if w.Weight < 0 {
+			bad(field+".weight", "must not be negative")
+		} else if w.Ban == (w.Weight != 0) {
+			bad(field, "needs either a weight or ban: true")
+		}
+	}
+	if s := c.Signatures; s != nil {
+		if s.Weight < 0 {
+			bad("scanner_signatures.weight", "must not be negative")
+		}
+		for i, pattern := range s.Extra {
+			if _, err := path.Match(pattern, ""); pattern == "" || err != nil {
+				bad(fmt.Sprintf("scanner_signatures.extra[%d]", i), "invalid pattern %q", pattern)
+			}
+		}
+	}
+	prefixes("trusted_proxies", c.TrustedProxies)
+	for i, name := range c.CDN {
+		if _, ok := cdnPresets[strings.ToLower(name)]; !ok {
+			bad(fmt.Sprintf("cdn[%d]", i), "unknown CDN %q (want cloudflare or fastly)", name)
+		}
+	}
+	prefixes("akamai_ranges", c.AkamaiRanges)
+
+	if a := c.Aggregation; a != nil {
+		if a.IPv4Bits < 0 || a.IPv4Bits > 32 {
+			bad("aggregation.ipv4_bits", "must be between 0 and 32")
+		}
+		if a.IPv6Bits < 0 || a.IPv6Bits > 128 {
+			bad("aggregation.ipv6_bits", "must be between 0 and 128")
+		}
+	}
+	if a := c.Anonymization; a != nil {
+		switch AnonymizationMode(a.Mode) {
+		case "", AnonymizeHash, AnonymizeTruncate:
+		default:
+			bad("anonymization.mode", "unknown mode %q (want hash or truncate)", a.Mode)
+		}
+		if a.IPv4Bits < 0 || a.IPv4Bits > 32 {
+			bad("anonymization.ipv4_bits", "must be between 0 and 32")
+		}
+		if a.IPv6Bits < 0 || a.IPv6Bits > 128 {
+			bad("anonymization.ipv6_bits", "must be between 0 and 128")
+		}
+		nonNegative("anonymization.retention", a.Retention)
+	}
+	if e := c.Escalation; e != nil {
+		for i, d := range e.Schedule {
+			if d <= 0 {
+				bad(fmt.Sprintf("escalation.schedule[%d]", i), "must be positive")
+			}
+		}
+		if e.Multiplier < 0 {
+			bad("escalation.multiplier", "must not be negative")
+		}
+		nonNegative("escalation.max", e.Max)
+		nonNegative("escalation.memory", e.Memory)
+	}
+	if p := c.PermanentBan; p != nil {
+		if p.Offenses <= 0 {
+			bad("permanent_ban.offenses", "must be positive")
+		}
+		nonNegative("permanent_ban.lookback", p.Lookback)
+		nonNegative("permanent_ban.duration", p.Duration)
+	}
+	if p := c.Probation; p != nil {
+		nonNegative("probation.period", p.Period)
+		if p.Allowance < 0 {
+			bad("probation.allowance", "must not be negative")
+		}
+	}
+
+	nonNegative("store.memory.resolution", c.Store.Memory.Resolution)
+	rs := c.Store.Redis
+	if rs.PoolSize < 0 {
+		bad("store.redis.pool_size", "must not be negative")
+	}
+	if rs.MinIdleConns < 0 {
+		bad("store.redis.min_idle_conns", "must not be negative")
+	} else if rs.PoolSize > 0 && rs.MinIdleConns > rs.PoolSize {
+		bad("store.redis.min_idle_conns", "must not be more than pool_size")
+	}
+	nonNegative("store.redis.dial_timeout", rs.DialTimeout)
+	nonNegative("store.redis.read_timeout", rs.ReadTimeout)
+	nonNegative("store.redis.write_timeout", rs.WriteTimeout)
+	switch c.Store.Type {
+	case "", "memory":
+		if c.Store.Memory.Resolution > c.Window {
+			bad("store.memory.resolution", "must not be longer than window")
+		}
+	case "redis":
+		if c.Store.Redis.Addr == "" {
+			bad("store.redis.addr", "required for the redis store")
+		}
+	case "bolt":
+		if c.Store.Bolt.Path == "" {
+			bad("store.bolt.path", "required for the bolt store")
+		}
+	default:
+		bad("store.type", "unknown store %q (want memory, redis or bolt)", c.Store.Type)
+	}
+	if a := c.Async; a != nil {
+		if a.Buffer < 0 {
+			bad("async.buffer", "must not be negative")
+		}
+		if a.BatchSize < 0 {
+			bad("async.batch_size", "must not be negative")
+		}
+	}
+	if p := c.Propagation; p != nil {
+		switch p.Transport {
+		case "", "redis":
+			if c.Store.Redis.Addr == "" {
+				bad("propagation", "needs store.redis.addr")
+			}
+		case "nats":
+			if c.NATS == nil {
+				bad("propagation", "needs the nats section")
+			}
+		default:
+			bad("propagation.transport", "must be redis or nats")
+		}
+	}
+	if s := c.Snapshot; s != nil {
+		if s.Path == "" {
+			bad("snapshot.path", "must not be empty")
+		}
+		nonNegative("snapshot.interval", s.Interval)
+	}
+	if i := c.Import; i != nil {
+		if i.Path == "" {
+			bad("import.path", "must not be empty")
+		}
+		switch ImportFormat(i.Format) {
+		case "", ImportPlain, ImportCSV, ImportSnapshot:
+		default:
+			bad("import.format", "unknown format %q (want plain, csv or snapshot)", i.Format)
+		}
+	}
+	if r := c.Report; r != nil {
+		nonNegative("report.interval", r.Interval)
+		nonNegative("report.retention", r.Retention)
+		switch ReportFormat(r.Format) {
+		case "", ReportText, ReportJSON:
+		default:
+			bad("report.format", "unknown format %q (want text or json)", r.Format)
+		}
+	}
+	if cl := c.Cleanup; cl != nil {
+		nonNegative("cleanup.interval", cl.Interval)
+		nonNegative("cleanup.min_interval", cl.MinInterval)
+		if cl.HighWater < 0 {
+			bad("cleanup.high_water", "must not be negative")
+		}
+		if cl.Interval > 0 && cl.MinInterval > cl.Interval {
+			bad("cleanup.min_interval", "must not exceed cleanup.interval")
+		}
+	}
+
+	if w := c.Webhook; w != nil {
+		absoluteURL("webhook.url", w.URL)
+		nonNegative("webhook.timeout", w.Timeout)
+	}
+	for _, chat := range []struct {
+		name string
+		cfg  *ChatFileConfig
+	}{{"slack", c.Slack}, {"discord", c.Discord}} {
+		if chat.cfg != nil {
+			absoluteURL(chat.name+".webhook_url", chat.cfg.WebhookURL)
+			nonNegative(chat.name+".blocked_request_window", chat.cfg.BlockedRequestWindow)
+			nonNegative(chat.name+".min_interval", chat.cfg.MinInterval)
+		}
+	}
+	if s := c.StatsD; s != nil {
+		nonNegative("statsd.flush_interval", s.FlushInterval)
+	}
+	if a := c.Audit; a != nil && a.Path == "" {
+		bad("audit.path", "must not be empty")
+	}
+	if f := c.Fail2Ban; f != nil && f.Path == "" {
+		bad("fail2ban.path", "must not be empty")
+	}
+	if s := c.SIEM; s != nil {
+		switch SIEMFormat(s.Format) {
+		case "", SIEMSyslog, SIEMCEF, SIEMLEEF:
+		default:
+			bad("siem.format", "unknown format %q (want syslog, cef or leef)", s.Format)
+		}
+		switch s.Network {
+		case "", "udp", "tcp", "tls":
+		default:
+			bad("siem.network", "unknown network %q (want udp, tcp or tls)", s.Network)
+		}
+		if s.Addr == "" {
+			bad("siem.addr", "must not be empty")
+		}
+		if s.Facility < 0 || s.Facility > 23 {
+			bad("siem.facility", "must be between 0 and 23")
+		}
+	}
+	if n := c.NATS; n != nil {
+		if n.Token != "" && n.User != "" {
+			bad("nats.token", "can't be combined with nats.user")
+		}
+		if n.Password != "" && n.User == "" {
+			bad("nats.password", "needs nats.user")
+		}
+		if n.CredsFile != "" && (n.Token != "" || n.User != "") {
+			bad("nats.creds_file", "can't be combined with nats.token or nats.user")
+		}
+		if !n.Events && (n.Subject != "" || len(n.Types) > 0) {
+			bad("nats.events", "must be set to publish events")
+		}
+	}
+	if k := c.Kafka; k != nil {
+		if len(k.Brokers) == 0 {
+			bad("kafka.brokers", "must not be empty")
+		}
+		if k.BatchSize < 0 {
+			bad("kafka.batch_size", "must not be negative")
+		}
+		nonNegative("kafka.batch_timeout", k.BatchTimeout)
+		if k.Buffer < 0 {
+			bad("kafka.buffer", "must not be negative")
+		}
+		if k.Password != "" && k.Username == "" {
+			bad("kafka.password", "needs a username")
+		}
+	}
+	if e := c.EventLog; e != nil {
+		if e.Path == "" {
+			bad("event_log.path", "must not be empty")
+		}
+		if e.MaxSizeMB < 0 {
+			bad("event_log.max_size_mb", "must not be negative")
+		}
+		nonNegative("event_log.rotate_every", e.RotateEvery)
+		if e.MaxBackups < 0 {
+			bad("event_log.max_backups", "must not be negative")
+		}
+	}
+	if f := c.Firewall; f != nil {
+		switch FirewallBackend(f.Backend) {
+		case "", FirewallIPSet, FirewallNFTables:
+		default:
+			bad("firewall.backend", "unknown backend %q (want ipset or nftables)", f.Backend)
+		}
+		if f.Set == "" && f.Set6 == "" {
+			bad("firewall.set", "set or set6 is required")
+		}
+	}
+	names := make(map[string]bool)
+	for i, feed := range c.Feeds {
+		field := fmt.Sprintf("feeds[%d]", i)
+		if feed.Name == "" {
+			bad(field+".name", "must not be empty")
+		} else if names[feed.Name] {
+			bad(field+".name", "duplicate feed %q", feed.Name)
+		}
+		names[feed.Name] = true
+		absoluteURL(field+".url", feed.URL)
+		nonNegative(field+".refresh", feed.Refresh)
+	}
+	if a := c.AbuseIPDB; a != nil {
+		if a.APIKey == "" {
+			bad("abuseipdb.api_key", "must not be empty")
+		}
+		if a.MinConfidence < 0 || a.MinConfidence > 100 {
+			bad("abuseipdb.min_confidence", "must be between 0 and 100")
+		}
+	}
+	if cs := c.CrowdSec; cs != nil {
+		if cs.URL != "" {
+			absoluteURL("crowdsec.url", cs.URL)
+		}
+		if cs.BouncerKey == "" && cs.MachineID == "" {
+			bad("crowdsec", "needs bouncer_key, machine_id or both")
+		}
+		if cs.MachineID != "" && cs.Password == "" {
+			bad("crowdsec.password", "required with machine_id")
+		}
+		nonNegative("crowdsec.poll_interval", cs.PollInterval)
+	}
+	if g := c.GeoIP; g != nil && g.CountryDB == "" && g.ASNDB == "" {
+		bad("geoip", "needs country_db, asn_db or both")
+	}
+
+	return errors.Join(errs...)
+}
+
+// cdnPresets are the CDN providers the configuration file can name
+var cdnPresets = map[string]CDNProvider{
+	"cloudflare": CDNCloudflare,
+	"fastly":     CDNFastly,
+}
+
+// mustPrefixes parses entries already checked by Validate
+func mustPrefixes(entries []string) []netip.Prefix {
+	result := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if prefix, err := parseIPOrCIDR(entry); err == nil {
+			result = append(result, prefix)
+		}
+	}
+	return result
+}
+
+// Options turns the configuration into tracker options. It opens the
+// storage backend and GeoIP databases, so it can fail.
+func (c *Config) Options() ([]Option, error) {
+	var opts []Option
+	if c.WhitelistFile != "" {
+		opts = append(opts, WithWhitelistFile(c.WhitelistFile))
+	}
+	if c.PrivateExemption {
+		opts = append(opts, WithPrivateExemption())
+	}
+	if c.CrawlerVerification {
+		opts = append(opts, WithCrawlerVerification())
+	}
+	if len(c.Windows) > 0 {
+		opts = append(opts, WithWindows(windowLimits(c.Windows)...))
+	}
+	if c.WarningThreshold > 0 {
+		opts = append(opts, WithWarningThreshold(c.WarningThreshold))
+	}
+	if c.OffendingRequests > 0 {
+		opts = append(opts, WithOffendingRequests(c.OffendingRequests))
+	}
+	if c.MaxTrackedIPs > 0 {
+		opts = append(opts, WithMaxTrackedIPs(c.MaxTrackedIPs))
+	}
+	if a := c.Admin; a != nil {
+		if auth := a.auth(); auth != nil {
+			opts = append(opts, WithAdminAuth(*auth))
+		}
+	}
+	if h := c.Health; h != nil {
+		opts = append(opts, WithHealthLimits(HealthLimits{MaxMemoryBytes: h.MaxMemoryBytes, MaxTrackedIPs: h.MaxTrackedIPs}))
+	}
+	if c.DryRun {
+		opts = append(opts, WithDryRun())
+	}
+	if c.RateLimit {
+		opts = append(opts, WithRateLimitHeaders())
+	}
+	if r := c.BanResponse; r != nil {
+		resp := BanResponse{Status: r.Status, Body: r.Body, ContentType: r.ContentType, Headers: r.Headers, RetryAfter: r.RetryAfter, Drop: r.Drop}
+		if r.Template != "" {
+			tmpl, err := template.ParseFiles(r.Template)
+			if err != nil {
+				return nil, fmt.Errorf("ban_response.template: %w", err)
+			}
+			resp.Template = tmpl
+		}
+		opts = append(opts, WithBanResponse(resp))
+	}
+	if d := c.Decoys; d != nil {
+		decoyCfg := DecoyConfig{Default: DecoyKind(d.Default), Status: d.Status}
+		for i, rule := range d.Rules {
+			decoy := DecoyRule{Pattern: rule.Pattern, Kind: DecoyKind(rule.Kind)}
+			if rule.Template != "" {
+				tmpl, err := template.ParseFiles(rule.Template)
+				if err != nil {
+					return nil, fmt.Errorf("decoys.rules[%d].template: %w", i, err)
+				}
+				decoy.Template = tmpl
+			}
+			decoyCfg.Rules = append(decoyCfg.Rules, decoy)
+		}
+		opts = append(opts, WithDecoys(decoyCfg))
+	}
+	if ch := c.Challenge; ch != nil {
+		opts = append(opts, WithChallenge(ChallengeConfig{
+			Provider:  ChallengeProvider(ch.Provider),
+			SiteKey:   ch.SiteKey,
+			Secret:    ch.Secret,
+			Path:      ch.Path,
+			Probation: ch.Probation,
+			Timeout:   ch.Timeout,
+		}))
+	}
+	if cc := c.CookieChallenge; cc != nil {
+		opts = append(opts, WithCookieChallenge(CookieChallengeConfig{
+			Secret:     []byte(cc.Secret),
+			Difficulty: cc.Difficulty,
+			TTL:        cc.TTL,
+			CookieName: cc.CookieName,
+			Path:       cc.Path,
+		}))
+	}
+	if tp := c.Tarpit; tp != nil {
+		opts = append(opts, WithTarpit(TarpitConfig{Base: tp.Base, Multiplier: tp.Multiplier, Max: tp.Max, MaxConcurrent: tp.MaxConcurrent}))
+	}
+	if len(c.TrustedProxies) > 0 {
+		opts = append(opts, WithTrustedProxies(ProxyConfig{
+			TrustedProxies: mustPrefixes(c.TrustedProxies),
+			Headers:        c.ProxyHeaders,
+		}))
+	}
+	if len(c.CDN) > 0 || len(c.AkamaiRanges) > 0 {
+		var providers []CDNProvider
+		for _, name := range c.CDN {
+			providers = append(providers, cdnPresets[strings.ToLower(name)])
+		}
+		if len(c.AkamaiRanges) > 0 {
+			providers = append(providers, CDNAkamai(mustPrefixes(c.AkamaiRanges)...))
+		}
+		opts = append(opts, WithCDN(CDNConfig{Providers: providers}))
+	}
+
+	if a := c.Aggregation; a != nil {
+		opts = append(opts, WithPrefixAggregation(a.IPv4Bits, a.IPv6Bits))
+	}
+	if a := c.Anonymization; a != nil {
+		opts = append(opts, WithAnonymization(AnonymizationConfig{
+			Mode:      AnonymizationMode(a.Mode),
+			Secret:    []byte(a.Secret),
+			IPv4Bits:  a.IPv4Bits,
+			IPv6Bits:  a.IPv6Bits,
+			Retention: a.Retention,
+		}))
+	}
+	if f := c.Fingerprint; f != nil {
+		opts = append(opts, WithFingerprinting(FingerprintConfig{AcceptLanguage: f.AcceptLanguage}))
+	}
+	if e := c.Escalation; e != nil {
+		opts = append(opts, WithBanEscalation(EscalationConfig{Schedule: e.Schedule, Multiplier: e.Multiplier, Max: e.Max, Memory: e.Memory}))
+	}
+	if p := c.PermanentBan; p != nil {
+		opts = append(opts, WithPermanentBan(PermanentBanConfig{Offenses: p.Offenses, Lookback: p.Lookback, Duration: p.Duration}))
+	}
+	if p := c.Probation; p != nil {
+		opts = append(opts, WithProbation(ProbationConfig{Period: p.Period, Allowance: p.Allowance}))
+	}
+	if len(c.StatusRules) > 0 {
+		opts = append(opts, WithStatusRules(c.statusRules()...))
+	}
+	if e := c.Exclusions; e != nil {
+		opts = append(opts, WithExcludedPaths(e.Paths...))
+		res := make([]*regexp.Regexp, 0, len(e.Regexps))
+		for _, expr := range e.Regexps {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, re)
+		}
+		opts = append(opts, WithExcludedPathRegexps(res...))
+		if e.NoDefaults {
+			opts = append(opts, WithoutDefaultExclusions())
+		}
+	}
+	if u := c.UniquePaths; u != nil {
+		opts = append(opts, WithUniquePathLimit(UniquePathConfig{Limit: u.Limit, Window: u.Window}))
+	}
+	if ps := c.PathStats; ps != nil {
+		opts = append(opts, WithPathStats(PathStatsConfig{Retention: ps.Retention, MaxPaths: ps.MaxPaths}))
+	}
+	if cp := c.Campaigns; cp != nil {
+		opts = append(opts, WithCampaignDetection(CampaignConfig{
+			Clients:  cp.Clients,
+			Window:   cp.Window,
+			Duration: cp.Duration,
+			Ban:      cp.Ban,
+			Tighten:  cp.Tighten,
+		}))
+	}
+	if rl := c.RequestLimit; rl != nil {
+		opts = append(opts, WithRateLimit(RateLimitConfig{Rate: rl.Rate, Burst: rl.Burst}))
+	}
+	if cb := c.Circuit; cb != nil {
+		opts = append(opts, WithCircuitBreaker(CircuitBreakerConfig{
+			Rate:        cb.Rate,
+			Window:      cb.Window,
+			Cooldown:    cb.Cooldown,
+			Threshold:   cb.Threshold,
+			BanDuration: cb.BanDuration,
+		}))
+	}
+	if m := c.Methods; m != nil {
+		opts = append(opts, WithMethodRules(MethodConfig{Methods: m.Methods, Allow: m.Allow, Weight: m.Weight}))
+	}
+	if len(c.PathWeights) > 0 {
+		weights := make([]PathWeight, 0, len(c.PathWeights))
+		for _, w := range c.PathWeights {
+			weight := w.Weight
+			if w.Ban {
+				weight = PathWeightBan
+			}
+			weights = append(weights, PathWeight{Pattern: w.Pattern, Weight: weight})
+		}
+		opts = append(opts, WithPathWeights(weights...))
+	}
+	if s := c.Signatures; s != nil {
+		opts = append(opts, WithScannerSignatures(s.Weight, s.Extra...))
+	}
+	if se := c.ServerErrors; se != nil {
+		opts = append(opts, WithServerErrorTracking(ServerErrorConfig{
+			Threshold:   se.Threshold,
+			Window:      se.Window,
+			BanDuration: se.BanDuration,
+			Action:      RuleAction(se.Action),
+		}))
+	}
+
+	redis := RedisStoreConfig{
+		Addr:         c.Store.Redis.Addr,
+		Password:     c.Store.Redis.Password,
+		DB:           c.Store.Redis.DB,
+		KeyPrefix:    c.Store.Redis.KeyPrefix,
+		PoolSize:     c.Store.Redis.PoolSize,
+		MinIdleConns: c.Store.Redis.MinIdleConns,
+		DialTimeout:  c.Store.Redis.DialTimeout,
+		ReadTimeout:  c.Store.Redis.ReadTimeout,
+		WriteTimeout: c.Store.Redis.WriteTimeout,
+		FailClosed:   c.Store.Redis.FailClosed,
+	}
+	switch c.Store.Type {
+	case "", "memory":
+		if r := c.Store.Memory.Resolution; r > 0 {
+			opts = append(opts, WithStore(NewMemoryStoreWithResolution(r)))
+		}
+	case "redis":
+		opts = append(opts, WithStore(NewRedisStore(redis)))
+	case "bolt":
+		store, err := NewBoltStore(c.Store.Bolt.Path)
+		if err != nil {
+			return nil, fmt.Errorf("store.bolt.path: %w", err)
+		}
+		opts = append(opts, WithStore(store))
+	}
+	if a := c.Async; a != nil {
+		opts = append(opts, WithAsyncRecording(AsyncConfig{Buffer: a.Buffer, BatchSize: a.BatchSize}))
+	}
+	if p := c.Propagation; p != nil {
+		if p.Transport == "nats" {
+			propagator, err := NewNATSPropagator(c.NATS.config(), p.Channel)
+			if err != nil {
+				return nil, fmt.Errorf("propagation: %w", err)
+			}
+			opts = append(opts, WithPropagator(propagator))
+		} else {
+			opts = append(opts, WithPropagator(NewRedisPropagator(redis, p.Channel)))
+		}
+	}
+	if s := c.Snapshot; s != nil {
+		opts = append(opts, WithSnapshotFile(s.Path, s.Interval))
+	}
+	if i := c.Import; i != nil {
+		format := ImportFormat(i.Format)
+		if format == "" {
+			format = ImportPlain
+		}
+		opts = append(opts, WithBanImport(i.Path, format))
+	}
+	if r := c.Report; r != nil {
+		reporter := ReporterConfig{Interval: r.Interval, Disabled: r.Disabled, Format: ReportFormat(r.Format), Retention: r.Retention}
+		if r.Format != "" {
+			reporter.Output = os.Stdout
+		}
+		opts = append(opts, WithReporter(reporter))
+	}
+	if cl := c.Cleanup; cl != nil {
+		opts = append(opts, WithCleanup(CleanupConfig{Interval: cl.Interval, MinInterval: cl.MinInterval, HighWater: cl.HighWater}))
+	}
+
+	if w := c.Webhook; w != nil {
+		opts = append(opts, WithWebhook(WebhookConfig{URL: w.URL, Secret: []byte(w.Secret), MaxRetries: w.MaxRetries, Timeout: w.Timeout}))
+	}
+	if s := c.Slack; s != nil {
+		opts = append(opts, WithSlack(s.chatConfig()))
+	}
+	if d := c.Discord; d != nil {
+		opts = append(opts, WithDiscord(d.chatConfig()))
+	}
+	if s := c.StatsD; s != nil {
+		opts = append(opts, WithStatsD(StatsDConfig{Addr: s.Addr, Prefix: s.Prefix, Tags: s.Tags, FlushInterval: s.FlushInterval}))
+	}
+	if a := c.Audit; a != nil {
+		opts = append(opts, WithAuditLog(a.Path))
+	}
+	if f := c.Fail2Ban; f != nil {
+		opts = append(opts, WithFail2BanLog(f.Path))
+	}
+	if e := c.EventLog; e != nil {
+		types := make([]EventType, len(e.Types))
+		for i, name := range e.Types {
+			types[i] = EventType(name)
+		}
+		opts = append(opts, WithEventLog(EventLogConfig{
+			Path:        e.Path,
+			MaxSize:     e.MaxSizeMB << 20,
+			RotateEvery: e.RotateEvery,
+			MaxBackups:  e.MaxBackups,
+			Compress:    e.Compress,
+			Types:       types,
+		}))
+	}
+	if k := c.Kafka; k != nil {
+		cfg := KafkaConfig{
+			Brokers:      k.Brokers,
+			Topic:        k.Topic,
+			BatchSize:    k.BatchSize,
+			BatchTimeout: k.BatchTimeout,
+			Buffer:       k.Buffer,
+			SpoolPath:    k.SpoolPath,
+			Username:     k.Username,
+			Password:     k.Password,
+		}
+		for _, name := range k.Types {
+			cfg.Types = append(cfg.Types, EventType(name))
+		}
+		if k.TLS {
+			cfg.TLS = &tls.Config{}
+		}
+		opts = append(opts, WithKafka(cfg))
+	}
+	if n := c.NATS; n != nil && n.Events {
+		opts = append(opts, WithNATS(n.config()))
+	}
+	if s := c.SIEM; s != nil {
+		opts = append(opts, WithSIEM(SIEMConfig{
+			Format:      SIEMFormat(s.Format),
+			Network:     s.Network,
+			Addr:        s.Addr,
+			Facility:    s.Facility,
+			AppName:     s.AppName,
+			Hostname:    s.Hostname,
+			SkipBlocked: s.SkipBlocked,
+		}))
+	}
+	if f := c.Firewall; f != nil {
+		opts = append(opts, WithFirewallSync(FirewallConfig{Backend: FirewallBackend(f.Backend), Set: f.Set, Set6: f.Set6, Table: f.Table}))
+	}
+	if len(c.Feeds) > 0 {
+		feeds := make([]FeedConfig, len(c.Feeds))
+		for i, f := range c.Feeds {
+			feeds[i] = FeedConfig{Name: f.Name, URL: f.URL, Refresh: f.Refresh, Disabled: f.Disabled}
+		}
+		opts = append(opts, WithFeeds(feeds...))
+	}
+	if a := c.AbuseIPDB; a != nil {
+		opts = append(opts, WithAbuseIPDB(AbuseIPDBConfig{
+			APIKey:           a.APIKey,
+			Report:           a.Report,
+			Categories:       a.Categories,
+			MaxReportsPerDay: a.MaxReportsPerDay,
+			MinConfidence:    a.MinConfidence,
+			MaxAgeInDays:     a.MaxAgeInDays,
+			MaxChecksPerDay:  a.MaxChecksPerDay,
+		}))
+	}
+	if cs := c.CrowdSec; cs != nil {
+		opts = append(opts, WithCrowdSec(CrowdSecConfig{
+			URL:          cs.URL,
+			BouncerKey:   cs.BouncerKey,
+			PollInterval: cs.PollInterval,
+			MachineID:    cs.MachineID,
+			Password:     cs.Password,
+			Scenario:     cs.Scenario,
+		}))
+	}
+	if g := c.GeoIP; g != nil {
+		resolver, err := NewMaxMindResolver(g.CountryDB, g.ASNDB)
+		if err != nil {
+			return nil, fmt.Errorf("geoip: %w", err)
+		}
+		opts = append(opts, WithGeoIP(GeoIPConfig{
+			Resolver:          resolver,
+			BlockedCountries:  g.BlockedCountries,
+			CountryThresholds: g.CountryThresholds,
+			BlockedASNs:       g.BlockedASNs,
+			ASNThresholds:     g.ASNThresholds,
+			ExemptASNs:        g.ExemptASNs,
+		}))
+	}
+
+	return opts, nil
+}
+
+// chatConfig converts the section to a ChatConfig
+func (c *ChatFileConfig) chatConfig() ChatConfig {
+	return ChatConfig{
+		WebhookURL:              c.WebhookURL,
+		BlockedRequestThreshold: c.BlockedRequestThreshold,
+		BlockedRequestWindow:    c.BlockedRequestWindow,
+		MinInterval:             c.MinInterval,
+	}
+}
+
+// NewTracker creates the tracker described by the configuration
+func (c *Config) NewTracker() (*IP404Tracker, error) {
+	opts, err := c.Options()
+	if err != nil {
+		return nil, err
+	}
+	prefixes, hosts, err := c.whitelistEntries()
+	if err != nil {
+		return nil, err
+	}
+	tracker := NewIP404Tracker(c.Threshold, c.Window, c.BanDuration, opts...)
+	tracker.replaceConfigWhitelist(prefixes, hosts)
+	return tracker, nil
+}
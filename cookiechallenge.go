@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CookieChallengeConfig has browsers prove they run JavaScript: a script
+// included in the site's pages solves a small proof of work and gets a
+// signed cookie back. 404s of requests carrying a valid cookie aren't
+// counted, so browsers following broken links are never banned while
+// headless scanners, which don't run the script, are counted as usual.
+type CookieChallengeConfig struct {
+	// Signs challenges and cookies; instances sharing bans need the same
+	// secret. A random one is generated when empty, which invalidates
+	// cookies on restart.
+	Secret []byte
+
+	Difficulty int           // Leading zero bits the SHA-256 of the solution needs (default 16)
+	TTL        time.Duration // Cookie lifetime (default 24h)
+	CookieName string        // Default "404blocker_pass"
+
+	// Serves the script and checks solutions (default "/.well-known/404blocker/pass.js")
+	Path string
+}
+
+// Age after which an unsolved challenge is refused
+const cookieChallengeMaxAge = 5 * time.Minute
+
+// cookieChallenge holds the cookie challenge settings
+type cookieChallenge struct {
+	cfg CookieChallengeConfig
+}
+
+// WithCookieChallenge exempts requests from browsers that solved the
+// JavaScript challenge from 404 counting. Add
+// <script src="/.well-known/404blocker/pass.js" async></script> to the
+// site's pages.
+func WithCookieChallenge(cfg CookieChallengeConfig) Option {
+	return func(t *IP404Tracker) {
+		if len(cfg.Secret) == 0 {
+			cfg.Secret = make([]byte, 32)
+			rand.Read(cfg.Secret)
+		}
+		if cfg.Difficulty <= 0 {
+			cfg.Difficulty = 16
+		}
+		if cfg.TTL <= 0 {
+			cfg.TTL = 24 * time.Hour
+		}
+		if cfg.CookieName == "" {
+			cfg.CookieName = "404blocker_pass"
+		}
+		if cfg.Path == "" {
+			cfg.Path = "/.well-known/404blocker/pass.js"
+		}
+		t.cookieChallenge = &cookieChallenge{cfg: cfg}
+	}
+}
+
+// sign returns the MAC of the dot-joined parts
+func (c *cookieChallenge) sign(parts ...string) string {
+	mac := hmac.New(sha256.New, c.cfg.Secret)
+	mac.Write([]byte(strings.Join(parts, ".")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifySigned checks a value made of dot-separated parts with the MAC of
+// the others and ip last, returning the parts
+func (c *cookieChallenge) verifySigned(value, ip string, n int) ([]string, bool) {
+	parts := strings.Split(value, ".")
+	if len(parts) != n+1 {
+		return nil, false
+	}
+	want := c.sign(append(parts[:n:n], ip)...)
+	if !hmac.Equal([]byte(parts[n]), []byte(want)) {
+		return nil, false
+	}
+	return parts[:n], true
+}
+
+// passed reports whether req carries a valid cookie for its IP
+func (c *cookieChallenge) passed(req clientRequest, now time.Time) bool {
+	if c == nil || req.passCookie == "" {
+		return false
+	}
+	parts, ok := c.verifySigned(req.passCookie, req.ip, 1)
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	return err == nil && now.Unix() < expires
+}
+
+// cookie returns the challenge cookie of r, if the cookie challenge is on
+func (c *cookieChallenge) cookie(r *http.Request) string {
+	if c == nil {
+		return ""
+	}
+	cookie, err := r.Cookie(c.cfg.CookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// newChallenge returns a challenge token for ip, valid for a few minutes
+func (c *cookieChallenge) newChallenge(ip string, now time.Time) string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	issued := strconv.FormatInt(now.Unix(), 10)
+	random := hex.EncodeToString(nonce)
+	return issued + "." + random + "." + c.sign(issued, random, ip)
+}
+
+// solved reports whether solution is a proof of work for a current
+// challenge issued to ip
+func (c *cookieChallenge) solved(challenge, solution, ip string, now time.Time) bool {
+	parts, ok := c.verifySigned(challenge, ip, 2)
+	if !ok {
+		return false
+	}
+	issued, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || now.Sub(time.Unix(issued, 0)) > cookieChallengeMaxAge {
+		return false
+	}
+	return leadingZeroBits(sha256.Sum256([]byte(challenge+":"+solution))) >= c.cfg.Difficulty
+}
+
+// leadingZeroBits counts the zero bits at the start of sum
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// serveCookieChallenge hands out the script with a fresh challenge, and the
+// cookie for a solved one
+func (t *IP404Tracker) serveCookieChallenge(w http.ResponseWriter, r *http.Request, req clientRequest) {
+	c := t.cookieChallenge
+	now := t.clock.Now()
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Method == http.MethodPost {
+		if !c.solved(r.FormValue("challenge"), r.FormValue("solution"), req.ip, now) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		expires := now.Add(c.cfg.TTL)
+		value := strconv.FormatInt(expires.Unix(), 10)
+		http.SetCookie(w, &http.Cookie{
+			Name:     c.cfg.CookieName,
+			Value:    value + "." + c.sign(value, req.ip),
+			Path:     "/",
+			Expires:  expires,
+			Secure:   r.TLS != nil,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	if c.passed(req, now) {
+		// Nothing to do until the cookie runs out
+		return
+	}
+	challenge, _ := json.Marshal(c.newChallenge(req.ip, now))
+	path, _ := json.Marshal(c.cfg.Path)
+	fmt.Fprintf(w, cookieChallengeScript, challenge, c.cfg.Difficulty, path)
+}
+
+// cookieChallengeScript finds a number whose SHA-256 with the challenge
+// starts with enough zero bits and posts it back
+const cookieChallengeScript = `(async () => {
+  const challenge = %s, difficulty = %d, path = %s;
+  const enc = new TextEncoder();
+  const zeros = (h) => {
+    let n = 0;
+    for (const b of h) {
+      if (b) return n + Math.clz32(b) - 24;
+      n += 8;
+    }
+    return n;
+  };
+  for (let i = 0; ; i++) {
+    const h = new Uint8Array(await crypto.subtle.digest("SHA-256", enc.encode(challenge + ":" + i)));
+    if (zeros(h) >= difficulty) {
+      await fetch(path, {
+        method: "POST",
+        credentials: "same-origin",
+        headers: {"Content-Type": "application/x-www-form-urlencoded"},
+        body: "challenge=" + encodeURIComponent(challenge) + "&solution=" + i,
+      });
+      return;
+    }
+  }
+})();
+`
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// knownCrawler maps a user agent token to the domains its reverse DNS
+// names must belong to
+type knownCrawler struct {
+	token   string
+	domains []string
+}
+
+// knownCrawlers lists the crawlers that publish forward-confirmed reverse
+// DNS as their verification method
+var knownCrawlers = []knownCrawler{
+	{"googlebot", []string{"googlebot.com", "google.com", "googleusercontent.com"}},
+	{"google-inspectiontool", []string{"googlebot.com", "google.com"}},
+	{"bingbot", []string{"search.msn.com"}},
+	{"applebot", []string{"applebot.apple.com"}},
+	{"yandexbot", []string{"yandex.ru", "yandex.net", "yandex.com"}},
+	{"baiduspider", []string{"baidu.com", "baidu.jp"}},
+}
+
+const (
+	// How long a verification result is trusted
+	crawlerVerifiedTTL = 24 * time.Hour
+	crawlerRejectedTTL = time.Hour
+
+	// Upper bound for the DNS lookups of one verification
+	crawlerLookupTimeout = 3 * time.Second
+)
+
+// crawlerVerdict is a cached verification result for one IP
+type crawlerVerdict struct {
+	genuine bool
+	expires time.Time
+}
+
+// crawlerVerifier checks that clients claiming to be search engine crawlers
+// really are, so they can be exempted without letting impostors in
+type crawlerVerifier struct {
+	resolver *net.Resolver
+
+	mu       sync.Mutex
+	verdicts map[string]crawlerVerdict
+}
+
+// WithCrawlerVerification exempts genuine search engine crawlers (Googlebot,
+// Bingbot, ...) from tracking. A client whose user agent names a known
+// crawler is verified with a reverse DNS lookup of its IP followed by a
+// forward lookup of the returned name; clients failing the check are
+// tracked and banned like anyone else. Results are cached per IP.
+func WithCrawlerVerification() Option {
+	return func(t *IP404Tracker) {
+		t.crawlers = &crawlerVerifier{
+			resolver: net.DefaultResolver,
+			verdicts: make(map[string]crawlerVerdict),
+		}
+	}
+}
+
+// claimedCrawler returns the crawler named in the user agent, if any
+func claimedCrawler(userAgent string) (knownCrawler, bool) {
+	ua := strings.ToLower(userAgent)
+	for _, c := range knownCrawlers {
+		if strings.Contains(ua, c.token) {
+			return c, true
+		}
+	}
+	return knownCrawler{}, false
+}
+
+// isVerified reports whether ip is a crawler that already passed
+// verification at the given time, without doing any lookups
+func (v *crawlerVerifier) isVerified(ip, userAgent string, now time.Time) bool {
+	if v == nil {
+		return false
+	}
+	if _, ok := claimedCrawler(userAgent); !ok {
+		return false
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	verdict, ok := v.verdicts[ip]
+	return ok && verdict.genuine && now.Before(verdict.expires)
+}
+
+// verify reports whether the client is a genuine crawler, resolving and
+// caching the result when the user agent claims to be one
+func (v *crawlerVerifier) verify(ctx context.Context, ip, userAgent string, now time.Time, logger Logger) bool {
+	if v == nil {
+		return false
+	}
+	crawler, ok := claimedCrawler(userAgent)
+	if !ok {
+		return false
+	}
+
+	v.mu.Lock()
+	verdict, ok := v.verdicts[ip]
+	v.mu.Unlock()
+	if ok && now.Before(verdict.expires) {
+		return verdict.genuine
+	}
+
+	// The request may already be finished; don't let its cancellation
+	// abort the lookup
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), crawlerLookupTimeout)
+	defer cancel()
+
+	genuine, err := v.confirm(ctx, ip, crawler)
+	if err != nil {
+		// Lookup failures aren't cached so the next request tries again
+		logger.Warn("crawler verification failed", "ip", ip, "crawler", crawler.token, "error", err)
+		return false
+	}
+
+	verdict = crawlerVerdict{genuine: genuine, expires: now.Add(crawlerVerifiedTTL)}
+	if !genuine {
+		verdict.expires = now.Add(crawlerRejectedTTL)
+		logger.Info("crawler impostor", "ip", ip, "crawler", crawler.token, "user_agent", userAgent)
+	}
+	v.mu.Lock()
+	v.verdicts[ip] = verdict
+	v.mu.Unlock()
+
+	return genuine
+}
+
+// confirm performs forward-confirmed reverse DNS: one of the IP's PTR names
+// must be in the crawler's domains and resolve back to the same IP
+func (v *crawlerVerifier) confirm(ctx context.Context, ip string, crawler knownCrawler) (bool, error) {
+	names, err := v.resolver.LookupAddr(ctx, ip)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	for _, name := range names {
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		if !inDomains(name, crawler.domains) {
+			continue
+		}
+
+		addrs, err := v.resolver.LookupHost(ctx, name)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if addr == ip {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// inDomains reports whether name is one of domains or a subdomain of one
+func inDomains(name string, domains []string) bool {
+	for _, domain := range domains {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanup forgets expired verification results
+func (v *crawlerVerifier) cleanup(now time.Time) {
+	if v == nil {
+		return
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for ip, verdict := range v.verdicts {
+		if !now.Before(verdict.expires) {
+			delete(v.verdicts, ip)
+		}
+	}
+}
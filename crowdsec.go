@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// crowdSecBuffer is how many ban events may queue up for pushing
+	crowdSecBuffer = 1024
+
+	// crowdSecDefaultScenario names the alerts pushed to CrowdSec
+	crowdSecDefaultScenario = "404blocker/404-scan"
+)
+
+// CrowdSecConfig configures WithCrowdSec
+type CrowdSecConfig struct {
+	URL string // Local API, default http://127.0.0.1:8080
+
+	// Bouncer API key (cscli bouncers add) for pulling decisions; pulling is
+	// disabled when empty
+	BouncerKey   string
+	PollInterval time.Duration // Default 10s
+
+	// Machine credentials (cscli machines add) for pushing bans as alerts;
+	// pushing is disabled when empty
+	MachineID string
+	Password  string
+	Scenario  string // Default "404blocker/404-scan"
+}
+
+// WithCrowdSec connects the tracker to a CrowdSec Local API: ban decisions
+// pulled from it (including the community blocklist) are enforced like
+// range bans, and the tracker's own threshold bans are pushed to it as alerts
+func WithCrowdSec(cfg CrowdSecConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.URL == "" {
+			cfg.URL = "http://127.0.0.1:8080"
+		}
+		cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+		if cfg.PollInterval <= 0 {
+			cfg.PollInterval = 10 * time.Second
+		}
+		if cfg.Scenario == "" {
+			cfg.Scenario = crowdSecDefaultScenario
+		}
+		t.crowdSec = &crowdSec{
+			cfg:       cfg,
+			client:    &http.Client{Timeout: 10 * time.Second},
+			decisions: &prefixTrie[time.Time]{},
+		}
+	}
+}
+
+// crowdSec holds the decisions pulled from CrowdSec and the push login
+type crowdSec struct {
+	cfg    CrowdSecConfig
+	client *http.Client
+
+	mu        sync.RWMutex
+	decisions *prefixTrie[time.Time] // Banned ranges and their expiry
+	token     string                 // JWT for pushing alerts
+}
+
+// crowdSecDecision is a remediation decision as returned by the Local API
+type crowdSecDecision struct {
+	Duration string `json:"duration"`
+	Origin   string `json:"origin,omitempty"`
+	Scenario string `json:"scenario"`
+	Scope    string `json:"scope"`
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+}
+
+// banned reports whether a CrowdSec decision bans addr
+func (c *crowdSec) banned(addr netip.Addr, now time.Time) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	banned := false
+	c.decisions.lookupAll(addr, func(_ netip.Prefix, until time.Time) bool {
+		banned = now.Before(until)
+		return !banned
+	})
+	return banned
+}
+
+// crowdSecBanned reports whether CrowdSec has decided to ban ip
+func (t *IP404Tracker) crowdSecBanned(ip string) bool {
+	if t.crowdSec == nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	return t.crowdSec.banned(addr.Unmap(), t.clock.Now())
+}
+
+// decisionPrefix returns the range an Ip or Range decision covers
+func decisionPrefix(d crowdSecDecision) (netip.Prefix, bool) {
+	switch strings.ToLower(d.Scope) {
+	case "ip", "range":
+	default:
+		// Country, AS and other scopes aren't supported
+		return netip.Prefix{}, false
+	}
+	prefix, err := parseIPOrCIDR(d.Value)
+	return prefix, err == nil
+}
+
+// apply merges a batch of new and deleted decisions
+func (c *crowdSec) apply(added, deleted []crowdSecDecision, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, d := range deleted {
+		if prefix, ok := decisionPrefix(d); ok {
+			c.decisions.remove(prefix)
+		}
+	}
+	for _, d := range added {
+		prefix, ok := decisionPrefix(d)
+		if !ok || !strings.EqualFold(d.Type, "ban") {
+			continue
+		}
+		duration, err := time.ParseDuration(d.Duration)
+		if err != nil {
+			continue
+		}
+		// Keep the longest of overlapping decisions on the same range
+		until := now.Add(duration)
+		if current, ok := c.decisions.get(prefix); !ok || until.After(current) {
+			c.decisions.insert(prefix, until)
+		}
+	}
+}
+
+// cleanup forgets decisions that ran out
+func (c *crowdSec) cleanup(now time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var expired []netip.Prefix
+	c.decisions.walk(func(prefix netip.Prefix, until time.Time) {
+		if !now.Before(until) {
+			expired = append(expired, prefix)
+		}
+	})
+	for _, prefix := range expired {
+		c.decisions.remove(prefix)
+	}
+}
+
+// poll fetches the decision changes since the last poll
+func (c *crowdSec) poll(startup bool) (added, deleted []crowdSecDecision, err error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v1/decisions/stream?startup=%t", c.cfg.URL, startup), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("X-Api-Key", c.cfg.BouncerKey)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("CrowdSec returned %s", resp.Status)
+	}
+
+	var stream struct {
+		New     []crowdSecDecision `json:"new"`
+		Deleted []crowdSecDecision `json:"deleted"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&stream); err != nil {
+		return nil, nil, err
+	}
+	return stream.New, stream.Deleted, nil
+}
+
+// crowdSecPullLoop keeps the pulled decisions up to date
+func (t *IP404Tracker) crowdSecPullLoop() {
+	c := t.crowdSec
+	// The first successful poll returns every active decision
+	startup := true
+	for {
+		added, deleted, err := c.poll(startup)
+		if err != nil {
+			t.logger.Error("pulling CrowdSec decisions failed", "error", err)
+		} else {
+			c.apply(added, deleted, t.clock.Now())
+			if startup || len(added)+len(deleted) > 0 {
+				t.logger.Debug("CrowdSec decisions pulled", "new", len(added), "deleted", len(deleted))
+			}
+			startup = false
+		}
+		if !t.sleep(c.cfg.PollInterval) {
+			return
+		}
+	}
+}
+
+// login gets a JWT for the machine credentials
+func (c *crowdSec) login() (string, error) {
+	body, err := json.Marshal(map[string]string{
+		"machine_id": c.cfg.MachineID,
+		"password":   c.cfg.Password,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.client.Post(c.cfg.URL+"/v1/watchers/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("CrowdSec login returned %s", resp.Status)
+	}
+
+	var result struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.Token == "" {
+		return "", errors.New("CrowdSec login returned no token")
+	}
+	return result.Token, nil
+}
+
+// errCrowdSecUnauthorized means the JWT expired and a new login is needed
+var errCrowdSecUnauthorized = errors.New("CrowdSec rejected the token")
+
+// postAlert pushes an alert with a token
+func (c *crowdSec) postAlert(token string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, c.cfg.URL+"/v1/alerts", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return errCrowdSecUnauthorized
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("CrowdSec returned %s", resp.Status)
+	}
+	return nil
+}
+
+// push sends a ban to CrowdSec as an alert carrying a ban decision made at
+// now, logging in again once if the token has expired
+func (c *crowdSec) push(prefix netip.Prefix, event Event, now time.Time) error {
+	scope, value := "Ip", prefix.Addr().String()
+	if !prefix.IsSingleIP() {
+		scope, value = "Range", prefix.String()
+	}
+	now = now.UTC()
+	duration := event.ExpiresAt.Sub(now).Round(time.Second)
+	message := fmt.Sprintf("%s banned by 404blocker: %d 404s", value, event.Count)
+
+	body, err := json.Marshal([]map[string]any{{
+		"scenario":         c.cfg.Scenario,
+		"scenario_hash":    "",
+		"scenario_version": "",
+		"message":          message,
+		"events_count":     event.Count,
+		"events":           []any{},
+		"start_at":         now.Format(time.RFC3339),
+		"stop_at":          now.Format(time.RFC3339),
+		"capacity":         0,
+		"leakspeed":        "0",
+		"simulated":        false,
+		"remediation":      true,
+		"source": map[string]string{
+			"scope": scope,
+			"value": value,
+		},
+		"decisions": []crowdSecDecision{{
+			Duration: duration.String(),
+			Origin:   "crowdsec",
+			Scenario: c.cfg.Scenario,
+			Scope:    scope,
+			Type:     "ban",
+			Value:    value,
+		}},
+	}})
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		c.mu.RLock()
+		token := c.token
+		c.mu.RUnlock()
+		if token == "" {
+			if token, err = c.login(); err != nil {
+				return err
+			}
+			c.mu.Lock()
+			c.token = token
+			c.mu.Unlock()
+		}
+
+		err = c.postAlert(token, body)
+		if !errors.Is(err, errCrowdSecUnauthorized) {
+			return err
+		}
+		c.mu.Lock()
+		c.token = ""
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// crowdSecPushLoop pushes threshold bans to CrowdSec
+func (t *IP404Tracker) crowdSecPushLoop(events <-chan Event) {
+	for event := range events {
+		if event.Type != EventBanned || event.Reason != BanReasonThreshold && event.Reason != BanReasonRepeatOffender {
+			continue
+		}
+		prefix, err := parseIPOrCIDR(event.IP)
+		if err != nil {
+			// Client keys and fingerprints don't stand for a whole address
+			continue
+		}
+		if err := t.crowdSec.push(prefix, event, t.clock.Now()); err != nil {
+			t.logger.Error("pushing ban to CrowdSec failed", "ip", event.IP, "error", err)
+			continue
+		}
+		t.logger.Debug("ban pushed to CrowdSec", "ip", event.IP)
+	}
+}
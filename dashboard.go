@@ -0,0 +1,31 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// topOffendersShown is how many offenders the dashboard lists
+const topOffendersShown = 20
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// adminDashboard serves the embedded dashboard page. The page holds no data;
+// it calls the admin API with the key the operator enters.
+func (t *IP404Tracker) adminDashboard(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", dashboardHTML)
+}
+
+func (t *IP404Tracker) adminActivity(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"recent":        t.RecentActivity(),
+		"top_offenders": t.TopOffenders(topOffendersShown),
+	})
+}
+
+func (t *IP404Tracker) adminStats(c *gin.Context) {
+	c.JSON(http.StatusOK, t.Stats())
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// DecoyKind selects a generated decoy page
+type DecoyKind string
+
+const (
+	// DecoyListing is an Apache-style directory index of made-up files
+	DecoyListing DecoyKind = "listing"
+	// DecoyLogin is a login form with random field names
+	DecoyLogin DecoyKind = "login"
+)
+
+// DecoyRule serves a decoy for paths matching Pattern, a path.Match glob.
+// Patterns containing a '/' are matched against the whole path (e.g.
+// "/wp-admin/*"), others against its last element (e.g. "*.php" or ".env").
+type DecoyRule struct {
+	Pattern string
+	Kind    DecoyKind
+
+	// Rendered with a DecoyPage instead of the built-in page for Kind
+	Template *template.Template
+}
+
+// DecoyConfig makes banned clients get believable but fake content instead
+// of the ban response, so scanners fill their results with junk
+type DecoyConfig struct {
+	Rules   []DecoyRule // First match wins
+	Default DecoyKind   // Served when no rule matches; empty uses the ban response
+	Status  int         // Status of decoy pages (default 200)
+}
+
+// DecoyPage is the data decoy templates are rendered with. It's generated
+// from the path, so the same path always shows the same decoy.
+type DecoyPage struct {
+	Path   string
+	Title  string
+	Files  []DecoyFile // For listings
+	Fields []string    // Random form field names for logins: user, password, token
+	Token  string      // Random hidden form value
+}
+
+// DecoyFile is an entry of a decoy directory listing
+type DecoyFile struct {
+	Name     string
+	Size     string
+	Modified time.Time
+}
+
+// WithDecoys serves generated decoys to banned clients in place of the ban
+// response, for the paths the rules cover
+func WithDecoys(cfg DecoyConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.Status == 0 {
+			cfg.Status = http.StatusOK
+		}
+		t.decoys = &decoys{cfg: cfg, seed: rand.Uint64()}
+	}
+}
+
+// decoys holds the decoy settings and the seed that keeps each tracker's
+// decoys its own
+type decoys struct {
+	cfg  DecoyConfig
+	seed uint64
+}
+
+// match returns the rule for urlPath, if any
+func (d *decoys) match(urlPath string) (DecoyRule, bool) {
+	for _, rule := range d.cfg.Rules {
+		if matchPath(rule.Pattern, urlPath) {
+			return rule, true
+		}
+	}
+	if d.cfg.Default != "" {
+		return DecoyRule{Kind: d.cfg.Default}, true
+	}
+	return DecoyRule{}, false
+}
+
+// decoyResponse renders the decoy for a blocked request, reporting false
+// when no decoy applies
+func (t *IP404Tracker) decoyResponse(blocked BlockedRequest) (BanResponse, bool) {
+	d := t.decoys
+	if d == nil {
+		return BanResponse{}, false
+	}
+	rule, ok := d.match(blocked.Path)
+	if !ok {
+		return BanResponse{}, false
+	}
+
+	tmpl := rule.Template
+	if tmpl == nil {
+		if tmpl = decoyTemplates[rule.Kind]; tmpl == nil {
+			t.logger.Error("unknown decoy kind", "kind", rule.Kind)
+			return BanResponse{}, false
+		}
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, d.page(blocked.Path, t.clock.Now())); err != nil {
+		t.logger.Error("rendering decoy failed", "path", blocked.Path, "error", err)
+		return BanResponse{}, false
+	}
+	return BanResponse{
+		Status:  d.cfg.Status,
+		Body:    body.String(),
+		Headers: map[string]string{"Content-Type": "text/html; charset=utf-8"},
+	}, true
+}
+
+// Words decoy file names and titles are made of
+var (
+	decoyNames      = []string{"backup", "config", "db", "dump", "old", "site", "private", "users", "export", "admin", "data", "secret", "prod", "staging", "wp", "archive"}
+	decoyExtensions = []string{".sql", ".zip", ".tar.gz", ".bak", ".env", ".php", ".old", ".json", ".yml", "/"}
+	decoyTitles     = []string{"Admin Login", "Sign In", "Control Panel", "Administration", "Dashboard Login", "Staff Login"}
+)
+
+// page generates the decoy contents for urlPath
+func (d *decoys) page(urlPath string, now time.Time) DecoyPage {
+	h := fnv.New64a()
+	h.Write([]byte(urlPath))
+	rng := rand.New(rand.NewPCG(d.seed, h.Sum64()))
+	// Dates only move on daily, so a repeated request sees the same page
+	now = now.Truncate(24 * time.Hour)
+
+	page := DecoyPage{
+		Path:  urlPath,
+		Title: decoyTitles[rng.IntN(len(decoyTitles))],
+		Fields: []string{
+			decoyField(rng, "u"),
+			decoyField(rng, "p"),
+			decoyField(rng, "t"),
+		},
+		Token: decoyToken(rng, 16),
+	}
+	seen := make(map[string]bool)
+	for range 4 + rng.IntN(12) {
+		name := decoyNames[rng.IntN(len(decoyNames))]
+		if rng.IntN(2) == 0 {
+			name += fmt.Sprintf("_%d", 2015+rng.IntN(11))
+		}
+		ext := decoyExtensions[rng.IntN(len(decoyExtensions))]
+		if seen[name+ext] {
+			continue
+		}
+		seen[name+ext] = true
+
+		file := DecoyFile{
+			Name:     name + ext,
+			Size:     "-",
+			Modified: now.Add(-time.Duration(rng.Int64N(int64(3 * 365 * 24 * time.Hour)))).Truncate(time.Minute),
+		}
+		if ext != "/" {
+			// Mostly small files with the odd large dump
+			file.Size = decoySize(int64(math.Exp(rng.Float64() * 18)))
+		}
+		page.Files = append(page.Files, file)
+	}
+	sort.Slice(page.Files, func(i, j int) bool { return page.Files[i].Name < page.Files[j].Name })
+	return page
+}
+
+// decoySize formats a file size the way Apache's index does
+func decoySize(size int64) string {
+	switch {
+	case size < 1<<10:
+		return fmt.Sprintf("%d", size)
+	case size < 1<<20:
+		return fmt.Sprintf("%dK", size>>10)
+	default:
+		return fmt.Sprintf("%.1fM", float64(size)/(1<<20))
+	}
+}
+
+// decoyField returns a random form field name starting with prefix
+func decoyField(rng *rand.Rand, prefix string) string {
+	return prefix + "_" + decoyToken(rng, 4)
+}
+
+// decoyToken returns n random bytes in hex
+func decoyToken(rng *rand.Rand, n int) string {
+	b := make([]byte, 0, n+8)
+	for len(b) < n {
+		b = binary.LittleEndian.AppendUint64(b, rng.Uint64())
+	}
+	return hex.EncodeToString(b[:n])
+}
+
+// decoyTemplates are the built-in decoy pages
+var decoyTemplates = map[DecoyKind]*template.Template{
+	DecoyListing: template.Must(template.New("listing").Parse(`<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 3.2 Final//EN">
+<html>
+ <head>
+  <title>Index of {{.Path}}</title>
+ </head>
+ <body>
+<h1>Index of {{.Path}}</h1>
+  <table>
+   <tr><th>Name</th><th>Last modified</th><th>Size</th></tr>
+   <tr><th colspan="3"><hr></th></tr>
+   <tr><td><a href="../">Parent Directory</a></td><td>&nbsp;</td><td align="right">-</td></tr>
+{{- range .Files}}
+   <tr><td><a href="{{.Name}}">{{.Name}}</a></td><td align="right">{{.Modified.Format "2006-01-02 15:04"}}</td><td align="right">{{.Size}}</td></tr>
+{{- end}}
+   <tr><th colspan="3"><hr></th></tr>
+</table>
+</body></html>
+`)),
+	DecoyLogin: template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{.Title}}</title></head>
+<body>
+<h2>{{.Title}}</h2>
+<form method="post" action="{{.Path}}">
+<input type="hidden" name="{{index .Fields 2}}" value="{{.Token}}">
+<label>Username <input type="text" name="{{index .Fields 0}}"></label><br>
+<label>Password <input type="password" name="{{index .Fields 1}}"></label><br>
+<button type="submit">Log in</button>
+</form>
+</body>
+</html>
+`)),
+}
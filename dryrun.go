@@ -0,0 +1,36 @@
+package main
+
+// WithDryRun makes the tracker observe without enforcing: 404s are counted,
+// bans are issued, and events, metrics and traces are produced as usual, but
+// banned clients are let through and logged as "request would have been
+// blocked". The firewall sync, the fail2ban log and reports to AbuseIPDB and
+// CrowdSec are held back too, so thresholds can be tuned against production
+// traffic before anyone is locked out.
+func WithDryRun() Option {
+	return func(t *IP404Tracker) {
+		t.dryRun = true
+	}
+}
+
+// holdEnforcement turns off the integrations that would block or report
+// clients outside the tracker, logging which ones were configured
+func (t *IP404Tracker) holdEnforcement() {
+	var held []string
+	if t.firewall != nil {
+		held = append(held, "firewall")
+		t.firewall = nil
+	}
+	if t.fail2banPath != "" {
+		held = append(held, "fail2ban")
+		t.fail2banPath = ""
+	}
+	if t.abuseIPDB != nil && t.abuseIPDB.cfg.Report {
+		held = append(held, "abuseipdb")
+		t.abuseIPDB.cfg.Report = false
+	}
+	if t.crowdSec != nil && t.crowdSec.cfg.MachineID != "" {
+		held = append(held, "crowdsec")
+		t.crowdSec.cfg.MachineID = ""
+	}
+	t.logger.Warn("dry-run mode: banned clients are not blocked", "held_back", held)
+}
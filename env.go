@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix starts the name of every environment variable read by ApplyEnv
+const envPrefix = "BLOCKER_"
+
+// ApplyEnv overrides the configuration with environment variables named
+// after the YAML keys: BLOCKER_ followed by the upper-cased path joined with
+// underscores, e.g. BLOCKER_THRESHOLD, BLOCKER_STORE_REDIS_ADDR or
+// BLOCKER_SLACK_WEBHOOK_URL. Lists are comma-separated, maps are written as
+// "CN=1,RU=2" and list entries with several fields are numbered from 0, as
+// in BLOCKER_FEEDS_0_NAME. Setting any variable of a section turns it on.
+// lookup is usually os.LookupEnv.
+func (c *Config) ApplyEnv(lookup func(name string) (string, bool)) error {
+	var errs []error
+	applyEnv(reflect.ValueOf(c).Elem(), strings.TrimSuffix(envPrefix, "_"), lookup, &errs)
+	return errors.Join(errs...)
+}
+
+// applyEnv sets the fields of the struct v from the variables under prefix
+// and reports whether any was set
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool), errs *[]error) bool {
+	set := false
+	for i := 0; i < v.NumField(); i++ {
+		tag, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(tag)
+		if applyEnvField(v.Field(i), name, lookup, errs) {
+			set = true
+		}
+	}
+	return set
+}
+
+// applyEnvField sets a single field from the variable name, or from the
+// variables under it for sections and lists of them
+func applyEnvField(field reflect.Value, name string, lookup func(string) (string, bool), errs *[]error) bool {
+	switch {
+	case field.Kind() == reflect.Pointer && field.Type().Elem().Kind() == reflect.Struct:
+		// Only allocate the section when one of its variables is set
+		section := reflect.New(field.Type().Elem())
+		if !field.IsNil() {
+			section.Elem().Set(field.Elem())
+		}
+		if !applyEnv(section.Elem(), name, lookup, errs) {
+			return false
+		}
+		field.Set(section)
+		return true
+
+	case field.Kind() == reflect.Struct:
+		return applyEnv(field, name, lookup, errs)
+
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Struct:
+		set := false
+		for i := 0; ; i++ {
+			entry := reflect.New(field.Type().Elem()).Elem()
+			if i < field.Len() {
+				entry.Set(field.Index(i))
+			}
+			if !applyEnv(entry, fmt.Sprintf("%s_%d", name, i), lookup, errs) {
+				break
+			}
+			if i < field.Len() {
+				field.Index(i).Set(entry)
+			} else {
+				field.Set(reflect.Append(field, entry))
+			}
+			set = true
+		}
+		return set
+	}
+
+	value, ok := lookup(name)
+	if !ok {
+		return false
+	}
+	if err := setEnvValue(field, strings.TrimSpace(value)); err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %w", name, err))
+	}
+	return true
+}
+
+// setEnvValue parses value into a scalar, list or map field
+func setEnvValue(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.Slice:
+		list := reflect.MakeSlice(field.Type(), 0, 0)
+		for _, item := range splitEnvList(value) {
+			elem := reflect.New(field.Type().Elem()).Elem()
+			if err := setEnvScalar(elem, item); err != nil {
+				return err
+			}
+			list = reflect.Append(list, elem)
+		}
+		field.Set(list)
+		return nil
+
+	case reflect.Map:
+		entries := reflect.MakeMap(field.Type())
+		for _, item := range splitEnvList(value) {
+			k, v, ok := strings.Cut(item, "=")
+			if !ok {
+				return fmt.Errorf("invalid map entry %q (want key=value)", item)
+			}
+			key := reflect.New(field.Type().Key()).Elem()
+			if err := setEnvScalar(key, strings.TrimSpace(k)); err != nil {
+				return err
+			}
+			elem := reflect.New(field.Type().Elem()).Elem()
+			if err := setEnvScalar(elem, strings.TrimSpace(v)); err != nil {
+				return err
+			}
+			entries.SetMapIndex(key, elem)
+		}
+		field.Set(entries)
+		return nil
+	}
+	return setEnvScalar(field, value)
+}
+
+// splitEnvList splits a comma-separated list, dropping empty items
+func splitEnvList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// setEnvScalar parses value into a string, number, bool or duration field
+func setEnvScalar(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetUint(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import "time"
+
+// defaultEscalationSchedule is used when EscalationConfig.Schedule is empty
+var defaultEscalationSchedule = []time.Duration{
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+}
+
+// defaultEscalationMemory is how long a ban counts towards escalation by default
+const defaultEscalationMemory = 30 * 24 * time.Hour
+
+// EscalationConfig makes repeat offenders wait longer for their ban to lift
+type EscalationConfig struct {
+	// Ban durations for the first, second, ... automatic ban of an IP
+	// (default 1h, 6h, 24h, 7d)
+	Schedule []time.Duration
+
+	// Bans past the end of the schedule multiply the previous duration by
+	// Multiplier; values up to 1 keep repeating the last duration
+	Multiplier float64
+
+	// Upper bound on any escalated duration, 0 for none
+	Max time.Duration
+
+	// How long a ban counts towards escalation (default 30 days)
+	Memory time.Duration
+}
+
+// WithBanEscalation bans repeat offenders for longer each time according to
+// cfg, instead of the flat ban duration given to NewIP404Tracker. Manual bans
+// keep the duration they were given.
+func WithBanEscalation(cfg EscalationConfig) Option {
+	return func(t *IP404Tracker) {
+		if len(cfg.Schedule) == 0 {
+			cfg.Schedule = defaultEscalationSchedule
+		}
+		if cfg.Memory <= 0 {
+			cfg.Memory = defaultEscalationMemory
+		}
+		t.escalation = &cfg
+	}
+}
+
+// duration returns the ban duration for an IP's offense'th ban
+func (cfg *EscalationConfig) duration(offense int) time.Duration {
+	offense = max(offense, 1)
+
+	var d time.Duration
+	if offense <= len(cfg.Schedule) {
+		d = cfg.Schedule[offense-1]
+	} else {
+		d = cfg.Schedule[len(cfg.Schedule)-1]
+		for i := len(cfg.Schedule); i < offense && cfg.Multiplier > 1; i++ {
+			d = time.Duration(float64(d) * cfg.Multiplier)
+			if cfg.Max > 0 && d >= cfg.Max {
+				break
+			}
+		}
+	}
+
+	if cfg.Max > 0 && d > cfg.Max {
+		d = cfg.Max
+	}
+	return d
+}
+
+// banDurationFor returns how long the offense'th automatic ban of an IP lasts
+func (t *IP404Tracker) banDurationFor(offense int) time.Duration {
+	if t.permanentFor(offense) {
+		return t.permanentBan.Duration
+	}
+	if t.escalation == nil {
+		return t.limits.Load().banDuration
+	}
+	return t.escalation.duration(offense)
+}
+
+// recordOffense counts a new automatic ban of ip, returning how many
+// remembered bans ip has had including this one
+func (t *IP404Tracker) recordOffense(ip string, now time.Time) int {
+	memory := t.offenseMemory()
+	if memory == 0 {
+		return 1
+	}
+
+	offense, err := t.store.RecordOffense(ip, now, memory)
+	if err != nil {
+		t.logger.Error("recording offense failed", "ip", ip, "error", err)
+		return 1
+	}
+	return offense
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// eventLogBuffer is how many events may queue up while the log is written
+// or rotated
+const eventLogBuffer = 4096
+
+// eventLogTimeFormat stamps rotated files; it sorts by time
+const eventLogTimeFormat = "20060102T150405.000Z"
+
+// EventLogConfig writes every event to a file as a JSON line, rotating it
+// by size and age, so events can be kept without a log shipper
+type EventLogConfig struct {
+	Path string
+
+	MaxSize     int64         // Bytes after which the file is rotated (default 100 MiB)
+	RotateEvery time.Duration // Rotate files this old too; zero rotates by size only
+	MaxBackups  int           // Rotated files kept (default 7); older ones are deleted
+	Compress    bool          // Gzip rotated files
+
+	// Event types written; empty writes all of them
+	Types []EventType
+}
+
+// WithEventLog appends every event to a JSON Lines file. Rotated files are
+// renamed to the path followed by the time of rotation, e.g.
+// events.jsonl.20260102T150405.000Z, with .gz when compressed.
+func WithEventLog(cfg EventLogConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.MaxSize <= 0 {
+			cfg.MaxSize = 100 << 20
+		}
+		if cfg.MaxBackups <= 0 {
+			cfg.MaxBackups = 7
+		}
+		t.eventLog = &cfg
+	}
+}
+
+// eventLogFile is the open event log and the state rotation goes by
+type eventLogFile struct {
+	cfg    *EventLogConfig
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// open opens the log at its path, carrying on with what's there
+func (l *eventLogFile) open(now time.Time) error {
+	file, err := os.OpenFile(l.cfg.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.size, l.opened = file, info.Size(), now
+	return nil
+}
+
+// write appends line, rotating the file first when it's due
+func (l *eventLogFile) write(line []byte, now time.Time) error {
+	if l.file != nil && l.due(len(line), now) {
+		if err := l.rotate(now); err != nil {
+			return err
+		}
+	}
+	if l.file == nil {
+		if err := l.open(now); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// due reports whether the file must be rotated before adding n bytes
+func (l *eventLogFile) due(n int, now time.Time) bool {
+	if l.size > 0 && l.size+int64(n) > l.cfg.MaxSize {
+		return true
+	}
+	return l.cfg.RotateEvery > 0 && l.size > 0 && now.Sub(l.opened) >= l.cfg.RotateEvery
+}
+
+// rotate moves the file aside, compresses it if configured and deletes
+// the backups past MaxBackups
+func (l *eventLogFile) rotate(now time.Time) error {
+	l.close()
+	rotated := l.cfg.Path + "." + now.UTC().Format(eventLogTimeFormat)
+	if err := os.Rename(l.cfg.Path, rotated); err != nil {
+		return err
+	}
+	if l.cfg.Compress {
+		if err := gzipFile(rotated); err != nil {
+			return err
+		}
+	}
+	return l.prune()
+}
+
+// prune deletes the oldest rotated files past MaxBackups
+func (l *eventLogFile) prune() error {
+	backups, err := filepath.Glob(l.cfg.Path + ".*")
+	if err != nil {
+		return err
+	}
+	// Only touch files this log rotated
+	backups = slices.DeleteFunc(backups, func(name string) bool {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, l.cfg.Path+"."), ".gz")
+		_, err := time.Parse(eventLogTimeFormat, stamp)
+		return err != nil
+	})
+	sort.Strings(backups)
+	for len(backups) > l.cfg.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// close closes the file if it's open
+func (l *eventLogFile) close() {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// gzipFile replaces name with name.gz
+func gzipFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+// eventLogLoop writes events to the event log until the tracker shuts down
+func (t *IP404Tracker) eventLogLoop(events <-chan Event) {
+	log := &eventLogFile{cfg: t.eventLog}
+	defer log.close()
+	for event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			t.logger.Error("encoding event failed", "event", event.Type, "error", err)
+			continue
+		}
+		if err := log.write(append(line, '\n'), t.clock.Now()); err != nil {
+			t.logger.Error("writing event log failed", "path", t.eventLog.Path, "error", err)
+			// Start over with a fresh file on the next event
+			log.close()
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifies what happened in an Event
+type EventType string
+
+const (
+	// Event404Recorded is emitted for every tracked 404, with the count in the window
+	Event404Recorded EventType = "404_recorded"
+	// EventBanned is emitted when an IP or CIDR is banned
+	EventBanned EventType = "banned"
+	// EventUnbanned is emitted when a ban is lifted manually
+	EventUnbanned EventType = "unbanned"
+	// EventBanExtended is emitted when a banned IP's rolling ban is renewed
+	EventBanExtended EventType = "ban_extended"
+	// EventBanExpired is emitted when cleanup finds a ban that ran out
+	EventBanExpired EventType = "ban_expired"
+	// EventThresholdExceeded is emitted when a client crosses the threshold
+	// of a status rule whose action is RuleAlert
+	EventThresholdExceeded EventType = "threshold_exceeded"
+)
+
+// Reasons attached to ban and unban events
+const (
+	BanReasonThreshold      = "404 threshold exceeded"
+	BanReasonRepeatOffender = "repeat offender"
+	BanReasonManual         = "manual"
+	BanReasonExpired        = "expired"
+	BanReasonPropagated     = "propagated from another instance"
+	BanReasonBlacklist      = "blacklist"
+	BanReasonAbuseIPDB      = "AbuseIPDB confidence score"
+	BanReasonImported       = "imported"
+	BanReasonChallenge      = "challenge solved"
+	BanReasonHoneypot       = "honeypot"              // Followed by the trap path
+	BanReasonHoneytoken     = "robots.txt honeytoken" // Followed by the token path
+	BanReasonUniquePaths    = "too many unique paths"
+	BanReasonCampaign       = "campaign" // Followed by the path
+	BanReasonMethod         = "method"   // Followed by the method
+)
+
+// Event describes a change in tracker state
+type Event struct {
+	Type      EventType          `json:"type"`
+	IP        string             `json:"ip"` // IP or CIDR
+	Reason    string             `json:"reason,omitempty"`
+	Path      string             `json:"path,omitempty"`     // Path of the request that caused the event
+	Paths     []string           `json:"paths,omitempty"`    // Latest 404 paths of a banned IP
+	Requests  []OffendingRequest `json:"requests,omitempty"` // The same with times and user agents
+	Count     int                `json:"count,omitempty"`
+	Weight    int                `json:"weight,omitempty"`    // How much the 404 counted, see WithPathWeights
+	Threshold int                `json:"threshold,omitempty"` // Threshold the 404 counted against, after probation, campaigns and the circuit breaker
+	Rule      string             `json:"rule,omitempty"`      // Status rule or route policy behind a ban, alert or 404; empty for the tracker's own threshold
+	ExpiresAt time.Time          `json:"expires_at,omitzero"`
+	Time      time.Time          `json:"time"`
+
+	// Where the banned client is, when GeoIP is enabled
+	GeoInfo
+}
+
+// defaultEventBuffer is the capacity of the channel returned by Events
+const defaultEventBuffer = 1024
+
+// eventBus fans events out to subscribers without ever blocking the publisher
+type eventBus struct {
+	mu     sync.RWMutex
+	subs   map[chan Event][]EventType // Types each subscriber wants, none for all
+	closed bool
+
+	// Events dropped because a subscriber's buffer was full
+	dropped atomic.Uint64
+}
+
+// subscribe returns a channel receiving the events of the given types
+// published from now on, or every event when no type is given. Subscribers
+// that block on the network pass the types they handle, so the 404s and
+// ban extensions of a scan can't fill their buffer and crowd out the bans.
+func (b *eventBus) subscribe(size int, types ...EventType) chan Event {
+	ch := make(chan Event, size)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	if b.subs == nil {
+		b.subs = make(map[chan Event][]EventType)
+	}
+	b.subs[ch] = types
+
+	return ch
+}
+
+// unsubscribe stops delivery to ch
+func (b *eventBus) unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subs, ch)
+}
+
+// close closes every subscriber channel; later events are dropped
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		close(ch)
+	}
+	b.subs = nil
+	b.closed = true
+}
+
+// publish delivers event to every subscriber with room in its buffer;
+// slow subscribers miss events rather than stalling request handling
+func (b *eventBus) publish(event Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch, types := range b.subs {
+		if len(types) > 0 && !slices.Contains(types, event.Type) {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+			b.dropped.Add(1)
+		}
+	}
+}
+
+// WithEventBuffer sets the capacity of the channel returned by Events (default 1024)
+func WithEventBuffer(size int) Option {
+	return func(t *IP404Tracker) {
+		t.eventBuffer = size
+	}
+}
+
+// Events returns a buffered channel receiving every tracker event. The same
+// channel is returned on every call. Events are dropped, never queued, when
+// the buffer is full; DroppedEvents reports how many were lost. The channel
+// is closed by Shutdown.
+func (t *IP404Tracker) Events() <-chan Event {
+	t.eventsOnce.Do(func() {
+		t.eventsCh = t.events.subscribe(t.eventBuffer)
+	})
+	return t.eventsCh
+}
+
+// DroppedEvents returns how many events have been dropped because a consumer
+// fell behind
+func (t *IP404Tracker) DroppedEvents() uint64 {
+	return t.events.dropped.Load()
+}
+
+// emit publishes an event stamped with the current time
+func (t *IP404Tracker) emit(event Event) {
+	event.Time = t.clock.Now()
+	t.events.publish(event)
+	t.runCallbacks(event)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventStreamBuffer is how many events a slow SSE client may lag behind before missing some
+const eventStreamBuffer = 256
+
+// eventStreamHeartbeat keeps idle SSE connections open through proxies
+const eventStreamHeartbeat = 30 * time.Second
+
+// adminEvents streams tracker events to the client as Server-Sent Events,
+// using the event type as the SSE event name
+func (t *IP404Tracker) adminEvents(c *gin.Context) {
+	events := t.events.subscribe(eventStreamBuffer)
+	defer t.events.unsubscribe(events)
+
+	// Heartbeats keep the connection open in real time, not the tracker's clock
+	heartbeat := time.NewTicker(eventStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // Disable nginx response buffering
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(string(event.Type), event)
+		case <-heartbeat.C:
+			c.SSEvent("heartbeat", t.clock.Now())
+		case <-c.Request.Context().Done():
+			return false
+		}
+		return true
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// EventEvicting is emitted when the tracker starts evicting clients to stay
+// under WithMaxTrackedIPs, and at most once a minute while it keeps doing so
+const EventEvicting EventType = "evicting"
+
+// evictionAlertInterval is how often evictions are logged and emitted
+const evictionAlertInterval = time.Minute
+
+// trackedKeys orders the tracked keys by when they were last seen
+type trackedKeys struct {
+	max int
+
+	mu    sync.Mutex
+	order *list.List // Least recently seen first
+	keys  map[string]*list.Element
+
+	// Evictions since the last alert, and when it was
+	evicted   int
+	lastAlert time.Time
+}
+
+// WithMaxTrackedIPs caps how many clients the tracker keeps state for. Once
+// more are tracked, the least recently seen one is forgotten: its 404 and
+// status counts, latest paths, banned request counter and probation. Bans
+// are kept. This bounds the memory a flood of spoofed addresses can take
+// between cleanups.
+func WithMaxTrackedIPs(n int) Option {
+	return func(t *IP404Tracker) {
+		if n > 0 {
+			t.tracked = &trackedKeys{max: n, order: list.New(), keys: make(map[string]*list.Element)}
+		}
+	}
+}
+
+// touch marks key as seen, returning the key that has to make room for it
+func (k *trackedKeys) touch(key string) (string, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if e, ok := k.keys[key]; ok {
+		k.order.MoveToBack(e)
+		return "", false
+	}
+	k.keys[key] = k.order.PushBack(key)
+	if k.order.Len() <= k.max {
+		return "", false
+	}
+	oldest := k.order.Remove(k.order.Front()).(string)
+	delete(k.keys, oldest)
+	return oldest, true
+}
+
+// alert counts an eviction at now, returning how many to report when it's
+// time for an alert
+func (k *trackedKeys) alert(now time.Time) (int, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.evicted++
+	if now.Sub(k.lastAlert) < evictionAlertInterval {
+		return 0, false
+	}
+	evicted := k.evicted
+	k.evicted, k.lastAlert = 0, now
+	return evicted, true
+}
+
+// track marks the tracking key as seen, evicting the least recently seen
+// client when there are too many
+func (t *IP404Tracker) track(key string) {
+	k := t.tracked
+	if k == nil {
+		return
+	}
+	evicted, ok := k.touch(key)
+	if !ok {
+		return
+	}
+	t.clearKeyCounters(evicted)
+	t.counters.evictions.Add(1)
+	if count, alert := k.alert(t.clock.Now()); alert {
+		t.logger.Warn("evicting tracked clients", "max", k.max, "evicted", count)
+		t.emit(Event{Type: EventEvicting, IP: evicted, Count: count})
+	}
+}
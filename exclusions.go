@@ -0,0 +1,67 @@
+package main
+
+import "regexp"
+
+// defaultExclusions are paths browsers and well-behaved bots ask for on
+// their own, whether the site has them or not
+var defaultExclusions = []string{
+	"favicon.ico",
+	"apple-touch-icon*.png",
+	"/robots.txt",
+	"/sitemap.xml",
+	"/browserconfig.xml",
+	"/.well-known/**",
+}
+
+// DefaultExcludedPaths returns the patterns of benign 404s that never count
+// unless WithoutDefaultExclusions is used: favicons, touch icons,
+// robots.txt and /.well-known URLs
+func DefaultExcludedPaths() []string {
+	return append([]string(nil), defaultExclusions...)
+}
+
+// WithExcludedPaths keeps 404s for paths matching any of the patterns from
+// counting, on top of DefaultExcludedPaths. Patterns are path.Match globs,
+// matched like PathWeight patterns.
+func WithExcludedPaths(patterns ...string) Option {
+	return func(t *IP404Tracker) {
+		t.exclusions = append(t.exclusions, patterns...)
+	}
+}
+
+// WithExcludedPathRegexps keeps 404s for paths matching any of the regular
+// expressions from counting
+func WithExcludedPathRegexps(res ...*regexp.Regexp) Option {
+	return func(t *IP404Tracker) {
+		t.exclusionRegexps = append(t.exclusionRegexps, res...)
+	}
+}
+
+// WithoutDefaultExclusions counts 404s for the DefaultExcludedPaths too
+func WithoutDefaultExclusions() Option {
+	return func(t *IP404Tracker) {
+		t.noDefaultExclusions = true
+	}
+}
+
+// excluded reports whether 404s for urlPath don't count
+func (t *IP404Tracker) excluded(urlPath string) bool {
+	if !t.noDefaultExclusions {
+		for _, pattern := range defaultExclusions {
+			if matchPath(pattern, urlPath) {
+				return true
+			}
+		}
+	}
+	for _, pattern := range t.exclusions {
+		if matchPath(pattern, urlPath) {
+			return true
+		}
+	}
+	for _, re := range t.exclusionRegexps {
+		if re.MatchString(urlPath) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExportFormat is a deny-list format ExportBans can write
+type ExportFormat string
+
+const (
+	// ExportNginx writes one "deny <ip>;" directive per line, for include
+	// in an nginx server or location block
+	ExportNginx ExportFormat = "nginx"
+	// ExportApache writes a <RequireAll> block of "Require not ip" lines
+	ExportApache ExportFormat = "apache"
+	// ExportPlain writes one IP or CIDR per line
+	ExportPlain ExportFormat = "plain"
+	// ExportCSV writes the target, reason, source, ban and expiry times and
+	// location of each ban, with a header row
+	ExportCSV ExportFormat = "csv"
+)
+
+// exportedBans returns the banned addresses and ranges, including the
+// blacklist, sorted by target. Client keys and fingerprints are left out
+// since no other software can match them.
+func (t *IP404Tracker) exportedBans() []banInfo {
+	var bans []banInfo
+	for key, record := range t.GetBans() {
+		prefix, err := parseIPOrCIDR(key)
+		if err != nil {
+			continue
+		}
+		bans = append(bans, banInfo{Target: prefixString(prefix), BanRecord: record})
+	}
+	for prefix := range t.GetBannedCIDRs() {
+		if record, ok := t.GetCIDRBanInfo(prefix); ok {
+			bans = append(bans, banInfo{Target: prefixString(prefix), BanRecord: record})
+		}
+	}
+	for _, entry := range t.GetBlacklist() {
+		bans = append(bans, banInfo{Target: entry, BanRecord: BanRecord{Reason: BanReasonBlacklist, Source: BanSourceManual}})
+	}
+
+	// Order addresses numerically so the output is stable and diffable
+	sort.Slice(bans, func(i, j int) bool {
+		a, errA := parseIPOrCIDR(bans[i].Target)
+		b, errB := parseIPOrCIDR(bans[j].Target)
+		if errA != nil || errB != nil {
+			return bans[i].Target < bans[j].Target
+		}
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c < 0
+		}
+		return a.Bits() < b.Bits()
+	})
+	return bans
+}
+
+// ExportBans writes the banned IPs and CIDRs, including the blacklist, in a
+// deny-list format other servers and tools can load
+func (t *IP404Tracker) ExportBans(w io.Writer, format ExportFormat) error {
+	bans := t.exportedBans()
+
+	switch format {
+	case ExportNginx:
+		fmt.Fprintf(w, "# 404blocker ban list, %d entries\n", len(bans))
+		for _, ban := range bans {
+			fmt.Fprintf(w, "deny %s;\n", ban.Target)
+		}
+	case ExportApache:
+		fmt.Fprintf(w, "# 404blocker ban list, %d entries\n", len(bans))
+		fmt.Fprintln(w, "<RequireAll>")
+		fmt.Fprintln(w, "    Require all granted")
+		for _, ban := range bans {
+			fmt.Fprintf(w, "    Require not ip %s\n", ban.Target)
+		}
+		fmt.Fprintln(w, "</RequireAll>")
+	case ExportPlain:
+		for _, ban := range bans {
+			fmt.Fprintln(w, ban.Target)
+		}
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"target", "reason", "source", "banned_at", "expires_at", "country", "asn"})
+		for _, ban := range bans {
+			asn := ""
+			if ban.ASN != 0 {
+				asn = strconv.FormatUint(uint64(ban.ASN), 10)
+			}
+			cw.Write([]string{
+				ban.Target,
+				ban.Reason,
+				string(ban.Source),
+				formatExportTime(ban.BannedAt),
+				formatExportTime(ban.ExpiresAt),
+				ban.Country,
+				asn,
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+	return nil
+}
+
+// formatExportTime formats t as RFC 3339, or "" when unset
+func formatExportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// exportContentTypes maps export formats to response content types
+var exportContentTypes = map[ExportFormat]string{
+	ExportNginx:  "text/plain; charset=utf-8",
+	ExportApache: "text/plain; charset=utf-8",
+	ExportPlain:  "text/plain; charset=utf-8",
+	ExportCSV:    "text/csv; charset=utf-8",
+}
+
+func (t *IP404Tracker) adminExportBans(c *gin.Context) {
+	format := ExportFormat(c.DefaultQuery("format", string(ExportPlain)))
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown format: " + string(format)})
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Status(http.StatusOK)
+	if err := t.ExportBans(c.Writer, format); err != nil {
+		t.logger.Error("exporting bans failed", "format", format, "error", err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	// fail2banBuffer is how many ban events may queue up while the log is written
+	fail2banBuffer = 1024
+
+	// fail2banTimeFormat is one of the date formats fail2ban detects on its own
+	fail2banTimeFormat = "2006-01-02 15:04:05"
+)
+
+// WithFail2BanLog appends a line to path for every ban, so a fail2ban jail
+// can block the offenders at the firewall. Lines look like
+//
+//	2026-01-02 15:04:05 404blocker: Ban 203.0.113.7 reason="404 threshold exceeded" count=5 expires="2026-01-03 15:04:05"
+//
+// in local time, with expires="never" for blacklist entries. Fields after
+// the address may be added but never reordered, so the filter regex in the
+// README keeps matching.
+func WithFail2BanLog(path string) Option {
+	return func(t *IP404Tracker) {
+		t.fail2banPath = path
+	}
+}
+
+// fail2banLine formats a ban event as a log line
+func fail2banLine(event Event) string {
+	// Blacklist entries never expire
+	expires := "never"
+	if !event.ExpiresAt.IsZero() {
+		expires = event.ExpiresAt.Local().Format(fail2banTimeFormat)
+	}
+	return fmt.Sprintf("%s 404blocker: Ban %s reason=%q count=%d expires=%q\n",
+		event.Time.Local().Format(fail2banTimeFormat),
+		event.IP,
+		event.Reason,
+		event.Count,
+		expires,
+	)
+}
+
+// fail2banLoop writes every ban of an address or range to the fail2ban log
+func (t *IP404Tracker) fail2banLoop(events <-chan Event) {
+	log := &appendLog{path: t.fail2banPath, perm: 0o640}
+	defer log.close()
+	for event := range events {
+		if _, err := parseIPOrCIDR(event.IP); err != nil {
+			// Client keys and fingerprints can't be blocked at the firewall
+			continue
+		}
+		if err := log.write(fail2banLine(event)); err != nil {
+			t.logger.Error("writing fail2ban log failed", "path", t.fail2banPath, "error", err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"hash/fnv"
+	"net/netip"
+	"sync/atomic"
+	"time"
+)
+
+// listView is a read-only copy of the whitelist, blacklist and range bans.
+// Request checks read it without taking t.mu, so the common case of a client
+// that's on none of them does no locking at all.
+type listView struct {
+	whitelist       *prefixTrie[whitelistSource]
+	whitelistExpiry map[netip.Prefix]time.Time
+	blacklist       *prefixTrie[struct{}]
+	cidrBans        *prefixTrie[BanRecord]
+}
+
+// lists returns the current view of the lists, copying them again if they
+// changed since the last one. Bulk changes like imports thus copy them once,
+// on the next request after them.
+func (t *IP404Tracker) lists() *listView {
+	if view := t.view.Load(); view != nil {
+		return view
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	view := &listView{
+		whitelist:       t.whitelist.clone(),
+		whitelistExpiry: make(map[netip.Prefix]time.Time, len(t.whitelistExpiry)),
+		blacklist:       t.blacklist.clone(),
+		cidrBans:        t.cidrBans.clone(),
+	}
+	for prefix, until := range t.whitelistExpiry {
+		view.whitelistExpiry[prefix] = until
+	}
+	// Holding t.mu keeps writers from invalidating a view older than this one
+	t.view.CompareAndSwap(nil, view)
+	return view
+}
+
+// listsChanged drops the view after a change to the lists. The caller must
+// hold t.mu for writing.
+func (t *IP404Tracker) listsChanged() {
+	t.view.Store(nil)
+}
+
+// whitelisted reports whether addr is covered by an active whitelist entry
+func (v *listView) whitelisted(addr netip.Addr, now time.Time) bool {
+	whitelisted := false
+	v.whitelist.lookupAll(addr, func(prefix netip.Prefix, sources whitelistSource) bool {
+		whitelisted = whitelistEntryActive(sources, v.whitelistExpiry[prefix], now)
+		return !whitelisted
+	})
+	return whitelisted
+}
+
+// banFilterSlots is the number of counters in a MemoryStore's ban filter
+const banFilterSlots = 1 << 15
+
+// banFilter is a counting Bloom filter of the banned IPs of a MemoryStore.
+// An IP that hashes to an empty slot is certainly not banned, which answers
+// most ban checks without taking a shard lock.
+type banFilter struct {
+	slots [banFilterSlots]atomic.Uint32
+}
+
+// banFilterIndexes returns the two slots of ip
+func banFilterIndexes(ip string) (uint32, uint32) {
+	h := fnv.New64a()
+	h.Write([]byte(ip))
+	sum := h.Sum64()
+	return uint32(sum) % banFilterSlots, uint32(sum>>32) % banFilterSlots
+}
+
+// add counts a newly banned ip
+func (f *banFilter) add(ip string) {
+	i, j := banFilterIndexes(ip)
+	f.slots[i].Add(1)
+	f.slots[j].Add(1)
+}
+
+// remove uncounts an ip whose ban was removed
+func (f *banFilter) remove(ip string) {
+	i, j := banFilterIndexes(ip)
+	f.slots[i].Add(^uint32(0))
+	f.slots[j].Add(^uint32(0))
+}
+
+// mayContain reports whether ip could be banned
+func (f *banFilter) mayContain(ip string) bool {
+	i, j := banFilterIndexes(ip)
+	return f.slots[i].Load() != 0 && f.slots[j].Load() != 0
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+// feedDefaultRefresh is how often a feed is fetched again by default.
+// Most list maintainers ask for no more than a few fetches a day.
+const feedDefaultRefresh = 12 * time.Hour
+
+// FeedConfig describes a threat intelligence blocklist, such as Spamhaus
+// DROP (https://www.spamhaus.org/drop/drop.txt) or a FireHOL level list.
+// The list may be plain text with one IP or CIDR per line, with '#' or ';'
+// comments, or any JSON listing addresses.
+type FeedConfig struct {
+	Name     string
+	URL      string
+	Refresh  time.Duration // How often the list is fetched again (default 12h)
+	Disabled bool          // Start disabled; see SetFeedEnabled
+}
+
+// FeedStatus describes the state of a feed
+type FeedStatus struct {
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Enabled   bool      `json:"enabled"`
+	Entries   int       `json:"entries"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+	Error     string    `json:"error,omitempty"` // Why the last fetch failed
+}
+
+// WithFeeds pre-bans every address listed by the given feeds. Entries are
+// refreshed in the background and the previous list is kept when a fetch
+// fails. The whitelist still takes precedence.
+func WithFeeds(feeds ...FeedConfig) Option {
+	return func(t *IP404Tracker) {
+		for _, cfg := range feeds {
+			if cfg.Refresh <= 0 {
+				cfg.Refresh = feedDefaultRefresh
+			}
+			t.feeds = append(t.feeds, &feed{
+				cfg:     cfg,
+				enabled: !cfg.Disabled,
+				wake:    make(chan struct{}, 1),
+			})
+		}
+	}
+}
+
+// feed is a blocklist and the addresses it listed last
+type feed struct {
+	cfg  FeedConfig
+	wake chan struct{} // Fetch now, after the feed was enabled
+
+	mu        sync.RWMutex
+	enabled   bool
+	ranges    *prefixTrie[struct{}]
+	updatedAt time.Time
+	err       error
+}
+
+// feedClient fetches feeds
+var feedClient = &http.Client{Timeout: cdnFetchTimeout}
+
+// refresh fetches the feed at now, keeping the previous entries on failure
+func (f *feed) refresh(now time.Time) error {
+	prefixes, err := fetchRangeList(feedClient, f.cfg.URL)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+	if err != nil {
+		return err
+	}
+	f.ranges = &prefixTrie[struct{}]{}
+	for _, prefix := range prefixes {
+		f.ranges.insert(prefix, struct{}{})
+	}
+	f.updatedAt = now
+	return nil
+}
+
+// listed reports whether the enabled feed lists addr
+func (f *feed) listed(addr netip.Addr) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if !f.enabled || f.ranges == nil {
+		return false
+	}
+	_, ok := f.ranges.lookup(addr)
+	return ok
+}
+
+// status reports the feed's current state
+func (f *feed) status() FeedStatus {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	status := FeedStatus{
+		Name:      f.cfg.Name,
+		URL:       f.cfg.URL,
+		Enabled:   f.enabled,
+		UpdatedAt: f.updatedAt,
+	}
+	if f.ranges != nil {
+		status.Entries = f.ranges.len()
+	}
+	if f.err != nil {
+		status.Error = f.err.Error()
+	}
+	return status
+}
+
+// feedLoop keeps a feed up to date while it's enabled
+func (t *IP404Tracker) feedLoop(f *feed) {
+	for {
+		f.mu.RLock()
+		enabled := f.enabled
+		f.mu.RUnlock()
+
+		delay := f.cfg.Refresh
+		if enabled {
+			if err := f.refresh(t.clock.Now()); err != nil {
+				t.logger.Error("fetching feed failed", "feed", f.cfg.Name, "error", err)
+				delay = min(delay, cdnRetryInterval)
+			} else {
+				t.logger.Info("feed loaded", "feed", f.cfg.Name, "entries", f.status().Entries)
+			}
+		}
+
+		tick, stop := t.clock.NewTicker(delay)
+		select {
+		case <-tick:
+		case <-f.wake:
+		case <-t.done:
+			stop()
+			return
+		}
+		stop()
+	}
+}
+
+// feedListing returns the name of the first enabled feed listing ip, or ""
+func (t *IP404Tracker) feedListing(ip string) string {
+	if len(t.feeds) == 0 {
+		return ""
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+
+	for _, f := range t.feeds {
+		if f.listed(addr) {
+			return f.cfg.Name
+		}
+	}
+	return ""
+}
+
+// Feeds returns the state of every configured feed, sorted by name
+func (t *IP404Tracker) Feeds() []FeedStatus {
+	result := make([]FeedStatus, 0, len(t.feeds))
+	for _, f := range t.feeds {
+		result = append(result, f.status())
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// SetFeedEnabled turns a feed on or off at runtime. A disabled feed bans
+// nobody and isn't fetched; enabling it fetches it right away.
+func (t *IP404Tracker) SetFeedEnabled(name string, enabled bool) error {
+	for _, f := range t.feeds {
+		if f.cfg.Name != name {
+			continue
+		}
+		f.mu.Lock()
+		wasEnabled := f.enabled
+		f.enabled = enabled
+		f.mu.Unlock()
+
+		if enabled && !wasEnabled {
+			select {
+			case f.wake <- struct{}{}:
+			default:
+			}
+		}
+		t.logger.Info("feed toggled", "feed", name, "enabled", enabled)
+		return nil
+	}
+	return errors.New("unknown feed: " + name)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+)
+
+// FiberMiddleware returns a Fiber middleware with the same 404 tracking and
+// shadow banning as Middleware
+func (t *IP404Tracker) FiberMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// fasthttp's remote IP, or the configured ProxyHeader when behind a proxy
+		clientIP := c.IP()
+		if t.resolvesClientIP() {
+			clientIP = t.clientIP(c.Context().RemoteIP().String(), func(name string) []string {
+				var values []string
+				for _, value := range c.Request().Header.PeekAll(name) {
+					values = append(values, string(value))
+				}
+				return values
+			})
+		}
+
+		// Shadow banned clients get a generic 404, or the ban response
+		req := clientRequest{
+			ctx:            c.UserContext(),
+			ip:             clientIP,
+			method:         c.Method(),
+			path:           c.Path(),
+			userAgent:      c.Get(fiber.HeaderUserAgent),
+			acceptLanguage: c.Get(fiber.HeaderAcceptLanguage),
+		}
+		if t.cookieChallenge != nil {
+			req.passCookie = c.Cookies(t.cookieChallenge.cfg.CookieName)
+		}
+		var key string
+		if t.fiberKeyFunc != nil {
+			key = t.fiberKeyFunc(c)
+		}
+		req.key = t.requestKey(req, key)
+		if t.isChallengePath(req.path) {
+			return adaptor.HTTPHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				t.serveChallengePath(w, r, req)
+			})(c)
+		}
+		if blocked, ok := t.blockBanned(req); ok {
+			t.holdBanned(c.Context(), blocked)
+			return sendFiberBanResponse(c, t.banResponse(blocked))
+		}
+		if resp, limited := t.rateLimited(req); limited {
+			return sendFiberBanResponse(c, resp)
+		}
+
+		for name, value := range t.rateLimitHeaders(req) {
+			c.Set(name, value)
+		}
+
+		// Process the request
+		err := c.Next()
+
+		// Fiber reports unmatched routes and handler failures as errors which the
+		// error handler only turns into a status after the middleware chain returns
+		status := c.Response().StatusCode()
+		if err != nil {
+			var fiberErr *fiber.Error
+			if errors.As(err, &fiberErr) {
+				status = fiberErr.Code
+			} else {
+				status = fiber.StatusInternalServerError
+			}
+		}
+
+		t.handleResponse(req, status)
+
+		return err
+	}
+}
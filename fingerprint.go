@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// fingerprintSeparator splits a fingerprint key into the tracking key of the
+// client IP and the hashed request headers
+const fingerprintSeparator = "#"
+
+// FingerprintConfig configures WithFingerprinting
+type FingerprintConfig struct {
+	// Also tell clients apart by their Accept-Language header
+	AcceptLanguage bool
+}
+
+// WithFingerprinting tracks and bans clients by their IP combined with a
+// hash of their User-Agent (and optionally Accept-Language), so other
+// browsers behind the same NAT keep working while a scanner gets banned.
+// A scanner that rotates its User-Agent is counted once per User-Agent.
+// Keys returned by a KeyFunc take precedence.
+func WithFingerprinting(cfg FingerprintConfig) Option {
+	return func(t *IP404Tracker) {
+		t.fingerprint = &cfg
+	}
+}
+
+// fingerprintKey returns the key the request is tracked under, e.g.
+// "203.0.113.7#9f86d081884c7d65"
+func (t *IP404Tracker) fingerprintKey(req clientRequest) string {
+	h := sha256.New()
+	h.Write([]byte(req.userAgent))
+	if t.fingerprint.AcceptLanguage {
+		h.Write([]byte{0})
+		h.Write([]byte(req.acceptLanguage))
+	}
+	return t.trackingKey(req.ip) + fingerprintSeparator + hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// fingerprinted reports whether key is a fingerprint key
+func fingerprinted(key string) bool {
+	addr, _, ok := strings.Cut(key, fingerprintSeparator)
+	if !ok {
+		return false
+	}
+	if pseudonymous(addr) {
+		return true
+	}
+	_, err := parseIPOrCIDR(addr)
+	return err == nil
+}
+
+// fingerprintAddr returns the IP part of a fingerprint key, or key itself
+func fingerprintAddr(key string) string {
+	addr, _, _ := strings.Cut(key, fingerprintSeparator)
+	return addr
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// firewallBuffer is how many ban events may queue up between syncs
+	firewallBuffer = 4096
+
+	// firewallBatch is how many events are applied with one command
+	firewallBatch = 512
+
+	// ipsetMaxTimeout is the longest timeout ipset accepts, in seconds
+	ipsetMaxTimeout = 2147483
+)
+
+// FirewallBackend names the kernel set implementation WithFirewallSync drives
+type FirewallBackend string
+
+const (
+	FirewallIPSet    FirewallBackend = "ipset"
+	FirewallNFTables FirewallBackend = "nftables"
+)
+
+// FirewallConfig configures WithFirewallSync
+type FirewallConfig struct {
+	Backend FirewallBackend
+
+	// Sets receiving banned IPv4 and IPv6 addresses and ranges; bans of the
+	// other family are skipped when one is empty
+	Set  string
+	Set6 string
+
+	// nftables family and table holding the sets (default "inet filter")
+	Table string
+}
+
+// WithFirewallSync keeps an ipset or nftables set in sync with the banned
+// addresses and ranges, so a firewall rule can drop those clients before
+// they reach the server. The sets are flushed and filled on startup; after
+// that bans are added with a matching timeout and removed when lifted.
+// The ipset or nft binary must be in PATH and the process needs CAP_NET_ADMIN.
+func WithFirewallSync(cfg FirewallConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.Backend == "" {
+			cfg.Backend = FirewallIPSet
+		}
+		if cfg.Table == "" {
+			cfg.Table = "inet filter"
+		}
+		t.firewall = &cfg
+	}
+}
+
+// firewallSet turns ban changes into ipset restore or nft -f scripts
+type firewallSet struct {
+	cfg *FirewallConfig
+}
+
+// setFor returns the set holding prefix, or "" when its family isn't synced
+func (f firewallSet) setFor(prefix netip.Prefix) string {
+	if prefix.Addr().Is4() {
+		return f.cfg.Set
+	}
+	return f.cfg.Set6
+}
+
+// flush returns the commands emptying the sets
+func (f firewallSet) flush() []string {
+	var lines []string
+	for _, set := range []string{f.cfg.Set, f.cfg.Set6} {
+		if set == "" {
+			continue
+		}
+		if f.cfg.Backend == FirewallNFTables {
+			lines = append(lines, fmt.Sprintf("flush set %s %s", f.cfg.Table, set))
+		} else {
+			lines = append(lines, "flush "+set)
+		}
+	}
+	return lines
+}
+
+// add returns the commands adding prefix until expiresAt, replacing any
+// timeout it had. A zero expiresAt adds it for good.
+func (f firewallSet) add(prefix netip.Prefix, expiresAt time.Time, now time.Time) []string {
+	set := f.setFor(prefix)
+	if set == "" {
+		return nil
+	}
+	value := prefixString(prefix)
+
+	var timeout int64
+	if !expiresAt.IsZero() {
+		timeout = int64(expiresAt.Sub(now).Round(time.Second) / time.Second)
+		if timeout <= 0 {
+			return nil
+		}
+	}
+
+	if f.cfg.Backend == FirewallNFTables {
+		element := value
+		if timeout > 0 {
+			element = fmt.Sprintf("%s timeout %ds", value, timeout)
+		}
+		// nft can't update an element in place: make sure it exists, delete
+		// it and add it afresh, all in one transaction
+		return []string{
+			fmt.Sprintf("add element %s %s { %s }", f.cfg.Table, set, value),
+			fmt.Sprintf("delete element %s %s { %s }", f.cfg.Table, set, value),
+			fmt.Sprintf("add element %s %s { %s }", f.cfg.Table, set, element),
+		}
+	}
+
+	// ipset treats a timeout of 0 as permanent
+	if timeout > ipsetMaxTimeout {
+		timeout = 0
+	}
+	return []string{fmt.Sprintf("add %s %s timeout %d", set, value, timeout)}
+}
+
+// remove returns the commands deleting prefix, whether or not it's in the set
+func (f firewallSet) remove(prefix netip.Prefix) []string {
+	set := f.setFor(prefix)
+	if set == "" {
+		return nil
+	}
+	value := prefixString(prefix)
+
+	if f.cfg.Backend == FirewallNFTables {
+		return []string{
+			fmt.Sprintf("add element %s %s { %s }", f.cfg.Table, set, value),
+			fmt.Sprintf("delete element %s %s { %s }", f.cfg.Table, set, value),
+		}
+	}
+	return []string{fmt.Sprintf("del %s %s", set, value)}
+}
+
+// run applies a script of commands
+func (f firewallSet) run(lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	// -exist makes ipset ignore adding present and deleting missing entries
+	cmd := exec.Command("ipset", "restore", "-exist")
+	if f.cfg.Backend == FirewallNFTables {
+		cmd = exec.Command("nft", "-f", "-")
+	}
+	cmd.Stdin = strings.NewReader(strings.Join(lines, "\n") + "\n")
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// firewallSync flushes the sets and adds every active ban and blacklist entry
+func (t *IP404Tracker) firewallSync(f firewallSet) error {
+	now := t.clock.Now()
+	bans, err := t.store.ListBans(now)
+	if err != nil {
+		return err
+	}
+
+	lines := f.flush()
+	for key, record := range bans {
+		prefix, err := parseIPOrCIDR(key)
+		if err != nil {
+			// Client keys and fingerprints can't be blocked at the firewall
+			continue
+		}
+		lines = append(lines, f.add(prefix, record.ExpiresAt, now)...)
+	}
+	for prefix, expiresAt := range t.GetBannedCIDRs() {
+		lines = append(lines, f.add(prefix, expiresAt, now)...)
+	}
+	for _, entry := range t.GetBlacklist() {
+		if prefix, err := parseIPOrCIDR(entry); err == nil {
+			lines = append(lines, f.add(prefix, time.Time{}, now)...)
+		}
+	}
+	return f.run(lines)
+}
+
+// firewallCommands returns the commands applying a ban change, if any
+func (t *IP404Tracker) firewallCommands(f firewallSet, event Event, now time.Time) []string {
+	prefix, err := parseIPOrCIDR(event.IP)
+	if err != nil {
+		return nil
+	}
+
+	switch event.Type {
+	case EventBanned, EventBanExtended:
+		return f.add(prefix, event.ExpiresAt, now)
+	case EventUnbanned, EventBanExpired:
+		// The address may still be banned another way, e.g. blacklisted
+		if prefix.IsSingleIP() && t.IsBanned(event.IP) {
+			return nil
+		}
+		return f.remove(prefix)
+	}
+	return nil
+}
+
+// firewallLoop syncs the sets on startup and then applies ban changes in
+// batches
+func (t *IP404Tracker) firewallLoop(events <-chan Event) {
+	f := firewallSet{cfg: t.firewall}
+	if err := t.firewallSync(f); err != nil {
+		t.logger.Error("syncing firewall set failed", "backend", t.firewall.Backend, "error", err)
+	}
+
+	for event := range events {
+		// Take whatever else is queued so a burst of bans costs one command
+		batch := []Event{event}
+		for len(batch) < firewallBatch && len(events) > 0 {
+			batch = append(batch, <-events)
+		}
+
+		now := t.clock.Now()
+		var lines []string
+		for _, event := range batch {
+			lines = append(lines, t.firewallCommands(f, event, now)...)
+		}
+		if err := f.run(lines); err == nil || len(batch) == 1 {
+			if err != nil {
+				t.logger.Error("updating firewall set failed", "ip", event.IP, "error", err)
+			}
+			continue
+		}
+
+		// One bad entry fails the whole script: apply them one by one
+		for _, event := range batch {
+			if err := f.run(t.firewallCommands(f, event, now)); err != nil {
+				t.logger.Error("updating firewall set failed", "ip", event.IP, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// GeoInfo tells where an address is and which network it belongs to
+type GeoInfo struct {
+	Country      string `json:"country,omitempty"` // ISO 3166-1 alpha-2 code, e.g. "DE"
+	ASN          uint   `json:"asn,omitempty"`
+	Organization string `json:"as_org,omitempty"` // Owner of the ASN
+}
+
+// GeoResolver looks up where an address is
+type GeoResolver interface {
+	Lookup(addr netip.Addr) (GeoInfo, error)
+}
+
+// MaxMindResolver is a GeoResolver reading MaxMind GeoLite2 or GeoIP2
+// database files
+type MaxMindResolver struct {
+	country *geoip2.Reader
+	asn     *geoip2.Reader
+}
+
+// NewMaxMindResolver opens a Country (or City) database and an ASN database,
+// e.g. GeoLite2-Country.mmdb and GeoLite2-ASN.mmdb. Either path may be empty
+// to skip that lookup.
+func NewMaxMindResolver(countryDB, asnDB string) (*MaxMindResolver, error) {
+	r := &MaxMindResolver{}
+	var err error
+	if countryDB != "" {
+		if r.country, err = geoip2.Open(countryDB); err != nil {
+			return nil, fmt.Errorf("opening %s: %w", countryDB, err)
+		}
+	}
+	if asnDB != "" {
+		if r.asn, err = geoip2.Open(asnDB); err != nil {
+			r.Close()
+			return nil, fmt.Errorf("opening %s: %w", asnDB, err)
+		}
+	}
+	return r, nil
+}
+
+// Lookup implements GeoResolver
+func (r *MaxMindResolver) Lookup(addr netip.Addr) (GeoInfo, error) {
+	var info GeoInfo
+	ip := addr.Unmap().AsSlice()
+
+	if r.country != nil {
+		record, err := r.country.Country(ip)
+		if err != nil {
+			return info, err
+		}
+		info.Country = record.Country.IsoCode
+	}
+	if r.asn != nil {
+		record, err := r.asn.ASN(ip)
+		if err != nil {
+			return info, err
+		}
+		info.ASN = record.AutonomousSystemNumber
+		info.Organization = record.AutonomousSystemOrganization
+	}
+
+	return info, nil
+}
+
+// Close closes the database files
+func (r *MaxMindResolver) Close() error {
+	var errs []error
+	if r.country != nil {
+		errs = append(errs, r.country.Close())
+	}
+	if r.asn != nil {
+		errs = append(errs, r.asn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// GeoIPConfig configures WithGeoIP
+type GeoIPConfig struct {
+	Resolver GeoResolver
+
+	// Countries the site never serves; their clients are blocked outright
+	BlockedCountries []string
+
+	// 404 thresholds replacing the default one for clients from a country
+	CountryThresholds map[string]int
+
+	// Networks blocked outright, e.g. known bulletproof hosting providers
+	BlockedASNs []uint
+
+	// 404 thresholds for clients from an ASN; they take precedence over
+	// country thresholds
+	ASNThresholds map[uint]int
+
+	// Networks of partners that are never tracked or banned
+	ExemptASNs []uint
+}
+
+// WithGeoIP adds the country and ASN of the client to ban records, events
+// and top offenders, and applies per-country and per-ASN rules
+func WithGeoIP(cfg GeoIPConfig) Option {
+	return func(t *IP404Tracker) {
+		t.geo = &geoRules{
+			resolver:      cfg.Resolver,
+			blocked:       make(map[string]bool, len(cfg.BlockedCountries)),
+			thresholds:    make(map[string]int, len(cfg.CountryThresholds)),
+			blockedASNs:   make(map[uint]bool, len(cfg.BlockedASNs)),
+			asnThresholds: cfg.ASNThresholds,
+			exemptASNs:    make(map[uint]bool, len(cfg.ExemptASNs)),
+		}
+		// Country codes are matched case-insensitively
+		for _, country := range cfg.BlockedCountries {
+			t.geo.blocked[strings.ToUpper(country)] = true
+		}
+		for country, threshold := range cfg.CountryThresholds {
+			t.geo.thresholds[strings.ToUpper(country)] = threshold
+		}
+		for _, asn := range cfg.BlockedASNs {
+			t.geo.blockedASNs[asn] = true
+		}
+		for _, asn := range cfg.ExemptASNs {
+			t.geo.exemptASNs[asn] = true
+		}
+	}
+}
+
+// geoRules holds the GeoIP policies in lookup-friendly form
+type geoRules struct {
+	resolver      GeoResolver
+	blocked       map[string]bool
+	thresholds    map[string]int
+	blockedASNs   map[uint]bool
+	asnThresholds map[uint]int
+	exemptASNs    map[uint]bool
+}
+
+// geoLookup returns what's known about the address behind a tracking key.
+// Keys that aren't addresses, like API keys, have no location.
+func (t *IP404Tracker) geoLookup(key string) GeoInfo {
+	if t.geo == nil || t.geo.resolver == nil {
+		return GeoInfo{}
+	}
+	prefix, err := parseIPOrCIDR(fingerprintAddr(key))
+	if err != nil {
+		return GeoInfo{}
+	}
+
+	info, err := t.geo.resolver.Lookup(prefix.Addr())
+	if err != nil {
+		t.logger.Warn("GeoIP lookup failed", "ip", key, "error", err)
+	}
+	return info
+}
+
+// geoBlocked returns "country" or "asn" when ip is blocked by a GeoIP rule,
+// or "" when it isn't
+func (t *IP404Tracker) geoBlocked(ip string) string {
+	if t.geo == nil || len(t.geo.blocked) == 0 && len(t.geo.blockedASNs) == 0 {
+		return ""
+	}
+	info := t.geoLookup(ip)
+	switch {
+	case t.geo.blockedASNs[info.ASN]:
+		return "asn"
+	case t.geo.blocked[info.Country]:
+		return "country"
+	}
+	return ""
+}
+
+// asnExempt reports whether addr belongs to an exempt network
+func (t *IP404Tracker) asnExempt(addr netip.Addr) bool {
+	if t.geo == nil || len(t.geo.exemptASNs) == 0 {
+		return false
+	}
+	info := t.geoLookup(addr.String())
+	return info.ASN != 0 && t.geo.exemptASNs[info.ASN]
+}
+
+// baseThreshold returns the 404 threshold for the client behind key before
+// probation is taken into account
+func (t *IP404Tracker) baseThreshold(key string) int {
+	if t.geo == nil || len(t.geo.thresholds) == 0 && len(t.geo.asnThresholds) == 0 {
+		return t.limits.Load().threshold
+	}
+	info := t.geoLookup(key)
+	if threshold, ok := t.geo.asnThresholds[info.ASN]; ok && info.ASN != 0 {
+		return threshold
+	}
+	if threshold, ok := t.geo.thresholds[info.Country]; ok {
+		return threshold
+	}
+	return t.limits.Load().threshold
+}
@@ -0,0 +1,338 @@
+package main
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative adminpb/admin.proto
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/netip"
+	"slices"
+	"time"
+
+	"404BlockerDemo/adminpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// RegisterAdminService adds the admin API as the gRPC service defined in
+// adminpb/admin.proto:
+//
+//	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+//	tracker.RegisterAdminService(server)
+//	server.Serve(listener)
+//
+// Calls are authorized like the HTTP admin API when the tracker is
+// configured WithAdminAuth, with the API key or bearer token in the
+// "x-api-key" or "authorization" metadata, and audited the same way.
+func (t *IP404Tracker) RegisterAdminService(s grpc.ServiceRegistrar) {
+	adminpb.RegisterAdminServiceServer(s, &adminService{t: t})
+}
+
+// adminService implements adminpb.AdminServiceServer
+type adminService struct {
+	adminpb.UnimplementedAdminServiceServer
+	t *IP404Tracker
+}
+
+// grpcCaller is who made a call and from where
+type grpcCaller struct {
+	actor  string
+	source string
+}
+
+// authorize checks that the caller may reach the admin API and has at
+// least role min
+func (s *adminService) authorize(ctx context.Context, min AdminRole) (grpcCaller, error) {
+	var (
+		caller grpcCaller
+		local  bool // Over a Unix socket
+		chains [][]*x509.Certificate
+	)
+	if p, ok := peer.FromContext(ctx); ok {
+		if p.Addr != nil {
+			local = p.Addr.Network() == "unix"
+			if addrPort, err := netip.ParseAddrPort(p.Addr.String()); err == nil {
+				caller.source = addrPort.Addr().Unmap().String()
+			}
+		}
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			chains = info.State.VerifiedChains
+		}
+	}
+
+	auth := s.t.adminAuth
+	if auth == nil {
+		// Unprotected admin API: everybody is an operator
+		caller.actor = AuditActorAnonymous
+		return caller, nil
+	}
+	if !local && !auth.allowsIP(caller.source) {
+		return caller, status.Error(codes.PermissionDenied, "forbidden")
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	role, actor, err := auth.authenticateCredentials(chains, first("x-api-key"), first("authorization"), s.t.clock.Now())
+	if err != nil {
+		return caller, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if role < min {
+		return caller, status.Error(codes.PermissionDenied, "insufficient role")
+	}
+	caller.actor = actor
+	return caller, nil
+}
+
+// audit records an administrative change made by caller
+func (s *adminService) audit(caller grpcCaller, action AuditAction, target string, before, after any) {
+	s.t.Audit(AuditEntry{Actor: caller.actor, Source: caller.source, Action: action, Target: target, Before: before, After: after})
+}
+
+// grpcBanError converts an error of the ban operations to a gRPC status
+func grpcBanError(err error) error {
+	switch {
+	case errors.Is(err, errWhitelisted):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, errNotBanned):
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.InvalidArgument, err.Error())
+}
+
+// grpcDuration returns a positive duration, or fallback when none was given
+func grpcDuration(d *durationpb.Duration, fallback time.Duration) (time.Duration, error) {
+	if d == nil {
+		return fallback, nil
+	}
+	if err := d.CheckValid(); err != nil || d.AsDuration() <= 0 {
+		return 0, status.Error(codes.InvalidArgument, "invalid duration: "+d.AsDuration().String())
+	}
+	return d.AsDuration(), nil
+}
+
+// ListBans implements adminpb.AdminServiceServer
+func (s *adminService) ListBans(ctx context.Context, _ *adminpb.ListBansRequest) (*adminpb.ListBansResponse, error) {
+	if _, err := s.authorize(ctx, RoleViewer); err != nil {
+		return nil, err
+	}
+	resp := &adminpb.ListBansResponse{}
+	for _, ban := range s.t.listBans() {
+		resp.Bans = append(resp.Bans, protoBan(ban))
+	}
+	return resp, nil
+}
+
+// GetBan implements adminpb.AdminServiceServer
+func (s *adminService) GetBan(ctx context.Context, req *adminpb.GetBanRequest) (*adminpb.Ban, error) {
+	if _, err := s.authorize(ctx, RoleViewer); err != nil {
+		return nil, err
+	}
+	ban, err := s.t.getBan(req.GetTarget())
+	if err != nil {
+		return nil, grpcBanError(err)
+	}
+	return protoBan(ban), nil
+}
+
+// Ban implements adminpb.AdminServiceServer
+func (s *adminService) Ban(ctx context.Context, req *adminpb.BanRequest) (*adminpb.Ban, error) {
+	caller, err := s.authorize(ctx, RoleOperator)
+	if err != nil {
+		return nil, err
+	}
+	duration, err := grpcDuration(req.GetDuration(), s.t.limits.Load().banDuration)
+	if err != nil {
+		return nil, err
+	}
+	ban, before, err := s.t.banTarget(req.GetTarget(), duration)
+	if err != nil {
+		return nil, grpcBanError(err)
+	}
+	s.audit(caller, AuditBan, ban.Target, before, &ban.BanRecord)
+	return protoBan(ban), nil
+}
+
+// Unban implements adminpb.AdminServiceServer
+func (s *adminService) Unban(ctx context.Context, req *adminpb.UnbanRequest) (*emptypb.Empty, error) {
+	caller, err := s.authorize(ctx, RoleOperator)
+	if err != nil {
+		return nil, err
+	}
+	target, before, err := s.t.unbanTarget(req.GetTarget(), req.GetResetCounts())
+	if err != nil {
+		return nil, grpcBanError(err)
+	}
+	s.audit(caller, AuditUnban, target, before, nil)
+	return &emptypb.Empty{}, nil
+}
+
+// ListWhitelist implements adminpb.AdminServiceServer
+func (s *adminService) ListWhitelist(ctx context.Context, _ *emptypb.Empty) (*adminpb.ListWhitelistResponse, error) {
+	if _, err := s.authorize(ctx, RoleViewer); err != nil {
+		return nil, err
+	}
+	resp := &adminpb.ListWhitelistResponse{
+		Entries:   s.t.GetWhitelist(),
+		Temporary: make(map[string]*timestamppb.Timestamp),
+	}
+	for entry, until := range s.t.GetTemporaryWhitelist() {
+		resp.Temporary[entry] = timestamppb.New(until)
+	}
+	return resp, nil
+}
+
+// AddWhitelist implements adminpb.AdminServiceServer
+func (s *adminService) AddWhitelist(ctx context.Context, req *adminpb.AddWhitelistRequest) (*emptypb.Empty, error) {
+	caller, err := s.authorize(ctx, RoleOperator)
+	if err != nil {
+		return nil, err
+	}
+	entry := req.GetEntry()
+	before := s.t.whitelistEntryState(entry)
+	if req.GetDuration() != nil {
+		var d time.Duration
+		if d, err = grpcDuration(req.GetDuration(), 0); err != nil {
+			return nil, err
+		}
+		err = s.t.AddToWhitelistFor(entry, d)
+	} else {
+		err = s.t.AddToWhitelist(entry)
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	s.audit(caller, AuditWhitelistAdd, entry, before, s.t.whitelistEntryState(entry))
+	return &emptypb.Empty{}, nil
+}
+
+// RemoveWhitelist implements adminpb.AdminServiceServer
+func (s *adminService) RemoveWhitelist(ctx context.Context, req *adminpb.RemoveWhitelistRequest) (*emptypb.Empty, error) {
+	caller, err := s.authorize(ctx, RoleOperator)
+	if err != nil {
+		return nil, err
+	}
+	entry := req.GetEntry()
+	before := s.t.whitelistEntryState(entry)
+	if err := s.t.RemoveFromWhitelist(entry); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	s.audit(caller, AuditWhitelistRemove, entry, before, s.t.whitelistEntryState(entry))
+	return &emptypb.Empty{}, nil
+}
+
+// GetStats implements adminpb.AdminServiceServer
+func (s *adminService) GetStats(ctx context.Context, _ *emptypb.Empty) (*adminpb.Stats, error) {
+	if _, err := s.authorize(ctx, RoleViewer); err != nil {
+		return nil, err
+	}
+	stats := s.t.Stats()
+	return &adminpb.Stats{
+		TrackedIps:      int64(stats.TrackedIPs),
+		ActiveBans:      int64(stats.ActiveBans),
+		TotalNotFound:   stats.Total404s,
+		BlockedRequests: stats.BlockedRequests,
+		MemoryBytes:     stats.MemoryBytes,
+	}, nil
+}
+
+// StreamEvents implements adminpb.AdminServiceServer. Like the SSE stream,
+// a client lagging more than eventStreamBuffer events behind misses some.
+func (s *adminService) StreamEvents(req *adminpb.StreamEventsRequest, stream grpc.ServerStreamingServer[adminpb.Event]) error {
+	if _, err := s.authorize(stream.Context(), RoleViewer); err != nil {
+		return err
+	}
+	events := s.t.events.subscribe(eventStreamBuffer)
+	defer s.t.events.unsubscribe(events)
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// The tracker shut down
+				return nil
+			}
+			if len(req.GetTypes()) > 0 && !slices.Contains(req.GetTypes(), string(event.Type)) {
+				continue
+			}
+			if err := stream.Send(protoEvent(event)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// protoTime converts t, leaving zero times unset
+func protoTime(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// protoGeo converts geo, leaving it unset when GeoIP found nothing
+func protoGeo(geo GeoInfo) *adminpb.Geo {
+	if geo == (GeoInfo{}) {
+		return nil
+	}
+	return &adminpb.Geo{Country: geo.Country, Asn: uint32(geo.ASN), AsOrg: geo.Organization}
+}
+
+// protoRequests converts offending requests
+func protoRequests(requests []OffendingRequest) []*adminpb.OffendingRequest {
+	var result []*adminpb.OffendingRequest
+	for _, r := range requests {
+		result = append(result, &adminpb.OffendingRequest{Path: r.Path, Time: protoTime(r.Time), UserAgent: r.UserAgent})
+	}
+	return result
+}
+
+// protoBan converts a ban
+func protoBan(ban banInfo) *adminpb.Ban {
+	return &adminpb.Ban{
+		Target:    ban.Target,
+		BannedAt:  protoTime(ban.BannedAt),
+		ExpiresAt: protoTime(ban.ExpiresAt),
+		Reason:    ban.Reason,
+		Count:     int64(ban.Count),
+		Rule:      ban.Rule,
+		Requests:  protoRequests(ban.Requests),
+		Offense:   int64(ban.Offense),
+		Source:    string(ban.Source),
+		Geo:       protoGeo(ban.GeoInfo),
+		Paths:     ban.Paths,
+	}
+}
+
+// protoEvent converts an event
+func protoEvent(event Event) *adminpb.Event {
+	return &adminpb.Event{
+		Type:      string(event.Type),
+		Ip:        event.IP,
+		Reason:    event.Reason,
+		Path:      event.Path,
+		Requests:  protoRequests(event.Requests),
+		Count:     int64(event.Count),
+		Rule:      event.Rule,
+		ExpiresAt: protoTime(event.ExpiresAt),
+		Time:      protoTime(event.Time),
+		Geo:       protoGeo(event.GeoInfo),
+		Paths:     event.Paths,
+		Weight:    int64(event.Weight),
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// pingTimeout bounds how long the readiness check waits for the store
+const pingTimeout = 2 * time.Second
+
+// PingStore is implemented by stores that can check they're reachable;
+// the readiness check fails while Ping does
+type PingStore interface {
+	Ping(ctx context.Context) error
+}
+
+// HealthLimits are the state sizes past which the tracker reports itself
+// not ready, so an instance that's filling up is taken out of rotation
+// before it runs out of memory; zero means no limit
+type HealthLimits struct {
+	MaxMemoryBytes int64 // Stats().MemoryBytes
+	MaxTrackedIPs  int   // Stats().TrackedIPs
+}
+
+// WithHealthLimits sets the state sizes the readiness check allows
+func WithHealthLimits(limits HealthLimits) Option {
+	return func(t *IP404Tracker) {
+		t.healthLimits = limits
+	}
+}
+
+// HealthCheck is the outcome of one check of a HealthReport
+type HealthCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// HealthReport is the answer of the liveness or readiness check
+type HealthReport struct {
+	OK     bool          `json:"ok"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// add records a check, failing the report when err isn't nil
+func (r *HealthReport) add(name string, err error) {
+	check := HealthCheck{Name: name, OK: err == nil}
+	if err != nil {
+		check.Error = err.Error()
+		r.OK = false
+	}
+	r.Checks = append(r.Checks, check)
+}
+
+// Health checks the tracker is alive: every background loop is running
+func (t *IP404Tracker) Health() HealthReport {
+	report := HealthReport{OK: true}
+	report.add("loops", t.checkLoops())
+	return report
+}
+
+// Ready checks the tracker can take traffic: it's alive, its store is
+// reachable and its state is within the HealthLimits
+func (t *IP404Tracker) Ready(ctx context.Context) HealthReport {
+	report := t.Health()
+	report.add("store", t.checkStore(ctx))
+
+	stats := t.Stats()
+	var err error
+	if limit := t.healthLimits.MaxMemoryBytes; limit > 0 && stats.MemoryBytes > limit {
+		err = fmt.Errorf("holding about %d bytes, over the limit of %d", stats.MemoryBytes, limit)
+	} else if limit := t.healthLimits.MaxTrackedIPs; limit > 0 && stats.TrackedIPs > limit {
+		err = fmt.Errorf("tracking %d IPs, over the limit of %d", stats.TrackedIPs, limit)
+	}
+	report.add("state", err)
+	return report
+}
+
+// checkLoops fails when a background loop has returned or is waiting to be
+// restarted after a panic
+func (t *IP404Tracker) checkLoops() error {
+	select {
+	case <-t.done:
+		return errors.New("shut down")
+	default:
+	}
+	var stopped []string
+	for _, loop := range t.Loops() {
+		if !loop.Running {
+			stopped = append(stopped, loop.Name)
+		}
+	}
+	if len(stopped) > 0 {
+		return fmt.Errorf("not running: %s", strings.Join(stopped, ", "))
+	}
+	return nil
+}
+
+// checkStore pings the store if it can be pinged
+func (t *IP404Tracker) checkStore(ctx context.Context) error {
+	store, ok := t.store.(PingStore)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	return store.Ping(ctx)
+}
+
+// HealthHandler serves the liveness check, e.g. for a Kubernetes
+// livenessProbe: 200 with the report when alive, 503 otherwise
+func (t *IP404Tracker) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealthReport(w, t.Health())
+	})
+}
+
+// ReadyHandler serves the readiness check, e.g. for a Kubernetes
+// readinessProbe: 200 with the report when ready, 503 otherwise
+func (t *IP404Tracker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealthReport(w, t.Ready(r.Context()))
+	})
+}
+
+// HealthRoutes serves the liveness check at livenessPath and the readiness
+// check at readinessPath, e.g. "/healthz" and "/readyz", in front of next.
+// Probes never reach next, so they aren't tracked or blocked.
+func (t *IP404Tracker) HealthRoutes(next http.Handler, livenessPath, readinessPath string) http.Handler {
+	health, ready := t.HealthHandler(), t.ReadyHandler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case livenessPath:
+			health.ServeHTTP(w, r)
+		case readinessPath:
+			ready.ServeHTTP(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// writeHealthReport answers a probe with report
+func writeHealthReport(w http.ResponseWriter, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !report.OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
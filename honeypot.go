@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Honeypot registers trap routes on r for paths no real visitor asks for,
+// such as /admin.php or /backup.zip. Any request for one bans the client on
+// the spot, whitelisted and verified crawler clients excepted, with the trap
+// in the ban reason, e.g. "honeypot /admin.php". Paths are Gin route
+// patterns, so "/wp-admin/*any" traps a whole tree.
+func (t *IP404Tracker) Honeypot(r *gin.Engine, paths ...string) {
+	for _, path := range paths {
+		r.Any(path, t.trapHandler(BanReasonHoneypot))
+	}
+}
+
+// trapHandler bans whoever requests a trap route, giving reason and the
+// path as the ban reason
+func (t *IP404Tracker) trapHandler(reason string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req := t.ginRequest(c)
+		t.springTrap(req, reason+" "+req.path)
+		if blocked, ok := t.blockBanned(req); ok {
+			t.ginBlock(c, blocked)
+			return
+		}
+		c.Status(http.StatusNotFound)
+	}
+}
+
+// springTrap bans the client behind req for requesting a trap path
+func (t *IP404Tracker) springTrap(req clientRequest, reason string) {
+	if t.IsWhitelisted(req.ip) || t.crawlers.verify(req.ctx, req.ip, req.userAgent, t.clock.Now(), t.logger) {
+		return
+	}
+	key := t.trackingKey(req.key)
+	if t.IsBanned(key) {
+		return
+	}
+
+	now := t.clock.Now()
+	t.recordActivity(req.key, req.path, now)
+	t.recordPath(key, req, now)
+	t.banAutomatic(key, req.path, 0, "", reason, now)
+}
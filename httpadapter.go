@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net"
+	"net/http"
+)
+
+// statusRecorder wraps a ResponseWriter to capture the status code sent by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+// WriteHeader records the status code before passing it on
+func (r *statusRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Write records an implicit 200 when the handler writes without a header
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.status = http.StatusOK
+		r.wroteHeader = true
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush passes flushes through for streaming handlers
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the original ResponseWriter to http.ResponseController
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// remoteIP returns the IP part of the request's remote address
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Handler wraps a standard net/http handler with the same 404 tracking and
+// shadow banning as Middleware, for use with chi, gorilla/mux or plain net/http
+func (t *IP404Tracker) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientIP := remoteIP(r)
+		if t.resolvesClientIP() {
+			clientIP = t.clientIP(clientIP, r.Header.Values)
+		}
+
+		// Shadow banned clients get a generic 404, or the ban response
+		req := clientRequest{
+			ctx:            r.Context(),
+			ip:             clientIP,
+			method:         r.Method,
+			path:           r.URL.Path,
+			userAgent:      r.UserAgent(),
+			acceptLanguage: r.Header.Get("Accept-Language"),
+			passCookie:     t.cookieChallenge.cookie(r),
+		}
+		var key string
+		if t.httpKeyFunc != nil {
+			key = t.httpKeyFunc(r)
+		}
+		req.key = t.requestKey(req, key)
+		if t.isChallengePath(req.path) {
+			t.serveChallengePath(w, r, req)
+			return
+		}
+		if blocked, ok := t.blockBanned(req); ok {
+			t.holdBanned(req.ctx, blocked)
+			writeBanResponse(w, t.banResponse(blocked))
+			return
+		}
+		if resp, limited := t.rateLimited(req); limited {
+			writeBanResponse(w, resp)
+			return
+		}
+
+		for name, value := range t.rateLimitHeaders(req) {
+			w.Header().Set(name, value)
+		}
+
+		// Process the request and capture its status
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		t.handleResponse(req, rec.status)
+	})
+}
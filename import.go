@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+)
+
+// ImportFormat is a ban list format ImportBans can read
+type ImportFormat string
+
+const (
+	// ImportPlain reads every IP and CIDR in the text, one or more per line,
+	// with '#' and ';' comments. nginx deny and Apache Require not ip lines
+	// work as they are.
+	ImportPlain ImportFormat = "plain"
+	// ImportCSV reads rows with a target column and optional reason and
+	// expires_at (RFC 3339) columns, as written by ExportBans. Without a
+	// header row the first column is the target.
+	ImportCSV ImportFormat = "csv"
+	// ImportSnapshot reads the JSON written by Snapshot, see Restore
+	ImportSnapshot ImportFormat = "snapshot"
+)
+
+// WithBanImport loads a ban list from path on startup, e.g. when migrating
+// from another tool or seeding known bad addresses
+func WithBanImport(path string, format ImportFormat) Option {
+	return func(t *IP404Tracker) {
+		t.importPath = path
+		t.importFormat = format
+	}
+}
+
+// importedBan is an entry of an imported ban list
+type importedBan struct {
+	prefix    netip.Prefix
+	record    BanRecord
+	blacklist bool // Permanent, added to the blacklist
+	expires   bool // The list gave the expiry rather than the ban duration
+}
+
+// ImportBans bans every entry of a ban list and returns how many were
+// imported. Entries without an expiry are banned for the tracker's ban
+// duration, except CSV rows with the reason "blacklist", which are added to
+// the blacklist. Expired and whitelisted entries are skipped, and so are
+// entries already banned unless the list gives a later expiry.
+func (t *IP404Tracker) ImportBans(r io.Reader, format ImportFormat) (int, error) {
+	now := t.clock.Now()
+	defaults := BanRecord{
+		BannedAt:  now,
+		ExpiresAt: now.Add(t.limits.Load().banDuration),
+		Reason:    BanReasonImported,
+		Source:    BanSourceManual,
+	}
+
+	var entries []importedBan
+	switch format {
+	case ImportPlain:
+		body, err := io.ReadAll(r)
+		if err != nil {
+			return 0, err
+		}
+		for _, prefix := range parseRangeList(string(body)) {
+			entries = append(entries, importedBan{prefix: prefix, record: defaults})
+		}
+	case ImportCSV:
+		var err error
+		if entries, err = t.parseBanCSV(r, defaults); err != nil {
+			return 0, err
+		}
+	case ImportSnapshot:
+		return t.restore(r)
+	default:
+		return 0, fmt.Errorf("unknown import format %q", format)
+	}
+
+	imported := 0
+	for _, entry := range entries {
+		if t.importBan(entry, now) {
+			imported++
+		}
+	}
+	t.logger.Info("bans imported", "format", format, "entries", len(entries), "imported", imported)
+	return imported, nil
+}
+
+// parseBanCSV reads the rows of a CSV ban list
+func (t *IP404Tracker) parseBanCSV(r io.Reader, defaults BanRecord) ([]importedBan, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+
+	columns := map[string]int{"target": 0, "reason": -1, "expires_at": -1}
+	var entries []importedBan
+	for row := 0; ; row++ {
+		fields, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if row == 0 && !strings.ContainsAny(fields[0], ".:") {
+			// Header row naming the columns
+			columns["target"] = -1
+			for i, name := range fields {
+				name = strings.ToLower(strings.TrimSpace(name))
+				if name == "ip" {
+					name = "target"
+				}
+				columns[name] = i
+			}
+			if columns["target"] < 0 {
+				return nil, errors.New("CSV header has no target column")
+			}
+			continue
+		}
+
+		field := func(name string) string {
+			if i := columns[name]; i >= 0 && i < len(fields) {
+				return strings.TrimSpace(fields[i])
+			}
+			return ""
+		}
+
+		line, _ := cr.FieldPos(0)
+		prefix, err := parseIPOrCIDR(field("target"))
+		if err != nil {
+			t.logger.Warn("skipping invalid ban list entry", "line", line, "error", err)
+			continue
+		}
+		entry := importedBan{prefix: prefix, record: defaults}
+		if reason := field("reason"); reason != "" {
+			entry.record.Reason = reason
+		}
+		if expires := field("expires_at"); expires != "" {
+			if entry.record.ExpiresAt, err = time.Parse(time.RFC3339, expires); err != nil {
+				t.logger.Warn("skipping invalid ban list entry", "line", line, "error", err)
+				continue
+			}
+			entry.expires = true
+		} else if entry.record.Reason == BanReasonBlacklist {
+			entry.blacklist = true
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// importBan applies one imported entry, reporting whether it was banned.
+// Entries already banned are skipped unless the list says to ban them for
+// longer, so importing the same list on every restart neither cuts longer
+// bans short nor announces the bans again.
+func (t *IP404Tracker) importBan(entry importedBan, now time.Time) bool {
+	target := prefixString(entry.prefix)
+	if !entry.blacklist && !entry.record.activeAt(now) {
+		return false
+	}
+	if entry.prefix.IsSingleIP() && t.IsWhitelisted(target) {
+		return false
+	}
+	if t.importCovered(entry, now) {
+		return false
+	}
+	entry.record.GeoInfo = t.geoLookup(target)
+
+	switch {
+	case entry.blacklist:
+		t.mu.Lock()
+		t.blacklist.insert(entry.prefix, struct{}{})
+		t.listsChanged()
+		t.mu.Unlock()
+		entry.record.ExpiresAt = time.Time{}
+	case entry.prefix.IsSingleIP():
+		target = t.trackingKey(target)
+		t.ban(target, entry.record)
+	default:
+		t.banCIDR(entry.prefix, entry.record)
+	}
+
+	t.emit(Event{Type: EventBanned, IP: target, Reason: entry.record.Reason, ExpiresAt: entry.record.ExpiresAt, GeoInfo: entry.record.GeoInfo})
+	return true
+}
+
+// importCovered reports whether entry is already blacklisted, or banned
+// until at least its expiry when the list gave one
+func (t *IP404Tracker) importCovered(entry importedBan, now time.Time) bool {
+	switch {
+	case entry.blacklist:
+		t.mu.RLock()
+		defer t.mu.RUnlock()
+		_, ok := t.blacklist.get(entry.prefix)
+		return ok
+	case entry.prefix.IsSingleIP():
+		key := t.trackingKey(prefixString(entry.prefix))
+		current, ok, err := t.store.GetBan(key, now)
+		if err != nil {
+			t.logger.Error("checking ban failed", "ip", key, "error", err)
+		}
+		return ok && (!entry.expires || !current.ExpiresAt.Before(entry.record.ExpiresAt))
+	default:
+		current, ok := t.GetCIDRBanInfo(entry.prefix)
+		return ok && (!entry.expires || !current.ExpiresAt.Before(entry.record.ExpiresAt))
+	}
+}
+
+// loadBanImport imports the ban list configured with WithBanImport
+func (t *IP404Tracker) loadBanImport() {
+	f, err := os.Open(t.importPath)
+	if err != nil {
+		t.logger.Error("opening ban list failed", "error", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := t.ImportBans(f, t.importFormat); err != nil {
+		t.logger.Error("importing ban list failed", "path", t.importPath, "error", err)
+	}
+}
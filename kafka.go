@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+// KafkaConfig publishes events to a Kafka topic. Messages are keyed by the
+// banned IP, CIDR or client key, so all events about a client land on the
+// same partition in order, and carry the event as JSON.
+type KafkaConfig struct {
+	Brokers []string
+	Topic   string // Default "404blocker-events"
+
+	// Event types published; empty publishes all of them
+	Types []EventType
+
+	BatchSize    int           // Events per produce request (default 100)
+	BatchTimeout time.Duration // Longest an event waits for a batch to fill (default 1s)
+	Buffer       int           // Events queued in memory while Kafka is slow (default 10000)
+
+	// File holding the events Kafka didn't acknowledge yet, so they survive
+	// outages and restarts; without it they're retried from memory only
+	SpoolPath string
+
+	TLS      *tls.Config // Connect over TLS when set
+	Username string      // SASL/PLAIN credentials, when set
+	Password string
+}
+
+// Most time between attempts to reach Kafka
+const kafkaMaxBackoff = 30 * time.Second
+
+// WithKafka publishes events to Kafka with at-least-once delivery: a batch
+// is only dropped once every in-sync replica acknowledged it, and retried
+// until then. Consumers should expect the odd duplicate.
+func WithKafka(cfg KafkaConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.Topic == "" {
+			cfg.Topic = "404blocker-events"
+		}
+		if cfg.BatchSize <= 0 {
+			cfg.BatchSize = 100
+		}
+		if cfg.BatchTimeout <= 0 {
+			cfg.BatchTimeout = time.Second
+		}
+		if cfg.Buffer <= 0 {
+			cfg.Buffer = 10000
+		}
+		t.kafka = &cfg
+	}
+}
+
+// kafkaProducer sends batches of events and keeps the ones it couldn't
+type kafkaProducer struct {
+	cfg    *KafkaConfig
+	writer *kafka.Writer
+	spool  appendLog
+}
+
+// newKafkaProducer returns a producer for cfg
+func newKafkaProducer(cfg *KafkaConfig) *kafkaProducer {
+	transport := &kafka.Transport{TLS: cfg.TLS, ClientID: "404blocker"}
+	if cfg.Username != "" {
+		transport.SASL = plain.Mechanism{Username: cfg.Username, Password: cfg.Password}
+	}
+	return &kafkaProducer{
+		cfg: cfg,
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Topic:        cfg.Topic,
+			Balancer:     &kafka.Murmur2Balancer{}, // Partitions like the Java client
+			RequiredAcks: kafka.RequireAll,
+			MaxAttempts:  1, // Retries are ours, so failed batches can be spooled
+			BatchSize:    cfg.BatchSize,
+			BatchTimeout: time.Millisecond,
+			Transport:    transport,
+		},
+		spool: appendLog{path: cfg.SpoolPath, perm: 0o600},
+	}
+}
+
+// kafkaMessage converts an event
+func kafkaMessage(event Event) (kafka.Message, error) {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	return kafka.Message{
+		Key:     []byte(event.IP),
+		Value:   value,
+		Time:    event.Time,
+		Headers: []kafka.Header{{Key: "type", Value: []byte(event.Type)}},
+	}, nil
+}
+
+// send produces messages, waiting at most 10s for the acknowledgement
+func (p *kafkaProducer) send(messages []kafka.Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return p.writer.WriteMessages(ctx, messages...)
+}
+
+// spooled reports whether events are waiting in the spool file
+func (p *kafkaProducer) spooled() bool {
+	if p.cfg.SpoolPath == "" {
+		return false
+	}
+	info, err := os.Stat(p.cfg.SpoolPath)
+	return err == nil && info.Size() > 0
+}
+
+// save appends messages to the spool file
+func (p *kafkaProducer) save(messages []kafka.Message) error {
+	for _, m := range messages {
+		if err := p.spool.write(string(m.Value) + "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replay sends the spooled events and empties the spool once all of them
+// went through. A failure resends everything next time, which at least once
+// allows.
+func (p *kafkaProducer) replay() error {
+	p.spool.close()
+	file, err := os.Open(p.cfg.SpoolPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var batch []kafka.Message
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var event Event
+		if json.Unmarshal(scanner.Bytes(), &event) != nil {
+			// A line cut short by a crash
+			continue
+		}
+		m, err := kafkaMessage(event)
+		if err != nil {
+			continue
+		}
+		if batch = append(batch, m); len(batch) == p.cfg.BatchSize {
+			if err := p.send(batch); err != nil {
+				return err
+			}
+			batch = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		if err := p.send(batch); err != nil {
+			return err
+		}
+	}
+	return os.Truncate(p.cfg.SpoolPath, 0)
+}
+
+// collectKafkaBatch reads the next batch of published events, waiting for
+// the first and then until the batch is full or the timeout passed. It
+// returns false once the channel is closed.
+func (t *IP404Tracker) collectKafkaBatch(events <-chan Event) ([]kafka.Message, bool) {
+	var batch []kafka.Message
+	add := func(event Event) {
+		m, err := kafkaMessage(event)
+		if err != nil {
+			t.logger.Error("encoding event failed", "event", event.Type, "error", err)
+			return
+		}
+		batch = append(batch, m)
+	}
+
+	event, ok := <-events
+	if !ok {
+		return nil, false
+	}
+	add(event)
+	timeout, stop := t.clock.NewTicker(t.kafka.BatchTimeout)
+	defer stop()
+	for len(batch) < t.kafka.BatchSize {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return batch, false
+			}
+			add(event)
+		case <-timeout:
+			return batch, true
+		}
+	}
+	return batch, true
+}
+
+// publish sends the spooled events, then batch
+func (p *kafkaProducer) publish(batch []kafka.Message) error {
+	if p.spooled() {
+		if err := p.replay(); err != nil {
+			return err
+		}
+	}
+	return p.send(batch)
+}
+
+// kafkaLoop publishes events until the tracker shuts down. While Kafka is
+// unreachable, batches go to the spool file if there is one, and are
+// retried from memory otherwise.
+func (t *IP404Tracker) kafkaLoop(events <-chan Event) {
+	p := newKafkaProducer(t.kafka)
+	defer p.writer.Close()
+	defer p.spool.close()
+
+	// Events left over from the last run go out first
+	if p.spooled() {
+		if err := p.replay(); err != nil {
+			t.logger.Error("publishing spooled kafka events failed", "topic", t.kafka.Topic, "error", err)
+		}
+	}
+
+	backoff := time.Second
+	for {
+		batch, open := t.collectKafkaBatch(events)
+		for len(batch) > 0 {
+			err := p.publish(batch)
+			if err == nil {
+				backoff = time.Second
+				break
+			}
+			t.logger.Error("publishing to kafka failed", "topic", t.kafka.Topic, "error", err)
+			if t.kafka.SpoolPath != "" {
+				t.spoolKafka(p, batch)
+				batch = nil
+			}
+			if !t.sleep(backoff) {
+				// Shutting down with Kafka out of reach
+				if t.kafka.SpoolPath == "" {
+					t.logger.Warn("dropping unpublished kafka events", "events", len(batch))
+					return
+				}
+				for open {
+					batch, open = t.collectKafkaBatch(events)
+					t.spoolKafka(p, batch)
+				}
+				return
+			}
+			backoff = min(2*backoff, kafkaMaxBackoff)
+		}
+		if !open {
+			return
+		}
+	}
+}
+
+// spoolKafka keeps batch in the spool file for later
+func (t *IP404Tracker) spoolKafka(p *kafkaProducer, batch []kafka.Message) {
+	if err := p.save(batch); err != nil {
+		t.logger.Error("spooling kafka events failed", "path", t.kafka.SpoolPath, "error", err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofiber/fiber/v2"
+)
+
+// KeyFunc returns the key a Gin request is tracked and banned under, such
+// as its API key or session ID, or "" to fall back to the client IP.
+// It runs before the request's handlers.
+type KeyFunc func(c *gin.Context) string
+
+// WithKeyFunc tracks and bans Gin clients by the key fn returns instead of
+// by IP, so a single bad client behind a shared NAT doesn't get the whole
+// network banned. Keyed requests are checked against bans of their key only;
+// the whitelist, blacklist and range bans still apply by address.
+//
+// Keys must not look like IP addresses: prefix them (e.g. "apikey:"+key)
+// so a client can't name somebody else's address as its key.
+func WithKeyFunc(fn KeyFunc) Option {
+	return func(t *IP404Tracker) {
+		t.keyFunc = fn
+	}
+}
+
+// WithHTTPKeyFunc is WithKeyFunc for Handler
+func WithHTTPKeyFunc(fn func(r *http.Request) string) Option {
+	return func(t *IP404Tracker) {
+		t.httpKeyFunc = fn
+	}
+}
+
+// WithFiberKeyFunc is WithKeyFunc for FiberMiddleware
+func WithFiberKeyFunc(fn func(c *fiber.Ctx) string) Option {
+	return func(t *IP404Tracker) {
+		t.fiberKeyFunc = fn
+	}
+}
+
+// tracksKeys reports whether clients may be tracked by something other
+// than their IP
+func (t *IP404Tracker) tracksKeys() bool {
+	return t.keyFunc != nil || t.httpKeyFunc != nil || t.fiberKeyFunc != nil || t.fingerprint != nil
+}
+
+// requestKey returns the key req is tracked under: key when a KeyFunc found
+// one, else the request's fingerprint or IP
+func (t *IP404Tracker) requestKey(req clientRequest, key string) string {
+	switch {
+	case key != "":
+		return key
+	case t.fingerprint != nil:
+		return t.fingerprintKey(req)
+	}
+	return req.ip
+}
+
+// clearKeyCounters forgets the 404 and status counts, paths, banned
+// request counter and probation of a non-IP key
+func (t *IP404Tracker) clearKeyCounters(key string) {
+	if err := t.store.ClearCounts(key); err != nil {
+		t.logger.Error("clearing counts failed", "ip", key, "error", err)
+	}
+	t.endProbation(key)
+	t.clearStatusCounts(func(k string) bool { return k == key })
+	t.uniquePaths.clear(func(k string) bool { return k == key })
+	t.rateLimiter.clear(func(k string) bool { return k == key })
+	t.paths.delete(key)
+	t.bannedRequest.delete(key)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Close stops the tracker's background work and releases what it holds,
+// waiting as long as that takes. See Shutdown.
+func (t *IP404Tracker) Close() error {
+	return t.Shutdown(context.Background())
+}
+
+// Shutdown stops the background loops, delivers the events already queued
+// for notifiers and integrations, writes a final snapshot and closes the
+// store, propagator and GeoIP resolver when they have a Close method. The
+// channel returned by Events is closed. If ctx ends before the loops have
+// finished, Shutdown returns its error without releasing anything, and the
+// loops finish on their own. Calls after the first return the same result;
+// the tracker can't be used once it's shut down.
+func (t *IP404Tracker) Shutdown(ctx context.Context) error {
+	t.closeOnce.Do(func() {
+		close(t.done)
+		// Subscribers drain their buffers and return once their channel is closed
+		t.events.close()
+
+		finished := make(chan struct{})
+		go func() {
+			t.wg.Wait()
+			close(finished)
+		}()
+		select {
+		case <-finished:
+		case <-ctx.Done():
+			t.closeErr = ctx.Err()
+			return
+		}
+
+		var errs []error
+		if t.snapshotPath != "" {
+			if err := t.writeSnapshotFile(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if t.propagator != nil {
+			errs = append(errs, closeIfCloser(t.propagator))
+		}
+		errs = append(errs, closeIfCloser(t.store))
+		if t.geo != nil && t.geo.resolver != nil {
+			errs = append(errs, closeIfCloser(t.geo.resolver))
+		}
+		t.closeAudit()
+		t.closeErr = errors.Join(errs...)
+		t.logger.Info("tracker shut down")
+	})
+	return t.closeErr
+}
+
+// closeIfCloser closes v if it has a Close method
+func closeIfCloser(v any) error {
+	if c, ok := v.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Delays before a background loop that panicked is restarted, doubling from
+// the first to the longest with every panic in a row
+const (
+	firstRestartDelay = time.Second
+	maxRestartDelay   = time.Minute
+)
+
+// LoopStatus is the state of one of the tracker's background loops
+type LoopStatus struct {
+	Name       string    `json:"name"`
+	Running    bool      `json:"running"`  // False once it has returned, or while waiting to restart
+	Restarts   int       `json:"restarts"` // Times it was restarted after a panic
+	LastPanic  string    `json:"last_panic,omitempty"`
+	PanickedAt time.Time `json:"panicked_at,omitzero"`
+}
+
+// loopRegistry holds the state of the background loops
+type loopRegistry struct {
+	mu    sync.Mutex
+	loops []*LoopStatus
+}
+
+// add registers a running loop called name
+func (r *loopRegistry) add(name string) *LoopStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := &LoopStatus{Name: name, Running: true}
+	r.loops = append(r.loops, status)
+	return status
+}
+
+// update changes the state of a loop
+func (r *loopRegistry) update(status *LoopStatus, fn func(*LoopStatus)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(status)
+}
+
+// list returns a copy of the state of every loop
+func (r *loopRegistry) list() []LoopStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]LoopStatus, len(r.loops))
+	for i, status := range r.loops {
+		result[i] = *status
+	}
+	return result
+}
+
+// Loops returns the state of the tracker's background loops
+func (t *IP404Tracker) Loops() []LoopStatus {
+	return t.loops.list()
+}
+
+// background runs fn in a goroutine Shutdown waits for. A panic in fn is
+// logged and fn started again after a delay, so one bad cleanup or
+// delivery doesn't stop the loop for good.
+func (t *IP404Tracker) background(name string, fn func()) {
+	status := t.loops.add(name)
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		defer t.loops.update(status, func(s *LoopStatus) { s.Running = false })
+
+		delay := firstRestartDelay
+		for {
+			started := t.clock.Now()
+			if t.runRecovered(status, fn) {
+				return
+			}
+			// A loop that ran fine for a while starts over from the first delay
+			if t.clock.Now().Sub(started) > maxRestartDelay {
+				delay = firstRestartDelay
+			}
+			if !t.sleep(delay) {
+				return
+			}
+			delay = min(2*delay, maxRestartDelay)
+			var restarts int
+			t.loops.update(status, func(s *LoopStatus) {
+				s.Running = true
+				s.Restarts++
+				restarts = s.Restarts
+			})
+			t.logger.Warn("restarting background loop", "loop", status.Name, "restarts", restarts)
+		}
+	}()
+}
+
+// runRecovered runs fn, reporting false if it panicked
+func (t *IP404Tracker) runRecovered(status *LoopStatus, fn func()) (returned bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			t.logger.Error("background loop panicked", "loop", status.Name, "panic", v, "stack", string(debug.Stack()))
+			t.loops.update(status, func(s *LoopStatus) {
+				s.Running = false
+				s.LastPanic = fmt.Sprint(v)
+				s.PanickedAt = t.clock.Now()
+			})
+		}
+	}()
+	fn()
+	return true
+}
+
+// sleep waits for d and reports whether the tracker is still running
+func (t *IP404Tracker) sleep(d time.Duration) bool {
+	tick, stop := t.clock.NewTicker(d)
+	defer stop()
+
+	select {
+	case <-tick:
+		return true
+	case <-t.done:
+		return false
+	}
+}
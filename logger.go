@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// Logger receives the tracker's structured log output as a message followed
+// by alternating keys and values. *slog.Logger satisfies it directly; zerolog,
+// zap and others need a small adapter.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// WithLogger sets where the tracker writes its logs (text to stdout by default)
+func WithLogger(logger Logger) Option {
+	return func(t *IP404Tracker) {
+		t.logger = logger
+	}
+}
+
+// defaultLogger writes text logs at info level to stdout
+func defaultLogger() Logger {
+	return slog.New(slog.NewTextHandler(os.Stdout, nil)).With("component", "404blocker")
+}
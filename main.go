@@ -1,26 +1,128 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	configPath := flag.String("config", os.Getenv("BLOCKER_CONFIG"), "YAML configuration file (see config.example.yaml)")
+	listen := flag.String("listen", ":8080", "address to listen on")
+	upstream := flag.String("upstream", "", "run as a reverse proxy in front of this URL, e.g. http://127.0.0.1:3000")
+	flag.Parse()
+
+	loadConfig := func() (*Config, error) {
+		// Defaults: 3 404s within 1 minute ban for 24 hours
+		cfg := DefaultConfig()
+		if *configPath != "" {
+			var err error
+			if cfg, err = LoadConfig(*configPath); err != nil {
+				return nil, err
+			}
+		}
+		// Environment variables win over the file, and flags over both
+		if err := cfg.ApplyEnv(os.LookupEnv); err != nil {
+			return nil, err
+		}
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "listen":
+				cfg.Listen = *listen
+			case "upstream":
+				cfg.Upstream = *upstream
+			}
+		})
+		return cfg, cfg.Validate()
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Initialize 404 Limiter Middleware
-	tracker := NewIP404Tracker(
-		3,             // threshold: 3 404s
-		1*time.Minute, // window: within 1 minute
-		24*time.Hour,  // banDuration: ban for 24 hours
-	)
+	tracker, err := cfg.NewTracker()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Re-read the configuration on SIGHUP; a broken file keeps the old one
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			cfg, err := loadConfig()
+			if err == nil {
+				err = tracker.Reload(cfg)
+			}
+			if err != nil {
+				log.Printf("reloading configuration failed: %v", err)
+			}
+		}
+	}()
+
+	server := &http.Server{Addr: cfg.Listen}
+	if cfg.Upstream != "" {
+		// Sidecar mode: guard any backend without touching its code
+		target, _ := url.Parse(cfg.Upstream)
+		server.Handler = tracker.ReverseProxy(target)
+	} else {
+		// Prepare router
+		router := gin.Default()
+
+		// 404 Limiter Middleware
+		router.Use(tracker.Middleware())
+		server.Handler = router
+	}
+	if ch := cfg.Check; ch != nil {
+		server.Handler = tracker.CheckRoutes(server.Handler, ch.path())
+	}
+	if h := cfg.Health; h != nil {
+		liveness, readiness := h.paths()
+		server.Handler = tracker.HealthRoutes(server.Handler, liveness, readiness)
+	}
 
-	// Prepare router
-	router := gin.Default()
+	// Management traffic stays off the public listener
+	var admin *adminServers
+	if a := cfg.Admin; a != nil {
+		if admin, err = a.serve(tracker); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	// 404 Limiter Middleware
-	router.Use(tracker.Middleware())
+	// Finish in-flight requests on SIGINT/SIGTERM, then flush the tracker
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	shutdown := make(chan struct{})
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("stopping server: %v", err)
+		}
+		if admin != nil {
+			if err := admin.shutdown(ctx); err != nil {
+				log.Printf("stopping admin server: %v", err)
+			}
+		}
+		if err := tracker.Shutdown(ctx); err != nil {
+			log.Printf("stopping tracker: %v", err)
+		}
+		close(shutdown)
+	}()
 
 	// Start Server
-	router.Run(":8080")
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-shutdown
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// MethodConfig flags requests with methods real clients don't send, such as
+// TRACE for cross-site tracing or made-up verbs that fingerprint the
+// server. They only count when the app turned them down with a 4xx or 5xx,
+// so endpoints that do support a method aren't affected.
+type MethodConfig struct {
+	// Suspicious methods (default TRACE, TRACK, CONNECT and DEBUG). Any
+	// method that isn't a standard one is suspicious too.
+	Methods []string
+
+	// Nonstandard methods the app uses, e.g. WebDAV's PROPFIND
+	Allow []string
+
+	// How many 404s a rejected request counts as, in place of its own
+	// count; 0 bans on the spot
+	Weight int
+}
+
+// Methods RFC 9110 defines, and PATCH
+var standardMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace,
+}
+
+// methodRules holds the method anomaly settings
+type methodRules struct {
+	cfg MethodConfig
+}
+
+// WithMethodRules penalizes clients sending unusual methods to endpoints
+// that don't support them
+func WithMethodRules(cfg MethodConfig) Option {
+	return func(t *IP404Tracker) {
+		if len(cfg.Methods) == 0 {
+			cfg.Methods = []string{http.MethodTrace, "TRACK", http.MethodConnect, "DEBUG"}
+		}
+		for i, method := range cfg.Methods {
+			cfg.Methods[i] = strings.ToUpper(method)
+		}
+		for i, method := range cfg.Allow {
+			cfg.Allow[i] = strings.ToUpper(method)
+		}
+		if cfg.Weight < 0 {
+			cfg.Weight = 0
+		}
+		t.methodRules = &methodRules{cfg: cfg}
+	}
+}
+
+// anomalous reports whether method is one the rules flag
+func (m *methodRules) anomalous(method string) bool {
+	if m == nil {
+		return false
+	}
+	method = strings.ToUpper(method)
+	if slices.Contains(m.cfg.Methods, method) {
+		return true
+	}
+	return !slices.Contains(standardMethods, method) && !slices.Contains(m.cfg.Allow, method)
+}
+
+// recordMethodAnomaly bans or penalizes the client of a rejected request
+// with a flagged method
+func (t *IP404Tracker) recordMethodAnomaly(req clientRequest) {
+	if t.methodRules.cfg.Weight == 0 {
+		t.springTrap(req, BanReasonMethod+" "+strings.ToUpper(req.method))
+		return
+	}
+	t.handle404(req, t.methodRules.cfg.Weight)
+}
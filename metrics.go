@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// trackerCounters are monotonically increasing totals maintained by the tracker
+type trackerCounters struct {
+	recorded404s    atomic.Uint64 // Tracked 404 responses
+	bansIssued      atomic.Uint64 // Automatic and manual bans
+	blockedRequests atomic.Uint64 // Requests refused while banned
+	whitelistedHits atomic.Uint64 // Requests from whitelisted IPs
+	rateLimited     atomic.Uint64 // Requests answered 429 by the rate limiter or warning threshold
+	evictions       atomic.Uint64 // Clients forgotten to stay under WithMaxTrackedIPs
+	asyncDropped    atomic.Uint64 // Responses not recorded because the WithAsyncRecording queue was full
+}
+
+// trackedIPs returns how many IPs currently have 404s within the window
+func (t *IP404Tracker) trackedIPs() int {
+	counts, err := t.store.ListCounts(t.clock.Now(), t.limits.Load().window)
+	if err != nil {
+		t.logger.Error("listing counts failed", "error", err)
+		return 0
+	}
+	return len(counts)
+}
+
+// bannedCount returns how many IPs and CIDRs are currently banned
+func (t *IP404Tracker) bannedCount() int {
+	return len(t.GetBannedIPs()) + len(t.GetBannedCIDRs())
+}
+
+var (
+	recorded404sDesc = prometheus.NewDesc(
+		"blocker_404s_recorded_total", "Total 404 responses tracked.", nil, nil)
+	bansIssuedDesc = prometheus.NewDesc(
+		"blocker_bans_issued_total", "Total bans issued, automatic and manual.", nil, nil)
+	blockedRequestsDesc = prometheus.NewDesc(
+		"blocker_banned_requests_total", "Total requests refused because the client was banned.", nil, nil)
+	whitelistedHitsDesc = prometheus.NewDesc(
+		"blocker_whitelisted_requests_total", "Total requests from whitelisted clients.", nil, nil)
+	rateLimitedDesc = prometheus.NewDesc(
+		"blocker_rate_limited_requests_total", "Total requests answered 429 by the rate limiter or warning threshold.", nil, nil)
+	evictionsDesc = prometheus.NewDesc(
+		"blocker_evicted_clients_total", "Total clients forgotten to stay under the tracked client limit.", nil, nil)
+	asyncDroppedDesc = prometheus.NewDesc(
+		"blocker_async_dropped_total", "Total responses not recorded because the asynchronous recording queue was full.", nil, nil)
+	bannedIPsDesc = prometheus.NewDesc(
+		"blocker_banned_ips", "IPs and CIDRs currently banned.", nil, nil)
+	trackedIPsDesc = prometheus.NewDesc(
+		"blocker_tracked_ips", "IPs with 404s inside the current window.", nil, nil)
+	statusesRecordedDesc = prometheus.NewDesc(
+		"blocker_status_responses_recorded_total", "Total responses tracked by status rules.", []string{"rule"}, nil)
+	feedEntriesDesc = prometheus.NewDesc(
+		"blocker_feed_entries", "IPs and CIDRs listed by each threat feed.", []string{"feed"}, nil)
+)
+
+// trackerCollector exposes tracker state as Prometheus metrics
+type trackerCollector struct {
+	tracker *IP404Tracker
+}
+
+// Describe implements prometheus.Collector
+func (c trackerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- recorded404sDesc
+	ch <- bansIssuedDesc
+	ch <- blockedRequestsDesc
+	ch <- whitelistedHitsDesc
+	ch <- rateLimitedDesc
+	ch <- evictionsDesc
+	ch <- asyncDroppedDesc
+	ch <- bannedIPsDesc
+	ch <- trackedIPsDesc
+	ch <- statusesRecordedDesc
+	ch <- feedEntriesDesc
+}
+
+// Collect implements prometheus.Collector
+func (c trackerCollector) Collect(ch chan<- prometheus.Metric) {
+	counters := &c.tracker.counters
+	ch <- prometheus.MustNewConstMetric(recorded404sDesc, prometheus.CounterValue, float64(counters.recorded404s.Load()))
+	ch <- prometheus.MustNewConstMetric(bansIssuedDesc, prometheus.CounterValue, float64(counters.bansIssued.Load()))
+	ch <- prometheus.MustNewConstMetric(blockedRequestsDesc, prometheus.CounterValue, float64(counters.blockedRequests.Load()))
+	ch <- prometheus.MustNewConstMetric(whitelistedHitsDesc, prometheus.CounterValue, float64(counters.whitelistedHits.Load()))
+	ch <- prometheus.MustNewConstMetric(rateLimitedDesc, prometheus.CounterValue, float64(counters.rateLimited.Load()))
+	ch <- prometheus.MustNewConstMetric(evictionsDesc, prometheus.CounterValue, float64(counters.evictions.Load()))
+	ch <- prometheus.MustNewConstMetric(asyncDroppedDesc, prometheus.CounterValue, float64(counters.asyncDropped.Load()))
+	ch <- prometheus.MustNewConstMetric(bannedIPsDesc, prometheus.GaugeValue, float64(c.tracker.bannedCount()))
+	ch <- prometheus.MustNewConstMetric(trackedIPsDesc, prometheus.GaugeValue, float64(c.tracker.trackedIPs()))
+	for _, counter := range c.tracker.statusRules {
+		ch <- prometheus.MustNewConstMetric(statusesRecordedDesc, prometheus.CounterValue, float64(counter.recorded.Load()), counter.rule.Name)
+	}
+	for _, feed := range c.tracker.Feeds() {
+		ch <- prometheus.MustNewConstMetric(feedEntriesDesc, prometheus.GaugeValue, float64(feed.Entries), feed.Name)
+	}
+}
+
+// Collector returns a Prometheus collector for registering the tracker's
+// metrics with an existing registry
+func (t *IP404Tracker) Collector() prometheus.Collector {
+	return trackerCollector{tracker: t}
+}
+
+// MetricsHandler returns an http.Handler serving the tracker's metrics on a
+// dedicated registry, e.g. router.GET("/metrics", gin.WrapH(tracker.MetricsHandler()))
+func (t *IP404Tracker) MetricsHandler() http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(t.Collector())
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATSConfig connects to a NATS server for publishing events, see WithNATS,
+// or sharing bans between instances, see NewNATSPropagator
+type NATSConfig struct {
+	URL string // Comma-separated server URLs (default "nats://127.0.0.1:4222")
+
+	// Events are published on <Subject>.<event type>, e.g.
+	// "404blocker.events.banned" (default "404blocker.events")
+	Subject string
+
+	// Event types published; empty publishes all of them
+	Types []EventType
+
+	Token     string // Token authentication
+	User      string // User and password authentication
+	Password  string
+	CredsFile string      // JWT and NKey credentials file
+	TLS       *tls.Config // Connect over TLS when set
+}
+
+// connect opens a connection that keeps reconnecting while the server is away,
+// buffering what's published in the meantime
+func (cfg NATSConfig) connect(name string) (*nats.Conn, error) {
+	url := cfg.URL
+	if url == "" {
+		url = nats.DefaultURL
+	}
+	opts := []nats.Option{
+		nats.Name(name),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+	}
+	switch {
+	case cfg.Token != "":
+		opts = append(opts, nats.Token(cfg.Token))
+	case cfg.User != "":
+		opts = append(opts, nats.UserInfo(cfg.User, cfg.Password))
+	case cfg.CredsFile != "":
+		opts = append(opts, nats.UserCredentials(cfg.CredsFile))
+	}
+	if cfg.TLS != nil {
+		opts = append(opts, nats.Secure(cfg.TLS))
+	}
+	return nats.Connect(url, opts...)
+}
+
+// WithNATS publishes events to NATS subjects as JSON, one subject per event type
+func WithNATS(cfg NATSConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.Subject == "" {
+			cfg.Subject = "404blocker.events"
+		}
+		t.nats = &cfg
+	}
+}
+
+// natsBuffer is how many events may queue up while they're published
+const natsBuffer = 4096
+
+// natsLoop publishes events until the tracker shuts down
+func (t *IP404Tracker) natsLoop(events <-chan Event) {
+	conn, err := t.nats.connect("404blocker events")
+	if err != nil {
+		t.logger.Error("connecting to nats failed", "url", t.nats.URL, "error", err)
+		return
+	}
+	defer conn.Close()
+
+	for event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			t.logger.Error("encoding event failed", "event", event.Type, "error", err)
+			continue
+		}
+		if err := conn.Publish(t.nats.Subject+"."+string(event.Type), data); err != nil {
+			t.logger.Error("publishing to nats failed", "event", event.Type, "error", err)
+		}
+	}
+	// Send what's still buffered before closing, unless the server is away
+	if !conn.IsConnected() {
+		t.logger.Warn("dropping unpublished nats events", "url", t.nats.URL)
+		return
+	}
+	if err := conn.FlushTimeout(5 * time.Second); err != nil {
+		t.logger.Warn("flushing nats events failed", "error", err)
+	}
+}
+
+// NATSPropagator is a Propagator using NATS core pub/sub
+type NATSPropagator struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// NewNATSPropagator creates a propagator publishing on the given subject
+// (default "404blocker.bans")
+func NewNATSPropagator(cfg NATSConfig, subject string) (*NATSPropagator, error) {
+	if subject == "" {
+		subject = "404blocker.bans"
+	}
+	conn, err := cfg.connect("404blocker bans")
+	if err != nil {
+		return nil, err
+	}
+	return &NATSPropagator{conn: conn, subject: subject}, nil
+}
+
+// Close closes the connection and subscription
+func (p *NATSPropagator) Close() error {
+	p.conn.Close()
+	return nil
+}
+
+// Publish implements Propagator
+func (p *NATSPropagator) Publish(event BanEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return p.conn.Publish(p.subject, payload)
+}
+
+// natsBanBuffer is how many ban changes may queue up before the NATS
+// client drops them as a slow consumer
+const natsBanBuffer = 4096
+
+// Subscribe implements Propagator. Messages are delivered from a goroutine
+// of the NATS client, skipping malformed ones; the subscription survives
+// reconnects. A tracker using the propagator reads the subscription itself
+// instead.
+func (p *NATSPropagator) Subscribe(handler func(BanEvent)) error {
+	handle := decodeBanEvents(handler)
+	_, err := p.conn.Subscribe(p.subject, func(msg *nats.Msg) { handle(msg.Data) })
+	return err
+}
+
+// listen implements banReceiver
+func (p *NATSPropagator) listen() (func(<-chan struct{}, func([]byte)), error) {
+	messages := make(chan *nats.Msg, natsBanBuffer)
+	sub, err := p.conn.ChanSubscribe(p.subject, messages)
+	if err != nil {
+		return nil, err
+	}
+	return func(stop <-chan struct{}, handle func([]byte)) {
+		for {
+			select {
+			case msg := <-messages:
+				handle(msg.Data)
+			case <-stop:
+				sub.Unsubscribe()
+				return
+			}
+		}
+	}, nil
+}
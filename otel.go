@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// otelScope is the instrumentation scope name used for spans and metrics
+const otelScope = "404BlockerDemo"
+
+// WithOpenTelemetry records a span whenever the middleware blocks a request
+// and reports the tracker's metrics through the given providers. Either
+// provider may be nil to enable only tracing or only metrics.
+func WithOpenTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) Option {
+	return func(t *IP404Tracker) {
+		if tp != nil {
+			t.tracer = tp.Tracer(otelScope)
+		}
+		t.meterProvider = mp
+	}
+}
+
+// traceBlocked marks the request span as blocked and records a child span for the block
+func (t *IP404Tracker) traceBlocked(ctx context.Context, ip, reason string) {
+	if t.tracer == nil {
+		return
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("client.address", ip),
+		attribute.String("404blocker.ban_type", reason),
+	}
+	if t.dryRun {
+		attrs = append(attrs, attribute.Bool("404blocker.dry_run", true))
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("404blocker.blocked", !t.dryRun))
+
+	_, span := t.tracer.Start(ctx, "404blocker.block", trace.WithAttributes(attrs...))
+	span.End()
+}
+
+// registerOTelMetrics exposes the tracker totals and gauges as observable instruments
+func (t *IP404Tracker) registerOTelMetrics(meter metric.Meter) error {
+	recorded404s, err := meter.Int64ObservableCounter("blocker.404s.recorded",
+		metric.WithDescription("Total 404 responses tracked."))
+	if err != nil {
+		return err
+	}
+	bansIssued, err := meter.Int64ObservableCounter("blocker.bans.issued",
+		metric.WithDescription("Total bans issued, automatic and manual."))
+	if err != nil {
+		return err
+	}
+	blockedRequests, err := meter.Int64ObservableCounter("blocker.requests.banned",
+		metric.WithDescription("Total requests refused because the client was banned."))
+	if err != nil {
+		return err
+	}
+	whitelistedHits, err := meter.Int64ObservableCounter("blocker.requests.whitelisted",
+		metric.WithDescription("Total requests from whitelisted clients."))
+	if err != nil {
+		return err
+	}
+	rateLimited, err := meter.Int64ObservableCounter("blocker.requests.rate_limited",
+		metric.WithDescription("Total requests answered 429 by the rate limiter or warning threshold."))
+	if err != nil {
+		return err
+	}
+	evictions, err := meter.Int64ObservableCounter("blocker.clients.evicted",
+		metric.WithDescription("Total clients forgotten to stay under the tracked client limit."))
+	if err != nil {
+		return err
+	}
+	asyncDropped, err := meter.Int64ObservableCounter("blocker.async.dropped",
+		metric.WithDescription("Total responses not recorded because the asynchronous recording queue was full."))
+	if err != nil {
+		return err
+	}
+	bannedIPs, err := meter.Int64ObservableGauge("blocker.banned_ips",
+		metric.WithDescription("IPs and CIDRs currently banned."))
+	if err != nil {
+		return err
+	}
+	trackedIPs, err := meter.Int64ObservableGauge("blocker.tracked_ips",
+		metric.WithDescription("IPs with 404s inside the current window."))
+	if err != nil {
+		return err
+	}
+
+	statusesRecorded, err := meter.Int64ObservableCounter("blocker.statuses.recorded",
+		metric.WithDescription("Total responses tracked by status rules."))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		o.ObserveInt64(recorded404s, int64(t.counters.recorded404s.Load()))
+		o.ObserveInt64(bansIssued, int64(t.counters.bansIssued.Load()))
+		o.ObserveInt64(blockedRequests, int64(t.counters.blockedRequests.Load()))
+		o.ObserveInt64(whitelistedHits, int64(t.counters.whitelistedHits.Load()))
+		o.ObserveInt64(rateLimited, int64(t.counters.rateLimited.Load()))
+		o.ObserveInt64(evictions, int64(t.counters.evictions.Load()))
+		o.ObserveInt64(asyncDropped, int64(t.counters.asyncDropped.Load()))
+		o.ObserveInt64(bannedIPs, int64(t.bannedCount()))
+		o.ObserveInt64(trackedIPs, int64(t.trackedIPs()))
+		for _, counter := range t.statusRules {
+			o.ObserveInt64(statusesRecorded, int64(counter.recorded.Load()),
+				metric.WithAttributes(attribute.String("404blocker.rule", counter.rule.Name)))
+		}
+		return nil
+	}, recorded404s, bansIssued, blockedRequests, whitelistedHits, rateLimited, evictions, asyncDropped, bannedIPs, trackedIPs, statusesRecorded)
+
+	return err
+}
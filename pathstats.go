@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// pathStatsResolution is the size of the time buckets paths are counted in
+const pathStatsResolution = time.Minute
+
+// PathStatsConfig sets how long and how many 404 paths are counted for
+// TopPaths
+type PathStatsConfig struct {
+	Retention time.Duration // Longest window TopPaths can cover (default 24h)
+	MaxPaths  int           // Distinct paths counted per minute (default 10000)
+}
+
+// PathCount is how many 404s a path got
+type PathCount struct {
+	Path  string `json:"path"`
+	Count int    `json:"count"`
+}
+
+// pathStats counts 404s per path in minute buckets
+type pathStats struct {
+	cfg PathStatsConfig
+
+	mu      sync.Mutex
+	buckets []pathBucket // Ring indexed by minute
+}
+
+// pathBucket holds the counts of one minute
+type pathBucket struct {
+	start  time.Time
+	counts map[string]int
+}
+
+// WithPathStats counts the 404s of every path across all clients, crawlers
+// and whitelisted ones included, for TopPaths
+func WithPathStats(cfg PathStatsConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.Retention <= 0 {
+			cfg.Retention = 24 * time.Hour
+		}
+		if cfg.MaxPaths <= 0 {
+			cfg.MaxPaths = 10000
+		}
+		n := int((cfg.Retention + pathStatsResolution - 1) / pathStatsResolution)
+		t.pathStats = &pathStats{cfg: cfg, buckets: make([]pathBucket, n)}
+	}
+}
+
+// record counts a 404 for path at now
+func (s *pathStats) record(path string, now time.Time) {
+	if s == nil || path == "" {
+		return
+	}
+	start := now.Truncate(pathStatsResolution)
+	i := int(start.Unix()/int64(pathStatsResolution/time.Second)) % len(s.buckets)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &s.buckets[i]
+	if !b.start.Equal(start) {
+		*b = pathBucket{start: start, counts: make(map[string]int)}
+	}
+	if _, ok := b.counts[path]; ok || len(b.counts) < s.cfg.MaxPaths {
+		b.counts[path]++
+	}
+}
+
+// top returns the n paths with the most 404s within window of now
+func (s *pathStats) top(window time.Duration, n int, now time.Time) []PathCount {
+	since := now.Add(-window)
+
+	s.mu.Lock()
+	totals := make(map[string]int)
+	for _, b := range s.buckets {
+		if b.counts == nil || !b.start.Add(pathStatsResolution).After(since) || b.start.After(now) {
+			continue
+		}
+		for path, count := range b.counts {
+			totals[path] += count
+		}
+	}
+	s.mu.Unlock()
+
+	result := make([]PathCount, 0, len(totals))
+	for path, count := range totals {
+		result = append(result, PathCount{Path: path, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Path < result[j].Path
+	})
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// TopPaths returns the n paths with the most 404s within window, to the
+// minute, whoever asked for them: scanners' favorite targets and broken
+// links alike. It needs WithPathStats and covers at most its Retention.
+func (t *IP404Tracker) TopPaths(window time.Duration, n int) []PathCount {
+	if t.pathStats == nil {
+		return nil
+	}
+	return t.pathStats.top(min(window, t.pathStats.cfg.Retention), n, t.clock.Now())
+}
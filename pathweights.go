@@ -0,0 +1,85 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// PathWeightBan as a PathWeight's Weight bans on the first 404 for the
+// path, whatever the threshold
+const PathWeightBan = -1
+
+// PathWeight makes 404s for paths matching Pattern count Weight times, so
+// probes for /.env or /.git/config weigh more than a mistyped blog URL
+type PathWeight struct {
+	// A path.Match glob; patterns containing a '/' are matched against the
+	// whole path (e.g. "/wp-admin/*", or "/wp-admin/**" for everything
+	// below it), others against its last element (e.g. "*.php" or ".env")
+	Pattern string
+
+	// How many 404s a hit counts as, or PathWeightBan. A weight above the
+	// threshold bans on a single hit.
+	Weight int
+}
+
+// WithPathWeights weighs 404s by path; the first matching pattern wins and
+// other paths count once
+func WithPathWeights(weights ...PathWeight) Option {
+	return func(t *IP404Tracker) {
+		for _, w := range weights {
+			if w.Weight == 0 || w.Weight < PathWeightBan {
+				t.logger.Warn("ignoring path weight below 1", "pattern", w.Pattern, "weight", w.Weight)
+				continue
+			}
+			t.pathWeights = append(t.pathWeights, w)
+		}
+	}
+}
+
+// weightFor returns how many 404s a 404 for urlPath counts as against
+// threshold
+func (t *IP404Tracker) weightFor(urlPath string, threshold int) int {
+	if urlPath == "" {
+		// Record404 calls know no path
+		return 1
+	}
+	weight, matched := 1, false
+	for _, w := range t.pathWeights {
+		if matchPath(w.Pattern, urlPath) {
+			weight, matched = w.Weight, true
+			break
+		}
+	}
+	if !matched {
+		if w, ok := t.scannerSignatureWeight(urlPath); ok {
+			weight = w
+		}
+	}
+	if weight == PathWeightBan {
+		return threshold + 1
+	}
+	return weight
+}
+
+// matchPath reports whether urlPath matches a path.Match pattern, against
+// the whole path when the pattern has a '/' and its last element otherwise.
+// A pattern ending in "/**" matches everything below the directory too.
+func matchPath(pattern, urlPath string) bool {
+	if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+		for p := urlPath; ; p = path.Dir(p) {
+			if ok, _ := path.Match(dir, p); ok {
+				return true
+			}
+			if p == "/" || p == "." {
+				return false
+			}
+		}
+	}
+
+	name := urlPath
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(urlPath)
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
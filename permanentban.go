@@ -0,0 +1,54 @@
+package main
+
+import "time"
+
+// foreverBan is how far ahead permanent bans expire; stores need an expiry
+const foreverBan = 100 * 365 * 24 * time.Hour
+
+// PermanentBanConfig turns the ban of a persistent offender into a
+// permanent (or very long) one
+type PermanentBanConfig struct {
+	// Number of automatic bans within Lookback after which the ban is permanent
+	Offenses int
+
+	// How far back bans are counted (default 30 days)
+	Lookback time.Duration
+
+	// Length of the final ban, 0 for forever
+	Duration time.Duration
+}
+
+// WithPermanentBan makes the Offenses'th automatic ban of an IP within the
+// lookback period permanent. Past bans are kept in the BanStore, so the
+// count survives restarts with a persistent store. When combined with
+// WithBanEscalation, bans are remembered for the longer of Lookback and the
+// escalation Memory.
+func WithPermanentBan(cfg PermanentBanConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.Lookback <= 0 {
+			cfg.Lookback = defaultEscalationMemory
+		}
+		if cfg.Duration <= 0 {
+			cfg.Duration = foreverBan
+		}
+		t.permanentBan = &cfg
+	}
+}
+
+// offenseMemory returns how long past bans must be remembered, or 0 when
+// nothing looks at them
+func (t *IP404Tracker) offenseMemory() time.Duration {
+	var memory time.Duration
+	if t.escalation != nil {
+		memory = t.escalation.Memory
+	}
+	if t.permanentBan != nil {
+		memory = max(memory, t.permanentBan.Lookback)
+	}
+	return t.anonymizer.retain(memory)
+}
+
+// permanentFor reports whether an IP's offense'th ban is its last
+func (t *IP404Tracker) permanentFor(offense int) bool {
+	return t.permanentBan != nil && t.permanentBan.Offenses > 0 && offense >= t.permanentBan.Offenses
+}
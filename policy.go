@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RoutePolicy overrides how 404s are counted for the routes of a Gin
+// group, e.g. a strict threshold for /api and a lenient one for /static
+type RoutePolicy struct {
+	// Names the policy in ban reasons; policies sharing a name share their
+	// counts (default "policy<n>")
+	Name string
+
+	Threshold int           // 404 weight allowed within Window (default: the tracker's)
+	Window    time.Duration // Default: the tracker's window
+
+	// Default: the tracker's ban duration, or what escalation says
+	BanDuration time.Duration
+
+	// Don't count the routes' 404s at all
+	Exempt bool
+}
+
+// routePolicy is a registered RoutePolicy and its 404 counts
+type routePolicy struct {
+	cfg     RoutePolicy
+	counter *statusCounter
+}
+
+// Gin context keys linking Middleware and MiddlewareWithPolicy
+const (
+	trackedKey     = "404blocker.tracked"
+	routePolicyKey = "404blocker.policy"
+)
+
+// MiddlewareWithPolicy returns a Middleware variant that counts the 404s of
+// the routes it's attached to under policy instead of the tracker's
+// threshold. Bans still apply everywhere, and status rules keep counting.
+// Attach it to a group in place of Middleware, or on top of a Middleware on
+// the engine, which then hands the group's 404s to the policy:
+//
+//	api := router.Group("/api", tracker.MiddlewareWithPolicy(RoutePolicy{Name: "api", Threshold: 2}))
+//
+// 404s for paths no route matches only go through the engine's middleware,
+// so they follow the tracker's threshold. Policy counts are kept in memory
+// on each instance.
+func (t *IP404Tracker) MiddlewareWithPolicy(policy RoutePolicy) gin.HandlerFunc {
+	p := t.routePolicy(policy)
+	middleware := t.ginMiddleware(p)
+	return func(c *gin.Context) {
+		if _, ok := c.Get(trackedKey); ok {
+			c.Set(routePolicyKey, p)
+			return
+		}
+		middleware(c)
+	}
+}
+
+// routePolicy registers policy, or returns the one registered under its name
+func (t *IP404Tracker) routePolicy(policy RoutePolicy) *routePolicy {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if policy.Name == "" {
+		policy.Name = fmt.Sprintf("policy%d", len(t.policies)+1)
+	}
+	if p, ok := t.policies[policy.Name]; ok {
+		return p
+	}
+	if t.statusRule(policy.Name) != nil {
+		t.logger.Warn("route policy has the name of a status rule", "policy", policy.Name)
+	}
+	if policy.Window <= 0 {
+		policy.Window = t.limits.Load().window
+	}
+	rule := StatusRule{Status: 404, Name: policy.Name, Window: policy.Window, BanDuration: policy.BanDuration}
+	p := &routePolicy{cfg: policy, counter: newStatusCounter(rule)}
+	if t.policies == nil {
+		t.policies = make(map[string]*routePolicy)
+	}
+	t.policies[policy.Name] = p
+	return p
+}
+
+// recordPolicy404 counts a 404 under the request's route policy, weight
+// times or by its path when weight is 0, and bans the client once it
+// crosses the policy's threshold
+func (t *IP404Tracker) recordPolicy404(req clientRequest, weight int) {
+	p := req.policy
+	if t.IsWhitelisted(req.key) || t.IsBanned(req.key) {
+		return
+	}
+
+	now := t.clock.Now()
+	key := t.trackingKey(req.key)
+	t.track(key)
+	threshold := p.cfg.Threshold
+	if threshold <= 0 {
+		threshold = t.thresholdFor(key, now)
+	}
+	if weight == 0 {
+		weight = t.weightFor(req.path, threshold)
+	}
+	count := p.counter.record(key, now, weight)
+	t.counters.recorded404s.Add(1)
+	t.recordActivity(req.key, req.path, now)
+	t.recordPath(key, req, now)
+	t.emit(Event{Type: Event404Recorded, IP: key, Path: req.path, Count: count, Weight: weight, Threshold: threshold, Rule: p.cfg.Name})
+
+	t.judge404(key, req.path, count, threshold, p.cfg.Name, now)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// prefixTrie maps IP prefixes to values and finds the longest prefix
+// containing an address in O(address bits). IPv4 and IPv6 live in separate
+// trees; IPv4-mapped IPv6 addresses are looked up as IPv4.
+type prefixTrie[V any] struct {
+	root4 *trieNode[V]
+	root6 *trieNode[V]
+	size  int
+}
+
+type trieNode[V any] struct {
+	children [2]*trieNode[V]
+	prefix   netip.Prefix
+	value    V
+	set      bool
+}
+
+// parseIPOrCIDR parses "1.2.3.4", "2001:db8::1" or "10.0.0.0/8" into a
+// masked prefix; single addresses become /32 or /128 prefixes
+func parseIPOrCIDR(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", s)
+		}
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP %q", s)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// prefixString formats prefix, leaving off the length for single addresses
+func prefixString(prefix netip.Prefix) string {
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String()
+	}
+	return prefix.String()
+}
+
+// addrBit returns bit i (0 = most significant) of addr
+func addrBit(addr netip.Addr, i int) int {
+	bytes := addr.AsSlice()
+	return int(bytes[i/8]>>(7-i%8)) & 1
+}
+
+func (t *prefixTrie[V]) root(addr netip.Addr, create bool) **trieNode[V] {
+	root := &t.root6
+	if addr.Is4() {
+		root = &t.root4
+	}
+	if *root == nil && create {
+		*root = &trieNode[V]{}
+	}
+	return root
+}
+
+// insert sets the value for prefix, replacing any previous value
+func (t *prefixTrie[V]) insert(prefix netip.Prefix, value V) {
+	prefix = prefix.Masked()
+	node := *t.root(prefix.Addr(), true)
+	for i := 0; i < prefix.Bits(); i++ {
+		bit := addrBit(prefix.Addr(), i)
+		if node.children[bit] == nil {
+			node.children[bit] = &trieNode[V]{}
+		}
+		node = node.children[bit]
+	}
+
+	if !node.set {
+		t.size++
+	}
+	node.prefix = prefix
+	node.value = value
+	node.set = true
+}
+
+// remove deletes prefix and reports whether it was present. Emptied branches
+// are left in place; they are cheap and get reused by later inserts.
+func (t *prefixTrie[V]) remove(prefix netip.Prefix) bool {
+	prefix = prefix.Masked()
+	node := *t.root(prefix.Addr(), false)
+	for i := 0; node != nil && i < prefix.Bits(); i++ {
+		node = node.children[addrBit(prefix.Addr(), i)]
+	}
+	if node == nil || !node.set {
+		return false
+	}
+
+	var zero V
+	node.value = zero
+	node.set = false
+	t.size--
+	return true
+}
+
+// get returns the value stored for exactly prefix
+func (t *prefixTrie[V]) get(prefix netip.Prefix) (V, bool) {
+	prefix = prefix.Masked()
+	node := *t.root(prefix.Addr(), false)
+	for i := 0; node != nil && i < prefix.Bits(); i++ {
+		node = node.children[addrBit(prefix.Addr(), i)]
+	}
+
+	var zero V
+	if node == nil || !node.set {
+		return zero, false
+	}
+	return node.value, true
+}
+
+// lookup returns the value of the longest prefix containing addr
+func (t *prefixTrie[V]) lookup(addr netip.Addr) (V, bool) {
+	var (
+		best  V
+		found bool
+	)
+	t.lookupAll(addr, func(_ netip.Prefix, value V) bool {
+		best, found = value, true
+		return true
+	})
+	return best, found
+}
+
+// lookupAll calls fn for every prefix containing addr, shortest first,
+// until fn returns false
+func (t *prefixTrie[V]) lookupAll(addr netip.Addr, fn func(netip.Prefix, V) bool) {
+	addr = addr.Unmap()
+	node := *t.root(addr, false)
+	for i := 0; node != nil; i++ {
+		if node.set && !fn(node.prefix, node.value) {
+			return
+		}
+		if i == addr.BitLen() {
+			return
+		}
+		node = node.children[addrBit(addr, i)]
+	}
+}
+
+// walk calls fn for every stored prefix
+func (t *prefixTrie[V]) walk(fn func(netip.Prefix, V)) {
+	var visit func(*trieNode[V])
+	visit = func(node *trieNode[V]) {
+		if node == nil {
+			return
+		}
+		if node.set {
+			fn(node.prefix, node.value)
+		}
+		visit(node.children[0])
+		visit(node.children[1])
+	}
+	visit(t.root4)
+	visit(t.root6)
+}
+
+// len returns the number of stored prefixes
+func (t *prefixTrie[V]) len() int {
+	return t.size
+}
+
+// clone returns a copy of the trie, sharing nothing with it
+func (t *prefixTrie[V]) clone() *prefixTrie[V] {
+	c := &prefixTrie[V]{}
+	t.walk(func(prefix netip.Prefix, value V) {
+		c.insert(prefix, value)
+	})
+	return c
+}
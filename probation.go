@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/netip"
+	"time"
+)
+
+// defaultProbationPeriod is how long probation lasts when not configured
+const defaultProbationPeriod = 24 * time.Hour
+
+// ProbationConfig lowers the threshold of an IP whose ban just expired, so
+// a scanner coming back doesn't get a fresh allowance straight away
+type ProbationConfig struct {
+	// Time after the ban expires for the threshold to recover (default 24h)
+	Period time.Duration
+
+	// 404s tolerated right after the ban expires; the next one bans the IP
+	// again. The allowance grows linearly back to the normal threshold over
+	// Period. The default of 0 re-bans on the first 404.
+	Allowance int
+}
+
+// WithProbation puts IPs on probation when their ban expires
+func WithProbation(cfg ProbationConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.Period <= 0 {
+			cfg.Period = defaultProbationPeriod
+		}
+		t.probation = &cfg
+	}
+}
+
+// startProbation schedules probation for ip once its ban ends
+func (t *IP404Tracker) startProbation(ip string, banEnds time.Time) {
+	if t.probation == nil {
+		return
+	}
+	// Rolling bans come back here often; skip the write lock when nothing moves
+	t.mu.RLock()
+	from, ok := t.probationFrom[ip]
+	t.mu.RUnlock()
+	if ok && from.Equal(banEnds) {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.probationFrom[ip] = banEnds
+}
+
+// endProbation forgets any probation of ip
+func (t *IP404Tracker) endProbation(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.probationFrom, ip)
+}
+
+// thresholdFor returns the 404 threshold that applies to ip at now
+func (t *IP404Tracker) thresholdFor(ip string, now time.Time) int {
+	return t.breaker.tighten(t.campaigns.tighten(t.probationThreshold(ip, now), now), now)
+}
+
+// probationThreshold returns the threshold of ip, lowered while it's on
+// probation
+func (t *IP404Tracker) probationThreshold(ip string, now time.Time) int {
+	threshold := t.baseThreshold(ip)
+	if t.probation == nil {
+		return threshold
+	}
+
+	t.mu.RLock()
+	from, ok := t.probationFrom[ip]
+	t.mu.RUnlock()
+
+	elapsed := now.Sub(from)
+	if !ok || elapsed < 0 || elapsed >= t.probation.Period || t.probation.Allowance >= threshold {
+		return threshold
+	}
+
+	recovered := float64(threshold-t.probation.Allowance) * float64(elapsed) / float64(t.probation.Period)
+	return t.probation.Allowance + int(recovered)
+}
+
+// cleanupProbation forgets probations that have run their course
+func (t *IP404Tracker) cleanupProbation(now time.Time) {
+	if t.probation == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for ip, from := range t.probationFrom {
+		if now.Sub(from) >= t.probation.Period {
+			delete(t.probationFrom, ip)
+		}
+	}
+}
+
+// endProbationIn forgets the probation of every IP inside prefix
+func (t *IP404Tracker) endProbationIn(prefix netip.Prefix) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for ip := range t.probationFrom {
+		if keyInPrefix(ip, prefix) {
+			delete(t.probationFrom, ip)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Ban change actions carried by a BanEvent
+const (
+	BanActionBan    = "ban"
+	BanActionExtend = "extend" // A rolling ban renewed by a blocked request
+	BanActionUnban  = "unban"
+
+	// Range bans made with BanCIDR, where IP holds the CIDR
+	BanActionBanCIDR   = "ban_cidr"
+	BanActionUnbanCIDR = "unban_cidr"
+)
+
+// BanEvent describes a ban change broadcast between tracker instances
+type BanEvent struct {
+	Origin string    `json:"origin"` // Instance that made the change
+	Action string    `json:"action"` // One of the BanAction constants
+	IP     string    `json:"ip"`
+	Until  time.Time `json:"until,omitzero"`
+
+	// Details of the ban; instances that predate ban records only send Until
+	Record *BanRecord `json:"record,omitempty"`
+}
+
+// Propagator broadcasts ban changes to every tracker instance in a cluster
+type Propagator interface {
+	// Publish sends event to the other instances
+	Publish(event BanEvent) error
+
+	// Subscribe delivers events published by any instance to handler
+	Subscribe(handler func(BanEvent)) error
+}
+
+// banReceiver is implemented by the built-in propagators, whose messages
+// the tracker reads in a background loop of its own so that Shutdown waits
+// for it and Loops lists it
+type banReceiver interface {
+	// listen subscribes, returning a function that passes the payloads
+	// received to handle until stop is closed
+	listen() (receive func(stop <-chan struct{}, handle func(payload []byte)), err error)
+}
+
+// WithPropagator broadcasts local bans and unbans through p and applies the
+// changes made by other instances
+func WithPropagator(p Propagator) Option {
+	return func(t *IP404Tracker) {
+		t.propagator = p
+	}
+}
+
+// newInstanceID returns a random identifier used to ignore our own broadcasts
+func newInstanceID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// subscribeBans starts applying the ban changes made by other instances
+func (t *IP404Tracker) subscribeBans() {
+	r, ok := t.propagator.(banReceiver)
+	if !ok {
+		if err := t.propagator.Subscribe(t.applyBanEvent); err != nil {
+			t.logger.Error("subscribing to ban events failed", "error", err)
+		}
+		return
+	}
+
+	receive, err := r.listen()
+	if err != nil {
+		t.logger.Error("subscribing to ban events failed", "error", err)
+		return
+	}
+	t.background("propagation", func() { receive(t.done, t.applyBanPayload) })
+}
+
+// applyBanPayload decodes and applies a ban change received as JSON
+func (t *IP404Tracker) applyBanPayload(payload []byte) {
+	var event BanEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		t.logger.Warn("ignoring malformed ban event", "error", err)
+		return
+	}
+	t.applyBanEvent(event)
+}
+
+// decodeBanEvents returns a handler passing the payloads that decode to
+// handler, skipping malformed ones
+func decodeBanEvents(handler func(BanEvent)) func(payload []byte) {
+	return func(payload []byte) {
+		var event BanEvent
+		if json.Unmarshal(payload, &event) == nil {
+			handler(event)
+		}
+	}
+}
+
+// publish broadcasts a ban change made by this instance
+func (t *IP404Tracker) publish(action, ip string, record *BanRecord) {
+	if t.propagator == nil {
+		return
+	}
+
+	event := BanEvent{Origin: t.instanceID, Action: action, IP: ip, Record: record}
+	if record != nil {
+		event.Until = record.ExpiresAt
+	}
+	if err := t.propagator.Publish(event); err != nil {
+		t.logger.Error("publishing ban change failed", "action", action, "ip", ip, "error", err)
+	}
+}
+
+// applyBanEvent applies a ban change received from another instance.
+// Conflicting bans resolve to whichever expires last. Extensions of a
+// rolling ban arrive with every blocked request, so they only emit
+// EventBanExtended rather than announcing a new ban.
+func (t *IP404Tracker) applyBanEvent(event BanEvent) {
+	if event.Origin == t.instanceID {
+		return
+	}
+
+	switch event.Action {
+	case BanActionBan:
+		if t.applyBan(event) {
+			t.emit(Event{Type: EventBanned, IP: event.IP, Reason: BanReasonPropagated, ExpiresAt: event.Until})
+		}
+
+	case BanActionExtend:
+		if t.applyBan(event) {
+			t.emit(Event{Type: EventBanExtended, IP: event.IP, ExpiresAt: event.Until})
+		}
+
+	case BanActionUnban:
+		if err := t.store.Unban(event.IP); err != nil {
+			t.logger.Error("applying unban failed", "ip", event.IP, "error", err)
+			return
+		}
+		t.endProbation(event.IP)
+		t.emit(Event{Type: EventUnbanned, IP: event.IP, Reason: BanReasonPropagated})
+
+	case BanActionBanCIDR:
+		if t.applyCIDRBan(event) {
+			t.emit(Event{Type: EventBanned, IP: event.IP, Reason: BanReasonPropagated, ExpiresAt: event.Until})
+		}
+
+	case BanActionUnbanCIDR:
+		if t.applyCIDRUnban(event) {
+			t.emit(Event{Type: EventUnbanned, IP: event.IP, Reason: BanReasonPropagated})
+		}
+	}
+}
+
+// applyBan stores the ban carried by event unless ours already lasts
+// longer, reporting whether it did
+func (t *IP404Tracker) applyBan(event BanEvent) bool {
+	now := t.clock.Now()
+	if !event.Until.After(now) || t.IsWhitelisted(event.IP) {
+		return false
+	}
+	current, _, err := t.store.GetBan(event.IP, now)
+	if err != nil {
+		t.logger.Error("checking ban failed", "ip", event.IP, "error", err)
+		return false
+	}
+	if !event.Until.After(current.ExpiresAt) {
+		return false // Our ban already lasts longer
+	}
+	record := BanRecord{ExpiresAt: event.Until}
+	if event.Record != nil {
+		record = *event.Record
+	}
+	if err := t.store.Ban(event.IP, record); err != nil {
+		t.logger.Error("applying ban failed", "ip", event.IP, "error", err)
+		return false
+	}
+	t.startProbation(event.IP, record.ExpiresAt)
+	return true
+}
+
+// RedisPropagator is a Propagator using Redis pub/sub
+type RedisPropagator struct {
+	client  *redis.Client
+	channel string
+}
+
+// NewRedisPropagator creates a propagator publishing on the given Redis channel
+// (default "<KeyPrefix>bans")
+func NewRedisPropagator(cfg RedisStoreConfig, channel string) *RedisPropagator {
+	if channel == "" {
+		channel = cfg.prefix() + "bans"
+	}
+
+	return &RedisPropagator{
+		client:  newRedisClient(cfg),
+		channel: channel,
+	}
+}
+
+// Close closes the underlying connection pool and subscription
+func (p *RedisPropagator) Close() error {
+	return p.client.Close()
+}
+
+// Publish implements Propagator
+func (p *RedisPropagator) Publish(event BanEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return p.client.Publish(context.Background(), p.channel, payload).Err()
+}
+
+// Subscribe implements Propagator. Messages are delivered from a background
+// goroutine until the propagator is closed, skipping malformed ones; the
+// subscription reconnects on its own if Redis goes away. A tracker using
+// the propagator reads the subscription itself instead.
+func (p *RedisPropagator) Subscribe(handler func(BanEvent)) error {
+	receive, err := p.listen()
+	if err != nil {
+		return err
+	}
+	go receive(nil, decodeBanEvents(handler))
+	return nil
+}
+
+// listen implements banReceiver
+func (p *RedisPropagator) listen() (func(<-chan struct{}, func([]byte)), error) {
+	ctx := context.Background()
+	sub := p.client.Subscribe(ctx, p.channel)
+
+	// Wait for the subscription to be confirmed so errors surface here
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, err
+	}
+
+	messages := sub.Channel()
+	return func(stop <-chan struct{}, handle func([]byte)) {
+		for {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					return // The propagator was closed
+				}
+				handle([]byte(msg.Payload))
+			case <-stop:
+				sub.Close()
+				return
+			}
+		}
+	}, nil
+}
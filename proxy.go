@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"net/url"
+)
+
+// ReverseProxy returns a handler forwarding every request to upstream with
+// the same 404 tracking and shadow banning as Handler, so the tracker can
+// sit in front of an application written in any language. The upstream
+// sees the client in X-Forwarded-For, X-Forwarded-Host and X-Forwarded-Proto;
+// the X-Forwarded-For hops of earlier proxies are kept when they came from
+// one of WithTrustedProxies.
+func (t *IP404Tracker) ReverseProxy(upstream *url.URL) http.Handler {
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(upstream)
+			// Keep the hops before us, as a proxy in the middle should, but
+			// only when a trusted proxy reported them: a client connecting
+			// directly could claim to be forwarding for anybody
+			if t.trustedPeer(r.In) {
+				r.Out.Header["X-Forwarded-For"] = r.In.Header["X-Forwarded-For"]
+			}
+			r.SetXForwarded()
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			t.logger.Error("proxying request failed", "upstream", upstream.String(), "path", r.URL.Path, "error", err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	return t.Handler(proxy)
+}
+
+// trustedPeer reports whether r came straight from a trusted proxy
+func (t *IP404Tracker) trustedPeer(r *http.Request) bool {
+	addr, err := netip.ParseAddr(remoteIP(r))
+	return err == nil && t.isTrustedProxy(addr.Unmap())
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitConfig limits how many requests each client makes, whatever
+// their status, with a token bucket per tracking key
+type RateLimitConfig struct {
+	Rate  float64 // Requests per second a client keeps up over time
+	Burst int     // Requests a client can make at once (default: Rate, at least 1)
+}
+
+// RateLimitStore is implemented by stores that keep token buckets
+// themselves, so instances sharing the store share the limits too. The
+// tracker keeps the buckets in memory for stores that don't.
+type RateLimitStore interface {
+	// TakeToken takes a token from the bucket of key, refilled at rate per
+	// second up to burst, reporting whether there was one and otherwise
+	// how long until there is
+	TakeToken(key string, now time.Time, rate float64, burst int) (bool, time.Duration, error)
+}
+
+// rateLimiter holds the in-memory token buckets
+type rateLimiter struct {
+	cfg RateLimitConfig
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket is the state of one client's bucket
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// WithRateLimit answers clients making requests faster than cfg allows with
+// 429 Too Many Requests. Whitelisted clients aren't limited, clients are
+// counted by the same key 404s are, and WithDryRun only logs them.
+func WithRateLimit(cfg RateLimitConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.Rate <= 0 {
+			t.logger.Warn("ignoring rate limit without a rate")
+			return
+		}
+		if cfg.Burst <= 0 {
+			cfg.Burst = max(1, int(cfg.Rate))
+		}
+		t.rateLimiter = &rateLimiter{cfg: cfg, buckets: make(map[string]*tokenBucket)}
+	}
+}
+
+// take takes a token from the bucket of key
+func (l *rateLimiter) take(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.cfg.Burst), updated: now}
+		l.buckets[key] = b
+	}
+	return b.take(now, l.cfg.Rate, l.cfg.Burst)
+}
+
+// take refills the bucket up to now and takes a token from it
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) (bool, time.Duration) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(float64(burst), b.tokens+elapsed.Seconds()*rate)
+		b.updated = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// cleanup forgets buckets that have filled back up
+func (l *rateLimiter) cleanup(now time.Time) {
+	if l == nil {
+		return
+	}
+	full := time.Duration(float64(l.cfg.Burst) / l.cfg.Rate * float64(time.Second))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// clear forgets the buckets of every key matching
+func (l *rateLimiter) clear(matching func(key string) bool) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key := range l.buckets {
+		if matching(key) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimited returns the response for req when its client is over the
+// rate limit or the warning threshold
+func (t *IP404Tracker) rateLimited(req clientRequest) (BanResponse, bool) {
+	if resp, warned := t.warned(req); warned {
+		return resp, true
+	}
+	l := t.rateLimiter
+	if l == nil || t.IsWhitelisted(req.ip) {
+		return BanResponse{}, false
+	}
+
+	key := t.trackingKey(req.key)
+	now := t.clock.Now()
+	var (
+		ok    bool
+		retry time.Duration
+	)
+	if store, shared := t.store.(RateLimitStore); shared {
+		var err error
+		ok, retry, err = store.TakeToken(key, now, l.cfg.Rate, l.cfg.Burst)
+		if err != nil {
+			// Fail open like the store does for bans
+			t.logger.Error("rate limiting failed", "ip", key, "error", err)
+			return BanResponse{}, false
+		}
+	} else {
+		ok, retry = l.take(key, now)
+	}
+	if ok {
+		return BanResponse{}, false
+	}
+	t.counters.rateLimited.Add(1)
+	if t.dryRun {
+		t.logger.Info("request would have been rate limited", "ip", key, "path", req.path)
+		return BanResponse{}, false
+	}
+	t.logger.Debug("rate limited request", "ip", key, "path", req.path, "retry_after", retry)
+	return tooManyRequests(retry), true
+}
+
+// tooManyRequests is the 429 response asking the client to come back after retry
+func tooManyRequests(retry time.Duration) BanResponse {
+	seconds := int(math.Ceil(retry.Seconds()))
+	return BanResponse{
+		Status:  http.StatusTooManyRequests,
+		Body:    http.StatusText(http.StatusTooManyRequests),
+		Headers: map[string]string{"Retry-After": strconv.Itoa(max(1, seconds))},
+	}
+}
+
+// redisTakeToken refills and takes from a bucket kept as a hash of its
+// tokens and last update in microseconds:
+// KEYS[1] bucket, ARGV[1] now, ARGV[2] rate per second, ARGV[3] burst.
+// Returns 1 and 0 when a token was taken, or 0 and the microseconds until
+// the next one.
+var redisTakeToken = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(bucket[1]) or burst
+local updated = tonumber(bucket[2]) or now
+if now > updated then
+  tokens = math.min(burst, tokens + (now - updated) / 1e6 * rate)
+  updated = now
+end
+local ok, wait = 0, 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  ok = 1
+else
+  wait = math.ceil((1 - tokens) / rate * 1e6)
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated", updated)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {ok, wait}
+`)
+
+// TakeToken implements RateLimitStore
+func (s *RedisStore) TakeToken(key string, now time.Time, rate float64, burst int) (bool, time.Duration, error) {
+	result, err := redisTakeToken.Run(context.Background(), s.client, []string{s.prefix + "rate:" + key},
+		now.UnixMicro(), rate, burst).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return result[0] == 1, time.Duration(result[1]) * time.Microsecond, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import "strconv"
+
+// WithRateLimitHeaders tells well-behaved clients how close they are to a
+// ban: every response carries RateLimit-Limit with the 404 threshold and
+// RateLimit-Remaining with the 404s the client has left in the window, and
+// ban responses add Retry-After with the time left on the ban. The headers
+// would give a shadow ban away, so they're only sent when banned clients
+// get a real answer from WithBanResponse, WithBanResponder or WithBanHandler.
+func WithRateLimitHeaders() Option {
+	return func(t *IP404Tracker) {
+		t.sendRateLimit = true
+	}
+}
+
+// rateLimitHeaders returns the headers for a request that isn't blocked
+func (t *IP404Tracker) rateLimitHeaders(req clientRequest) map[string]string {
+	if !t.sendRateLimit || t.IsWhitelisted(req.ip) {
+		return nil
+	}
+
+	key := t.trackingKey(req.key)
+	now := t.clock.Now()
+	count, err := t.store.Count404s(key, now, t.limits.Load().window)
+	if err != nil {
+		t.logger.Error("counting 404s failed", "ip", key, "error", err)
+		return nil
+	}
+	limit := t.thresholdFor(key, now)
+	return map[string]string{
+		"RateLimit-Limit":     strconv.Itoa(limit),
+		"RateLimit-Remaining": strconv.Itoa(max(0, limit-count)),
+	}
+}
+
+// blockedRateLimitHeaders returns the headers for a blocked request
+func (t *IP404Tracker) blockedRateLimitHeaders(blocked BlockedRequest) map[string]string {
+	if !t.sendRateLimit {
+		return nil
+	}
+	return map[string]string{
+		"RateLimit-Limit":     strconv.Itoa(t.baseThreshold(t.trackingKey(blocked.Key))),
+		"RateLimit-Remaining": "0",
+		"Retry-After":         strconv.Itoa(t.retryAfter(blocked)),
+	}
+}
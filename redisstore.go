@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStoreConfig configures a RedisStore
+type RedisStoreConfig struct {
+	// Connection settings
+	Addr     string
+	Password string
+	DB       int
+
+	// Prefix for every key written by the store (default "404blocker:")
+	KeyPrefix string
+
+	// Connection pooling
+	PoolSize     int           // Maximum number of socket connections
+	MinIdleConns int           // Idle connections kept open
+	DialTimeout  time.Duration // Timeout for establishing new connections
+	ReadTimeout  time.Duration // Timeout for socket reads
+	WriteTimeout time.Duration // Timeout for socket writes
+
+	// FailClosed treats every IP as banned while Redis is unreachable.
+	// By default the store fails open and lets requests through.
+	FailClosed bool
+}
+
+// RedisStore is a BanStore shared by every instance pointing at the same Redis.
+// Bans are JSON ban records in keys expiring with their TTL and 404s are kept as a sorted
+// set of timestamps per IP, trimmed to the window on every write.
+type RedisStore struct {
+	client     *redis.Client
+	prefix     string
+	failClosed bool
+	clock      Clock // Turns ban expiries into TTLs
+
+	// Sequence used to keep sorted set members unique
+	seq atomic.Uint64
+}
+
+// NewRedisStore creates a store backed by the Redis server described in cfg
+func NewRedisStore(cfg RedisStoreConfig) *RedisStore {
+	return &RedisStore{
+		client:     newRedisClient(cfg),
+		prefix:     cfg.prefix(),
+		failClosed: cfg.FailClosed,
+		clock:      systemClock{},
+	}
+}
+
+// newRedisClient creates a pooled client from the connection settings in cfg
+func newRedisClient(cfg RedisStoreConfig) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:         cfg.Addr,
+		Password:     cfg.Password,
+		DB:           cfg.DB,
+		PoolSize:     cfg.PoolSize,
+		MinIdleConns: cfg.MinIdleConns,
+		DialTimeout:  cfg.DialTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+	})
+}
+
+func (cfg RedisStoreConfig) prefix() string {
+	if cfg.KeyPrefix == "" {
+		return "404blocker:"
+	}
+	return cfg.KeyPrefix
+}
+
+// Close closes the underlying connection pool
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
+
+func (s *RedisStore) banKey(ip string) string {
+	return s.prefix + "ban:" + ip
+}
+
+func (s *RedisStore) countKey(ip string) string {
+	return s.prefix + "count:" + ip
+}
+
+func (s *RedisStore) offenseKey(ip string) string {
+	return s.prefix + "offense:" + ip
+}
+
+// Record404 implements BanStore
+func (s *RedisStore) Record404(ip string, at time.Time, window time.Duration, weight int) (int, error) {
+	ctx := context.Background()
+	key := s.countKey(ip)
+	members := make([]redis.Z, weight)
+	for i := range members {
+		members[i] = redis.Z{Score: float64(at.UnixNano()), Member: fmt.Sprintf("%d-%d", at.UnixNano(), s.seq.Add(1))}
+	}
+
+	var card *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		// Drop timestamps outside the window, add the new ones and count
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(at.Add(-window).UnixNano(), 10))
+		pipe.ZAdd(ctx, key, members...)
+		card = pipe.ZCard(ctx, key)
+		pipe.PExpire(ctx, key, window)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(card.Val()), nil
+}
+
+// Record404Batch implements BatchRecordStore, sending the whole batch in
+// one pipeline
+func (s *RedisStore) Record404Batch(entries []Record404Entry, window time.Duration) ([]int, error) {
+	ctx := context.Background()
+	cards := make([]*redis.IntCmd, len(entries))
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, entry := range entries {
+			key := s.countKey(entry.IP)
+			members := make([]redis.Z, entry.Weight)
+			for j := range members {
+				members[j] = redis.Z{Score: float64(entry.At.UnixNano()), Member: fmt.Sprintf("%d-%d", entry.At.UnixNano(), s.seq.Add(1))}
+			}
+			pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(entry.At.Add(-window).UnixNano(), 10))
+			pipe.ZAdd(ctx, key, members...)
+			cards[i] = pipe.ZCard(ctx, key)
+			pipe.PExpire(ctx, key, window)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]int, len(entries))
+	for i, card := range cards {
+		counts[i] = int(card.Val())
+	}
+	return counts, nil
+}
+
+// Count404s implements BanStore
+func (s *RedisStore) Count404s(ip string, now time.Time, window time.Duration) (int, error) {
+	windowStart := strconv.FormatInt(now.Add(-window).UnixNano(), 10)
+	count, err := s.client.ZCount(context.Background(), s.countKey(ip), "("+windowStart, "+inf").Result()
+	return int(count), err
+}
+
+// IsBanned implements BanStore. When Redis is unreachable the configured
+// failure mode decides the answer and the error is returned alongside it.
+func (s *RedisStore) IsBanned(ip string, now time.Time) (bool, error) {
+	_, banned, err := s.GetBan(ip, now)
+	if err != nil {
+		return s.failClosed, err
+	}
+	return banned, nil
+}
+
+// GetBan implements BanStore
+func (s *RedisStore) GetBan(ip string, now time.Time) (BanRecord, bool, error) {
+	raw, err := s.client.Get(context.Background(), s.banKey(ip)).Result()
+	if err == redis.Nil {
+		return BanRecord{}, false, nil
+	}
+	if err != nil {
+		return BanRecord{}, false, err
+	}
+
+	record, err := decodeRedisBan(raw)
+	if err != nil || !record.activeAt(now) {
+		return BanRecord{}, false, err
+	}
+	return record, true, nil
+}
+
+// decodeRedisBan parses a ban value, accepting the bare unix nanosecond
+// expiry written before ban records existed
+func decodeRedisBan(raw string) (BanRecord, error) {
+	if until, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return BanRecord{ExpiresAt: time.Unix(0, until)}, nil
+	}
+
+	var record BanRecord
+	err := json.Unmarshal([]byte(raw), &record)
+	return record, err
+}
+
+// useClock implements clockUser
+func (s *RedisStore) useClock(clock Clock) {
+	s.clock = clock
+}
+
+// Ban implements BanStore
+func (s *RedisStore) Ban(ip string, record BanRecord) error {
+	ttl := record.ExpiresAt.Sub(s.clock.Now())
+	if ttl <= 0 {
+		return s.Unban(ip)
+	}
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(context.Background(), s.banKey(ip), value, ttl).Err()
+}
+
+// Unban implements BanStore
+func (s *RedisStore) Unban(ip string) error {
+	return s.client.Del(context.Background(), s.banKey(ip)).Err()
+}
+
+// ListBans implements BanStore
+func (s *RedisStore) ListBans(now time.Time) (map[string]BanRecord, error) {
+	ctx := context.Background()
+	banPrefix := s.prefix + "ban:"
+	result := make(map[string]BanRecord)
+
+	iter := s.client.Scan(ctx, 0, banPrefix+"*", 100).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return result, nil
+	}
+
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			continue // Expired between SCAN and MGET
+		}
+		record, err := decodeRedisBan(raw)
+		if err != nil {
+			continue
+		}
+		if record.activeAt(now) {
+			result[strings.TrimPrefix(keys[i], banPrefix)] = record
+		}
+	}
+
+	return result, nil
+}
+
+// ClearCounts implements BanStore
+func (s *RedisStore) ClearCounts(ip string) error {
+	return s.client.Del(context.Background(), s.countKey(ip)).Err()
+}
+
+// ListCounts implements BanStore
+func (s *RedisStore) ListCounts(now time.Time, window time.Duration) (map[string][]time.Time, error) {
+	ctx := context.Background()
+	countPrefix := s.prefix + "count:"
+	windowStart := strconv.FormatInt(now.Add(-window).UnixNano(), 10)
+	result := make(map[string][]time.Time)
+
+	iter := s.client.Scan(ctx, 0, countPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		entries, err := s.client.ZRangeByScoreWithScores(ctx, iter.Val(), &redis.ZRangeBy{
+			Min: "(" + windowStart,
+			Max: "+inf",
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		ip := strings.TrimPrefix(iter.Val(), countPrefix)
+		for _, entry := range entries {
+			result[ip] = append(result[ip], time.Unix(0, int64(entry.Score)))
+		}
+	}
+
+	return result, iter.Err()
+}
+
+// RecordOffense implements BanStore. Offenses are kept in a sorted set scored
+// by when they'll be forgotten.
+func (s *RedisStore) RecordOffense(ip string, at time.Time, memory time.Duration) (int, error) {
+	ctx := context.Background()
+	key := s.offenseKey(ip)
+	forgetAt := at.Add(memory).UnixNano()
+	member := fmt.Sprintf("%d-%d", forgetAt, s.seq.Add(1))
+
+	var card *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(at.UnixNano(), 10))
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(forgetAt), Member: member})
+		card = pipe.ZCard(ctx, key)
+		pipe.PExpire(ctx, key, memory)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(card.Val()), nil
+}
+
+// Ping implements PingStore
+func (s *RedisStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+// ClearOffenses implements BanStore
+func (s *RedisStore) ClearOffenses(ip string) error {
+	return s.client.Del(context.Background(), s.offenseKey(ip)).Err()
+}
+
+// Cleanup implements BanStore. Redis expires bans, counts and offenses through key
+// TTLs so there is nothing to do beyond checking the connection, and expired
+// bans are never reported.
+func (s *RedisStore) Cleanup(now time.Time, window time.Duration) ([]string, error) {
+	return nil, s.client.Ping(context.Background()).Err()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+// trackerLimits holds the settings Reload can change while the tracker runs
+type trackerLimits struct {
+	threshold   int           // Number of 404s allowed in window
+	window      time.Duration // Time window to count 404s
+	banDuration time.Duration // How long to shadow ban
+	windows     []WindowLimit // Thresholds over windows of their own, see WithWindows
+}
+
+// Reload applies the threshold, window, extra windows, ban duration and
+// whitelist of cfg to the running tracker. Bans, 404 counts and whitelist
+// entries added any other way are kept; the whitelist file is re-read too.
+// Other settings only take effect on restart. Nothing changes when cfg is
+// invalid or changes the status rules, whose counters can't be swapped.
+func (t *IP404Tracker) Reload(cfg *Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if err := t.checkStatusRules(cfg); err != nil {
+		return err
+	}
+	prefixes, hosts, err := cfg.whitelistEntries()
+	if err != nil {
+		return err
+	}
+
+	// Requests see either the old or the new limits, never a mix
+	limits := &trackerLimits{
+		threshold:   cfg.Threshold,
+		window:      cfg.Window,
+		banDuration: cfg.BanDuration,
+		windows:     windowLimits(cfg.Windows),
+	}
+	before := t.limits.Swap(limits)
+	t.replaceConfigWhitelist(prefixes, hosts)
+
+	if t.whitelistPath != "" {
+		t.loadWhitelistFile()
+	}
+
+	t.logger.Info("configuration reloaded",
+		"threshold", cfg.Threshold,
+		"window", cfg.Window.String(),
+		"ban_duration", cfg.BanDuration.String(),
+		"windows", len(cfg.Windows),
+		"whitelist", len(cfg.Whitelist),
+	)
+	t.Audit(AuditEntry{Actor: AuditActorReload, Action: AuditReload, Before: before.auditState(), After: limits.auditState()})
+	return nil
+}
+
+// checkStatusRules returns an error naming the first status rule cfg
+// changes, as the rules' counters are only set up on startup
+func (t *IP404Tracker) checkStatusRules(cfg *Config) error {
+	rules := cfg.statusRules()
+	if len(rules) != len(t.statusRules) {
+		return errors.New("status_rules: adding or removing rules needs a restart")
+	}
+	for i, rule := range rules {
+		current := t.statusRules[i].rule
+		if rule.Name == "" {
+			rule.Name = rule.defaultName()
+		}
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule%d", i+1)
+		}
+		// The defaults WithStatusRules fills in
+		if rule.Weight <= 0 {
+			rule.Weight = 1
+		}
+		if rule.Window <= 0 {
+			rule.Window = cfg.Window
+		}
+		if rule.Action == "" {
+			rule.Action = RuleBan
+		}
+		var field string
+		switch {
+		case rule.Name != current.Name, rule.Status != current.Status, rule.Min != current.Min, rule.Max != current.Max:
+			// A different rule altogether
+		case rule.Weight != current.Weight:
+			field = ".weight"
+		case rule.Threshold != current.Threshold:
+			field = ".threshold"
+		case rule.Window != current.Window:
+			// Also when it follows the tracker's window
+			field = ".window"
+		case !slices.Equal(rule.Windows, current.Windows):
+			field = ".windows"
+		case rule.BanDuration != current.BanDuration:
+			field = ".ban_duration"
+		case rule.Action != current.Action:
+			field = ".action"
+		default:
+			continue
+		}
+		return fmt.Errorf("status_rules[%d]%s: changing it needs a restart", i, field)
+	}
+	return nil
+}
+
+// replaceConfigWhitelist makes prefixes and hosts the only whitelist entries
+// that came from the configuration
+func (t *IP404Tracker) replaceConfigWhitelist(prefixes []netip.Prefix, hosts []string) {
+	t.mu.Lock()
+	t.replaceWhitelistSource(whitelistConfig, prefixes)
+	t.mu.Unlock()
+	t.replaceWhitelistHosts(whitelistConfig, hosts)
+}
+
+// whitelistEntries splits the whitelist into address ranges and hostnames
+func (c *Config) whitelistEntries() ([]netip.Prefix, []string, error) {
+	var (
+		prefixes []netip.Prefix
+		hosts    []string
+	)
+	for i, entry := range c.Whitelist {
+		entry = strings.TrimSpace(entry)
+		if isHostname(entry) {
+			hosts = append(hosts, entry)
+			continue
+		}
+		prefix, err := parseIPOrCIDR(entry)
+		if err != nil {
+			return nil, nil, fmt.Errorf("whitelist[%d]: %w", i, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, hosts, nil
+}
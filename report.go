@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ReportFormat selects how reports are written to ReporterConfig.Output
+type ReportFormat string
+
+const (
+	// ReportText writes the human-readable banner format
+	ReportText ReportFormat = "text"
+	// ReportJSON writes one JSON object per report
+	ReportJSON ReportFormat = "json"
+)
+
+// BannedRequestReport lists how many requests each banned IP has sent.
+// Forgotten adds up the requests of the IPs whose counters were dropped
+// after the retention.
+type BannedRequestReport struct {
+	Timestamp      time.Time      `json:"timestamp"`
+	BannedRequests map[string]int `json:"banned_requests"`
+	Forgotten      uint64         `json:"forgotten_requests,omitempty"`
+}
+
+// ReporterConfig configures the periodic banned request report. Reports go
+// to the logger unless an Output or Callback is set.
+type ReporterConfig struct {
+	Interval time.Duration             // How often to report (default 10s)
+	Disabled bool                      // Turn reporting off entirely
+	Output   io.Writer                 // Write formatted reports here, e.g. an *os.File
+	Format   ReportFormat              // Format used for Output (default ReportText)
+	Callback func(BannedRequestReport) // Receive each report programmatically
+
+	// Forget the counter of an IP neither banned nor blocked for this long
+	// (default 24h)
+	Retention time.Duration
+}
+
+// defaultBannedRequestRetention is how long the banned request counter of an
+// IP is kept after its last blocked request, once it's no longer banned
+const defaultBannedRequestRetention = 24 * time.Hour
+
+// WithReporter configures the periodic banned request report
+func WithReporter(cfg ReporterConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.Interval <= 0 {
+			cfg.Interval = 10 * time.Second
+		}
+		if cfg.Format == "" {
+			cfg.Format = ReportText
+		}
+		if cfg.Retention <= 0 {
+			cfg.Retention = defaultBannedRequestRetention
+		}
+		t.reporter = cfg
+	}
+}
+
+// WithReportInterval changes how often the banned request report is
+// delivered, keeping the rest of the reporter settings
+func WithReportInterval(interval time.Duration) Option {
+	return func(t *IP404Tracker) {
+		if interval > 0 {
+			t.reporter.Interval = interval
+		}
+	}
+}
+
+// blockedCount is the banned request counter of a client
+type blockedCount struct {
+	requests int
+	last     time.Time // Latest blocked request
+}
+
+// bannedRequestReport captures the current banned request counters
+func (t *IP404Tracker) bannedRequestReport() BannedRequestReport {
+	counts := make(map[string]int)
+	t.bannedRequest.each(func(ip string, c blockedCount) {
+		counts[ip] = c.requests
+	})
+
+	return BannedRequestReport{Timestamp: t.clock.Now(), BannedRequests: counts, Forgotten: t.forgottenBlocked.Load()}
+}
+
+// cleanupBannedRequests forgets the counters of IPs that weren't blocked
+// within the retention and aren't banned anymore, adding their requests to
+// the forgotten total
+func (t *IP404Tracker) cleanupBannedRequests(now time.Time) {
+	cutoff := now.Add(-t.anonymizer.retain(t.reporter.Retention))
+	var stale []string
+	t.bannedRequest.each(func(ip string, c blockedCount) {
+		if c.last.Before(cutoff) {
+			stale = append(stale, ip)
+		}
+	})
+
+	for _, ip := range stale {
+		// A long ban may simply have gone quiet
+		if t.isBannedIP(ip) {
+			continue
+		}
+		t.bannedRequest.deleteIf(ip, func(c blockedCount) bool {
+			if !c.last.Before(cutoff) {
+				return false
+			}
+			t.forgottenBlocked.Add(uint64(c.requests))
+			return true
+		})
+	}
+}
+
+// ResetBannedRequestCounters forgets every banned request counter and the
+// forgotten total, so the report starts again from zero
+func (t *IP404Tracker) ResetBannedRequestCounters() {
+	t.bannedRequest.deleteKeys(func(string) bool { return true })
+	t.forgottenBlocked.Store(0)
+	t.logger.Info("banned request counters reset")
+}
+
+// writeText writes the report in the banner format
+func (r BannedRequestReport) writeText(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("=== Banned Requests Report ===\n")
+	fmt.Fprintf(&b, "Timestamp: %s\n", r.Timestamp.Format(time.RFC3339))
+	if len(r.BannedRequests) == 0 {
+		b.WriteString("No banned requests recorded\n")
+	} else {
+		ips := make([]string, 0, len(r.BannedRequests))
+		for ip := range r.BannedRequests {
+			ips = append(ips, ip)
+		}
+		sort.Strings(ips)
+		for _, ip := range ips {
+			fmt.Fprintf(&b, "IP: %s - Banned Requests: %d\n", ip, r.BannedRequests[ip])
+		}
+	}
+	if r.Forgotten > 0 {
+		fmt.Fprintf(&b, "Forgotten IPs - Banned Requests: %d\n", r.Forgotten)
+	}
+	b.WriteString("==============================\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// deliverReport sends a report to every configured destination
+func (t *IP404Tracker) deliverReport(report BannedRequestReport) {
+	cfg := t.reporter
+
+	if cfg.Callback != nil {
+		cfg.Callback(report)
+	}
+
+	if cfg.Output != nil {
+		var err error
+		if cfg.Format == ReportJSON {
+			err = json.NewEncoder(cfg.Output).Encode(report)
+		} else {
+			err = report.writeText(cfg.Output)
+		}
+		if err != nil {
+			t.logger.Error("writing banned requests report failed", "error", err)
+		}
+	}
+
+	if cfg.Callback == nil && cfg.Output == nil {
+		t.logger.Info("banned requests report", "ips", len(report.BannedRequests), "forgotten", report.Forgotten)
+		for ip, count := range report.BannedRequests {
+			t.logger.Info("banned requests", "ip", ip, "count", count)
+		}
+	}
+}
+
+// startBannedRequestLogger reports banned request counts every report interval
+func (t *IP404Tracker) startBannedRequestLogger() {
+	ticker, stop := t.clock.NewTicker(t.reporter.Interval)
+	defer stop()
+
+	for {
+		select {
+		case <-ticker:
+			t.deliverReport(t.bannedRequestReport())
+		case <-t.done:
+			return
+		}
+	}
+}
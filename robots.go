@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RobotsConfig configures the robots.txt served by HoneytokenRobots
+type RobotsConfig struct {
+	// Derives the honeytoken paths; instances sharing bans need the same
+	// secret. A random one is generated when empty, which changes the
+	// paths on restart.
+	Secret []byte
+
+	Tokens int // Honeytoken paths to list (default 3)
+
+	// The site's own robots.txt rules, served ahead of the honeytokens
+	Rules string
+}
+
+// HoneytokenRobots serves /robots.txt on r with Disallow entries for made-up
+// paths, and bans any client that then requests one of them, like a
+// Honeypot. Well-behaved crawlers never visit a disallowed path, so this
+// catches the ones that read robots.txt for places to probe. It returns the
+// honeytoken paths.
+func (t *IP404Tracker) HoneytokenRobots(r *gin.Engine, cfg RobotsConfig) []string {
+	if len(cfg.Secret) == 0 {
+		cfg.Secret = make([]byte, 32)
+		rand.Read(cfg.Secret)
+	}
+	if cfg.Tokens <= 0 {
+		cfg.Tokens = 3
+	}
+
+	paths := honeytokenPaths(cfg.Secret, cfg.Tokens)
+	var body strings.Builder
+	if cfg.Rules != "" {
+		body.WriteString(strings.TrimRight(cfg.Rules, "\n"))
+		body.WriteString("\n\n")
+	}
+	body.WriteString("User-agent: *\n")
+	for _, path := range paths {
+		body.WriteString("Disallow: " + path + "\n")
+	}
+	robots := body.String()
+
+	r.GET("/robots.txt", func(c *gin.Context) {
+		c.String(http.StatusOK, robots)
+	})
+	trap := t.trapHandler(BanReasonHoneytoken)
+	for _, path := range paths {
+		r.Any(strings.TrimSuffix(path, "/"), trap)
+		r.Any(path+"*rest", trap)
+	}
+	return paths
+}
+
+// honeytokenPaths derives n directory paths that look worth a visit, like
+// "/backup-3f9a1c07e2/"
+func honeytokenPaths(secret []byte, n int) []string {
+	paths := make([]string, n)
+	for i := range paths {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte("robots.txt honeytoken " + strconv.Itoa(i)))
+		sum := mac.Sum(nil)
+		name := decoyNames[binary.BigEndian.Uint32(sum)%uint32(len(decoyNames))]
+		paths[i] = "/" + name + "-" + hex.EncodeToString(sum[4:9]) + "/"
+	}
+	return paths
+}
@@ -0,0 +1,31 @@
+package main
+
+import "time"
+
+// ServerErrorConfig counts the 5xx responses each client causes. Clients
+// that reliably trigger server errors are often probing for crashes, but a
+// bug can make real users trip them too, so the default only alerts.
+type ServerErrorConfig struct {
+	Threshold   int           // 5xx responses allowed within Window
+	Window      time.Duration // Default: the tracker's window
+	BanDuration time.Duration // Default: the tracker's ban duration
+	Action      RuleAction    // Default RuleAlert
+}
+
+// WithServerErrorTracking tracks 5xx responses per client as a signal of
+// their own, alerting or banning once a client crosses the threshold. It's
+// the status rule named "5xx" matching 500 to 599.
+func WithServerErrorTracking(cfg ServerErrorConfig) Option {
+	if cfg.Action == "" {
+		cfg.Action = RuleAlert
+	}
+	return WithStatusRules(StatusRule{
+		Name:        "5xx",
+		Min:         500,
+		Max:         599,
+		Threshold:   cfg.Threshold,
+		Window:      cfg.Window,
+		BanDuration: cfg.BanDuration,
+		Action:      cfg.Action,
+	})
+}
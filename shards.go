@@ -0,0 +1,129 @@
+package main
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// trackerShards is how many lock-striped shards per-client tracker state is
+// split over
+const trackerShards = 32
+
+// shardIndex returns which of n shards key belongs to
+func shardIndex(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// shardedMap is a map by tracking key split over shards with a lock each,
+// so requests of different clients rarely wait for each other
+type shardedMap[V any] struct {
+	shards [trackerShards]mapShard[V]
+}
+
+// mapShard is one shard of a shardedMap
+type mapShard[V any] struct {
+	mu sync.RWMutex
+	m  map[string]V
+}
+
+// newShardedMap returns an empty map
+func newShardedMap[V any]() *shardedMap[V] {
+	m := &shardedMap[V]{}
+	for i := range m.shards {
+		m.shards[i].m = make(map[string]V)
+	}
+	return m
+}
+
+// shard returns the shard holding key
+func (m *shardedMap[V]) shard(key string) *mapShard[V] {
+	return &m.shards[shardIndex(key, trackerShards)]
+}
+
+// update replaces the value of key with what fn returns for the current one
+func (m *shardedMap[V]) update(key string, fn func(v V, ok bool) V) {
+	sh := m.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	v, ok := sh.m[key]
+	sh.m[key] = fn(v, ok)
+}
+
+// read calls fn with the value of key, if any, while no one can change it
+func (m *shardedMap[V]) read(key string, fn func(v V)) bool {
+	sh := m.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	v, ok := sh.m[key]
+	if ok {
+		fn(v)
+	}
+	return ok
+}
+
+// get returns the value of key
+func (m *shardedMap[V]) get(key string) (V, bool) {
+	sh := m.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	v, ok := sh.m[key]
+	return v, ok
+}
+
+// deleteIf deletes key if matching accepts its value
+func (m *shardedMap[V]) deleteIf(key string, matching func(v V) bool) {
+	sh := m.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if v, ok := sh.m[key]; ok && matching(v) {
+		delete(sh.m, key)
+	}
+}
+
+// delete deletes key
+func (m *shardedMap[V]) delete(key string) {
+	sh := m.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	delete(sh.m, key)
+}
+
+// deleteKeys deletes every key matching, a shard at a time
+func (m *shardedMap[V]) deleteKeys(matching func(key string) bool) {
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.Lock()
+		for key := range sh.m {
+			if matching(key) {
+				delete(sh.m, key)
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// each calls fn for every entry, a shard at a time; fn must not change the map
+func (m *shardedMap[V]) each(fn func(key string, v V)) {
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.RLock()
+		for key, v := range sh.m {
+			fn(key, v)
+		}
+		sh.mu.RUnlock()
+	}
+}
+
+// len returns the number of entries
+func (m *shardedMap[V]) len() int {
+	n := 0
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.RLock()
+		n += len(sh.m)
+		sh.mu.RUnlock()
+	}
+	return n
+}
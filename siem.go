@@ -0,0 +1,398 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// siemBuffer is how many events and blocked requests may queue up while
+// the collector is slow or unreachable
+const siemBuffer = 4096
+
+// SIEMFormat selects how events are written for a SIEM
+type SIEMFormat string
+
+const (
+	// SIEMSyslog sends RFC 5424 messages with the details as structured data
+	SIEMSyslog SIEMFormat = "syslog"
+	// SIEMCEF sends ArcSight Common Event Format messages, e.g. for Sentinel
+	SIEMCEF SIEMFormat = "cef"
+	// SIEMLEEF sends IBM QRadar Log Event Extended Format 1.0 messages
+	SIEMLEEF SIEMFormat = "leef"
+)
+
+// SIEMConfig ships ban changes, alerts and blocked requests to a syslog
+// collector such as Splunk, QRadar, Sentinel's agent or rsyslog. Every
+// format travels in an RFC 5424 syslog message, as the collectors expect.
+type SIEMConfig struct {
+	Format  SIEMFormat // Default SIEMSyslog
+	Network string     // "udp" (default), "tcp" or "tls"
+	Addr    string     // Collector address, e.g. "siem.example.com:514"
+
+	// Config of "tls" connections; nil verifies the collector against the
+	// system roots
+	TLS *tls.Config
+
+	Facility int    // Syslog facility (default 4, security/authorization)
+	AppName  string // Default "404blocker"
+	Hostname string // Default the machine's hostname
+
+	// Only ship ban changes and alerts, not every blocked request
+	SkipBlocked bool
+}
+
+// siemSink holds the SIEM settings, the blocked requests waiting to be sent
+// and the connection to the collector
+type siemSink struct {
+	cfg     SIEMConfig
+	blocked chan siemRecord
+	conn    net.Conn
+}
+
+// WithSIEM ships ban changes, alerts and blocked requests to a SIEM over
+// syslog, as plain RFC 5424, CEF or LEEF. Records are dropped rather than
+// slowing requests down when the collector can't keep up.
+func WithSIEM(cfg SIEMConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.Format == "" {
+			cfg.Format = SIEMSyslog
+		}
+		if cfg.Network == "" {
+			cfg.Network = "udp"
+		}
+		if cfg.Facility == 0 {
+			cfg.Facility = 4
+		}
+		if cfg.AppName == "" {
+			cfg.AppName = "404blocker"
+		}
+		if cfg.Hostname == "" {
+			cfg.Hostname, _ = os.Hostname()
+		}
+		t.siem = &siemSink{cfg: cfg, blocked: make(chan siemRecord, siemBuffer)}
+	}
+}
+
+// Syslog severities of the records
+const (
+	siemWarning = 4
+	siemNotice  = 5
+	siemInfo    = 6
+)
+
+// siemRecord is an event or blocked request on its way to the SIEM
+type siemRecord struct {
+	kind     string // Event type or "blocked"
+	name     string // Human readable kind
+	severity int    // Syslog severity
+	time     time.Time
+
+	target  string // IP, CIDR or client key the record is about
+	ip      string // Client address of a blocked request
+	method  string
+	path    string
+	reason  string
+	banType string
+	rule    string
+	count   int
+	expires time.Time
+}
+
+// siemNames name the event types shipped to the SIEM
+var siemNames = map[EventType]string{
+	EventBanned:            "Client banned",
+	EventUnbanned:          "Ban lifted",
+	EventBanExpired:        "Ban expired",
+	EventThresholdExceeded: "Threshold exceeded",
+	EventCampaignDetected:  "Campaign detected",
+	EventCircuitTripped:    "Circuit breaker tripped",
+}
+
+// siemEvents are the event types shipped to the SIEM
+var siemEvents = slices.Collect(maps.Keys(siemNames))
+
+// siemEvent converts an event, reporting false for events not shipped
+func siemEvent(event Event) (siemRecord, bool) {
+	name, ok := siemNames[event.Type]
+	if !ok {
+		return siemRecord{}, false
+	}
+	severity := siemWarning
+	if event.Type == EventUnbanned || event.Type == EventBanExpired {
+		severity = siemNotice
+	}
+	return siemRecord{
+		kind:     string(event.Type),
+		name:     name,
+		severity: severity,
+		time:     event.Time,
+		target:   event.IP,
+		path:     event.Path,
+		reason:   event.Reason,
+		rule:     event.Rule,
+		count:    event.Count,
+		expires:  event.ExpiresAt,
+	}, true
+}
+
+// recordBlocked queues a blocked request for the SIEM, dropping it when
+// the queue is full
+func (s *siemSink) recordBlocked(blocked BlockedRequest, now time.Time) {
+	if s == nil || s.cfg.SkipBlocked {
+		return
+	}
+	record := siemRecord{
+		kind:     "blocked",
+		name:     "Request blocked",
+		severity: siemInfo,
+		time:     now,
+		target:   blocked.Key,
+		ip:       blocked.IP,
+		method:   blocked.Method,
+		path:     blocked.Path,
+		banType:  blocked.BanType,
+	}
+	select {
+	case s.blocked <- record:
+	default:
+	}
+}
+
+// siemLoop sends the shipped events and the blocked requests to the SIEM
+// until the tracker shuts down
+func (t *IP404Tracker) siemLoop(events <-chan Event) {
+	s := t.siem
+	defer s.close()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// Send what was blocked before the shutdown
+				for {
+					select {
+					case record := <-s.blocked:
+						t.sendSIEM(record)
+					default:
+						return
+					}
+				}
+			}
+			if record, ok := siemEvent(event); ok {
+				t.sendSIEM(record)
+			}
+		case record := <-s.blocked:
+			t.sendSIEM(record)
+		}
+	}
+}
+
+// sendSIEM sends a record, reconnecting once when the connection broke
+func (t *IP404Tracker) sendSIEM(record siemRecord) {
+	s := t.siem
+	msg := s.message(record)
+	err := s.write(msg)
+	if err != nil {
+		s.close()
+		err = s.write(msg)
+	}
+	if err != nil {
+		t.logger.Error("sending to SIEM failed", "addr", s.cfg.Addr, "error", err)
+		s.close()
+	}
+}
+
+// write sends msg, connecting first if needed. Stream connections frame
+// messages by octet counting as RFC 6587 describes.
+func (s *siemSink) write(msg string) error {
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	if s.cfg.Network != "udp" {
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	}
+	// Socket deadlines work in real time, whatever the tracker's clock says
+	s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := s.conn.Write([]byte(msg))
+	return err
+}
+
+// dial connects to the collector
+func (s *siemSink) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if s.cfg.Network == "tls" {
+		return tls.DialWithDialer(dialer, "tcp", s.cfg.Addr, s.cfg.TLS)
+	}
+	return dialer.Dial(s.cfg.Network, s.cfg.Addr)
+}
+
+// close drops the connection, if any
+func (s *siemSink) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// siemSDID is the structured data ID of syslog records; 32473 is the
+// enterprise number reserved for documentation and examples
+const siemSDID = "404blocker@32473"
+
+// message formats record as a syslog message in the configured format
+func (s *siemSink) message(record siemRecord) string {
+	sd, msg := "-", ""
+	switch s.cfg.Format {
+	case SIEMCEF:
+		msg = cefMessage(record)
+	case SIEMLEEF:
+		msg = leefMessage(record)
+	default:
+		sd, msg = syslogData(record), syslogText(record)
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		s.cfg.Facility*8+record.severity,
+		record.time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderField(s.cfg.Hostname),
+		syslogHeaderField(s.cfg.AppName),
+		os.Getpid(),
+		syslogHeaderField(record.kind),
+		sd,
+		msg,
+	)
+}
+
+// syslogHeaderField makes value fit a syslog header field
+func syslogHeaderField(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, value)
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// fields returns the details of record as ordered key-value pairs, leaving
+// out empty ones
+func (r siemRecord) fields(keys map[string]string) [][2]string {
+	var fields [][2]string
+	add := func(name, value string) {
+		if key := keys[name]; key != "" && value != "" {
+			fields = append(fields, [2]string{key, value})
+		}
+	}
+	add("target", r.target)
+	if r.ip != "" {
+		add("src", r.ip)
+	} else if addr, err := netip.ParseAddr(r.target); err == nil {
+		add("src", addr.String())
+	}
+	add("method", r.method)
+	add("path", r.path)
+	add("reason", r.reason)
+	add("ban_type", r.banType)
+	add("rule", r.rule)
+	if r.count > 0 {
+		add("count", strconv.Itoa(r.count))
+	}
+	if !r.expires.IsZero() {
+		add("expires", r.expires.UTC().Format(time.RFC3339))
+	}
+	return fields
+}
+
+// syslogKeys name the fields of syslog structured data
+var syslogKeys = map[string]string{
+	"target": "target", "src": "ip", "method": "method", "path": "path", "reason": "reason",
+	"ban_type": "ban_type", "rule": "rule", "count": "count", "expires": "expires",
+}
+
+// syslogData formats the structured data element of a syslog record
+func syslogData(record siemRecord) string {
+	var b strings.Builder
+	b.WriteString("[" + siemSDID)
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+	for _, field := range record.fields(syslogKeys) {
+		fmt.Fprintf(&b, ` %s="%s"`, field[0], escaper.Replace(field[1]))
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// syslogText is the free-form message of a syslog record
+func syslogText(record siemRecord) string {
+	text := record.name + ": " + record.target
+	if record.reason != "" {
+		text += " (" + record.reason + ")"
+	}
+	return text
+}
+
+// cefKeys map the fields to CEF extension keys; the rest go in custom strings
+var cefKeys = map[string]string{
+	"target": "cs3", "src": "src", "method": "requestMethod", "path": "request", "reason": "reason",
+	"ban_type": "cs1", "rule": "cs2", "count": "cnt", "expires": "end",
+}
+
+// cefSeverities rate the records from 0 to 10
+var cefSeverities = map[int]int{siemWarning: 7, siemNotice: 3, siemInfo: 5}
+
+// cefMessage formats record as a CEF event
+func cefMessage(record siemRecord) string {
+	header := strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	extension := strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+
+	parts := []string{"rt=" + strconv.FormatInt(record.time.UnixMilli(), 10)}
+	for _, field := range record.fields(cefKeys) {
+		key, value := field[0], field[1]
+		switch key {
+		case "cs1":
+			parts = append(parts, "cs1Label=banType")
+		case "cs2":
+			parts = append(parts, "cs2Label=rule")
+		case "cs3":
+			parts = append(parts, "cs3Label=target")
+		case "end":
+			value = strconv.FormatInt(record.expires.UnixMilli(), 10)
+		}
+		parts = append(parts, key+"="+extension.Replace(value))
+	}
+	return fmt.Sprintf("CEF:0|404blocker|404blocker|1|%s|%s|%d|%s",
+		header.Replace(record.kind), header.Replace(record.name), cefSeverities[record.severity], strings.Join(parts, " "))
+}
+
+// leefKeys map the fields to LEEF attributes
+var leefKeys = map[string]string{
+	"target": "target", "src": "src", "method": "method", "path": "url", "reason": "reason",
+	"ban_type": "banType", "rule": "rule", "count": "count", "expires": "expires",
+}
+
+// leefMessage formats record as a LEEF 1.0 event
+func leefMessage(record siemRecord) string {
+	header := strings.NewReplacer("|", " ", "\t", " ", "\n", " ", "\r", " ")
+	value := strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+	parts := []string{
+		"devTime=" + record.time.UTC().Format("Jan 02 2006 15:04:05"),
+		"cat=" + record.kind,
+		"sev=" + strconv.Itoa(cefSeverities[record.severity]),
+	}
+	for _, field := range record.fields(leefKeys) {
+		parts = append(parts, field[0]+"="+value.Replace(field[1]))
+	}
+	return fmt.Sprintf("LEEF:1.0|404blocker|404blocker|1|%s|%s", header.Replace(record.kind), strings.Join(parts, "\t"))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+//go:embed scannerpaths.txt
+var scannerPathsFile string
+
+// DefaultScannerSignatures returns the built-in list of paths vulnerability
+// scanners request: phpMyAdmin, wp-admin, .env, Spring actuators and so on
+func DefaultScannerSignatures() []string {
+	var patterns []string
+	for _, line := range strings.Split(scannerPathsFile, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns
+}
+
+// WithScannerSignatures gives 404s for the built-in scanner paths, and the
+// extra patterns, weight, see PathWeight; 0 bans on the first hit. Patterns
+// from WithPathWeights take precedence. The list can be changed at runtime
+// with AddScannerSignature and RemoveScannerSignature.
+func WithScannerSignatures(weight int, extra ...string) Option {
+	return func(t *IP404Tracker) {
+		if weight <= 0 {
+			weight = PathWeightBan
+		}
+		t.signatureWeight = weight
+		t.signatures = DefaultScannerSignatures()
+		for _, pattern := range extra {
+			if err := t.AddScannerSignature(pattern); err != nil {
+				t.logger.Warn("ignoring scanner signature", "error", err)
+			}
+		}
+	}
+}
+
+// scannerSignatureWeight returns the weight of a 404 for urlPath if it
+// matches a scanner signature
+func (t *IP404Tracker) scannerSignatureWeight(urlPath string) (int, bool) {
+	if t.signatureWeight == 0 {
+		return 0, false
+	}
+	urlPath = strings.ToLower(urlPath)
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, pattern := range t.signatures {
+		if matchPath(pattern, urlPath) {
+			return t.signatureWeight, true
+		}
+	}
+	return 0, false
+}
+
+// GetScannerSignatures returns the scanner signatures in use
+func (t *IP404Tracker) GetScannerSignatures() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return slices.Clone(t.signatures)
+}
+
+// AddScannerSignature adds a path.Match pattern to the scanner signatures.
+// Like the list itself it's kept in memory, by each instance.
+func (t *IP404Tracker) AddScannerSignature(pattern string) error {
+	if t.signatureWeight == 0 {
+		return fmt.Errorf("scanner signatures are disabled")
+	}
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if _, err := path.Match(pattern, ""); pattern == "" || err != nil {
+		return fmt.Errorf("invalid pattern %q", pattern)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !slices.Contains(t.signatures, pattern) {
+		t.signatures = append(t.signatures, pattern)
+	}
+	return nil
+}
+
+// RemoveScannerSignature drops a pattern from the scanner signatures,
+// reporting whether it was there
+func (t *IP404Tracker) RemoveScannerSignature(pattern string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i := slices.Index(t.signatures, pattern)
+	if i < 0 {
+		return false
+	}
+	t.signatures = slices.Delete(t.signatures, i, i+1)
+	return true
+}
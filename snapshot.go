@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TrackerSnapshot is the serialized state of a tracker
+type TrackerSnapshot struct {
+	Timestamp      time.Time              `json:"timestamp"`
+	Bans           map[string]BanRecord   `json:"bans"`
+	CIDRBans       map[string]BanRecord   `json:"cidr_bans,omitempty"`
+	Counts         map[string][]time.Time `json:"counts"`
+	BannedRequests map[string]int         `json:"banned_requests"`
+	Blacklist      []string               `json:"blacklist,omitempty"`
+}
+
+// WithSnapshotFile loads tracker state from path on startup (if the file
+// exists) and writes a fresh snapshot to it every interval
+func WithSnapshotFile(path string, interval time.Duration) Option {
+	return func(t *IP404Tracker) {
+		t.snapshotPath = path
+		t.snapshotInterval = interval
+	}
+}
+
+// Snapshot writes the active bans and range bans, 404 counts, banned
+// request counters and blacklist as JSON
+func (t *IP404Tracker) Snapshot(w io.Writer) error {
+	now := t.clock.Now()
+
+	bans, err := t.store.ListBans(now)
+	if err != nil {
+		return err
+	}
+	counts, err := t.store.ListCounts(now, t.countRetention())
+	if err != nil {
+		return err
+	}
+
+	bannedRequests := make(map[string]int)
+	t.bannedRequest.each(func(ip string, c blockedCount) {
+		bannedRequests[ip] = c.requests
+	})
+
+	cidrBans := make(map[string]BanRecord)
+	t.mu.RLock()
+	t.cidrBans.walk(func(prefix netip.Prefix, record BanRecord) {
+		if record.activeAt(now) {
+			cidrBans[prefix.String()] = record
+		}
+	})
+	t.mu.RUnlock()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(TrackerSnapshot{
+		Timestamp:      now,
+		Bans:           bans,
+		CIDRBans:       cidrBans,
+		Counts:         counts,
+		BannedRequests: bannedRequests,
+		Blacklist:      t.GetBlacklist(),
+	})
+}
+
+// Restore loads state written by Snapshot, skipping bans and 404s that have
+// expired since. Restored state is merged into the current state.
+func (t *IP404Tracker) Restore(r io.Reader) error {
+	_, err := t.restore(r)
+	return err
+}
+
+// restore loads a snapshot and returns how many bans it restored
+func (t *IP404Tracker) restore(r io.Reader) (int, error) {
+	var snapshot TrackerSnapshot
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return 0, err
+	}
+
+	now := t.clock.Now()
+	window := t.countRetention()
+	windowStart := now.Add(-window)
+
+	restored := 0
+	for ip, record := range snapshot.Bans {
+		if !record.activeAt(now) {
+			continue
+		}
+		if err := t.store.Ban(ip, record); err != nil {
+			return restored, err
+		}
+		restored++
+	}
+
+	for ip, timestamps := range snapshot.Counts {
+		for _, ts := range timestamps {
+			if !ts.After(windowStart) {
+				continue
+			}
+			if _, err := t.store.Record404(ip, ts, window, 1); err != nil {
+				return restored, err
+			}
+		}
+	}
+
+	// Restored counters are kept for the retention from now
+	for ip, count := range snapshot.BannedRequests {
+		t.bannedRequest.update(ip, func(c blockedCount, _ bool) blockedCount {
+			return blockedCount{requests: c.requests + count, last: now}
+		})
+	}
+	t.mu.Lock()
+	for entry, record := range snapshot.CIDRBans {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil || !record.activeAt(now) {
+			continue
+		}
+		t.cidrBans.insert(normalizePrefix(prefix), record)
+		restored++
+	}
+	for _, entry := range snapshot.Blacklist {
+		if prefix, err := parseIPOrCIDR(entry); err == nil {
+			t.blacklist.insert(prefix, struct{}{})
+			restored++
+		}
+	}
+	t.listsChanged()
+	t.mu.Unlock()
+
+	return restored, nil
+}
+
+// loadSnapshotFile restores state from the configured snapshot file
+func (t *IP404Tracker) loadSnapshotFile() {
+	f, err := os.Open(t.snapshotPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return // First run
+	}
+	if err != nil {
+		t.logger.Error("opening snapshot failed", "error", err)
+		return
+	}
+	defer f.Close()
+
+	if err := t.Restore(f); err != nil {
+		t.logger.Error("restoring snapshot failed", "error", err)
+	}
+}
+
+// writeSnapshotFile atomically replaces the snapshot file with the current state
+func (t *IP404Tracker) writeSnapshotFile() error {
+	tmp, err := os.CreateTemp(filepath.Dir(t.snapshotPath), ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := t.Snapshot(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), t.snapshotPath)
+}
+
+// snapshotLoop periodically writes the snapshot file
+func (t *IP404Tracker) snapshotLoop() {
+	ticker, stop := t.clock.NewTicker(t.snapshotInterval)
+	defer stop()
+
+	for {
+		select {
+		case <-ticker:
+			if err := t.writeSnapshotFile(); err != nil {
+				t.logger.Error("writing snapshot failed", "error", err)
+			}
+		case <-t.done:
+			// Shutdown writes the last one
+			return
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+// Rough sizes used to estimate the memory a tracker holds: a map entry with
+// its string key, a 404 timestamp, a ban record and a kept request
+const (
+	mapEntrySize     = 64
+	timestampSize    = 24
+	banRecordSize    = 256
+	requestEntrySize = 96
+)
+
+// Stats is a snapshot of what a tracker is currently holding
+type Stats struct {
+	TrackedIPs      int    `json:"tracked_ips"`      // IPs with 404s inside the current window
+	ActiveBans      int    `json:"active_bans"`      // IPs and CIDRs currently banned
+	Total404s       uint64 `json:"total_404s"`       // 404 responses tracked since startup
+	BlockedRequests uint64 `json:"blocked_requests"` // Requests refused while banned since startup
+
+	// Rough estimate of the memory held by counts, bans and per-client
+	// history. Counts and bans kept by Redis or bbolt aren't included.
+	MemoryBytes int64 `json:"memory_bytes"`
+
+	// Background loops, and how often they were restarted after a panic
+	Loops []LoopStatus `json:"loops"`
+}
+
+// Stats returns the tracker's current totals
+func (t *IP404Tracker) Stats() Stats {
+	counts, err := t.store.ListCounts(t.clock.Now(), t.limits.Load().window)
+	if err != nil {
+		t.logger.Error("listing counts failed", "error", err)
+	}
+	bans, cidrBans := len(t.GetBans()), len(t.GetBannedCIDRs())
+	stats := Stats{
+		TrackedIPs:      len(counts),
+		ActiveBans:      bans + cidrBans,
+		Total404s:       t.counters.recorded404s.Load(),
+		BlockedRequests: t.counters.blockedRequests.Load(),
+		Loops:           t.Loops(),
+	}
+
+	size := int64(cidrBans) * (mapEntrySize + banRecordSize)
+	if store, inMemory := t.store.(*MemoryStore); inMemory {
+		size += store.size()
+	}
+
+	t.paths.each(func(_ string, entry *ipPaths) {
+		size += mapEntrySize + int64(len(entry.requests))*requestEntrySize
+	})
+	size += int64(t.bannedRequest.len()) * mapEntrySize
+	t.recentMu.Lock()
+	size += int64(len(t.recent)) * requestEntrySize
+	t.recentMu.Unlock()
+
+	stats.MemoryBytes = size
+	return stats
+}
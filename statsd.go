@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// StatsDConfig configures the StatsD/DogStatsD metrics emitter
+type StatsDConfig struct {
+	Addr          string        // Agent address (default "127.0.0.1:8125")
+	Prefix        string        // Metric name prefix (default "blocker.")
+	Tags          []string      // DogStatsD tags such as "env:prod"; leave empty for plain StatsD
+	FlushInterval time.Duration // How often metrics are sent (default 10s)
+}
+
+// WithStatsD reports bans, blocked requests and tracked-IP counts to a
+// StatsD-compatible agent every flush interval
+func WithStatsD(cfg StatsDConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.Addr == "" {
+			cfg.Addr = "127.0.0.1:8125"
+		}
+		if cfg.Prefix == "" {
+			cfg.Prefix = "blocker."
+		}
+		if cfg.FlushInterval <= 0 {
+			cfg.FlushInterval = 10 * time.Second
+		}
+		t.statsd = &cfg
+	}
+}
+
+// statsdFlusher turns tracker totals into StatsD counters by sending the
+// change since the previous flush
+type statsdFlusher struct {
+	cfg  *StatsDConfig
+	tags string
+	last map[string]uint64
+}
+
+// line formats a single metric
+func (f *statsdFlusher) line(name string, value int64, kind string) string {
+	return fmt.Sprintf("%s%s:%d|%s%s", f.cfg.Prefix, name, value, kind, f.tags)
+}
+
+// counter returns the line for the change in a total since the last flush
+func (f *statsdFlusher) counter(name string, total uint64) string {
+	delta := total - f.last[name]
+	f.last[name] = total
+	return f.line(name, int64(delta), "c")
+}
+
+// statsdLoop sends metrics to the agent every flush interval
+func (t *IP404Tracker) statsdLoop() {
+	conn, err := net.Dial("udp", t.statsd.Addr)
+	if err != nil {
+		t.logger.Error("connecting to statsd failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	f := &statsdFlusher{cfg: t.statsd, last: make(map[string]uint64)}
+	if len(t.statsd.Tags) > 0 {
+		f.tags = "|#" + strings.Join(t.statsd.Tags, ",")
+	}
+
+	ticker, stop := t.clock.NewTicker(t.statsd.FlushInterval)
+	defer stop()
+
+	for {
+		select {
+		case <-ticker:
+			f.flush(t, conn)
+		case <-t.done:
+			// Send what was counted since the last flush
+			f.flush(t, conn)
+			return
+		}
+	}
+}
+
+// flush sends the current metrics over conn
+func (f *statsdFlusher) flush(t *IP404Tracker, conn net.Conn) {
+	lines := []string{
+		f.counter("recorded_404s", t.counters.recorded404s.Load()),
+		f.counter("bans", t.counters.bansIssued.Load()),
+		f.counter("blocked_requests", t.counters.blockedRequests.Load()),
+		f.counter("whitelisted_requests", t.counters.whitelistedHits.Load()),
+		f.counter("rate_limited_requests", t.counters.rateLimited.Load()),
+		f.counter("evicted_clients", t.counters.evictions.Load()),
+		f.counter("async_dropped", t.counters.asyncDropped.Load()),
+		f.line("banned_ips", int64(t.bannedCount()), "g"),
+		f.line("tracked_ips", int64(t.trackedIPs()), "g"),
+	}
+	for _, counter := range t.statusRules {
+		lines = append(lines, f.counter("recorded_"+counter.rule.Name, counter.recorded.Load()))
+	}
+
+	// UDP is fire-and-forget; a missing agent only shows up as write errors
+	if _, err := conn.Write([]byte(strings.Join(lines, "\n"))); err != nil {
+		t.logger.Error("sending statsd metrics failed", "error", err)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RuleAction is what a status rule does once a client crosses its threshold
+type RuleAction string
+
+const (
+	// RuleBan bans the client
+	RuleBan RuleAction = "ban"
+	// RuleAlert only logs and emits an EventThresholdExceeded
+	RuleAlert RuleAction = "alert"
+)
+
+// StatusRule counts responses matching it against a threshold of its own,
+// e.g. 401 and 403 for credential stuffing and forced browsing, 405 for
+// method probing or 500-599 for clients that keep crashing the app. A rule
+// matches Status exactly, the inclusive range Min to Max, or whatever Match
+// accepts. 404s always follow the tracker's own threshold and window too.
+type StatusRule struct {
+	Status   int
+	Min, Max int
+	Match    func(status int) bool
+
+	// Names the rule in ban reasons and metrics (default: the status or
+	// range, or "rule<n>" for Match rules)
+	Name string
+
+	Weight    int           // How much each response counts (default 1)
+	Threshold int           // Weight allowed within Window; more crosses it
+	Window    time.Duration // Default: the tracker's window
+
+	// Further thresholds over windows of their own, see WithWindows
+	Windows []WindowLimit
+
+	// Default: the tracker's ban duration, or what escalation says
+	BanDuration time.Duration
+
+	Action RuleAction // Default RuleBan
+}
+
+// matches reports whether the rule applies to status
+func (r StatusRule) matches(status int) bool {
+	switch {
+	case r.Match != nil:
+		return r.Match(status)
+	case r.Status != 0:
+		return status == r.Status
+	}
+	return r.Min != 0 && status >= r.Min && status <= r.Max
+}
+
+// defaultName names a rule after what it matches
+func (r StatusRule) defaultName() string {
+	switch {
+	case r.Match != nil:
+		return ""
+	case r.Status != 0:
+		return strconv.Itoa(r.Status)
+	}
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// WithStatusRules tracks responses by status on top of 404s. Every rule a
+// response matches counts it. The counts are kept in memory on each
+// instance; the bans rules issue go to the store like any other.
+func WithStatusRules(rules ...StatusRule) Option {
+	return func(t *IP404Tracker) {
+		for _, rule := range rules {
+			if rule.Name == "" {
+				rule.Name = rule.defaultName()
+			}
+			if rule.Name == "" {
+				rule.Name = fmt.Sprintf("rule%d", len(t.statusRules)+1)
+			}
+			if t.statusRule(rule.Name) != nil {
+				t.logger.Warn("skipping status rule with a duplicate name", "rule", rule.Name)
+				continue
+			}
+			if rule.Weight <= 0 {
+				rule.Weight = 1
+			}
+			if rule.Window <= 0 {
+				rule.Window = t.limits.Load().window
+			}
+			if rule.Action == "" {
+				rule.Action = RuleBan
+			}
+			t.statusRules = append(t.statusRules, newStatusCounter(rule))
+		}
+	}
+}
+
+// statusCounter holds the recent responses of one status rule
+type statusCounter struct {
+	rule      StatusRule
+	retention time.Duration // The longest window of the rule
+	recorded  atomic.Uint64 // Tracked responses, for metrics
+
+	mu     sync.Mutex
+	counts map[string][]statusHit
+}
+
+// newStatusCounter returns an empty counter for rule
+func newStatusCounter(rule StatusRule) *statusCounter {
+	return &statusCounter{
+		rule:      rule,
+		retention: longestWindow(rule.Window, rule.Windows),
+		counts:    make(map[string][]statusHit),
+	}
+}
+
+// statusHit is a counted response
+type statusHit struct {
+	at     time.Time
+	weight int
+}
+
+// record adds a response of weight for key at now and returns the weight
+// key has collected within the window
+func (s *statusCounter) record(key string, now time.Time, weight int) int {
+	windowStart := now.Add(-s.rule.Window)
+	keepFrom := now.Add(-s.retention)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		recent []statusHit
+		total  int
+	)
+	for _, hit := range s.counts[key] {
+		if hit.at.After(keepFrom) {
+			recent = append(recent, hit)
+		}
+		if hit.at.After(windowStart) {
+			total += hit.weight
+		}
+	}
+	recent = append(recent, statusHit{at: now, weight: weight})
+	s.counts[key] = recent
+	return total + weight
+}
+
+// overWindows returns the weight key collected within the first of the
+// rule's extra windows it's over, with that window's threshold and length;
+// the length is 0 when it's over none
+func (s *statusCounter) overWindows(key string, now time.Time) (count, threshold int, window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, limit := range s.rule.Windows {
+		windowStart := now.Add(-limit.Window)
+		total := 0
+		for _, hit := range s.counts[key] {
+			if hit.at.After(windowStart) {
+				total += hit.weight
+			}
+		}
+		if total > limit.Threshold {
+			return total, limit.Threshold, limit.Window
+		}
+	}
+	return 0, 0, 0
+}
+
+// cleanup forgets responses that left the window
+func (s *statusCounter) cleanup(now time.Time) {
+	windowStart := now.Add(-s.retention)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, hits := range s.counts {
+		if len(hits) == 0 || !hits[len(hits)-1].at.After(windowStart) {
+			delete(s.counts, key)
+		}
+	}
+}
+
+// clear forgets the responses of every key matching
+func (s *statusCounter) clear(matching func(key string) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.counts {
+		if matching(key) {
+			delete(s.counts, key)
+		}
+	}
+}
+
+// handleResponse runs the response a request got through the 404 tracking,
+// the method rules and the status rules
+func (t *IP404Tracker) handleResponse(req clientRequest, status int) {
+	if t.deferred(req, status) {
+		return
+	}
+	if status == 404 {
+		t.pathStats.record(req.path, t.clock.Now())
+	}
+	switch {
+	case status >= 400 && t.methodRules.anomalous(req.method):
+		t.recordMethodAnomaly(req)
+	case status == 404:
+		t.handle404(req, 0)
+	}
+
+	var matched []*statusCounter
+	for _, counter := range t.statusRules {
+		if counter.rule.matches(status) {
+			matched = append(matched, counter)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+	if t.crawlers.verify(req.ctx, req.ip, req.userAgent, t.clock.Now(), t.logger) {
+		return
+	}
+	if t.IsWhitelisted(req.ip) || t.IsBanned(req.key) {
+		return
+	}
+
+	key := t.trackingKey(req.key)
+	t.track(key)
+	now := t.clock.Now()
+	for _, counter := range matched {
+		rule := counter.rule
+		count := counter.record(key, now, rule.Weight)
+		counter.recorded.Add(1)
+		reason := rule.Name + " threshold exceeded"
+		threshold := rule.Threshold
+		if count <= threshold {
+			var window time.Duration
+			if count, threshold, window = counter.overWindows(key, now); window == 0 {
+				continue
+			}
+			reason = windowReason(reason, window)
+		}
+
+		if rule.Action == RuleAlert {
+			// Once per crossing, not for every response after it
+			if count-rule.Weight <= threshold {
+				t.logger.Warn("threshold exceeded", "ip", key, "path", req.path, "rule", rule.Name, "count", count)
+				t.emit(Event{Type: EventThresholdExceeded, IP: key, Reason: reason, Path: req.path, Count: count, Rule: rule.Name})
+			}
+			continue
+		}
+		t.banAutomatic(key, req.path, count, rule.Name, reason, now)
+		// One ban is enough
+		return
+	}
+}
+
+// statusRule returns the rule called name, if any
+func (t *IP404Tracker) statusRule(name string) *statusCounter {
+	for _, counter := range t.statusRules {
+		if counter.rule.Name == name {
+			return counter
+		}
+	}
+	return nil
+}
+
+// ruleCounter returns the status rule or route policy called name, if any
+func (t *IP404Tracker) ruleCounter(name string) *statusCounter {
+	if counter := t.statusRule(name); counter != nil {
+		return counter
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if p, ok := t.policies[name]; ok {
+		return p.counter
+	}
+	return nil
+}
+
+// ruleBanDuration returns how long the offense'th ban by the named rule or
+// policy, or for 404s when it's empty, lasts: the rule's ban duration
+// unless it's a permanent ban
+func (t *IP404Tracker) ruleBanDuration(name string, offense int) time.Duration {
+	if name == "" || t.permanentFor(offense) {
+		return t.banDurationFor(offense)
+	}
+	if counter := t.ruleCounter(name); counter != nil && counter.rule.BanDuration > 0 {
+		return counter.rule.BanDuration
+	}
+	return t.banDurationFor(offense)
+}
+
+// ruleCounters returns the counters of the status rules and route policies
+func (t *IP404Tracker) ruleCounters() []*statusCounter {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	counters := append([]*statusCounter(nil), t.statusRules...)
+	for _, p := range t.policies {
+		counters = append(counters, p.counter)
+	}
+	return counters
+}
+
+// cleanupStatusCounts forgets responses that left their rule's window
+func (t *IP404Tracker) cleanupStatusCounts(now time.Time) {
+	for _, counter := range t.ruleCounters() {
+		counter.cleanup(now)
+	}
+}
+
+// clearStatusCounts forgets the tracked responses of every key matching
+func (t *IP404Tracker) clearStatusCounts(matching func(key string) bool) {
+	for _, counter := range t.ruleCounters() {
+		counter.clear(matching)
+	}
+}
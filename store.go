@@ -0,0 +1,415 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// BanStore is the storage backend used by IP404Tracker to keep 404 counts and bans
+type BanStore interface {
+	// Record404 records weight 404s for ip at the given time and returns the
+	// number of 404s recorded for ip within the window
+	Record404(ip string, at time.Time, window time.Duration, weight int) (int, error)
+
+	// Count404s returns the number of 404s recorded for ip within the
+	// window, without recording one
+	Count404s(ip string, now time.Time, window time.Duration) (int, error)
+
+	// IsBanned reports whether ip is banned at the given time
+	IsBanned(ip string, now time.Time) (bool, error)
+
+	// GetBan returns the ban on ip active at the given time, if any
+	GetBan(ip string, now time.Time) (BanRecord, bool, error)
+
+	// Ban bans ip until record.ExpiresAt, replacing any existing ban
+	Ban(ip string, record BanRecord) error
+
+	// Unban lifts any ban on ip
+	Unban(ip string) error
+
+	// ListBans returns the bans active at the given time
+	ListBans(now time.Time) (map[string]BanRecord, error)
+
+	// ClearCounts forgets every 404 recorded for ip
+	ClearCounts(ip string) error
+
+	// ListCounts returns the 404 timestamps recorded within the window for every IP
+	ListCounts(now time.Time, window time.Duration) (map[string][]time.Time, error)
+
+	// RecordOffense records that ip was banned at the given time, remembering
+	// the offense for the given duration, and returns how many offenses are
+	// remembered for ip including this one
+	RecordOffense(ip string, at time.Time, memory time.Duration) (int, error)
+
+	// ClearOffenses forgets every offense recorded for ip
+	ClearOffenses(ip string) error
+
+	// Cleanup removes 404 counts older than the window, expired bans and
+	// forgotten offenses, returning the IPs whose bans it removed
+	Cleanup(now time.Time, window time.Duration) ([]string, error)
+}
+
+// memoryShards is how many lock-striped shards a MemoryStore splits IPs over
+const memoryShards = 32
+
+// defaultCountResolution is how long the 404 count buckets of a MemoryStore
+// are when not configured
+const defaultCountResolution = time.Second
+
+// MemoryStore is the default in-process BanStore. IPs are spread over
+// shards with a lock each, so concurrent requests from different clients
+// rarely wait for each other. 404s are counted in buckets of a fixed length
+// rather than kept one by one, so a flood of them costs no allocations.
+type MemoryStore struct {
+	shards     [memoryShards]memoryShard
+	resolution int64 // Bucket length in nanoseconds
+
+	// Answers ban checks for IPs that aren't banned without locking
+	filter banFilter
+}
+
+// memoryShard holds the state of the IPs hashing to it
+type memoryShard struct {
+	// Mutex for thread safety
+	mu sync.RWMutex
+
+	// Map to track 404 counts by IP
+	counts map[string]countBuckets
+
+	// Map to track shadow-banned IPs and why and until when they are banned
+	bans map[string]BanRecord
+
+	// Map to track when each past ban of an IP will be forgotten
+	offenses map[string][]time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store counting 404s by the second
+func NewMemoryStore() *MemoryStore {
+	return NewMemoryStoreWithResolution(defaultCountResolution)
+}
+
+// NewMemoryStoreWithResolution creates an empty in-memory store counting
+// 404s in buckets of resolution. Counts over a window are exact for whole
+// buckets; the oldest bucket, which the window only partly covers, counts
+// in proportion. Longer buckets take less memory per IP.
+func NewMemoryStoreWithResolution(resolution time.Duration) *MemoryStore {
+	if resolution <= 0 {
+		resolution = defaultCountResolution
+	}
+	s := &MemoryStore{resolution: int64(resolution)}
+	for i := range s.shards {
+		s.shards[i] = memoryShard{
+			counts:   make(map[string]countBuckets),
+			bans:     make(map[string]BanRecord),
+			offenses: make(map[string][]time.Time),
+		}
+	}
+	return s
+}
+
+// shard returns the shard holding ip
+func (s *MemoryStore) shard(ip string) *memoryShard {
+	return &s.shards[shardIndex(ip, memoryShards)]
+}
+
+// Record404 implements BanStore
+func (s *MemoryStore) Record404(ip string, at time.Time, window time.Duration, weight int) (int, error) {
+	windowStart := at.Add(-window)
+
+	sh := s.shard(ip)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	// Drop buckets outside the window and count into the one of at
+	buckets := sh.counts[ip].trim(windowStart.UnixNano(), s.resolution)
+	buckets = buckets.add(at.UnixNano()-at.UnixNano()%s.resolution, weight)
+	sh.counts[ip] = buckets
+
+	return buckets.total(at.UnixNano(), windowStart.UnixNano(), s.resolution), nil
+}
+
+// Count404s implements BanStore
+func (s *MemoryStore) Count404s(ip string, now time.Time, window time.Duration) (int, error) {
+	windowStart := now.Add(-window)
+
+	sh := s.shard(ip)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	return sh.counts[ip].total(now.UnixNano(), windowStart.UnixNano(), s.resolution), nil
+}
+
+// IsBanned implements BanStore
+func (s *MemoryStore) IsBanned(ip string, now time.Time) (bool, error) {
+	if !s.filter.mayContain(ip) {
+		return false, nil
+	}
+	sh := s.shard(ip)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	record, exists := sh.bans[ip]
+	return exists && record.activeAt(now), nil
+}
+
+// GetBan implements BanStore
+func (s *MemoryStore) GetBan(ip string, now time.Time) (BanRecord, bool, error) {
+	if !s.filter.mayContain(ip) {
+		return BanRecord{}, false, nil
+	}
+	sh := s.shard(ip)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	if record, exists := sh.bans[ip]; exists && record.activeAt(now) {
+		return record, true, nil
+	}
+	return BanRecord{}, false, nil
+}
+
+// Ban implements BanStore
+func (s *MemoryStore) Ban(ip string, record BanRecord) error {
+	sh := s.shard(ip)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if _, exists := sh.bans[ip]; !exists {
+		s.filter.add(ip)
+	}
+	sh.bans[ip] = record
+	return nil
+}
+
+// Unban implements BanStore
+func (s *MemoryStore) Unban(ip string) error {
+	sh := s.shard(ip)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if _, exists := sh.bans[ip]; exists {
+		delete(sh.bans, ip)
+		s.filter.remove(ip)
+	}
+	return nil
+}
+
+// ListBans implements BanStore
+func (s *MemoryStore) ListBans(now time.Time) (map[string]BanRecord, error) {
+	result := make(map[string]BanRecord)
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		for ip, record := range sh.bans {
+			if record.activeAt(now) {
+				result[ip] = record
+			}
+		}
+		sh.mu.RUnlock()
+	}
+
+	return result, nil
+}
+
+// ClearCounts implements BanStore
+func (s *MemoryStore) ClearCounts(ip string) error {
+	sh := s.shard(ip)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	delete(sh.counts, ip)
+	return nil
+}
+
+// ListCounts implements BanStore. Each 404 is listed at the start of its
+// bucket, or just inside the window for the partly covered oldest bucket.
+func (s *MemoryStore) ListCounts(now time.Time, window time.Duration) (map[string][]time.Time, error) {
+	windowStart := now.Add(-window)
+
+	result := make(map[string][]time.Time)
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		for ip, buckets := range sh.counts {
+			if timestamps := buckets.times(now.UnixNano(), windowStart.UnixNano(), s.resolution); len(timestamps) > 0 {
+				result[ip] = timestamps
+			}
+		}
+		sh.mu.RUnlock()
+	}
+
+	return result, nil
+}
+
+// RecordOffense implements BanStore
+func (s *MemoryStore) RecordOffense(ip string, at time.Time, memory time.Duration) (int, error) {
+	sh := s.shard(ip)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	sh.offenses[ip] = append(rememberedOffenses(sh.offenses[ip], at), at.Add(memory))
+	return len(sh.offenses[ip]), nil
+}
+
+// rememberedOffenses drops the offenses forgotten by now
+func rememberedOffenses(forgetAt []time.Time, now time.Time) []time.Time {
+	var remembered []time.Time
+	for _, ts := range forgetAt {
+		if ts.After(now) {
+			remembered = append(remembered, ts)
+		}
+	}
+	return remembered
+}
+
+// ClearOffenses implements BanStore
+func (s *MemoryStore) ClearOffenses(ip string) error {
+	sh := s.shard(ip)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	delete(sh.offenses, ip)
+	return nil
+}
+
+// Cleanup implements BanStore
+func (s *MemoryStore) Cleanup(now time.Time, window time.Duration) ([]string, error) {
+	var expired []string
+	for i := range s.shards {
+		expired = append(expired, s.shards[i].cleanup(now, window, s.resolution, &s.filter)...)
+	}
+	return expired, nil
+}
+
+// cleanup removes the shard's old counts, offenses and bans, one shard at a
+// time so requests for the others aren't held up
+func (sh *memoryShard) cleanup(now time.Time, window time.Duration, resolution int64, filter *banFilter) []string {
+	windowCutoff := now.Add(-window).UnixNano()
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	// Clean up expired 404 counts
+	for ip, buckets := range sh.counts {
+		if buckets = buckets.trim(windowCutoff, resolution); len(buckets) == 0 {
+			delete(sh.counts, ip)
+		} else {
+			sh.counts[ip] = buckets
+		}
+	}
+
+	// Forget old offenses
+	for ip, forgetAt := range sh.offenses {
+		if remembered := rememberedOffenses(forgetAt, now); len(remembered) == 0 {
+			delete(sh.offenses, ip)
+		} else {
+			sh.offenses[ip] = remembered
+		}
+	}
+
+	// Clean up expired bans
+	var expired []string
+	for ip, record := range sh.bans {
+		if record.ExpiresAt.Before(now) {
+			delete(sh.bans, ip)
+			filter.remove(ip)
+			expired = append(expired, ip)
+		}
+	}
+
+	return expired
+}
+
+// size estimates the memory the store holds
+func (s *MemoryStore) size() int64 {
+	var size int64
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		for _, buckets := range sh.counts {
+			size += mapEntrySize + int64(cap(buckets))*countBucketSize
+		}
+		for _, forgetAt := range sh.offenses {
+			size += mapEntrySize + int64(len(forgetAt))*timestampSize
+		}
+		size += int64(len(sh.bans)) * (mapEntrySize + banRecordSize)
+		sh.mu.RUnlock()
+	}
+	return size
+}
+
+// countBucket holds the 404s of one bucket, by the Unix nanosecond it starts at
+type countBucket struct {
+	start int64
+	count int
+}
+
+// Size of a countBucket, for memory estimates
+const countBucketSize = 16
+
+// countBuckets are the 404 buckets of one IP, oldest first
+type countBuckets []countBucket
+
+// trim drops the buckets that end by windowStart, reusing the slice
+func (b countBuckets) trim(windowStart, resolution int64) countBuckets {
+	i := 0
+	for i < len(b) && b[i].start+resolution <= windowStart {
+		i++
+	}
+	if i == 0 {
+		return b
+	}
+	return b[:copy(b, b[i:])]
+}
+
+// add counts weight 404s into the bucket starting at start
+func (b countBuckets) add(start int64, weight int) countBuckets {
+	// Almost always the newest bucket, or a new one after it
+	i := len(b)
+	for i > 0 && b[i-1].start > start {
+		i--
+	}
+	if i > 0 && b[i-1].start == start {
+		b[i-1].count += weight
+		return b
+	}
+	b = append(b, countBucket{})
+	copy(b[i+1:], b[i:])
+	b[i] = countBucket{start: start, count: weight}
+	return b
+}
+
+// share returns how much of the bucket starting at start the window from
+// windowStart to now covers: all of it unless the window starts inside it,
+// and the bucket of now, which is still filling, always counts in full
+func share(start, now, windowStart, resolution int64) float64 {
+	end := start + resolution
+	switch {
+	case end <= windowStart:
+		return 0
+	case start >= windowStart || end > now:
+		return 1
+	}
+	return float64(end-windowStart) / float64(resolution)
+}
+
+// total returns the 404s within the window from windowStart to now
+func (b countBuckets) total(now, windowStart, resolution int64) int {
+	var total float64
+	for _, bucket := range b {
+		total += float64(bucket.count) * share(bucket.start, now, windowStart, resolution)
+	}
+	return int(total)
+}
+
+// times lists the 404s within the window as timestamps
+func (b countBuckets) times(now, windowStart, resolution int64) []time.Time {
+	var timestamps []time.Time
+	for _, bucket := range b {
+		n := int(float64(bucket.count) * share(bucket.start, now, windowStart, resolution))
+		at := time.Unix(0, max(bucket.start, windowStart+1))
+		for range n {
+			timestamps = append(timestamps, at)
+		}
+	}
+	return timestamps
+}
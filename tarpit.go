@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// TarpitConfig holds banned clients' connections open before their response
+// goes out, longer with each blocked request, so scanners spend their time
+// waiting instead of moving on to the next target
+type TarpitConfig struct {
+	Base          time.Duration // Delay of a client's first blocked request (default 1s)
+	Multiplier    float64       // Growth with each blocked request after it (default 2)
+	Max           time.Duration // Longest delay (default 30s)
+	MaxConcurrent int           // Connections held at once; more are answered right away (default 256)
+}
+
+// tarpit holds the settings and how many connections are being held
+type tarpit struct {
+	cfg  TarpitConfig
+	held atomic.Int64
+}
+
+// WithTarpit delays the response to banned clients, whatever the response is
+func WithTarpit(cfg TarpitConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.Base <= 0 {
+			cfg.Base = time.Second
+		}
+		if cfg.Multiplier < 1 {
+			cfg.Multiplier = 2
+		}
+		if cfg.Max <= 0 {
+			cfg.Max = 30 * time.Second
+		}
+		if cfg.MaxConcurrent <= 0 {
+			cfg.MaxConcurrent = 256
+		}
+		t.tarpit = &tarpit{cfg: cfg}
+	}
+}
+
+// delay returns how long to hold the n'th blocked request of a client
+func (p *tarpit) delay(n int) time.Duration {
+	d := float64(p.cfg.Base) * math.Pow(p.cfg.Multiplier, float64(max(n-1, 0)))
+	if d >= float64(p.cfg.Max) {
+		return p.cfg.Max
+	}
+	return time.Duration(d)
+}
+
+// holdBanned waits out the tarpit delay for a blocked request, giving up
+// early when the client disconnects or the tracker shuts down
+func (t *IP404Tracker) holdBanned(ctx context.Context, blocked BlockedRequest) {
+	p := t.tarpit
+	// People sent to the challenge shouldn't wait to get there
+	if p == nil || t.challengeable(blocked) {
+		return
+	}
+	// Don't let a flood of banned connections exhaust the server
+	if p.held.Add(1) > int64(p.cfg.MaxConcurrent) {
+		p.held.Add(-1)
+		return
+	}
+	defer p.held.Add(-1)
+
+	n, _ := t.bannedRequest.get(t.trackingKey(blocked.Key))
+	delay := p.delay(n.requests)
+
+	// The client waits in real time, so this timer isn't the tracker's clock
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	case <-t.done:
+	}
+	t.logger.Debug("tarpitted request", "ip", blocked.IP, "key", blocked.Key, "delay", delay.String())
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// UniquePathConfig catches scanners pacing themselves under the threshold
+// by the number of distinct paths they get 404s for over a longer window
+type UniquePathConfig struct {
+	Limit  int           // Distinct 404 paths allowed within Window; more bans
+	Window time.Duration // Default 1h
+}
+
+// uniquePaths holds the distinct 404 paths of each client, as hashes. A
+// set never grows past the limit plus one, since crossing it bans.
+type uniquePaths struct {
+	cfg UniquePathConfig
+
+	mu   sync.Mutex
+	sets map[string]map[uint64]time.Time // Path hash to when it was last seen
+}
+
+// WithUniquePathLimit bans clients getting 404s for more than cfg.Limit
+// distinct paths within cfg.Window, however slowly they ask
+func WithUniquePathLimit(cfg UniquePathConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.Window <= 0 {
+			cfg.Window = time.Hour
+		}
+		t.uniquePaths = &uniquePaths{cfg: cfg, sets: make(map[string]map[uint64]time.Time)}
+	}
+}
+
+// record adds path to the paths of key and returns how many distinct ones
+// key has within the window
+func (u *uniquePaths) record(key, path string, now time.Time) int {
+	h := fnv.New64a()
+	h.Write([]byte(path))
+	sum := h.Sum64()
+	windowStart := now.Add(-u.cfg.Window)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	set := u.sets[key]
+	if set == nil {
+		set = make(map[uint64]time.Time)
+		u.sets[key] = set
+	}
+	if _, seen := set[sum]; !seen && len(set) >= u.cfg.Limit {
+		// Only make room when the set is full
+		for hash, at := range set {
+			if !at.After(windowStart) {
+				delete(set, hash)
+			}
+		}
+	}
+	set[sum] = now
+	return len(set)
+}
+
+// cleanup forgets paths that left the window
+func (u *uniquePaths) cleanup(now time.Time) {
+	if u == nil {
+		return
+	}
+	windowStart := now.Add(-u.cfg.Window)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for key, set := range u.sets {
+		for hash, at := range set {
+			if !at.After(windowStart) {
+				delete(set, hash)
+			}
+		}
+		if len(set) == 0 {
+			delete(u.sets, key)
+		}
+	}
+}
+
+// clear forgets the paths of every key matching
+func (u *uniquePaths) clear(matching func(key string) bool) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for key := range u.sets {
+		if matching(key) {
+			delete(u.sets, key)
+		}
+	}
+}
+
+// checkUniquePaths records a 404 for path by the tracking key and bans the
+// client when it crossed the unique path limit, reporting whether it did
+func (t *IP404Tracker) checkUniquePaths(key, path string, now time.Time) bool {
+	if t.uniquePaths == nil || path == "" {
+		return false
+	}
+	count := t.uniquePaths.record(key, path, now)
+	if count <= t.uniquePaths.cfg.Limit {
+		return false
+	}
+	t.banAutomatic(key, path, count, "", BanReasonUniquePaths, now)
+	return true
+}
@@ -0,0 +1,48 @@
+package main
+
+// WithWarningThreshold gives clients a chance to back off before they're
+// banned: once a client has more than threshold 404s in the window, every
+// request it makes is answered 429 Too Many Requests with a Retry-After of
+// the window, until enough of its 404s leave the window. Requests made in
+// spite of the warning count as 404s, so a client that ignores it is still
+// banned at the ban threshold. The warning threshold never exceeds the ban
+// threshold that applies to the client, e.g. while it's on probation.
+func WithWarningThreshold(threshold int) Option {
+	return func(t *IP404Tracker) {
+		if threshold > 0 {
+			t.warningThreshold = threshold
+		}
+	}
+}
+
+// warned returns the response for req when its client is over the warning
+// threshold, counting the request as a 404
+func (t *IP404Tracker) warned(req clientRequest) (BanResponse, bool) {
+	if t.warningThreshold == 0 || t.IsWhitelisted(req.ip) || t.excluded(req.path) {
+		return BanResponse{}, false
+	}
+
+	key := t.trackingKey(req.key)
+	now := t.clock.Now()
+	window := t.limits.Load().window
+	count, err := t.store.Count404s(key, now, window)
+	if err != nil {
+		t.logger.Error("counting 404s failed", "ip", key, "error", err)
+		return BanResponse{}, false
+	}
+	if count <= min(t.warningThreshold, t.thresholdFor(key, now)) {
+		return BanResponse{}, false
+	}
+	if t.cookieChallenge.passed(req, now) {
+		return BanResponse{}, false
+	}
+
+	t.counters.rateLimited.Add(1)
+	if t.dryRun {
+		t.logger.Info("request would have been warned", "ip", key, "path", req.path, "count", count)
+		return BanResponse{}, false
+	}
+	t.logger.Debug("warned request", "ip", key, "path", req.path, "count", count)
+	t.handle404(req, 0)
+	return tooManyRequests(window), true
+}
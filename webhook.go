@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// webhookBuffer is how many ban changes and alerts may queue up while
+// deliveries are retried
+const webhookBuffer = 1024
+
+// webhookEvents are the event types POSTed to the webhook
+var webhookEvents = []EventType{EventBanned, EventUnbanned, EventBanExpired, EventThresholdExceeded, EventCampaignDetected, EventCircuitTripped}
+
+// WebhookSignatureHeader carries the hex HMAC-SHA256 of the request body, as "sha256=<hex>"
+const WebhookSignatureHeader = "X-404Blocker-Signature"
+
+// WebhookConfig configures the ban notification webhook
+type WebhookConfig struct {
+	URL        string        // Endpoint receiving the POSTs
+	Secret     []byte        // Key for signing payloads (unsigned when empty)
+	MaxRetries int           // Extra attempts after a failed delivery (default 3)
+	Timeout    time.Duration // Per-attempt timeout (default 5s)
+}
+
+// WebhookPayload is the JSON body POSTed for each ban change and alert
+type WebhookPayload struct {
+	Event     EventType          `json:"event"` // banned, unbanned, ban_expired, threshold_exceeded or campaign_detected
+	IP        string             `json:"ip"`
+	Reason    string             `json:"reason,omitempty"`
+	Count     int                `json:"count,omitempty"`
+	Rule      string             `json:"rule,omitempty"` // Status rule behind a ban or alert
+	Paths     []string           `json:"paths,omitempty"`
+	Requests  []OffendingRequest `json:"requests,omitempty"` // The paths with times and user agents
+	ExpiresAt time.Time          `json:"expires_at,omitzero"`
+	Timestamp time.Time          `json:"timestamp"`
+}
+
+// WithWebhook POSTs a JSON payload to a URL whenever an IP is banned or unbanned
+func WithWebhook(cfg WebhookConfig) Option {
+	return func(t *IP404Tracker) {
+		if cfg.MaxRetries <= 0 {
+			cfg.MaxRetries = 3
+		}
+		if cfg.Timeout <= 0 {
+			cfg.Timeout = 5 * time.Second
+		}
+		t.webhook = &cfg
+	}
+}
+
+// webhookLoop delivers the webhookEvents one at a time
+func (t *IP404Tracker) webhookLoop(events <-chan Event) {
+	client := &http.Client{Timeout: t.webhook.Timeout}
+	defer client.CloseIdleConnections()
+
+	for event := range events {
+		body, err := json.Marshal(WebhookPayload{
+			Event:     event.Type,
+			IP:        event.IP,
+			Reason:    event.Reason,
+			Count:     event.Count,
+			Rule:      event.Rule,
+			Paths:     event.Paths,
+			Requests:  event.Requests,
+			ExpiresAt: event.ExpiresAt,
+			Timestamp: event.Time,
+		})
+		if err != nil {
+			t.logger.Error("encoding webhook payload failed", "error", err)
+			continue
+		}
+
+		if err := t.deliverWebhook(client, body); err != nil {
+			t.logger.Error("webhook delivery failed", "ip", event.IP, "event", event.Type, "error", err)
+		}
+	}
+}
+
+// deliverWebhook POSTs body, retrying with exponential backoff on network
+// errors, 5xx and 429 responses
+func (t *IP404Tracker) deliverWebhook(client *http.Client, body []byte) error {
+	var signature string
+	if len(t.webhook.Secret) > 0 {
+		mac := hmac.New(sha256.New, t.webhook.Secret)
+		mac.Write(body)
+		signature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	backoff := time.Second
+	var lastErr error
+	for attempt := 0; attempt <= t.webhook.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if !t.sleep(backoff) {
+				break // Shutting down
+			}
+			backoff *= 2
+		}
+
+		req, err := http.NewRequest(http.MethodPost, t.webhook.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if signature != "" {
+			req.Header.Set(WebhookSignatureHeader, signature)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			lastErr = fmt.Errorf("webhook returned %s", resp.Status)
+		default:
+			return fmt.Errorf("webhook returned %s", resp.Status) // Retrying won't help
+		}
+	}
+
+	return lastErr
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// whitelistSource records how a whitelist entry was added, so reloading the
+// whitelist file only replaces the entries that came from it
+type whitelistSource uint8
+
+const (
+	whitelistManual    whitelistSource = 1 << iota // Hardcoded or AddToWhitelist
+	whitelistFile                                  // WithWhitelistFile
+	whitelistHostname                              // Resolved hostname entries
+	whitelistTemporary                             // AddToWhitelistFor
+	whitelistConfig                                // Config.Whitelist
+)
+
+// WithWhitelistFile loads whitelist entries from path and reloads them
+// whenever the file changes. The file holds one IP or CIDR per line; blank
+// lines and anything after a '#' are ignored.
+func WithWhitelistFile(path string) Option {
+	return func(t *IP404Tracker) {
+		t.whitelistPath = path
+	}
+}
+
+// WithPrivateExemption whitelists RFC 1918 and IPv6 unique local addresses,
+// link-local addresses and loopback, so health checks, local reverse proxies
+// and internal monitoring are never banned.
+func WithPrivateExemption() Option {
+	return func(t *IP404Tracker) {
+		t.exemptPrivate = true
+	}
+}
+
+// isInternalAddr reports whether addr is covered by WithPrivateExemption
+func isInternalAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast()
+}
+
+// addWhitelistEntry whitelists prefix on behalf of source
+func (t *IP404Tracker) addWhitelistEntry(prefix netip.Prefix, source whitelistSource) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sources, _ := t.whitelist.get(prefix)
+	t.whitelist.insert(prefix, sources|source)
+	t.listsChanged()
+}
+
+// replaceWhitelistSource makes prefixes the only entries added by source,
+// keeping entries that were also added another way. The caller must hold t.mu.
+func (t *IP404Tracker) replaceWhitelistSource(source whitelistSource, prefixes []netip.Prefix) {
+	var stale []netip.Prefix
+	t.whitelist.walk(func(prefix netip.Prefix, sources whitelistSource) {
+		if sources&source != 0 {
+			stale = append(stale, prefix)
+		}
+	})
+	for _, prefix := range stale {
+		sources, _ := t.whitelist.get(prefix)
+		if sources &^= source; sources == 0 {
+			t.whitelist.remove(prefix)
+		} else {
+			t.whitelist.insert(prefix, sources)
+		}
+	}
+
+	for _, prefix := range prefixes {
+		sources, _ := t.whitelist.get(prefix)
+		t.whitelist.insert(prefix, sources|source)
+	}
+	t.listsChanged()
+}
+
+// AddToWhitelist exempts an IP, CIDR (e.g. "10.0.0.0/8") or hostname (e.g.
+// "office.example.com") from tracking and banning. Hostnames are resolved
+// right away and re-resolved whenever their DNS records expire.
+func (t *IP404Tracker) AddToWhitelist(ipOrCIDR string) error {
+	if isHostname(ipOrCIDR) {
+		t.addWhitelistHost(ipOrCIDR, whitelistManual)
+		return nil
+	}
+
+	prefix, err := parseIPOrCIDR(ipOrCIDR)
+	if err != nil {
+		return err
+	}
+
+	t.addWhitelistEntry(prefix, whitelistManual)
+	return nil
+}
+
+// AddToWhitelistFor exempts an IP or CIDR for the given duration, after
+// which the entry expires and normal tracking resumes. Whitelisting an entry
+// again restarts its timer; entries also whitelisted permanently stay.
+func (t *IP404Tracker) AddToWhitelistFor(ipOrCIDR string, duration time.Duration) error {
+	if isHostname(ipOrCIDR) {
+		return errors.New("hostnames can't be whitelisted temporarily")
+	}
+	prefix, err := parseIPOrCIDR(ipOrCIDR)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.whitelistExpiry[prefix] = t.clock.Now().Add(duration)
+	t.listsChanged()
+	t.mu.Unlock()
+	t.addWhitelistEntry(prefix, whitelistTemporary)
+	return nil
+}
+
+// whitelistActive reports whether a whitelist entry still applies. The
+// caller must hold t.mu.
+func (t *IP404Tracker) whitelistActive(prefix netip.Prefix, sources whitelistSource, now time.Time) bool {
+	return whitelistEntryActive(sources, t.whitelistExpiry[prefix], now)
+}
+
+// whitelistEntryActive reports whether an entry added by sources applies,
+// temporary entries only until their expiry
+func whitelistEntryActive(sources whitelistSource, until, now time.Time) bool {
+	return sources&^whitelistTemporary != 0 || now.Before(until)
+}
+
+// GetTemporaryWhitelist returns the temporary whitelist entries and when
+// they expire
+func (t *IP404Tracker) GetTemporaryWhitelist() map[string]time.Time {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	now := t.clock.Now()
+	result := make(map[string]time.Time, len(t.whitelistExpiry))
+	for prefix, until := range t.whitelistExpiry {
+		// Entries also whitelisted permanently never really expire
+		if sources, _ := t.whitelist.get(prefix); sources == whitelistTemporary && now.Before(until) {
+			result[prefixString(prefix)] = until
+		}
+	}
+	return result
+}
+
+// cleanupTemporaryWhitelist removes expired temporary entries and clears the
+// counters left over for them, returning the entries removed
+func (t *IP404Tracker) cleanupTemporaryWhitelist(now time.Time) []netip.Prefix {
+	var expired []netip.Prefix
+
+	t.mu.Lock()
+	for prefix, until := range t.whitelistExpiry {
+		if now.Before(until) {
+			continue
+		}
+		delete(t.whitelistExpiry, prefix)
+		sources, ok := t.whitelist.get(prefix)
+		if !ok {
+			continue
+		}
+		if sources &^= whitelistTemporary; sources == 0 {
+			t.whitelist.remove(prefix)
+			expired = append(expired, prefix)
+		} else {
+			t.whitelist.insert(prefix, sources)
+		}
+	}
+	if len(expired) > 0 {
+		t.listsChanged()
+	}
+	t.mu.Unlock()
+
+	for _, prefix := range expired {
+		t.clearCountersIn(prefix)
+		t.logger.Info("whitelist entry expired", "entry", prefixString(prefix))
+	}
+	return expired
+}
+
+// RemoveFromWhitelist removes an IP, CIDR or hostname entry from the
+// whitelist and clears any 404 history left over from before the entry was
+// added, so the addresses start tracking from a clean slate. Entries also
+// listed in the whitelist file come back the next time the file changes.
+func (t *IP404Tracker) RemoveFromWhitelist(ipOrCIDR string) error {
+	if isHostname(ipOrCIDR) {
+		for _, addr := range t.removeWhitelistHost(ipOrCIDR) {
+			t.clearCountersIn(netip.PrefixFrom(addr, addr.BitLen()))
+		}
+		return nil
+	}
+
+	prefix, err := parseIPOrCIDR(ipOrCIDR)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.whitelist.remove(prefix)
+	delete(t.whitelistExpiry, prefix)
+	t.listsChanged()
+	t.mu.Unlock()
+
+	t.clearCountersIn(prefix)
+	return nil
+}
+
+// clearCountersIn forgets 404 and status counts, paths, banned request
+// counters and probation of every tracked IP inside prefix
+func (t *IP404Tracker) clearCountersIn(prefix netip.Prefix) {
+	// Pseudonyms can't be matched against the range; clear the one it maps to
+	if key := t.trackingKey(prefixString(prefix)); pseudonymous(key) {
+		t.clearKeyCounters(key)
+	}
+
+	var ips []string
+	if prefix.IsSingleIP() && t.fingerprint == nil {
+		ips = []string{prefix.Addr().String()}
+	} else {
+		counts, err := t.store.ListCounts(t.clock.Now(), t.limits.Load().window)
+		if err != nil {
+			t.logger.Error("listing counts failed", "error", err)
+		}
+		for ip := range counts {
+			if keyInPrefix(ip, prefix) {
+				ips = append(ips, ip)
+			}
+		}
+	}
+
+	for _, ip := range ips {
+		if err := t.store.ClearCounts(ip); err != nil {
+			t.logger.Error("clearing counts failed", "ip", ip, "error", err)
+		}
+	}
+
+	t.endProbationIn(prefix)
+	t.clearStatusCounts(func(ip string) bool { return keyInPrefix(ip, prefix) })
+	t.uniquePaths.clear(func(ip string) bool { return keyInPrefix(ip, prefix) })
+	t.rateLimiter.clear(func(ip string) bool { return keyInPrefix(ip, prefix) })
+	t.paths.deleteKeys(func(ip string) bool { return keyInPrefix(ip, prefix) })
+	t.bannedRequest.deleteKeys(func(ip string) bool { return keyInPrefix(ip, prefix) })
+}
+
+// GetWhitelist returns the whitelist entries in sorted order; single IPs are
+// listed without their /32 or /128 suffix. Hostname entries are listed by
+// name rather than by the addresses they resolve to.
+func (t *IP404Tracker) GetWhitelist() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	now := t.clock.Now()
+	result := make([]string, 0, t.whitelist.len()+len(t.whitelistHosts))
+	t.whitelist.walk(func(prefix netip.Prefix, sources whitelistSource) {
+		if sources != whitelistHostname && t.whitelistActive(prefix, sources, now) {
+			result = append(result, prefixString(prefix))
+		}
+	})
+	for host := range t.whitelistHosts {
+		result = append(result, host)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// parseWhitelist reads one IP, CIDR or hostname per line, skipping blanks
+// and comments
+func parseWhitelist(r io.Reader) ([]netip.Prefix, []string, error) {
+	var (
+		prefixes []netip.Prefix
+		hosts    []string
+		errs     []error
+	)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if isHostname(entry) {
+			hosts = append(hosts, entry)
+			continue
+		}
+		prefix, err := parseIPOrCIDR(entry)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", line, err))
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return prefixes, hosts, errors.Join(errs...)
+}
+
+// loadWhitelistFile replaces the file-sourced whitelist entries with the
+// current contents of the whitelist file
+func (t *IP404Tracker) loadWhitelistFile() {
+	f, err := os.Open(t.whitelistPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		t.logger.Warn("whitelist file not found", "path", t.whitelistPath)
+	} else if err != nil {
+		t.logger.Error("opening whitelist file failed", "path", t.whitelistPath, "error", err)
+		return
+	}
+
+	var (
+		prefixes []netip.Prefix
+		hosts    []string
+	)
+	if f != nil {
+		prefixes, hosts, err = parseWhitelist(f)
+		f.Close()
+		if err != nil {
+			// Keep the valid lines; a typo shouldn't drop the whole whitelist
+			t.logger.Error("invalid whitelist file entries", "path", t.whitelistPath, "error", err)
+		}
+	}
+
+	t.mu.Lock()
+	t.replaceWhitelistSource(whitelistFile, prefixes)
+	t.mu.Unlock()
+	t.replaceWhitelistHosts(whitelistFile, hosts)
+
+	t.logger.Info("whitelist file loaded", "path", t.whitelistPath, "entries", len(prefixes)+len(hosts))
+}
+
+// watchWhitelistFile starts reloading the whitelist file when it changes.
+// The directory is watched rather than the file so editors that save by
+// renaming a temp file over the original are picked up too.
+func (t *IP404Tracker) watchWhitelistFile() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.logger.Error("watching whitelist file failed", "error", err)
+		return
+	}
+	if err := watcher.Add(filepath.Dir(t.whitelistPath)); err != nil {
+		watcher.Close()
+		t.logger.Error("watching whitelist file failed", "error", err)
+		return
+	}
+
+	t.background("whitelist_watch", func() { t.whitelistWatchLoop(watcher) })
+}
+
+// whitelistWatchLoop reloads the whitelist file on events for it
+func (t *IP404Tracker) whitelistWatchLoop(watcher *fsnotify.Watcher) {
+	defer watcher.Close()
+
+	// Editors often produce several events per save; reload once they settle.
+	// File events happen in real time, so this isn't the tracker's clock.
+	const settle = 100 * time.Millisecond
+	reload := time.NewTimer(settle)
+	reload.Stop()
+
+	name := filepath.Clean(t.whitelistPath)
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) == name {
+				reload.Reset(settle)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			t.logger.Error("watching whitelist file failed", "error", err)
+		case <-reload.C:
+			t.loadWhitelistFile()
+		case <-t.done:
+			return
+		}
+	}
+}
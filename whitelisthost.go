@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// Bounds on how long resolved hostname addresses are trusted, whatever
+	// the TTL of their records says
+	hostMinTTL = 30 * time.Second
+	hostMaxTTL = time.Hour
+
+	// Refresh interval when the system resolver, which hides TTLs, is used
+	hostDefaultTTL = 5 * time.Minute
+
+	// Delay before retrying a failed lookup
+	hostRetryInterval = 30 * time.Second
+
+	// Upper bound for resolving one hostname
+	hostLookupTimeout = 5 * time.Second
+)
+
+// errNoSuchHost is returned when a whitelisted hostname doesn't exist
+var errNoSuchHost = errors.New("no such host")
+
+// whitelistHost is a whitelisted hostname and the addresses it resolved to
+type whitelistHost struct {
+	sources whitelistSource
+	addrs   []netip.Addr
+	refresh time.Time // Zero until first resolved
+}
+
+// isHostname reports whether s looks like a DNS name rather than an IP or CIDR
+func isHostname(s string) bool {
+	s = strings.TrimSuffix(s, ".")
+	if len(s) == 0 || len(s) > 253 || !strings.Contains(s, ".") {
+		return false
+	}
+
+	letter := false
+	for _, label := range strings.Split(s, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+				letter = true
+			case r >= '0' && r <= '9', r == '-':
+			default:
+				return false
+			}
+		}
+	}
+
+	// Require a letter so dotted IPv4 addresses are never taken for names
+	return letter
+}
+
+// normalizeHost lowercases host and drops a trailing dot
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// addWhitelistHost whitelists host on behalf of source, resolving it right
+// away when it's new
+func (t *IP404Tracker) addWhitelistHost(host string, source whitelistSource) {
+	host = normalizeHost(host)
+
+	t.mu.Lock()
+	entry, ok := t.whitelistHosts[host]
+	if !ok {
+		entry = &whitelistHost{}
+		t.whitelistHosts[host] = entry
+	}
+	entry.sources |= source
+	t.mu.Unlock()
+
+	t.startHostRefresh()
+	if !ok {
+		t.resolveWhitelistHost(host)
+	}
+}
+
+// replaceWhitelistHosts makes hosts the only hostnames added by source
+func (t *IP404Tracker) replaceWhitelistHosts(source whitelistSource, hosts []string) {
+	wanted := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		wanted[normalizeHost(host)] = true
+	}
+
+	t.mu.Lock()
+	for host, entry := range t.whitelistHosts {
+		if entry.sources&source != 0 && !wanted[host] {
+			if entry.sources &^= source; entry.sources == 0 {
+				delete(t.whitelistHosts, host)
+			}
+		}
+	}
+	for host := range wanted {
+		entry, ok := t.whitelistHosts[host]
+		if !ok {
+			entry = &whitelistHost{}
+			t.whitelistHosts[host] = entry
+		}
+		entry.sources |= source
+	}
+	t.syncWhitelistHosts()
+	t.mu.Unlock()
+
+	// New hostnames are resolved by the refresh loop
+	if len(wanted) > 0 {
+		t.startHostRefresh()
+		t.wakeHostRefresh()
+	}
+}
+
+// removeWhitelistHost removes host from every source and returns the
+// addresses it no longer whitelists
+func (t *IP404Tracker) removeWhitelistHost(host string) []netip.Addr {
+	host = normalizeHost(host)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.whitelistHosts[host]
+	if !ok {
+		return nil
+	}
+	delete(t.whitelistHosts, host)
+	t.syncWhitelistHosts()
+
+	var removed []netip.Addr
+	for _, addr := range entry.addrs {
+		if _, still := t.whitelist.lookup(addr); !still {
+			removed = append(removed, addr)
+		}
+	}
+	return removed
+}
+
+// syncWhitelistHosts puts the current addresses of every whitelisted
+// hostname in the whitelist trie. The caller must hold t.mu.
+func (t *IP404Tracker) syncWhitelistHosts() {
+	var prefixes []netip.Prefix
+	for _, entry := range t.whitelistHosts {
+		for _, addr := range entry.addrs {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		}
+	}
+	t.replaceWhitelistSource(whitelistHostname, prefixes)
+}
+
+// startHostRefresh starts the hostname re-resolution loop the first time a
+// hostname is whitelisted
+func (t *IP404Tracker) startHostRefresh() {
+	t.hostsOnce.Do(func() {
+		t.background("whitelist_hosts", t.hostRefreshLoop)
+	})
+}
+
+// wakeHostRefresh makes the refresh loop look for due hostnames now
+func (t *IP404Tracker) wakeHostRefresh() {
+	select {
+	case t.hostsWake <- struct{}{}:
+	default:
+	}
+}
+
+// hostRefreshLoop re-resolves whitelisted hostnames as their records expire
+func (t *IP404Tracker) hostRefreshLoop() {
+	var wait time.Duration // Look right away the first time
+	for {
+		if wait > 0 && !t.waitForHosts(wait) {
+			return
+		}
+
+		// Resolve everything that's due and find when the next one is
+		now := t.clock.Now()
+		var due []string
+		next := now.Add(hostMaxTTL)
+		t.mu.RLock()
+		for host, entry := range t.whitelistHosts {
+			if !entry.refresh.After(now) {
+				due = append(due, host)
+			} else if entry.refresh.Before(next) {
+				next = entry.refresh
+			}
+		}
+		t.mu.RUnlock()
+
+		for _, host := range due {
+			if refresh := t.resolveWhitelistHost(host); refresh.Before(next) {
+				next = refresh
+			}
+		}
+
+		wait = next.Sub(t.clock.Now())
+	}
+}
+
+// waitForHosts waits d or until the refresh loop is woken, returning false
+// if the tracker shuts down first
+func (t *IP404Tracker) waitForHosts(d time.Duration) bool {
+	tick, stop := t.clock.NewTicker(d)
+	defer stop()
+
+	select {
+	case <-tick:
+	case <-t.hostsWake:
+	case <-t.done:
+		return false
+	}
+	return true
+}
+
+// resolveWhitelistHost looks up host and updates its whitelisted addresses,
+// returning when it should be resolved again
+func (t *IP404Tracker) resolveWhitelistHost(host string) time.Time {
+	ctx, cancel := context.WithTimeout(context.Background(), hostLookupTimeout)
+	defer cancel()
+
+	addrs, ttl, err := lookupHostTTL(ctx, host)
+	if err == nil && len(addrs) == 0 {
+		err = errors.New("no addresses")
+	}
+	now := t.clock.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.whitelistHosts[host]
+	if !ok {
+		// Removed while resolving
+		return now.Add(hostMaxTTL)
+	}
+	if err != nil {
+		// Keep the last known addresses rather than banning the office
+		// because of a DNS hiccup
+		t.logger.Warn("resolving whitelisted hostname failed", "host", host, "error", err)
+		entry.refresh = now.Add(hostRetryInterval)
+		return entry.refresh
+	}
+
+	entry.addrs = addrs
+	entry.refresh = now.Add(min(max(ttl, hostMinTTL), hostMaxTTL))
+	t.syncWhitelistHosts()
+	t.logger.Debug("whitelisted hostname resolved", "host", host, "addrs", addrs, "ttl", ttl)
+	return entry.refresh
+}
+
+// lookupHostTTL resolves host to its IPv4 and IPv6 addresses along with the
+// lowest TTL of the answers. It queries the nameservers in /etc/resolv.conf
+// directly since the standard resolver doesn't expose TTLs, falling back to
+// it with a fixed TTL where there's no resolv.conf.
+func lookupHostTTL(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil || len(conf.Servers) == 0 {
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		for i := range addrs {
+			addrs[i] = addrs[i].Unmap()
+		}
+		return addrs, hostDefaultTTL, err
+	}
+
+	var (
+		addrs []netip.Addr
+		ttl   = hostMaxTTL
+		errs  []error
+	)
+	client := &dns.Client{}
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		msg := new(dns.Msg)
+		msg.SetQuestion(dns.Fqdn(host), qtype)
+
+		reply, err := exchange(ctx, client, msg, conf)
+		if errors.Is(err, errNoSuchHost) {
+			return nil, 0, err
+		} else if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, rr := range reply.Answer {
+			ttl = min(ttl, time.Duration(rr.Header().Ttl)*time.Second)
+			switch rr := rr.(type) {
+			case *dns.A:
+				if addr, ok := netip.AddrFromSlice(rr.A.To4()); ok {
+					addrs = append(addrs, addr)
+				}
+			case *dns.AAAA:
+				if addr, ok := netip.AddrFromSlice(rr.AAAA); ok {
+					addrs = append(addrs, addr)
+				}
+			}
+		}
+	}
+
+	// One address family failing is fine as long as the other answered
+	if len(addrs) == 0 && len(errs) > 0 {
+		return nil, 0, errors.Join(errs...)
+	}
+	return addrs, ttl, nil
+}
+
+// exchange sends msg to each configured nameserver until one answers
+func exchange(ctx context.Context, client *dns.Client, msg *dns.Msg, conf *dns.ClientConfig) (*dns.Msg, error) {
+	var lastErr error
+	for _, server := range conf.Servers {
+		reply, _, err := client.ExchangeContext(ctx, msg, net.JoinHostPort(server, conf.Port))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		switch reply.Rcode {
+		case dns.RcodeSuccess:
+			return reply, nil
+		case dns.RcodeNameError:
+			return nil, errNoSuchHost
+		default:
+			lastErr = errors.New(dns.RcodeToString[reply.Rcode])
+		}
+	}
+	return nil, lastErr
+}
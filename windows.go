@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// WindowLimit is a threshold over a window of its own, checked next to the
+// main one: a short window catches bursts and a long one scanners pacing
+// themselves under it
+type WindowLimit struct {
+	Threshold int           // 404s allowed within Window; more bans the client
+	Window    time.Duration // e.g. an hour next to a one minute main window
+}
+
+// WithWindows bans clients crossing any of limits on top of the tracker's
+// threshold and window, e.g. WindowLimit{Threshold: 20, Window: time.Hour}
+// next to 3 within a minute. 404s are kept for the longest window.
+func WithWindows(limits ...WindowLimit) Option {
+	return func(t *IP404Tracker) {
+		l := *t.limits.Load()
+		for _, limit := range limits {
+			if limit.Threshold <= 0 || limit.Window <= 0 {
+				t.logger.Warn("skipping window without a threshold or length", "threshold", limit.Threshold, "window", limit.Window)
+				continue
+			}
+			l.windows = append(l.windows, limit)
+		}
+		t.limits.Store(&l)
+	}
+}
+
+// longestWindow returns the longest of window and those of limits
+func longestWindow(window time.Duration, limits []WindowLimit) time.Duration {
+	for _, limit := range limits {
+		window = max(window, limit.Window)
+	}
+	return window
+}
+
+// countRetention returns how long 404s are kept: the longest window
+func (t *IP404Tracker) countRetention() time.Duration {
+	l := t.limits.Load()
+	return longestWindow(l.window, l.windows)
+}
+
+// checkWindows bans the tracking key ip after a 404 for path if it crossed
+// the threshold of one of the extra windows, reporting whether it did
+func (t *IP404Tracker) checkWindows(ip, path string, now time.Time) bool {
+	for _, limit := range t.limits.Load().windows {
+		count, err := t.store.Count404s(ip, now, limit.Window)
+		if err != nil {
+			t.logger.Error("counting 404s failed", "ip", ip, "error", err)
+			return false
+		}
+		if count > limit.Threshold {
+			t.banAutomatic(ip, path, count, "", windowReason(BanReasonThreshold, limit.Window), now)
+			return true
+		}
+	}
+	return false
+}
+
+// windowReason names the window a threshold was crossed in
+func windowReason(reason string, window time.Duration) string {
+	return fmt.Sprintf("%s within %s", reason, window)
+}